@@ -1,12 +1,15 @@
 from aws_cdk import (
     aws_eks as eks,
-    CfnOutput
+    aws_sqs as sqs,
+    CfnOutput,
+    Duration
 )
 from constructs import Construct
 
 class GoOtelAppConstruct(Construct):
     """
-    Construct for the Go OpenTelemetry sample application
+    Construct for the Go OpenTelemetry sample application, deployed as
+    frontend, backend and worker services
     """
     def __init__(
         self, 
@@ -21,119 +24,112 @@ class GoOtelAppConstruct(Construct):
     ) -> None:
         super().__init__(scope, construct_id, **kwargs)
         self.compute_config = compute_config
+        self.cluster = cluster
+        self.repository_uri = repository_uri
+        self.region = region
+        self.prometheus_workspace_id = prometheus_workspace_id
         
-        # Create Deployment for Go OTEL app
-        app_deployment = cluster.add_manifest("GoOtelAppDeployment", {
-            "apiVersion": "apps/v1",
-            "kind": "Deployment",
+        # Queue through which the backend hands requests to the worker
+        request_queue = sqs.Queue(
+            self,
+            "GoOtelRequestQueue",
+            visibility_timeout=Duration.seconds(30)
+        )
+        queue_url = {"name": "SQS_QUEUE_URL", "value": request_queue.queue_url}
+
+        # IRSA service accounts: the backend only sends, the worker consumes
+        backend_sa = cluster.add_service_account(
+            "GoOtelBackendSA",
+            name="go-otel-backend",
+            namespace="default"
+        )
+        request_queue.grant_send_messages(backend_sa)
+        worker_sa = cluster.add_service_account(
+            "GoOtelWorkerSA",
+            name="go-otel-worker",
+            namespace="default"
+        )
+        request_queue.grant_consume_messages(worker_sa)
+
+        # The sample runs as three services from the same image, selected by
+        # SERVICE_ROLE: the frontend calls the backend over HTTP, the backend
+        # publishes to SQS and the worker consumes. The frontend keeps the
+        # go-otel-sample-app name, so the Service, the HPA and the dashboards
+        # built on the app label keep working.
+        app_deployment = self._add_deployment(
+            "GoOtelAppDeployment",
+            name="go-otel-sample-app",
+            role="frontend",
+            service_name="go-otel-frontend",
+            replicas=2,
+            env=[{"name": "DOWNSTREAM_URLS", "value": "http://go-otel-backend:8080/api"}]
+        )
+        backend_deployment = self._add_deployment(
+            "GoOtelBackendDeployment",
+            name="go-otel-backend",
+            role="backend",
+            service_name="go-otel-backend",
+            replicas=2,
+            env=[queue_url, {"name": "SQS_WORKERS", "value": "0"}],
+            service_account=backend_sa,
+            grpc=True
+        )
+        self._add_deployment(
+            "GoOtelWorkerDeployment",
+            name="go-otel-worker",
+            role="worker",
+            service_name="go-otel-worker",
+            replicas=1,
+            env=[queue_url],
+            service_account=worker_sa
+        )
+
+        # Create Services for the frontend and backend; the worker only
+        # needs its pods scraped
+        app_service = cluster.add_manifest("GoOtelAppService", {
+            "apiVersion": "v1",
+            "kind": "Service",
             "metadata": {
                 "name": "go-otel-sample-app",
-                "namespace": "default",
-                "labels": {
-                    "app": "go-otel-sample-app"
-                }
+                "namespace": "default"
             },
             "spec": {
-                "replicas": 2,
                 "selector": {
-                    "matchLabels": {
-                        "app": "go-otel-sample-app"
-                    }
+                    "app": "go-otel-sample-app"
                 },
-                "template": {
-                    "metadata": {
-                        "labels": self._get_pod_labels(),
-                        "annotations": {
-                            "prometheus.io/scrape": "true",
-                            "prometheus.io/port": "8080",
-                            "prometheus.io/path": "/metrics"
-                        }
-                    },
-                    "spec": {
-                        **self._get_pod_spec(),
-                        "containers": [{
-                            "name": "go-otel-sample-app",
-                            "image": f"{repository_uri}:latest",
-                            "ports": [
-                                {"containerPort": 8080, "name": "http"}
-                            ],
-                            "env": [
-                                {
-                                    "name": "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
-                                    "value": "otel-collector.opentelemetry:4317"
-                                },
-                                {
-                                    "name": "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", 
-                                    "value": "otel-collector.opentelemetry:4317"
-                                },
-                                {
-                                    "name": "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", 
-                                    "value": "otel-collector.opentelemetry:4317"
-                                },
-                                {
-                                    "name": "AWS_REGION",
-                                    "value": region
-                                },
-                                {
-                                    "name": "PROMETHEUS_WORKSPACE_ID",
-                                    "value": prometheus_workspace_id
-                                },
-                                {
-                                    "name": "ENVIRONMENT",
-                                    "value": "production"
-                                }
-                            ],
-                            "resources": {
-                                "requests": {
-                                    "memory": "64Mi",
-                                    "cpu": "50m"
-                                },
-                                "limits": {
-                                    "memory": "128Mi",
-                                    "cpu": "100m"
-                                }
-                            },
-                            "livenessProbe": {
-                                "httpGet": {
-                                    "path": "/health",
-                                    "port": 8080
-                                },
-                                "initialDelaySeconds": 30,
-                                "periodSeconds": 10
-                            },
-                            "readinessProbe": {
-                                "httpGet": {
-                                    "path": "/health",
-                                    "port": 8080
-                                },
-                                "initialDelaySeconds": 5,
-                                "periodSeconds": 5
-                            }
-                        }]
-                    }
-                }
+                "ports": [
+                    {"port": 8080, "targetPort": 8080, "name": "http"}
+                ]
             }
         })
-        
-        # Create Service for Go OTEL app
-        app_service = cluster.add_manifest("GoOtelAppService", {
+        app_service.node.add_dependency(app_deployment)
+
+        backend_service = cluster.add_manifest("GoOtelBackendService", {
             "apiVersion": "v1",
             "kind": "Service",
             "metadata": {
-                "name": "go-otel-sample-app",
+                "name": "go-otel-backend",
                 "namespace": "default"
             },
             "spec": {
                 "selector": {
-                    "app": "go-otel-sample-app"
+                    "app": "go-otel-backend"
                 },
                 "ports": [
-                    {"port": 8080, "targetPort": 8080, "name": "http"}
+                    {"port": 8080, "targetPort": 8080, "name": "http"},
+                    {"port": 9090, "targetPort": 9090, "name": "grpc"}
                 ]
             }
         })
-        app_service.node.add_dependency(app_deployment)
-        
+        backend_service.node.add_dependency(backend_deployment)
+
+        CfnOutput(
+            self,
+            "GoOtelRequestQueueUrl",
+            value=request_queue.queue_url,
+            description="SQS queue between the Go OTEL backend and worker"
+        )
+
         # Create HPA for Go OTEL app
         go_hpa = cluster.add_manifest("GoOtelAppHPA", {
             "apiVersion": "autoscaling/v2",
@@ -189,13 +185,151 @@ class GoOtelAppConstruct(Construct):
         # Store reference for external dependencies
         self.go_hpa = go_hpa
     
-    def _get_pod_labels(self) -> dict:
+    def _add_deployment(
+        self,
+        construct_id: str,
+        name: str,
+        role: str,
+        service_name: str,
+        replicas: int,
+        env: list,
+        service_account=None,
+        grpc: bool = False
+    ):
+        """Add the Deployment of one of the services"""
+        ports = [{"containerPort": 8080, "name": "http"}]
+        if grpc:
+            ports.append({"containerPort": 9090, "name": "grpc"})
+        # /metrics, probes, pprof and /admin, deliberately not part of any
+        # Service
+        ports.append({"containerPort": 8090, "name": "admin"})
+
+        pod_spec = self._get_pod_spec()
+        if service_account is not None:
+            pod_spec["serviceAccountName"] = service_account.service_account_name
+
+        deployment = self.cluster.add_manifest(construct_id, {
+            "apiVersion": "apps/v1",
+            "kind": "Deployment",
+            "metadata": {
+                "name": name,
+                "namespace": "default",
+                "labels": {
+                    "app": name
+                }
+            },
+            "spec": {
+                "replicas": replicas,
+                "selector": {
+                    "matchLabels": {
+                        "app": name
+                    }
+                },
+                "template": {
+                    "metadata": {
+                        "labels": self._get_pod_labels(name, role),
+                        "annotations": {
+                            "prometheus.io/scrape": "true",
+                            "prometheus.io/port": "8090",
+                            "prometheus.io/path": "/metrics"
+                        }
+                    },
+                    "spec": {
+                        **pod_spec,
+                        "containers": [{
+                            "name": "go-otel-sample-app",
+                            "image": f"{self.repository_uri}:latest",
+                            "ports": ports,
+                            "env": [
+                                {
+                                    "name": "SERVICE_ROLE",
+                                    "value": role
+                                },
+                                {
+                                    "name": "ADMIN_PORT",
+                                    "value": "8090"
+                                },
+                                {
+                                    "name": "OTEL_EXPORTER_OTLP_ENDPOINT",
+                                    "value": "http://otel-collector.opentelemetry:4317"
+                                },
+                                {
+                                    "name": "OTEL_SERVICE_NAME",
+                                    "value": service_name
+                                },
+                                {
+                                    "name": "AWS_REGION",
+                                    "value": self.region
+                                },
+                                {
+                                    "name": "CLUSTER_NAME",
+                                    "value": self.cluster.cluster_name
+                                },
+                                {
+                                    "name": "PROMETHEUS_WORKSPACE_ID",
+                                    "value": self.prometheus_workspace_id
+                                },
+                                {
+                                    "name": "ENVIRONMENT",
+                                    "value": "production"
+                                },
+                                # Pod metadata for the k8s.* resource attributes
+                                {"name": "POD_NAME", "valueFrom": {"fieldRef": {"fieldPath": "metadata.name"}}},
+                                {"name": "POD_NAMESPACE", "valueFrom": {"fieldRef": {"fieldPath": "metadata.namespace"}}},
+                                {"name": "POD_UID", "valueFrom": {"fieldRef": {"fieldPath": "metadata.uid"}}},
+                                {"name": "NODE_NAME", "valueFrom": {"fieldRef": {"fieldPath": "spec.nodeName"}}},
+                                # Go memory limit in bytes from the container limit, so the GC
+                                # works harder before the OOM killer and the memory_check job
+                                # has a limit to compare the heap with
+                                {"name": "GOMEMLIMIT", "valueFrom": {"resourceFieldRef": {"resource": "limits.memory"}}},
+                                *env
+                            ],
+                            "resources": {
+                                "requests": {
+                                    "memory": "64Mi",
+                                    "cpu": "50m"
+                                },
+                                "limits": {
+                                    "memory": "128Mi",
+                                    "cpu": "100m"
+                                }
+                            },
+                            "livenessProbe": {
+                                "httpGet": {
+                                    "path": "/livez",
+                                    "port": 8090
+                                },
+                                "initialDelaySeconds": 30,
+                                "periodSeconds": 10
+                            },
+                            "readinessProbe": {
+                                "httpGet": {
+                                    "path": "/readyz",
+                                    "port": 8090
+                                },
+                                "initialDelaySeconds": 5,
+                                "periodSeconds": 5
+                            }
+                        }]
+                    }
+                }
+            }
+        })
+        if service_account is not None:
+            deployment.node.add_dependency(service_account)
+        return deployment
+
+    def _get_pod_labels(self, name: str, role: str) -> dict:
         """Get pod labels based on compute mode"""
-        labels = {"app": "go-otel-sample-app"}
+        labels = {
+            "app": name,
+            "app.kubernetes.io/component": role,
+            "app.kubernetes.io/part-of": "go-otel-sample-app"
+        }
         if self.compute_config and self.compute_config.mode == "fargate":
             labels["compute-type"] = "fargate"
         return labels
-    
+
     def _get_pod_spec(self) -> dict:
         """Get pod spec based on compute mode"""
         if self.compute_config and self.compute_config.mode == "fargate":
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// accessLogger writes the records of the access component, one per
+// request. Being a component of its own, the access log has its own level,
+// e.g. warn to keep only failed requests, and is left out of log sampling
+// unless listed there.
+var accessLogger = slog.New(componentHandler{logComponentAccess, func() *slog.Logger { return logger }})
+
+// accessLogMiddleware logs every request once its response is sent, with
+// the same fields whatever the handler: route, method, path, status,
+// duration, sizes, client and user agent, next to the trace, span and
+// request IDs every record carries. Handlers log what happened inside the
+// request, not that it was served. Requests under the skipped routes, such
+// as the kubelet probes, are not logged.
+func accessLogMiddleware(next http.Handler, c accessLogConfig) http.Handler {
+	if !c.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := routeOf(r.Pattern)
+		if routeUnder(route, c.SkipRoutes) {
+			return
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status_code", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("response_bytes", rec.bytes),
+			slog.String("client_address", client),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("protocol", r.Proto),
+		}
+		if route != "" {
+			attrs = append(attrs, slog.String("endpoint", route))
+		}
+		if r.ContentLength >= 0 {
+			attrs = append(attrs, slog.Int64("request_bytes", r.ContentLength))
+		}
+		if tenant := tenantFromContext(r.Context()); tenant != "" {
+			attrs = append(attrs, slog.String("tenant_id", tenant))
+		}
+		if debugTraceForced(r.Context()) {
+			attrs = append(attrs, slog.Bool("debug_trace", true))
+		}
+		accessLogger.LogAttrs(r.Context(), level, "Request served", attrs...)
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// newAdminServer serves mux, holding /metrics, the probes, pprof and the
+// /admin endpoints, on the internal admin port. Only the traffic port then
+// needs to be in the Service or behind the load balancer; Prometheus and
+// the kubelet reach the pod directly, and operators with kubectl
+// port-forward.
+//
+// Admin requests are traced, but stay out of the RED metrics, rate
+// limiting, authentication and fault injection of the app traffic. pprof
+// requests are not traced at all, as on the pprof port.
+func newAdminServer(c adminServerConfig, access accessLogConfig, mux *http.ServeMux) *http.Server {
+	instrumented := newServerHandler(mux, requestIDMiddleware(accessLogMiddleware(recoverMiddleware(mux), access)))
+	return &http.Server{
+		Addr: ":" + c.Port,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			instrumented.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
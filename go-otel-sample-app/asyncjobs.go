@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Async job states, as reported by GET /api/jobs/{id}
+const (
+	asyncJobRunning   = "running"
+	asyncJobSucceeded = "succeeded"
+	asyncJobFailed    = "failed"
+	asyncJobCanceled  = "canceled"
+)
+
+// asyncJobLatency is the duration of a job submitted without duration_ms:
+// a few seconds, sometimes much longer
+var asyncJobLatency = latencyModel{LatencyMs: 500, LatencyJitterMs: 3000, Distribution: "lognormal", LatencyShape: 0.7}
+
+// asyncJobSteps are the stages of a job, each a child span taking its share
+// of the job's duration
+var asyncJobSteps = []struct {
+	name  string
+	share float64
+}{
+	{"prepare", 0.2},
+	{"process", 0.6},
+	{"store", 0.2},
+}
+
+var (
+	asyncJobDuration metric.Float64Histogram
+
+	// promAsyncJobDuration is created by newAsyncJobs once the configured
+	// bucket boundaries are known
+	promAsyncJobDuration *prometheus.HistogramVec
+)
+
+// asyncJob is a job submitted through POST /api/jobs. Its fields are
+// guarded by the asyncJobs mutex.
+type asyncJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Step        string     `json:"step,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// TraceID is the job's own trace, which links to the submitting request
+	TraceID string `json:"trace_id"`
+
+	duration time.Duration
+}
+
+// asyncJobs runs the jobs of /api/jobs in the background and keeps their
+// status for polling. Each job is traced as its own root span, linked to
+// the span of the request that submitted it: the request ends as soon as
+// the job is accepted, and a job that runs for minutes doesn't belong in
+// the request's trace, but either can be reached from the other.
+type asyncJobs struct {
+	cfg asyncJobConfig
+
+	// ctx is canceled on shutdown, which cancels the running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*asyncJob
+	running int
+}
+
+// newAsyncJobs registers the job metrics
+func newAsyncJobs(c asyncJobConfig) (*asyncJobs, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &asyncJobs{cfg: c, ctx: ctx, cancel: cancel, jobs: make(map[string]*asyncJob)}
+
+	var err error
+	asyncJobDuration, err = meter.Float64Histogram("async_job_duration_seconds",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of the /api/jobs jobs by result"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := meter.Int64ObservableUpDownCounter("async_jobs_in_progress",
+		metric.WithDescription("Number of /api/jobs jobs running"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(a.inProgress()))
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+
+	if !prometheusBridge {
+		promAsyncJobDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "async_job_duration_seconds",
+				Help:    "Duration of the /api/jobs jobs by result",
+				Buckets: histogramBuckets["async_job_duration_seconds"],
+			},
+			[]string{"result"},
+		)
+		promRegistry.MustRegister(promAsyncJobDuration, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "async_jobs_in_progress",
+			Help: "Number of /api/jobs jobs running",
+		}, func() float64 { return float64(a.inProgress()) }))
+	}
+	return a, nil
+}
+
+func (a *asyncJobs) inProgress() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running
+}
+
+// register serves the job API:
+//
+//	POST /api/jobs        submit a job, optionally {"duration_ms": 20000}
+//	GET  /api/jobs/{id}   the job's status
+func (a *asyncJobs) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/jobs", a.submitHandler)
+	mux.HandleFunc("GET /api/jobs/{id}", a.statusHandler)
+}
+
+func (a *asyncJobs) submitHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		DurationMs int64 `json:"duration_ms"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(ctx, w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	maxMs := a.cfg.MaxDuration.Milliseconds()
+	if req.DurationMs < 0 || req.DurationMs > maxMs {
+		writeError(ctx, w, http.StatusBadRequest, fmt.Sprintf("duration_ms must be between 0 and %d", maxMs))
+		return
+	}
+	duration := time.Duration(req.DurationMs) * time.Millisecond
+	if duration == 0 {
+		duration = min(asyncJobLatency.sample(), a.cfg.MaxDuration)
+	}
+
+	job, err := a.submit(ctx, duration)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(ctx, w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	requestLogger(r, "/api/jobs").InfoContext(ctx, "Async job submitted",
+		"job_id", job.ID,
+		"job_trace_id", job.TraceID,
+		"duration_ms", duration.Milliseconds(),
+	)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (a *asyncJobs) statusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.get(r.PathValue("id"))
+	if !ok {
+		writeError(r.Context(), w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// get returns a copy of the job with id, safe to encode
+func (a *asyncJobs) get(id string) (asyncJob, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	job, ok := a.jobs[id]
+	if !ok {
+		return asyncJob{}, false
+	}
+	return *job, true
+}
+
+// submit starts a job of duration in the background, unless too many are
+// running or the app is shutting down. The job's span is a new root linked
+// to the span in ctx.
+func (a *asyncJobs) submit(ctx context.Context, duration time.Duration) (asyncJob, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ctx.Err() != nil {
+		return asyncJob{}, errors.New("shutting down")
+	}
+	if a.running >= a.cfg.MaxInProgress {
+		return asyncJob{}, fmt.Errorf("too many jobs in progress, at most %d", a.cfg.MaxInProgress)
+	}
+	a.prune(time.Now())
+
+	id := uuid.NewString()
+	submitter := trace.SpanFromContext(ctx)
+	jobCtx, span := tracer.Start(a.ctx, "async_job",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.Link{SpanContext: submitter.SpanContext()}),
+		trace.WithAttributes(
+			attribute.String("async_job.id", id),
+			attribute.Int64("async_job.duration_ms", duration.Milliseconds()),
+		),
+	)
+	submitter.AddEvent("async_job.submitted", trace.WithAttributes(
+		attribute.String("async_job.id", id),
+		attribute.String("async_job.trace_id", span.SpanContext().TraceID().String()),
+	))
+
+	job := &asyncJob{
+		ID:          id,
+		Status:      asyncJobRunning,
+		SubmittedAt: time.Now(),
+		TraceID:     span.SpanContext().TraceID().String(),
+		duration:    duration,
+	}
+	a.jobs[id] = job
+	a.running++
+	a.wg.Add(1)
+	go a.run(jobCtx, span, job)
+	return *job, nil
+}
+
+// prune forgets the jobs that finished more than the retention ago
+func (a *asyncJobs) prune(now time.Time) {
+	for id, job := range a.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > a.cfg.Retention {
+			delete(a.jobs, id)
+		}
+	}
+}
+
+// run works through the job's steps, each in a child span, and records the
+// outcome
+func (a *asyncJobs) run(ctx context.Context, span trace.Span, job *asyncJob) {
+	defer a.wg.Done()
+	defer span.End()
+
+	start := time.Now()
+	err := a.runSteps(ctx, job)
+	duration := time.Since(start)
+
+	status := asyncJobSucceeded
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = asyncJobCanceled
+		span.SetStatus(codes.Error, "canceled")
+	case err != nil:
+		status = asyncJobFailed
+		failSpan(span, err, "job failed")
+	}
+	span.SetAttributes(attribute.String("async_job.status", status))
+
+	a.mu.Lock()
+	finished := time.Now()
+	job.Status = status
+	job.Step = ""
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Error = err.Error()
+	}
+	a.running--
+	a.mu.Unlock()
+
+	asyncJobDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attribute.String("result", status)))
+	if !prometheusBridge {
+		promAsyncJobDuration.WithLabelValues(status).Observe(duration.Seconds())
+	}
+	log := backgroundLogger.With("job_id", job.ID, "duration_ms", duration.Milliseconds())
+	if err != nil {
+		log.ErrorContext(ctx, "Async job did not complete", "status", status, "error", err)
+	} else {
+		log.InfoContext(ctx, "Async job completed")
+	}
+}
+
+func (a *asyncJobs) runSteps(ctx context.Context, job *asyncJob) error {
+	for _, step := range asyncJobSteps {
+		a.mu.Lock()
+		job.Step = step.name
+		a.mu.Unlock()
+
+		stepCtx, span := tracer.Start(ctx, "async_job."+step.name)
+		err := sleepJob(stepCtx, time.Duration(float64(job.duration)*step.share))
+		if err == nil && step.name == "process" && rand.Float64() < a.cfg.FailureRate {
+			err = errors.New("simulated processing failure")
+		}
+		if err != nil {
+			failSpan(span, err, step.name+" failed")
+			span.End()
+			return err
+		}
+		span.End()
+	}
+	return nil
+}
+
+// shutdown cancels the running jobs and waits for them to record their
+// outcome, or until ctx is done
+func (a *asyncJobs) shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.cancel()
+	a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiKeyHeader carries static API keys
+const apiKeyHeader = "X-API-Key"
+
+// Reasons reported in auth_failures_total
+const (
+	authMissingCredentials = "missing_credentials"
+	authInvalidAPIKey      = "invalid_api_key"
+	authMalformedToken     = "malformed_token"
+	authInvalidSignature   = "invalid_signature"
+	authExpiredToken       = "expired_token"
+	authInvalidClaims      = "invalid_claims"
+	authInvalidToken       = "invalid_token"
+)
+
+var (
+	authFailures metric.Int64Counter
+
+	promAuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "Requests rejected with 401 by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+// authenticator checks the credentials of requests to the protected routes:
+// a static key in X-API-Key, or a JWT in an Authorization: Bearer header.
+// Successful requests get an anonymized enduser.id on the server span;
+// failed ones are answered with 401 and counted by reason.
+type authenticator struct {
+	// apiKeys are the SHA-256 sums of the accepted keys, so comparisons take
+	// the same time whatever the length of the presented key
+	apiKeys [][sha256.Size]byte
+	parser  *jwt.Parser
+	keyfunc jwt.Keyfunc
+	routes  []string
+}
+
+// newAuthenticator returns nil when neither API keys nor a JWT key are
+// configured
+func newAuthenticator(c authConfig) (*authenticator, error) {
+	if len(c.APIKeys) == 0 && c.JWT.Secret == "" && c.JWT.PublicKeyFile == "" {
+		return nil, nil
+	}
+
+	a := &authenticator{routes: c.Routes}
+	for _, key := range c.APIKeys {
+		a.apiKeys = append(a.apiKeys, sha256.Sum256([]byte(key)))
+	}
+
+	if c.JWT.Secret != "" || c.JWT.PublicKeyFile != "" {
+		var methods []string
+		var key any
+		if c.JWT.PublicKeyFile != "" {
+			pem, err := os.ReadFile(c.JWT.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key, err = jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+				methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+			} else if key, err = jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+				methods = []string{"ES256", "ES384", "ES512"}
+			} else {
+				return nil, fmt.Errorf("%s holds neither an RSA nor an ECDSA public key", c.JWT.PublicKeyFile)
+			}
+		} else {
+			key = []byte(c.JWT.Secret)
+			methods = []string{"HS256", "HS384", "HS512"}
+		}
+
+		// Pinning the algorithms stops a token signed with HS256 and the
+		// public key as secret from passing as an RS256 token
+		opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+		if c.JWT.Issuer != "" {
+			opts = append(opts, jwt.WithIssuer(c.JWT.Issuer))
+		}
+		if c.JWT.Audience != "" {
+			opts = append(opts, jwt.WithAudience(c.JWT.Audience))
+		}
+		a.parser = jwt.NewParser(opts...)
+		a.keyfunc = func(*jwt.Token) (any, error) { return key, nil }
+	}
+
+	var err error
+	authFailures, err = meter.Int64Counter(
+		"auth_failures_total",
+		metric.WithDescription("Requests rejected with 401 by reason"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promAuthFailures)
+	}
+	return a, nil
+}
+
+// middleware authenticates the requests to routes under the configured
+// prefixes and passes everything else through. A nil authenticator lets
+// every request through.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r.Pattern)
+		if !routeUnder(route, a.routes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+		principal, method, reason := a.authenticate(r)
+		if reason == "" {
+			span.SetAttributes(
+				semconv.EnduserID(anonymizePrincipal(principal)),
+				attribute.String("auth.method", method),
+			)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		span.AddEvent("auth.failure", trace.WithAttributes(attribute.String("auth.failure_reason", reason)))
+		recordAuthFailure(ctx, reason)
+		requestLogger(r, route).WarnContext(ctx, "Authentication failed", "reason", reason)
+
+		if a.parser != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-otel-sample-app"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `ApiKey header="`+apiKeyHeader+`"`)
+		}
+		writeError(ctx, w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// authenticate returns the principal and the method that authenticated it,
+// or the reason the request was rejected
+func (a *authenticator) authenticate(r *http.Request) (principal, method, reason string) {
+	if key := r.Header.Get(apiKeyHeader); key != "" && len(a.apiKeys) > 0 {
+		sum := sha256.Sum256([]byte(key))
+		for _, valid := range a.apiKeys {
+			if subtle.ConstantTimeCompare(sum[:], valid[:]) == 1 {
+				return key, "api_key", ""
+			}
+		}
+		return "", "", authInvalidAPIKey
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || a.parser == nil {
+		return "", "", authMissingCredentials
+	}
+	var claims jwt.RegisteredClaims
+	if _, err := a.parser.ParseWithClaims(strings.TrimSpace(token), &claims, a.keyfunc); err != nil {
+		return "", "", jwtFailureReason(err)
+	}
+	return claims.Subject, "jwt", ""
+}
+
+// jwtFailureReason maps a token validation error onto a reason with a
+// bounded set of values, fit for a metric label
+func jwtFailureReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return authMalformedToken
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return authInvalidSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return authExpiredToken
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return authInvalidClaims
+	default:
+		return authInvalidToken
+	}
+}
+
+// anonymizePrincipal turns an API key or token subject into a stable
+// pseudonym, so spans can be grouped by caller without exposing who, or
+// which key, it is
+func anonymizePrincipal(principal string) string {
+	sum := sha256.Sum256([]byte(principal))
+	return "anon-" + hex.EncodeToString(sum[:8])
+}
+
+func recordAuthFailure(ctx context.Context, reason string) {
+	authFailures.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+	if !prometheusBridge {
+		promAuthFailures.WithLabelValues(reason).Inc()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// awsDemoMaxKeys bounds the objects /api/aws lists from the bucket
+const awsDemoMaxKeys = 10
+
+// awsDemoAPI serves /api/aws, which calls AWS with whatever credentials the
+// pod resolves and reports who it is to AWS. On EKS with IRSA the ARN is
+// the assumed role of the service account, and the trace holds a client
+// span per AWS call, so one request proves both the IAM wiring and the
+// AWS SDK instrumentation.
+type awsDemoAPI struct {
+	sts    *sts.Client
+	s3     *s3.Client
+	region string
+	bucket string
+}
+
+func newAWSDemoAPI(cfg aws.Config, c awsDemoConfig) *awsDemoAPI {
+	return &awsDemoAPI{
+		sts:    sts.NewFromConfig(cfg),
+		s3:     s3.NewFromConfig(cfg),
+		region: cfg.Region,
+		bucket: c.S3Bucket,
+	}
+}
+
+func (a awsDemoAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/aws", a.ServeHTTP)
+}
+
+func (a awsDemoAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "aws_identity")
+	defer span.End()
+	log := requestLogger(r, "/api/aws")
+
+	fail := func(err error, call string) {
+		code := http.StatusBadGateway
+		failSpan(span, err, call+" failed")
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		log.ErrorContext(ctx, "AWS call failed", "status_code", code, "call", call, "error", err)
+		writeError(ctx, w, code, call+" failed: "+err.Error())
+	}
+
+	identity, err := a.sts.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		fail(err, "sts:GetCallerIdentity")
+		return
+	}
+	// The credentials are cached by the SDK, so this costs no extra call
+	source := ""
+	if creds, err := a.sts.Options().Credentials.Retrieve(ctx); err == nil {
+		source = creds.Source
+	}
+	span.SetAttributes(
+		semconv.CloudAccountID(aws.ToString(identity.Account)),
+		attribute.String("aws.caller.arn", aws.ToString(identity.Arn)),
+		attribute.String("aws.credentials.source", source),
+	)
+	result := map[string]any{
+		"account":            aws.ToString(identity.Account),
+		"arn":                aws.ToString(identity.Arn),
+		"user_id":            aws.ToString(identity.UserId),
+		"credentials_source": source,
+		"region":             a.region,
+		"request_id":         requestIDFromContext(ctx),
+	}
+
+	if a.bucket != "" {
+		out, err := a.s3.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(a.bucket),
+			MaxKeys: aws.Int32(awsDemoMaxKeys),
+		})
+		if err != nil {
+			fail(err, "s3:ListObjectsV2")
+			return
+		}
+		keys := make([]string, 0, len(out.Contents))
+		for _, object := range out.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+		result["s3"] = map[string]any{
+			"bucket":    a.bucket,
+			"keys":      keys,
+			"truncated": aws.ToBool(out.IsTruncated),
+		}
+	}
+
+	log.InfoContext(ctx, "AWS identity resolved",
+		"status_code", http.StatusOK,
+		"arn", aws.ToString(identity.Arn),
+		"credentials_source", source,
+	)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// s3Attributes adds aws.s3.bucket to S3 spans
+func s3Attributes(_ context.Context, in middleware.InitializeInput) []attribute.KeyValue {
+	if params, ok := in.Parameters.(*s3.ListObjectsV2Input); ok && params.Bucket != nil {
+		return []attribute.KeyValue{semconv.AWSS3Bucket(*params.Bucket)}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// loadAWSConfig returns the shared AWS SDK configuration with tracing
+// middleware installed. Credentials come from the default chain. On EKS that
+// resolves to the IAM role of the pod's service account (IRSA, through
+// AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE) or to EKS Pod Identity, so
+// no static keys are needed.
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	appendAWSMiddlewares(&cfg.APIOptions, dynamoDBAttributes, sqsAttributes, snsAttributes, s3Attributes)
+	return cfg, nil
+}
+
+// awsTracingDisabledKey marks contexts whose AWS calls must not be traced
+type awsTracingDisabledKey struct{}
+
+// withoutAWSTracing suppresses spans for AWS calls made with the returned
+// context, for polling loops that would otherwise flood the trace backend
+func withoutAWSTracing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, awsTracingDisabledKey{}, true)
+}
+
+// awsAttributeSetter adds service-specific attributes, such as the DynamoDB
+// table name, to the span of an AWS API call
+type awsAttributeSetter func(ctx context.Context, in middleware.InitializeInput) []attribute.KeyValue
+
+// appendAWSMiddlewares instruments AWS SDK v2 clients built from a config
+// whose APIOptions it extends, in the spirit of the contrib otelaws package:
+// each API call gets a client span named "<Service>.<Operation>" carrying the
+// region, the AWS request ID and the HTTP status, and the trace context is
+// injected into the request so X-Ray can stitch the AWS segment in.
+func appendAWSMiddlewares(apiOptions *[]func(*middleware.Stack) error, setters ...awsAttributeSetter) {
+	*apiOptions = append(*apiOptions, func(stack *middleware.Stack) error {
+		// After the SDK's own initialize middlewares, so the service and
+		// operation names are already in the context
+		if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OTelSpan",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if ctx.Value(awsTracingDisabledKey{}) != nil {
+					return next.HandleInitialize(ctx, in)
+				}
+
+				service := awsmiddleware.GetServiceID(ctx)
+				operation := awsmiddleware.GetOperationName(ctx)
+
+				attrs := []attribute.KeyValue{
+					semconv.RPCSystemKey.String("aws-api"),
+					semconv.RPCService(service),
+					semconv.RPCMethod(operation),
+					semconv.CloudRegion(awsmiddleware.GetRegion(ctx)),
+				}
+				for _, setter := range setters {
+					attrs = append(attrs, setter(ctx, in)...)
+				}
+
+				ctx, span := tracer.Start(ctx, service+"."+operation,
+					trace.WithSpanKind(trace.SpanKindClient),
+					trace.WithAttributes(attrs...),
+				)
+				defer span.End()
+
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+					span.SetAttributes(semconv.AWSRequestID(requestID))
+				}
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				return out, metadata, err
+			}), middleware.After); err != nil {
+			return err
+		}
+
+		if err := stack.Build.Add(middleware.BuildMiddlewareFunc("OTelPropagator",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok && ctx.Value(awsTracingDisabledKey{}) == nil {
+					otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After); err != nil {
+			return err
+		}
+
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("OTelStatusCode",
+			func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleDeserialize(ctx, in)
+				if resp, ok := out.RawResponse.(*smithyhttp.Response); ok && ctx.Value(awsTracingDisabledKey{}) == nil {
+					trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+				}
+				return out, metadata, err
+			}), middleware.Before)
+	})
+}
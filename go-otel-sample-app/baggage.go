@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// baggageHeaders maps request headers to the baggage members the app sets
+// when it is the first service to see a request, e.g. behind an ingress
+// that knows the user but does not speak W3C Baggage. Members already in
+// the incoming baggage header win.
+var baggageHeaders = map[string]string{
+	"X-User-Tier":  "user.tier",
+	"X-Session-ID": "session.id",
+}
+
+// baggageMetricKeys are the baggage members added to the request metrics.
+// They become metric attributes, so only low-cardinality members belong
+// here: user.tier is fine, session.id is not.
+var baggageMetricKeys []string
+
+// baggageMiddleware completes the request baggage from baggageHeaders and
+// the tenant header, and copies the span keys onto the server span, which
+// was started before the baggage was complete. Everything downstream sees the baggage in the
+// context: child spans through baggageSpanProcessor, the request metrics
+// through baggageMetricKeys, and outgoing HTTP, SQS and Kafka calls through
+// the baggage propagator.
+func baggageMiddleware(next http.Handler, spanKeys []string, tenants *tenantResolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		bag := baggage.FromContext(ctx)
+		for header, key := range baggageHeaders {
+			value := r.Header.Get(header)
+			if value == "" || bag.Member(key).Key() != "" {
+				continue
+			}
+			member, err := baggage.NewMemberRaw(key, value)
+			if err != nil {
+				continue
+			}
+			if updated, err := bag.SetMember(member); err == nil {
+				bag = updated
+			}
+		}
+		ctx = baggage.ContextWithBaggage(ctx, tenants.resolve(r, bag))
+
+		trace.SpanFromContext(ctx).SetAttributes(baggageAttributes(ctx, spanKeys)...)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// baggageAttributes returns the members of the context's baggage named in
+// keys as attributes with the same keys
+func baggageAttributes(ctx context.Context, keys []string) []attribute.KeyValue {
+	bag := baggage.FromContext(ctx)
+	var attrs []attribute.KeyValue
+	for _, key := range keys {
+		if member := bag.Member(key); member.Key() != "" {
+			attrs = append(attrs, attribute.String(key, member.Value()))
+		}
+	}
+	return attrs
+}
+
+// baggageSpanProcessor copies baggage members onto every span when it
+// starts, so database, cache and messaging spans can be filtered by
+// user.tier as easily as the server span
+type baggageSpanProcessor struct {
+	keys []string
+}
+
+func (p baggageSpanProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(baggageAttributes(ctx, p.keys)...)
+}
+
+func (baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (baggageSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
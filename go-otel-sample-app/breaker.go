@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errBreakerOpen is returned for calls the circuit breaker turned away
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStates = []breakerState{breakerClosed, breakerOpen, breakerHalfOpen}
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+var (
+	breakerTrips    metric.Int64Counter
+	breakerRejected metric.Int64Counter
+
+	promBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "1 for the current state of each downstream circuit breaker, 0 for the others",
+		},
+		[]string{"target", "state"},
+	)
+	promBreakerTrips = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_trips_total",
+			Help: "Times a downstream circuit breaker opened",
+		},
+		[]string{"target"},
+	)
+	promBreakerRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejected_total",
+			Help: "Downstream calls short-circuited by an open breaker",
+		},
+		[]string{"target"},
+	)
+)
+
+// downstreamBreakers holds a breaker per downstream host, set from the
+// downstream configuration at startup
+var downstreamBreakers = newBreakerSet(breakerConfig{})
+
+// breakerSet creates the breaker of a target on its first call. Targets
+// are the hosts of the configured downstream URLs, so the set is bounded.
+type breakerSet struct {
+	c breakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newBreakerSet(c breakerConfig) *breakerSet {
+	return &breakerSet{c: c, breakers: make(map[string]*circuitBreaker)}
+}
+
+// get returns the breaker of target, or nil when breakers are disabled
+func (s *breakerSet) get(target string) *circuitBreaker {
+	if !s.c.Enabled {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[target]
+	if !ok {
+		b = &circuitBreaker{target: target, c: s.c}
+		s.breakers[target] = b
+		b.setStateGauge()
+	}
+	return b
+}
+
+func (s *breakerSet) snapshot() map[string]breakerState {
+	s.mu.Lock()
+	breakers := make([]*circuitBreaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+	states := make(map[string]breakerState, len(breakers))
+	for _, b := range breakers {
+		b.mu.Lock()
+		states[b.target] = b.state
+		b.mu.Unlock()
+	}
+	return states
+}
+
+// registerBreakerMetrics exports circuit_breaker_state, trips and
+// rejections
+func registerBreakerMetrics() error {
+	var err error
+	if breakerTrips, err = meter.Int64Counter("circuit_breaker_trips_total",
+		metric.WithDescription("Times a downstream circuit breaker opened, by target")); err != nil {
+		return err
+	}
+	if breakerRejected, err = meter.Int64Counter("circuit_breaker_rejected_total",
+		metric.WithDescription("Downstream calls short-circuited by an open breaker, by target")); err != nil {
+		return err
+	}
+	if _, err = meter.Int64ObservableGauge("circuit_breaker_state",
+		metric.WithDescription("1 for the current state of each downstream circuit breaker, 0 for the others, by target and state"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for target, current := range downstreamBreakers.snapshot() {
+				for _, state := range breakerStates {
+					var v int64
+					if state == current {
+						v = 1
+					}
+					o.Observe(v, metric.WithAttributes(
+						attribute.String("target", target),
+						attribute.String("state", state.String()),
+					))
+				}
+			}
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promBreakerState, promBreakerTrips, promBreakerRejected)
+	}
+	return nil
+}
+
+// circuitBreaker stops calling a downstream after Failures consecutive
+// failed calls. Once open, calls fail at once with errBreakerOpen, sparing
+// the downstream and the caller's latency; after OpenTimeout it lets
+// HalfOpenRequests trial calls through, and closes if they all succeed or
+// opens again on the first failure.
+type circuitBreaker struct {
+	target string
+	c      breakerConfig
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// trials are the calls let through while half-open, passed those of
+	// them that succeeded
+	trials, passed int
+}
+
+// allow reports whether a call may go ahead. Every allowed call must be
+// followed by record.
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	b.mu.Lock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.c.OpenTimeout {
+		b.transition(ctx, breakerHalfOpen)
+	}
+	allowed := true
+	switch b.state {
+	case breakerOpen:
+		allowed = false
+	case breakerHalfOpen:
+		if allowed = b.trials < b.c.HalfOpenRequests; allowed {
+			b.trials++
+		}
+	}
+	state := b.state
+	b.mu.Unlock()
+	if allowed {
+		return nil
+	}
+
+	breakerRejected.Add(ctx, 1, metric.WithAttributes(attribute.String("target", b.target)))
+	if !prometheusBridge {
+		promBreakerRejected.WithLabelValues(b.target).Inc()
+	}
+	trace.SpanFromContext(ctx).AddEvent("circuit_breaker.rejected", trace.WithAttributes(
+		attribute.String("circuit_breaker.target", b.target),
+		attribute.String("circuit_breaker.state", state.String()),
+	))
+	return errBreakerOpen
+}
+
+// record counts the outcome of an allowed call. Calls canceled by the
+// caller say nothing about the downstream and count neither way.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	canceled := errors.Is(err, context.Canceled)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		switch {
+		case canceled:
+		case err != nil:
+			b.failures++
+			if b.failures >= b.c.Failures {
+				b.transition(ctx, breakerOpen)
+			}
+		default:
+			b.failures = 0
+		}
+	case breakerHalfOpen:
+		switch {
+		case canceled:
+			b.trials--
+		case err != nil:
+			b.transition(ctx, breakerOpen)
+		default:
+			b.passed++
+			if b.passed >= b.c.HalfOpenRequests {
+				b.transition(ctx, breakerClosed)
+			}
+		}
+	}
+}
+
+// transition moves the breaker to state; b.mu is held
+func (b *circuitBreaker) transition(ctx context.Context, state breakerState) {
+	from := b.state
+	b.state = state
+	b.failures, b.trials, b.passed = 0, 0, 0
+	b.setStateGauge()
+
+	log := logger.With("component", logComponentHTTP, "target", b.target,
+		"from", from.String(), "to", state.String())
+	switch state {
+	case breakerOpen:
+		b.openedAt = time.Now()
+		breakerTrips.Add(ctx, 1, metric.WithAttributes(attribute.String("target", b.target)))
+		if !prometheusBridge {
+			promBreakerTrips.WithLabelValues(b.target).Inc()
+		}
+		log.WarnContext(ctx, "Circuit breaker opened", "open_timeout", b.c.OpenTimeout.String())
+	case breakerHalfOpen:
+		log.InfoContext(ctx, "Circuit breaker half-open, letting trial calls through", "trials", b.c.HalfOpenRequests)
+	case breakerClosed:
+		log.InfoContext(ctx, "Circuit breaker closed")
+	}
+	trace.SpanFromContext(ctx).AddEvent("circuit_breaker.transition", trace.WithAttributes(
+		attribute.String("circuit_breaker.target", b.target),
+		attribute.String("circuit_breaker.from", from.String()),
+		attribute.String("circuit_breaker.to", state.String()),
+	))
+}
+
+func (b *circuitBreaker) setStateGauge() {
+	if prometheusBridge {
+		return
+	}
+	for _, state := range breakerStates {
+		var v float64
+		if state == b.state {
+			v = 1
+		}
+		promBreakerState.WithLabelValues(b.target, state.String()).Set(v)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.gitCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Left empty, they are taken from the build information the Go toolchain
+// embeds, which has the module version with `go install` and the VCS
+// revision and commit time with `go build` in a checkout.
+var (
+	version   string
+	gitCommit string
+	buildDate string
+)
+
+// defaultVersion is the version of builds that know no better
+const defaultVersion = "1.0.0"
+
+// build describes the running binary
+var build = readBuildInfo()
+
+// buildInfo is the answer of /version and the labels of build_info
+type buildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	Modified  bool   `json:"modified"`
+	GoVersion string `json:"go_version"`
+	BuildDate string `json:"build_date"`
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		GoVersion: runtime.Version(),
+		BuildDate: buildDate,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v := info.Main.Version; b.Version == "" && v != "" && v != "(devel)" {
+			b.Version = v
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if b.GitCommit == "" {
+					b.GitCommit = s.Value
+				}
+			case "vcs.time":
+				// The commit time, the best guess at the build date
+				if b.BuildDate == "" {
+					b.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = defaultVersion
+	}
+	return b
+}
+
+// shortCommit is the commit abbreviated as in `git log --oneline`
+func (b buildInfo) shortCommit() string {
+	if len(b.GitCommit) > 12 {
+		return b.GitCommit[:12]
+	}
+	return b.GitCommit
+}
+
+// attributes are the build's resource attributes; service.version and
+// process.runtime.version are set elsewhere. vcs.ref.head.revision follows
+// the semantic conventions of the telemetry package's schema.
+func (b buildInfo) attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if b.GitCommit != "" {
+		attrs = append(attrs, attribute.String("vcs.ref.head.revision", b.GitCommit))
+	}
+	if b.BuildDate != "" {
+		attrs = append(attrs, attribute.String("build.date", b.BuildDate))
+	}
+	return attrs
+}
+
+var promBuildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Always 1, labeled with the version, git commit, Go version and build date of the running binary",
+	},
+	[]string{"version", "git_commit", "go_version", "build_date"},
+)
+
+// registerBuildInfo exports build_info, whose labels can be joined onto
+// any other series of a pod to tell which build produced it, e.g. to mark
+// deployments on dashboards
+func registerBuildInfo() error {
+	labels := metric.WithAttributes(
+		attribute.String("version", build.Version),
+		attribute.String("git_commit", build.shortCommit()),
+		attribute.String("go_version", build.GoVersion),
+		attribute.String("build_date", build.BuildDate),
+	)
+	if _, err := meter.Int64ObservableGauge("build_info",
+		metric.WithDescription("Always 1, labeled with the version, git commit, Go version and build date of the running binary"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(1, labels)
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promBuildInfo)
+		promBuildInfo.WithLabelValues(build.Version, build.shortCommit(), build.GoVersion, build.BuildDate).Set(1)
+	}
+	return nil
+}
+
+// versionHandler answers with the build of the running binary
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, build)
+}
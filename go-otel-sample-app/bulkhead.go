@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	concurrencyRejected metric.Int64Counter
+
+	promConcurrencyRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_concurrency_rejected_total",
+			Help: "Requests rejected with 503 by the concurrency limiter",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// bulkhead caps the requests in flight on each limited route, so a slow
+// route cannot take every goroutine, connection and byte of memory the
+// pod has and starve the others. Requests past the cap are shed at once
+// with 503 Service Unavailable, which the ALB or the client can retry on
+// another pod, rather than queued behind the slow ones.
+type bulkhead struct {
+	routes map[string]*routeSlots
+}
+
+// routeSlots counts the requests in flight on a route against its limit
+type routeSlots struct {
+	limit    int64
+	inFlight atomic.Int64
+}
+
+// newBulkhead returns nil when no route is limited
+func newBulkhead(c concurrencyConfig) (*bulkhead, error) {
+	if len(c.Limits) == 0 {
+		return nil, nil
+	}
+	b := &bulkhead{routes: make(map[string]*routeSlots, len(c.Limits))}
+	for route, limit := range c.Limits {
+		b.routes[route] = &routeSlots{limit: int64(limit)}
+	}
+
+	var err error
+	if concurrencyRejected, err = meter.Int64Counter("http_concurrency_rejected_total",
+		metric.WithDescription("Requests rejected with 503 by the concurrency limiter, by endpoint")); err != nil {
+		return nil, err
+	}
+	inFlight, err := meter.Int64ObservableGauge("http_inflight_requests",
+		metric.WithDescription("Requests in flight on the routes with a concurrency limit, by endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	limit, err := meter.Int64ObservableGauge("http_concurrency_limit",
+		metric.WithDescription("Concurrency limit of each limited route, by endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for route, s := range b.routes {
+			endpoint := metric.WithAttributes(attribute.String("endpoint", route))
+			o.ObserveInt64(inFlight, s.inFlight.Load(), endpoint)
+			o.ObserveInt64(limit, s.limit, endpoint)
+		}
+		return nil
+	}, inFlight, limit); err != nil {
+		return nil, err
+	}
+
+	if !prometheusBridge {
+		promRegistry.MustRegister(promConcurrencyRejected)
+		for route, s := range b.routes {
+			labels := prometheus.Labels{"endpoint": route}
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "http_inflight_requests",
+				Help:        "Requests in flight on the routes with a concurrency limit",
+				ConstLabels: labels,
+			}, func() float64 { return float64(s.inFlight.Load()) }))
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "http_concurrency_limit",
+				Help:        "Concurrency limit of each limited route",
+				ConstLabels: labels,
+			}, func() float64 { return float64(s.limit) }))
+		}
+	}
+	return b, nil
+}
+
+// middleware sheds the requests to a limited route that is at its limit
+// and passes everything else through. A nil bulkhead limits nothing.
+func (b *bulkhead) middleware(next http.Handler) http.Handler {
+	if b == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r.Pattern)
+		s, ok := b.routes[route]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if n := s.inFlight.Add(1); n <= s.limit {
+			defer s.inFlight.Add(-1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.inFlight.Add(-1)
+
+		ctx := r.Context()
+		trace.SpanFromContext(ctx).AddEvent("concurrency_limited", trace.WithAttributes(
+			attribute.Int64("concurrency.limit", s.limit),
+		))
+		concurrencyRejected.Add(ctx, 1, metric.WithAttributes(attribute.String("endpoint", route)))
+		if !prometheusBridge {
+			promConcurrencyRejected.WithLabelValues(route).Inc()
+		}
+		requestLogger(r, route).WarnContext(ctx, "Concurrency limit reached, request shed",
+			"concurrency_limit", s.limit,
+		)
+
+		w.Header().Set("Retry-After", "1")
+		writeError(ctx, w, http.StatusServiceUnavailable, "too many requests in flight")
+	})
+}
+
+// parseConcurrencyLimits adds the limits in a CONCURRENCY_LIMITS value to
+// limits. The format is "<route>=<max in flight>", with entries separated
+// by commas, e.g.
+//
+//	/api/slow=20,/api/fanout=50
+func parseConcurrencyLimits(value string, limits map[string]int) error {
+	for _, entry := range splitList(value) {
+		route, max, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(route) == "" {
+			return fmt.Errorf("invalid CONCURRENCY_LIMITS entry %q: expected <route>=<max in flight>", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(max))
+		if err != nil {
+			return fmt.Errorf("invalid concurrency limit %q for %s: %w", max, route, err)
+		}
+		limits[strings.TrimSpace(route)] = n
+	}
+	return nil
+}
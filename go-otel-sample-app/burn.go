@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxBurnCores   = 64
+	maxBurnSeconds = 600
+)
+
+// burningCores counts the goroutines currently spinning for /admin/burn
+var burningCores atomic.Int64
+
+// registerBurnAdmin serves POST /admin/burn?cores=N&seconds=S, which keeps N
+// goroutines busy for S seconds in the background. With a CPU limit on the
+// pod this shows throttling in the container CPU panels, and with an HPA on
+// CPU utilization it triggers a scale-out.
+func registerBurnAdmin(mux *http.ServeMux) error {
+	if _, err := meter.Int64ObservableGauge(
+		"cpu_burn_active",
+		metric.WithDescription("Number of goroutines busy-looping for a CPU burn"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(burningCores.Load())
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+
+	mux.HandleFunc("POST /admin/burn", burnHandler)
+	return nil
+}
+
+func burnHandler(w http.ResponseWriter, r *http.Request) {
+	cores, err := strconv.Atoi(r.URL.Query().Get("cores"))
+	if err != nil || cores < 1 || cores > maxBurnCores {
+		writeError(r.Context(), w, http.StatusBadRequest, "cores must be between 1 and "+strconv.Itoa(maxBurnCores))
+		return
+	}
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds < 1 || seconds > maxBurnSeconds {
+		writeError(r.Context(), w, http.StatusBadRequest, "seconds must be between 1 and "+strconv.Itoa(maxBurnSeconds))
+		return
+	}
+
+	// The span outlives the request, so it covers the whole burn
+	_, span := tracer.Start(r.Context(), "cpu_burn", trace.WithAttributes(
+		attribute.Int("cpu_burn.cores", cores),
+		attribute.Int("cpu_burn.seconds", seconds),
+	))
+	logger.WarnContext(r.Context(), "CPU burn started", "cores", cores, "seconds", seconds)
+
+	go func() {
+		defer span.End()
+		burnCPU(cores, time.Duration(seconds)*time.Second)
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"cores":    cores,
+		"seconds":  seconds,
+		"trace_id": span.SpanContext().TraceID().String(),
+	})
+}
+
+// burnCPU spins cores goroutines until d has elapsed
+func burnCPU(cores int, d time.Duration) {
+	deadline := time.Now().Add(d)
+	var wg sync.WaitGroup
+	for i := 0; i < cores; i++ {
+		wg.Add(1)
+		burningCores.Add(1)
+		go func() {
+			defer wg.Done()
+			defer burningCores.Add(-1)
+			for time.Now().Before(deadline) {
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// userTiers are the tiers /api/users/{id} hands out, by a hash of the ID
+var userTiers = []string{"free", "pro", "enterprise"}
+
+// attributeLimiters maps instrument names to the limiter of their
+// attributes. It drives both the OTel Views and the labels of the matching
+// Prometheus collectors on /metrics, and is set from the metrics
+// configuration at startup.
+var attributeLimiters = newAttributeLimiters(defaultAttributeLimits())
+
+func defaultAttributeLimits() map[string]attributeLimit {
+	return map[string]attributeLimit{
+		"user_requests_total": {Allow: []string{"user.id", "user.tier"}, MaxValues: 100},
+	}
+}
+
+var promUserRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "user_requests_total",
+		Help: "Requests to /api/users/{id}, by user",
+	},
+	[]string{"user_id", "user_tier", "client_address"},
+)
+
+var promMetricAttributeValues = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "metric_attribute_values",
+		Help: "Distinct values kept for each limited metric attribute",
+	},
+	[]string{"instrument", "attribute"},
+)
+
+// attributeLimiter caps the cardinality of an instrument's attributes
+// before they reach the aggregation: keys outside the allowlist are
+// dropped, and so are the values of a key past the first maxValues
+// distinct ones. Measurements with dropped attributes still count, in the
+// series without them, so totals stay right while the number of series,
+// which is what AMP bills for, stays bounded.
+type attributeLimiter struct {
+	instrument string
+	allow      map[attribute.Key]bool
+	maxValues  int
+
+	mu     sync.Mutex
+	values map[attribute.Key]map[string]bool
+}
+
+func newAttributeLimiters(limits map[string]attributeLimit) map[string]*attributeLimiter {
+	limiters := make(map[string]*attributeLimiter, len(limits))
+	for name, limit := range limits {
+		l := &attributeLimiter{
+			instrument: name,
+			maxValues:  limit.MaxValues,
+			values:     make(map[attribute.Key]map[string]bool),
+		}
+		if len(limit.Allow) > 0 {
+			l.allow = make(map[attribute.Key]bool, len(limit.Allow))
+			for _, key := range limit.Allow {
+				l.allow[attribute.Key(key)] = true
+			}
+		}
+		limiters[name] = l
+	}
+	return limiters
+}
+
+// keep is the attribute filter of the instrument's View. The values seen
+// first are the ones kept, so which users get their own series depends on
+// the order of requests since startup.
+func (l *attributeLimiter) keep(kv attribute.KeyValue) bool {
+	if l.allow != nil && !l.allow[kv.Key] {
+		return false
+	}
+	if l.maxValues <= 0 {
+		return true
+	}
+	value := kv.Value.Emit()
+
+	l.mu.Lock()
+	values, ok := l.values[kv.Key]
+	if !ok {
+		values = make(map[string]bool)
+		l.values[kv.Key] = values
+	}
+	if values[value] {
+		l.mu.Unlock()
+		return true
+	}
+	kept := len(values) < l.maxValues
+	if kept {
+		values[value] = true
+	}
+	count := len(values)
+	l.mu.Unlock()
+
+	reached := kept && count == l.maxValues
+	if kept {
+		promMetricAttributeValues.WithLabelValues(l.instrument, string(kv.Key)).Set(float64(count))
+	}
+
+	if reached {
+		telemetryLogger.Warn("Metric attribute reached its value limit, further values are dropped",
+			"instrument", l.instrument, "attribute", string(kv.Key), "max_values", l.maxValues)
+	}
+	return kept
+}
+
+// label applies the limiter to a Prometheus label, which is left empty,
+// and so absent from the series, when the attribute would be dropped
+func (l *attributeLimiter) label(key, value string) string {
+	if l == nil || l.keep(attribute.String(key, value)) {
+		return value
+	}
+	return ""
+}
+
+// parseAttributeLimits adds the limits in a METRICS_ATTRIBUTE_LIMITS value
+// to limits. The format is "<instrument>=<key1>,<key2>,...[:<max values>]",
+// with entries separated by semicolons; an empty key list keeps every key,
+// e.g.
+//
+//	user_requests_total=user.id,user.tier:50;http_requests_total=:20
+func parseAttributeLimits(value string, limits map[string]attributeLimit) error {
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid METRICS_ATTRIBUTE_LIMITS entry %q: expected <instrument>=<keys>[:<max values>]", entry)
+		}
+		var limit attributeLimit
+		keys, max, hasMax := strings.Cut(spec, ":")
+		limit.Allow = splitList(keys)
+		if hasMax {
+			n, err := strconv.Atoi(strings.TrimSpace(max))
+			if err != nil {
+				return fmt.Errorf("invalid max values %q for %s: %w", max, name, err)
+			}
+			limit.MaxValues = n
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return nil
+}
+
+// registerCardinalityMetrics reports how many values each limited
+// attribute holds, which levels off at max_values once the limit is hit
+func registerCardinalityMetrics() error {
+	if _, err := meter.Int64ObservableGauge("metric_attribute_values",
+		metric.WithDescription("Distinct values kept for each limited metric attribute, by instrument and attribute"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for name, l := range attributeLimiters {
+				l.mu.Lock()
+				for key, values := range l.values {
+					o.Observe(int64(len(values)), metric.WithAttributes(
+						attribute.String("instrument", name),
+						attribute.String("attribute", string(key)),
+					))
+				}
+				l.mu.Unlock()
+			}
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promMetricAttributeValues)
+	}
+	return nil
+}
+
+// usersAPI serves /api/users/{id}, whose counter is labeled with the user
+// ID and the client address: unbounded values that would create a series
+// per user and per client without the attribute limits.
+//
+//	GET /api/users/42
+type usersAPI struct {
+	requests metric.Int64Counter
+}
+
+func newUsersAPI() (usersAPI, error) {
+	requests, err := meter.Int64Counter("user_requests_total",
+		metric.WithDescription("Requests to /api/users/{id}, by user"),
+	)
+	if err != nil {
+		return usersAPI{}, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promUserRequests)
+	}
+	return usersAPI{requests: requests}, nil
+}
+
+func (a usersAPI) register(mux *http.ServeMux) {
+	mux.Handle("GET /api/users/{id}", a)
+}
+
+func (a usersAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "get_user")
+	defer span.End()
+	id := r.PathValue("id")
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	tier := userTiers[h.Sum32()%uint32(len(userTiers))]
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	a.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("user.id", id),
+		attribute.String("user.tier", tier),
+		attribute.String("client.address", client),
+	))
+	l := attributeLimiters["user_requests_total"]
+	promUserRequests.WithLabelValues(
+		l.label("user.id", id),
+		l.label("user.tier", tier),
+		l.label("client.address", client),
+	).Inc()
+
+	// The span keeps the user ID: spans are sampled and not aggregated into
+	// series, so high cardinality costs nothing there
+	span.SetAttributes(
+		attribute.String("user.id", id),
+		attribute.String("user.tier", tier),
+		semconv.HTTPResponseStatusCode(http.StatusOK),
+	)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":         id,
+		"tier":       tier,
+		"limits":     a.limits(),
+		"request_id": requestIDFromContext(ctx),
+	})
+}
+
+// limits reports the attribute limits of user_requests_total
+func (a usersAPI) limits() map[string]any {
+	l := attributeLimiters["user_requests_total"]
+	if l == nil {
+		return nil
+	}
+	allow := make([]string, 0, len(l.allow))
+	for key := range l.allow {
+		allow = append(allow, string(key))
+	}
+	sort.Strings(allow)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	values := make(map[string]int, len(l.values))
+	for key, v := range l.values {
+		values[string(key)] = len(v)
+	}
+	return map[string]any{"allow": allow, "max_values": l.maxValues, "values": values}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// cgroupRoot is where the container's own cgroup is mounted; with cgroup
+// namespaces (the default on EKS AL2023 and Bottlerocket) it is the pod
+// container's cgroup rather than the node's root
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupStats is a point-in-time reading of the container's cgroup. Limits
+// are zero when the container has none.
+type cgroupStats struct {
+	// cpuUsage is the cumulative CPU time consumed by the container
+	cpuUsage time.Duration
+	// cpuLimit is the CFS quota expressed in cores
+	cpuLimit          float64
+	throttledPeriods  uint64
+	throttledTime     time.Duration
+	memoryWorkingSet  uint64
+	memoryLimit       uint64
+	memoryUtilization float64
+}
+
+// cgroupReader reads CPU and memory accounting from cgroup v2 or, on older
+// AMIs, from the v1 cpu, cpuacct and memory controllers
+type cgroupReader struct {
+	root string
+	v2   bool
+}
+
+// newCgroupReader returns nil when no cgroup filesystem is mounted, for
+// example when running outside a container on macOS
+func newCgroupReader(root string) *cgroupReader {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return &cgroupReader{root: root, v2: true}
+	}
+	if _, err := os.Stat(filepath.Join(root, "memory", "memory.limit_in_bytes")); err == nil {
+		return &cgroupReader{root: root}
+	}
+	return nil
+}
+
+func (c *cgroupReader) read() (cgroupStats, error) {
+	if c.v2 {
+		return c.readV2()
+	}
+	return c.readV1()
+}
+
+func (c *cgroupReader) readV2() (cgroupStats, error) {
+	var stats cgroupStats
+
+	cpuStat, err := readKeyValues(filepath.Join(c.root, "cpu.stat"))
+	if err != nil {
+		return stats, err
+	}
+	stats.cpuUsage = time.Duration(cpuStat["usage_usec"]) * time.Microsecond
+	stats.throttledPeriods = cpuStat["nr_throttled"]
+	stats.throttledTime = time.Duration(cpuStat["throttled_usec"]) * time.Microsecond
+
+	// cpu.max holds "<quota> <period>", with "max" meaning unlimited
+	if cpuMax, err := readString(filepath.Join(c.root, "cpu.max")); err == nil {
+		fields := strings.Fields(cpuMax)
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, _ := strconv.ParseFloat(fields[0], 64)
+			period, _ := strconv.ParseFloat(fields[1], 64)
+			if period > 0 {
+				stats.cpuLimit = quota / period
+			}
+		}
+	}
+
+	usage, err := readUint(filepath.Join(c.root, "memory.current"))
+	if err != nil {
+		return stats, err
+	}
+	memStat, err := readKeyValues(filepath.Join(c.root, "memory.stat"))
+	if err != nil {
+		return stats, err
+	}
+	stats.memoryWorkingSet = workingSet(usage, memStat["inactive_file"])
+	if limit, err := readString(filepath.Join(c.root, "memory.max")); err == nil && limit != "max" {
+		stats.memoryLimit, _ = strconv.ParseUint(limit, 10, 64)
+	}
+
+	stats.setMemoryUtilization()
+	return stats, nil
+}
+
+func (c *cgroupReader) readV1() (cgroupStats, error) {
+	var stats cgroupStats
+
+	usage, err := readUint(filepath.Join(c.root, "cpuacct", "cpuacct.usage"))
+	if err != nil {
+		return stats, err
+	}
+	stats.cpuUsage = time.Duration(usage)
+
+	if cpuStat, err := readKeyValues(filepath.Join(c.root, "cpu", "cpu.stat")); err == nil {
+		stats.throttledPeriods = cpuStat["nr_throttled"]
+		stats.throttledTime = time.Duration(cpuStat["throttled_time"])
+	}
+
+	// A quota of -1 means unlimited
+	quota, err := readString(filepath.Join(c.root, "cpu", "cpu.cfs_quota_us"))
+	if err == nil && quota != "-1" {
+		period, err := readUint(filepath.Join(c.root, "cpu", "cpu.cfs_period_us"))
+		q, qerr := strconv.ParseFloat(quota, 64)
+		if err == nil && qerr == nil && period > 0 {
+			stats.cpuLimit = q / float64(period)
+		}
+	}
+
+	memUsage, err := readUint(filepath.Join(c.root, "memory", "memory.usage_in_bytes"))
+	if err != nil {
+		return stats, err
+	}
+	memStat, err := readKeyValues(filepath.Join(c.root, "memory", "memory.stat"))
+	if err != nil {
+		return stats, err
+	}
+	stats.memoryWorkingSet = workingSet(memUsage, memStat["total_inactive_file"])
+
+	// v1 reports an unlimited cgroup as a huge page-aligned number
+	if limit, err := readUint(filepath.Join(c.root, "memory", "memory.limit_in_bytes")); err == nil && limit < 1<<62 {
+		stats.memoryLimit = limit
+	}
+
+	stats.setMemoryUtilization()
+	return stats, nil
+}
+
+// workingSet mirrors the kubelet's container_memory_working_set_bytes:
+// usage minus inactive page cache, which is what the OOM killer acts on
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+func (s *cgroupStats) setMemoryUtilization() {
+	if s.memoryLimit > 0 {
+		s.memoryUtilization = float64(s.memoryWorkingSet) / float64(s.memoryLimit)
+	}
+}
+
+// cpuUtilization tracks CPU usage between successive readings as a fraction
+// of the CPU quota. Each consumer (OTel reader, Prometheus scrape) keeps its
+// own instance so their collection intervals do not interfere.
+type cpuUtilization struct {
+	mu        sync.Mutex
+	lastUsage time.Duration
+	lastTime  time.Time
+}
+
+// observe returns false on the first reading or when there is no quota
+func (u *cpuUtilization) observe(stats cgroupStats) (float64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	prevUsage, prevTime := u.lastUsage, u.lastTime
+	u.lastUsage, u.lastTime = stats.cpuUsage, now
+
+	if prevTime.IsZero() || stats.cpuLimit == 0 {
+		return 0, false
+	}
+	elapsed := now.Sub(prevTime)
+	if elapsed <= 0 {
+		return 0, false
+	}
+	cores := float64(stats.cpuUsage-prevUsage) / float64(elapsed)
+	return cores / stats.cpuLimit, true
+}
+
+// registerCgroupMetrics exports container CPU and memory against the pod's
+// resource limits. Host-wide figures from gopsutil hide CFS throttling and
+// approaching OOM kills, which are decided by these cgroup values.
+func registerCgroupMetrics() error {
+	reader := newCgroupReader(cgroupRoot)
+	if reader == nil {
+		logger.Info("No cgroup filesystem found, container metrics disabled")
+		return nil
+	}
+	if _, err := reader.read(); err != nil {
+		logger.Warn("Cgroup accounting unavailable, container metrics disabled", "error", err)
+		return nil
+	}
+
+	if err := registerCgroupInstruments(otel.Meter("go-otel-sample-app/cgroup"), reader); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		registerCgroupCollectors(reader)
+	}
+	return nil
+}
+
+func registerCgroupInstruments(m metric.Meter, reader *cgroupReader) error {
+	cpuTime, err := m.Float64ObservableCounter("container.cpu.time",
+		metric.WithUnit("s"),
+		metric.WithDescription("CPU time consumed by the container"))
+	if err != nil {
+		return err
+	}
+	cpuLimit, err := m.Float64ObservableGauge("container.cpu.limit",
+		metric.WithUnit("{cpu}"),
+		metric.WithDescription("CPU quota of the container in cores"))
+	if err != nil {
+		return err
+	}
+	cpuUtil, err := m.Float64ObservableGauge("container.cpu.utilization",
+		metric.WithUnit("1"),
+		metric.WithDescription("CPU usage as a fraction of the CPU quota"))
+	if err != nil {
+		return err
+	}
+	throttledPeriods, err := m.Int64ObservableCounter("container.cpu.throttled.periods",
+		metric.WithUnit("{period}"),
+		metric.WithDescription("CFS periods in which the container was throttled"))
+	if err != nil {
+		return err
+	}
+	throttledTime, err := m.Float64ObservableCounter("container.cpu.throttled.time",
+		metric.WithUnit("s"),
+		metric.WithDescription("Time the container spent throttled"))
+	if err != nil {
+		return err
+	}
+	memUsage, err := m.Int64ObservableGauge("container.memory.working_set",
+		metric.WithUnit("By"),
+		metric.WithDescription("Container memory usage minus inactive page cache"))
+	if err != nil {
+		return err
+	}
+	memLimit, err := m.Int64ObservableGauge("container.memory.limit",
+		metric.WithUnit("By"),
+		metric.WithDescription("Memory limit of the container"))
+	if err != nil {
+		return err
+	}
+	memUtil, err := m.Float64ObservableGauge("container.memory.utilization",
+		metric.WithUnit("1"),
+		metric.WithDescription("Working set as a fraction of the memory limit"))
+	if err != nil {
+		return err
+	}
+
+	var util cpuUtilization
+	_, err = m.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats, err := reader.read()
+		if err != nil {
+			return err
+		}
+		o.ObserveFloat64(cpuTime, stats.cpuUsage.Seconds())
+		o.ObserveInt64(throttledPeriods, int64(stats.throttledPeriods))
+		o.ObserveFloat64(throttledTime, stats.throttledTime.Seconds())
+		o.ObserveInt64(memUsage, int64(stats.memoryWorkingSet))
+		if stats.cpuLimit > 0 {
+			o.ObserveFloat64(cpuLimit, stats.cpuLimit)
+		}
+		if v, ok := util.observe(stats); ok {
+			o.ObserveFloat64(cpuUtil, v)
+		}
+		if stats.memoryLimit > 0 {
+			o.ObserveInt64(memLimit, int64(stats.memoryLimit))
+			o.ObserveFloat64(memUtil, stats.memoryUtilization)
+		}
+		return nil
+	}, cpuTime, cpuLimit, cpuUtil, throttledPeriods, throttledTime, memUsage, memLimit, memUtil)
+	return err
+}
+
+// registerCgroupCollectors serves the same values on /metrics, with the app
+// label used by the other system gauges
+func registerCgroupCollectors(reader *cgroupReader) {
+	appLabels := prometheus.Labels{"app": "go-otel-sample-app"}
+	read := func(f func(cgroupStats) float64) func() float64 {
+		return func() float64 {
+			stats, err := reader.read()
+			if err != nil {
+				return 0
+			}
+			return f(stats)
+		}
+	}
+
+	var util cpuUtilization
+	promRegistry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "go_container_cpu_usage_seconds_total",
+			Help:        "CPU time consumed by the container",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return s.cpuUsage.Seconds() })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "go_container_cpu_limit_cores",
+			Help:        "CPU quota of the container in cores, 0 when unlimited",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return s.cpuLimit })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "go_container_cpu_usage_percent",
+			Help:        "CPU usage as a percentage of the CPU quota since the previous scrape",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 {
+			v, _ := util.observe(s)
+			return v * 100
+		})),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "go_container_cpu_throttled_periods_total",
+			Help:        "CFS periods in which the container was throttled",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return float64(s.throttledPeriods) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "go_container_cpu_throttled_seconds_total",
+			Help:        "Time the container spent throttled",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return s.throttledTime.Seconds() })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "go_container_memory_working_set_bytes",
+			Help:        "Container memory usage minus inactive page cache",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return float64(s.memoryWorkingSet) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "go_container_memory_limit_bytes",
+			Help:        "Memory limit of the container, 0 when unlimited",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return float64(s.memoryLimit) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "go_container_memory_usage_percent",
+			Help:        "Working set as a percentage of the memory limit",
+			ConstLabels: appLabels,
+		}, read(func(s cgroupStats) float64 { return s.memoryUtilization * 100 })),
+	)
+}
+
+func readString(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readUint(path string) (uint64, error) {
+	value, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyValues parses flat keyed files such as cpu.stat and memory.stat
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return values, nil
+}
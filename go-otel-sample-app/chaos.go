@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// chaosRule describes the faults injected into one route: the latency of
+// its latencyModel, and errors.
+//
+// client_errors adds 4xx answers that mimic client mistakes, by status:
+// 400 for a bad payload, 404 for an unknown ID, 429 for throttling.
+type chaosRule struct {
+	ErrorRate    float64         `json:"error_rate" yaml:"error_rate"`
+	ErrorStatus  int             `json:"error_status,omitempty" yaml:"error_status"`
+	ClientErrors map[int]float64 `json:"client_errors,omitempty" yaml:"client_errors"`
+	latencyModel `yaml:",inline"`
+}
+
+// clientErrorMessages are the bodies of injected 4xx answers, worded like
+// the handlers' own
+var clientErrorMessages = map[int]string{
+	http.StatusBadRequest:      "invalid request payload",
+	http.StatusNotFound:        "resource not found",
+	http.StatusTooManyRequests: "rate limit exceeded",
+}
+
+func (r chaosRule) validate() error {
+	if r.ErrorRate < 0 || r.ErrorRate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1, got %v", r.ErrorRate)
+	}
+	if r.ErrorStatus != 0 && (r.ErrorStatus < 400 || r.ErrorStatus > 599) {
+		return fmt.Errorf("error_status must be a 4xx or 5xx code, got %d", r.ErrorStatus)
+	}
+	total := r.ErrorRate
+	for code, rate := range r.ClientErrors {
+		if code < 400 || code > 499 {
+			return fmt.Errorf("client_errors must be keyed by 4xx codes, got %d", code)
+		}
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("client_errors rate of %d must be between 0 and 1, got %v", code, rate)
+		}
+		total += rate
+	}
+	if total > 1 {
+		return fmt.Errorf("error_rate and client_errors add up to %v, more than every request", total)
+	}
+	return r.latencyModel.validate()
+}
+
+func (r chaosRule) errorStatus() int {
+	if r.ErrorStatus == 0 {
+		return http.StatusInternalServerError
+	}
+	return r.ErrorStatus
+}
+
+// faultStatus draws the status of the request's injected error, or 0 to
+// let the handler answer. Server errors come first, then the client errors
+// in status order, each taking its own share of the requests.
+func (r chaosRule) faultStatus() int {
+	roll := rand.Float64()
+	if roll < r.ErrorRate {
+		return r.errorStatus()
+	}
+	roll -= r.ErrorRate
+	for _, code := range slices.Sorted(maps.Keys(r.ClientErrors)) {
+		if roll < r.ClientErrors[code] {
+			return code
+		}
+		roll -= r.ClientErrors[code]
+	}
+	return 0
+}
+
+// defaultChaosRules give /api a production-like latency, a lognormal
+// around 40ms with 1% spikes of half a second, 10% server errors and a few
+// client errors so the 4xx class isn't empty, and make /dependency flaky.
+// The chaos section of the config file replaces them.
+func defaultChaosRules() map[string]chaosRule {
+	return map[string]chaosRule{
+		"/api": {
+			ErrorRate: 0.1,
+			ClientErrors: map[int]float64{
+				http.StatusBadRequest:      0.02,
+				http.StatusNotFound:        0.02,
+				http.StatusTooManyRequests: 0.01,
+			},
+			latencyModel: latencyModel{
+				LatencyMs:       5,
+				LatencyJitterMs: 35,
+				Distribution:    "lognormal",
+				LatencyShape:    0.6,
+				SpikeRate:       0.01,
+				SpikeMs:         500,
+			},
+		},
+		"/dependency": {
+			ErrorRate:    0.05,
+			ErrorStatus:  http.StatusServiceUnavailable,
+			latencyModel: latencyModel{LatencyMs: 10, LatencyJitterMs: 50},
+		},
+	}
+}
+
+// chaos holds the fault injection rules, keyed by route path as registered
+// on the mux (e.g. "/api/orders/{id}"). They can be changed at runtime
+// through /admin/chaos and by reloading the config file.
+var chaos = &chaosRegistry{rules: defaultChaosRules(), configured: defaultChaosRules()}
+
+var chaosInjections metric.Int64Counter
+
+type chaosRegistry struct {
+	mu    sync.RWMutex
+	rules map[string]chaosRule
+	// configured are the rules from the configuration, which a reset
+	// through the admin API goes back to
+	configured map[string]chaosRule
+}
+
+func (c *chaosRegistry) get(route string) (chaosRule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rule, ok := c.rules[route]
+	return rule, ok
+}
+
+func (c *chaosRegistry) snapshot() map[string]chaosRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rules := make(map[string]chaosRule, len(c.rules))
+	for route, rule := range c.rules {
+		rules[route] = rule
+	}
+	return rules
+}
+
+func (c *chaosRegistry) set(route string, rule chaosRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules[route] = rule
+}
+
+func (c *chaosRegistry) remove(route string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rules, route)
+}
+
+func (c *chaosRegistry) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = maps.Clone(c.configured)
+}
+
+// replace installs a new set of configured rules, discarding changes made
+// through the admin API
+func (c *chaosRegistry) replace(rules map[string]chaosRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configured = maps.Clone(rules)
+	c.rules = maps.Clone(rules)
+}
+
+// chaosMiddleware injects the latency and errors configured for the route
+// that mux would serve r with, made worse by a scenario incident or alert
+// drill in progress, as far as the chaos-latency and chaos-errors flags
+// allow. An injected error answers the request without calling the
+// handler, like an Envoy fault filter would.
+func chaosMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		route := routeOf(pattern)
+		rule, ok := chaos.get(route)
+		rule, incident, ok := scenario.adjust(route, rule, ok)
+		rule, incident, ok = drill.adjust(route, rule, incident, ok)
+		if !ok || strings.HasPrefix(route, "/admin/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+		if incident != "" {
+			span.SetAttributes(attribute.String("scenario.incident", incident))
+		}
+
+		if delay := rule.sample(); delay > 0 && boolFlag(ctx, flagChaosLatency, true) {
+			span.AddEvent("chaos.latency", trace.WithAttributes(
+				attribute.Int64("chaos.latency_ms", delay.Milliseconds())))
+			recordChaosInjection(ctx, route, "latency")
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				// The request timed out or the client gave up; neither
+				// the error nor the handler has anyone left to answer
+				return
+			case <-timer.C:
+			}
+		}
+
+		code := rule.faultStatus()
+		if code == 0 || !boolFlag(ctx, flagChaosErrors, true) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		span.AddEvent("chaos.error", trace.WithAttributes(attribute.Int("chaos.status_code", code)))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		log := requestLogger(r, route)
+		if code < http.StatusInternalServerError {
+			// A 4xx is the client's mistake: following the HTTP semantic
+			// conventions it leaves a server span's status unset, so
+			// error-rate queries on span status only count 5xx
+			recordChaosInjection(ctx, route, "client_error")
+			log.WarnContext(ctx, "Injected client error", "status_code", code)
+			if code == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			msg, ok := clientErrorMessages[code]
+			if !ok {
+				msg = http.StatusText(code)
+			}
+			writeError(ctx, w, code, msg)
+			return
+		}
+		span.RecordError(fmt.Errorf("injected fault: %d %s", code, http.StatusText(code)))
+		span.SetStatus(codes.Error, "injected fault")
+		recordChaosInjection(ctx, route, "error")
+		log.ErrorContext(ctx, "Injected fault", "status_code", code)
+
+		writeError(ctx, w, code, http.StatusText(code))
+	})
+}
+
+// routeOf strips the method from a mux pattern such as "GET /api/orders"
+func routeOf(pattern string) string {
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		return pattern[i+1:]
+	}
+	return pattern
+}
+
+func recordChaosInjection(ctx context.Context, route, fault string) {
+	chaosInjections.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("endpoint", route),
+		attribute.String("fault", fault),
+	))
+}
+
+// registerChaosAdmin serves the fault injection rules:
+//
+//	GET    /admin/chaos           all rules
+//	PUT    /admin/chaos/{route}   replace the rule of a route, e.g. /admin/chaos/api/orders
+//	DELETE /admin/chaos/{route}   stop injecting faults into a route
+//	DELETE /admin/chaos           restore the configured rules
+func registerChaosAdmin(mux *http.ServeMux) error {
+	var err error
+	chaosInjections, err = meter.Int64Counter(
+		"chaos_injections_total",
+		metric.WithDescription("Number of faults injected by endpoint and fault type"),
+	)
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("GET /admin/chaos", func(w http.ResponseWriter, r *http.Request) {
+		writeChaosRules(w, http.StatusOK)
+	})
+	mux.HandleFunc("DELETE /admin/chaos", func(w http.ResponseWriter, r *http.Request) {
+		chaos.reset()
+		logger.InfoContext(r.Context(), "Chaos rules reset to the configuration")
+		writeChaosRules(w, http.StatusOK)
+	})
+	mux.HandleFunc("PUT /admin/chaos/{route...}", func(w http.ResponseWriter, r *http.Request) {
+		route := "/" + r.PathValue("route")
+		var rule chaosRule
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&rule); err != nil {
+			writeChaosError(w, r, fmt.Errorf("invalid rule: %w", err))
+			return
+		}
+		if err := rule.validate(); err != nil {
+			writeChaosError(w, r, err)
+			return
+		}
+		chaos.set(route, rule)
+		logger.InfoContext(r.Context(), "Chaos rule updated",
+			"route", route,
+			"error_rate", rule.ErrorRate,
+			"error_status", rule.errorStatus(),
+			"client_errors", rule.ClientErrors,
+			"latency_ms", rule.LatencyMs,
+			"latency_jitter_ms", rule.LatencyJitterMs,
+			"distribution", rule.Distribution,
+			"latency_shape", rule.LatencyShape,
+			"spike_rate", rule.SpikeRate,
+			"spike_ms", rule.SpikeMs,
+		)
+		writeChaosRules(w, http.StatusOK)
+	})
+	mux.HandleFunc("DELETE /admin/chaos/{route...}", func(w http.ResponseWriter, r *http.Request) {
+		route := "/" + r.PathValue("route")
+		chaos.remove(route)
+		logger.InfoContext(r.Context(), "Chaos rule removed", "route", route)
+		writeChaosRules(w, http.StatusOK)
+	})
+	return nil
+}
+
+func writeChaosRules(w http.ResponseWriter, code int) {
+	writeJSON(w, code, chaos.snapshot())
+}
+
+func writeChaosError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(r.Context(), w, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of the binary. The same image serves the app,
+// drives load against it, and checks a deployment of it, so one artifact
+// covers the demo end to end.
+type command struct {
+	name    string
+	summary string
+	// failure is logged when run returns an error
+	failure string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "Run the HTTP, gRPC and admin servers (the default)", "Server failed", runServe},
+	{"loadgen", "Send requests to a deployment at a set rate", "Load generator failed", runLoadgen},
+	{"client", "Send one request and print the ID of its trace", "Request failed", runClient},
+	{"smoke", "Wait for a deployment to be ready and check its endpoints once", "Smoke test failed", runSmoke},
+}
+
+// runCLI runs the command named by the first argument, or by MODE when
+// there is none, e.g. MODE=loadgen in a pod spec. Without either it serves,
+// so images and manifests that pass no arguments keep working.
+func runCLI(args []string) {
+	name := getEnv("MODE", "serve")
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal(c.failure, err)
+		}
+		return
+	}
+	usage(os.Stderr)
+	fatal("Unknown command", fmt.Errorf("%q is not one of the commands", name))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// runServe parses the serve flags and serves until interrupted. Everything
+// else is configured by the configuration file and the environment.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configFile := fs.String("config", getEnv("CONFIG_FILE", ""), "path of the YAML configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	serve(*configFile)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-otel-sample-app/pkg/telemetry"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clientFlushTimeout bounds the export of the client's spans before it
+// exits
+const clientFlushTimeout = 5 * time.Second
+
+// runClient sends one request to the app and prints the response body to
+// stdout and the status and trace ID to stderr, so the trace of a request
+// can be looked up right after sending it. A status of 400 or above is an
+// error, so scripts can tell failed requests apart by the exit code.
+//
+// The trace begins in the client: a root span for the command and a client
+// span for the request, whose context is injected into the request with
+// the app's propagators, so the app's spans continue the trace. This shows
+// traces that start outside the cluster, e.g. on a laptop or in a CI job.
+// With -trace=false the client sends the request untraced and prints the
+// trace the app started, as returned in X-Trace-Id.
+//
+//	go run . client /api/orders
+//	go run . client -method POST -data '{"customer_id":"c1","items":[...]}' /api/orders
+func runClient(args []string) error {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	target := fs.String("target", getEnv("CLIENT_TARGET", "http://localhost:8080"), "base URL of the app")
+	method := fs.String("method", http.MethodGet, "HTTP method")
+	data := fs.String("data", "", "request body, sent as JSON")
+	timeout := fs.Duration("timeout", getEnvDuration("CLIENT_TIMEOUT", 10*time.Second), "request timeout")
+	apiKey := fs.String("api-key", getEnv("CLIENT_API_KEY", ""), "key sent in X-API-Key when the app requires authentication")
+	token := fs.String("token", getEnv("CLIENT_TOKEN", ""), "JWT sent as a bearer token when the app requires authentication")
+	traced := fs.Bool("trace", getEnvBool("CLIENT_TRACE", true), "start the trace in the client and export its spans; false leaves it to the app")
+	name := fs.String("service-name", getEnv("CLIENT_SERVICE_NAME", serviceName+"-client"), "service.name of the client's spans; OTEL_SERVICE_NAME overrides it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s client [flags] [path]\n\nThe path defaults to /api.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := "/api"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		path = fs.Arg(0)
+	default:
+		return fmt.Errorf("expected one path, got %q", fs.Args())
+	}
+	// The same links as LOG_TRACE_LINKS adds to the app's error logs
+	linkConfig := traceLinkConfig{
+		Backend:  getEnv("LOG_TRACE_LINKS", traceLinkNone),
+		Region:   getEnv("LOG_TRACE_LINK_REGION", ""),
+		Template: getEnv("LOG_TRACE_LINK_TEMPLATE", ""),
+	}
+	if err := linkConfig.validate(); err != nil {
+		return err
+	}
+	links := newTraceLinks(linkConfig)
+
+	ctx := context.Background()
+	client := &http.Client{}
+	span := trace.SpanFromContext(ctx)
+	if *traced {
+		tel, err := initClientTelemetry(ctx, *name)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), clientFlushTimeout)
+			defer cancel()
+			if err := tel.Shutdown(flushCtx); err != nil {
+				logger.Warn("Client spans were not all exported", "error", err)
+			}
+		}()
+		client.Transport = otelhttp.NewTransport(http.DefaultTransport)
+		route, _, _ := strings.Cut(path, "?")
+		ctx, span = tel.Tracer("go-otel-sample-app").Start(ctx, "client "+strings.ToUpper(*method)+" "+route)
+		defer span.End()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	var body io.Reader
+	if *data != "" {
+		body = strings.NewReader(*data)
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(*method), strings.TrimRight(*target, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *apiKey != "" {
+		req.Header.Set(apiKeyHeader, *apiKey)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		failSpan(span, err, "request failed")
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		failSpan(span, err, "reading response failed")
+		return err
+	}
+	if len(out) > 0 && !bytes.HasSuffix(out, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	os.Stdout.Write(out)
+
+	traceID := responseTraceID(resp.Header)
+	if sc := span.SpanContext(); sc.IsValid() {
+		if traceID != "-" && traceID != sc.TraceID().String() {
+			fmt.Fprintf(os.Stderr, "warning: the app answered in trace %s, not continuing the client's; check that OTEL_PROPAGATORS matches on both sides\n", traceID)
+		}
+		traceID = sc.TraceID().String()
+	}
+	fmt.Fprintf(os.Stderr, "status: %s\ntrace_id: %s\n", resp.Status, traceID)
+	if tid, err := trace.TraceIDFromHex(traceID); links != nil && err == nil {
+		fmt.Fprintf(os.Stderr, "trace_link: %s\n", links.link(tid))
+	}
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+		return fmt.Errorf("%s %s answered %s", req.Method, path, resp.Status)
+	}
+	return nil
+}
+
+// initClientTelemetry exports the client's spans the way the app exports
+// its own, to OTEL_EXPORTER_OTLP_ENDPOINT or as TELEMETRY_EXPORTER says,
+// and installs the app's propagators, X-Ray's included, for the request.
+// Every trace is sampled: the client is the root, and the app's parent-based
+// samplers follow its decision. Metrics and logs are left out.
+func initClientTelemetry(ctx context.Context, name string) (*telemetry.Telemetry, error) {
+	propagator, err := newPropagator()
+	if err != nil {
+		return nil, err
+	}
+	tracerOptions := []sdktrace.TracerProviderOption{sdktrace.WithSampler(sdktrace.AlwaysSample())}
+	if useXRayIDs() {
+		tracerOptions = append(tracerOptions, sdktrace.WithIDGenerator(xray.NewIDGenerator()))
+	}
+	return telemetry.New(ctx,
+		telemetry.WithServiceName(name),
+		telemetry.WithServiceVersion(serviceVersion),
+		telemetry.WithExporter(getEnv("TELEMETRY_EXPORTER", telemetry.ExporterOTLP)),
+		telemetry.WithoutMetrics(),
+		telemetry.WithoutLogs(),
+		telemetry.WithPropagator(propagator),
+		telemetry.WithLogger(telemetryLogger),
+		telemetry.WithTracerProviderOptions(tracerOptions...),
+	)
+}
+
+// responseTraceID returns the trace ID the app returned in X-Trace-Id, or
+// in traceresponse, or "-" when it returned none, e.g. with
+// TRACE_RESPONSE=false
+func responseTraceID(h http.Header) string {
+	if id := h.Get(traceIDHeader); id != "" {
+		return id
+	}
+	// traceresponse is 00-<trace ID>-<span ID>-<flags>
+	if fields := strings.Split(h.Get(traceResponseHeader), "-"); len(fields) == 4 {
+		return fields[1]
+	}
+	return "-"
+}
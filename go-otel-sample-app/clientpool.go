@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	promClientConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "http_client_connections",
+			Help: "Open connections of the downstream HTTP client by state and server address",
+		},
+		[]string{"state", "server_address"},
+	)
+	promClientConnectionsAcquired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_connections_acquired_total",
+			Help: "Connections taken by downstream calls, by server address and whether they were reused",
+		},
+		[]string{"server_address", "reused"},
+	)
+	promClientDialFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_dial_failures_total",
+			Help: "Failed dials of the downstream HTTP client by server address and reason",
+		},
+		[]string{"server_address", "reason"},
+	)
+	// promClientConnectionWait is created by clientPool.register once the
+	// configured bucket boundaries are known
+	promClientConnectionWait *prometheus.HistogramVec
+)
+
+// clientPool is the transport of the downstream client, with its
+// connection pool made visible. Go's http.Transport keeps no count of its
+// connections, so the pool wraps the dialer to follow each connection
+// from dial to close, and counts one as active from the moment a call gets
+// it until the call's response body is read or closed. A body never
+// closed keeps its connection active, so leaks show as a growing active
+// count; a pool too small for the traffic as calls waiting for a
+// connection, and connections dialed and closed instead of reused.
+type clientPool struct {
+	transport *http.Transport
+
+	mu    sync.Mutex
+	hosts map[string]*hostConns
+
+	acquired    metric.Int64Counter
+	dialFailure metric.Int64Counter
+	wait        metric.Float64Histogram
+}
+
+// hostConns counts the connections to one server address
+type hostConns struct {
+	open, active int64
+}
+
+func newClientPool(c downstreamConfig) *clientPool {
+	p := &clientPool{hosts: make(map[string]*hostConns)}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	p.transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	p.transport.MaxConnsPerHost = c.MaxConnsPerHost
+	p.transport.IdleConnTimeout = c.IdleConnTimeout
+	p.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host := hostOf(addr)
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			reason := dialFailureReason(err)
+			p.dialFailure.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("server.address", host), attribute.String("reason", reason)))
+			if !prometheusBridge {
+				promClientDialFailures.WithLabelValues(host, reason).Inc()
+			}
+			return nil, err
+		}
+		p.update(host, 1, 0)
+		return &poolConn{Conn: conn, closed: func() { p.update(host, -1, 0) }}, nil
+	}
+	return p
+}
+
+// register creates the pool's instruments and exports the connection
+// counts as http.client.open_connections by http.connection.state over
+// OTLP, and as http_client_connections{state} on /metrics
+func (p *clientPool) register() error {
+	var err error
+	if p.acquired, err = meter.Int64Counter("http_client_connections_acquired_total",
+		metric.WithDescription("Connections taken by downstream calls, by server address and whether they were reused")); err != nil {
+		return err
+	}
+	if p.dialFailure, err = meter.Int64Counter("http_client_dial_failures_total",
+		metric.WithDescription("Failed dials of the downstream HTTP client by server address and reason: timeout, refused, dns, canceled or other")); err != nil {
+		return err
+	}
+	if p.wait, err = meter.Float64Histogram("http_client_connection_wait_seconds",
+		metric.WithDescription("Time downstream calls waited for a connection in seconds, by server address"),
+		metric.WithUnit("s")); err != nil {
+		return err
+	}
+	open, err := meter.Int64ObservableUpDownCounter("http.client.open_connections",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Open connections of the downstream HTTP client by state and server address"))
+	if err != nil {
+		return err
+	}
+	if _, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for host, h := range p.hosts {
+			address := attribute.String("server.address", host)
+			o.ObserveInt64(open, h.active, metric.WithAttributes(address, attribute.String("http.connection.state", "active")))
+			o.ObserveInt64(open, max(h.open-h.active, 0), metric.WithAttributes(address, attribute.String("http.connection.state", "idle")))
+		}
+		return nil
+	}, open); err != nil {
+		return err
+	}
+
+	if !prometheusBridge {
+		promClientConnectionWait = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_client_connection_wait_seconds",
+				Help:    "Time downstream calls waited for a connection in seconds",
+				Buckets: histogramBuckets["http_client_connection_wait_seconds"],
+			},
+			[]string{"server_address"},
+		)
+		promRegistry.MustRegister(promClientConnections, promClientConnectionsAcquired,
+			promClientDialFailures, promClientConnectionWait)
+	}
+	return nil
+}
+
+// update adds to the open and active connections of host
+func (p *clientPool) update(host string, open, active int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.hosts[host]
+	if !ok {
+		h = &hostConns{}
+		p.hosts[host] = h
+	}
+	h.open += open
+	h.active += active
+	if !prometheusBridge {
+		promClientConnections.WithLabelValues("active", host).Set(float64(h.active))
+		promClientConnections.WithLabelValues("idle", host).Set(float64(max(h.open-h.active, 0)))
+	}
+}
+
+func (p *clientPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Hostname()
+	var (
+		getConn time.Time
+		got     atomic.Bool
+		release sync.Once
+	)
+	done := func() {
+		if got.Load() {
+			release.Do(func() { p.update(host, 0, -1) })
+		}
+	}
+	// The hooks are merged with those of the network phases, if any
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { getConn = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			got.Store(true)
+			p.update(host, 0, 1)
+			reused := strconv.FormatBool(info.Reused)
+			p.acquired.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("server.address", host), attribute.String("reused", reused)))
+			wait := time.Since(getConn).Seconds()
+			p.wait.Record(ctx, wait, metric.WithAttributes(attribute.String("server.address", host)))
+			if !prometheusBridge {
+				promClientConnectionsAcquired.WithLabelValues(host, reused).Inc()
+				promClientConnectionWait.WithLabelValues(host).Observe(wait)
+			}
+		},
+	}))
+
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: done}
+	return resp, nil
+}
+
+// releaseBody gives the connection of a call back to the pool's idle
+// count once the body is read to the end or closed, which is when the
+// transport puts the connection back in its pool or closes it
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
+
+// poolConn reports when the transport closes one of its connections
+type poolConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// dialFailureReason sorts a dial error into a few bounded reasons
+func dialFailureReason(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "other"
+}
+
+// hostOf returns the host of a host:port address
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
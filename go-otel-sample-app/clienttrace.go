@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How the network phases of outbound calls are recorded in the trace
+const (
+	// clientTraceSpans records each phase as a child span of the client span
+	clientTraceSpans = "spans"
+	// clientTraceEvents records each phase as an event on the client span,
+	// for backends that bill per span
+	clientTraceEvents = "events"
+	// clientTraceOff leaves the phases out of the trace and the metrics
+	clientTraceOff = "off"
+)
+
+// Network phases of an outbound call, the phase label of
+// http_client_phase_duration_seconds
+const (
+	phaseDNS     = "dns"
+	phaseConnect = "connect"
+	phaseTLS     = "tls"
+)
+
+var (
+	clientPhaseDuration metric.Float64Histogram
+
+	// promClientPhases is created by registerClientTraceMetrics once the
+	// configured bucket boundaries are known
+	promClientPhases *prometheus.HistogramVec
+)
+
+func registerClientTraceMetrics() error {
+	var err error
+	if clientPhaseDuration, err = meter.Float64Histogram("http_client_phase_duration_seconds",
+		metric.WithDescription("Duration of the DNS lookup, TCP connect and TLS handshake of outbound HTTP calls in seconds, by phase, server address and result"),
+		metric.WithUnit("s")); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promClientPhases = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_client_phase_duration_seconds",
+				Help:    "Duration of the DNS lookup, TCP connect and TLS handshake of outbound HTTP calls in seconds",
+				Buckets: histogramBuckets["http_client_phase_duration_seconds"],
+			},
+			[]string{"phase", "server_address", "result"},
+		)
+		promRegistry.MustRegister(promClientPhases)
+	}
+	return nil
+}
+
+// newClientTrace returns the httptrace hooks otelhttp attaches to each
+// outbound request, or nil when the phases are not recorded. Calls on a
+// reused keep-alive connection go through none of the phases; the client
+// span says so in http.connection.reused.
+func newClientTrace(mode string) func(context.Context) *httptrace.ClientTrace {
+	if mode == clientTraceOff {
+		return nil
+	}
+	return func(ctx context.Context) *httptrace.ClientTrace {
+		t := &clientPhaseTracer{ctx: ctx, events: mode == clientTraceEvents, connects: make(map[string]*clientPhase)}
+		return &httptrace.ClientTrace{
+			GetConn:           t.getConn,
+			GotConn:           t.gotConn,
+			DNSStart:          t.dnsStart,
+			DNSDone:           t.dnsDone,
+			ConnectStart:      t.connectStart,
+			ConnectDone:       t.connectDone,
+			TLSHandshakeStart: t.tlsStart,
+			TLSHandshakeDone:  t.tlsDone,
+		}
+	}
+}
+
+// clientPhaseTracer records the phases of one outbound call. The
+// transport calls its hooks from several goroutines: the connects to an
+// IPv4 and an IPv6 address race each other, and a dial carries on after a
+// request that gave up on it.
+type clientPhaseTracer struct {
+	ctx    context.Context
+	events bool
+
+	mu       sync.Mutex
+	host     string
+	dns      *clientPhase
+	connects map[string]*clientPhase
+	tls      *clientPhase
+}
+
+// clientPhase is a phase under way; attrs are those known at its start
+type clientPhase struct {
+	name  string
+	start time.Time
+	attrs []attribute.KeyValue
+	span  trace.Span
+}
+
+func (t *clientPhaseTracer) begin(name string, attrs ...attribute.KeyValue) *clientPhase {
+	p := &clientPhase{name: name, start: time.Now(), attrs: attrs}
+	if !t.events {
+		_, p.span = tracer.Start(t.ctx, "http."+name,
+			trace.WithTimestamp(p.start),
+			trace.WithAttributes(attrs...))
+	}
+	return p
+}
+
+// end records the phase in the trace and the histogram
+func (t *clientPhaseTracer) end(p *clientPhase, err error, attrs ...attribute.KeyValue) {
+	if p == nil {
+		return
+	}
+	duration := time.Since(p.start)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	if p.span != nil {
+		p.span.SetAttributes(attrs...)
+		if err != nil {
+			p.span.RecordError(err)
+			p.span.SetStatus(codes.Error, err.Error())
+		}
+		p.span.End()
+	} else {
+		attrs = append(append(p.attrs, attrs...), attribute.Float64("duration_ms", float64(duration.Microseconds())/1000))
+		if err != nil {
+			attrs = append(attrs, attribute.String("error", err.Error()))
+		}
+		trace.SpanFromContext(t.ctx).AddEvent("http."+p.name, trace.WithAttributes(attrs...))
+	}
+
+	t.mu.Lock()
+	host := t.host
+	t.mu.Unlock()
+	clientPhaseDuration.Record(t.ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("phase", p.name),
+		attribute.String("server.address", host),
+		attribute.String("result", result),
+	))
+	if !prometheusBridge {
+		promClientPhases.WithLabelValues(p.name, host, result).Observe(duration.Seconds())
+	}
+}
+
+func (t *clientPhaseTracer) getConn(hostPort string) {
+	t.mu.Lock()
+	t.host = hostOf(hostPort)
+	t.mu.Unlock()
+}
+
+func (t *clientPhaseTracer) gotConn(info httptrace.GotConnInfo) {
+	attrs := []attribute.KeyValue{
+		attribute.Bool("http.connection.reused", info.Reused),
+		attribute.Bool("http.connection.was_idle", info.WasIdle),
+	}
+	if info.WasIdle {
+		attrs = append(attrs, attribute.Float64("http.connection.idle_time_ms", float64(info.IdleTime.Microseconds())/1000))
+	}
+	trace.SpanFromContext(t.ctx).SetAttributes(attrs...)
+}
+
+func (t *clientPhaseTracer) dnsStart(info httptrace.DNSStartInfo) {
+	p := t.begin(phaseDNS, attribute.String("dns.question.name", info.Host))
+	t.mu.Lock()
+	t.dns = p
+	t.mu.Unlock()
+}
+
+func (t *clientPhaseTracer) dnsDone(info httptrace.DNSDoneInfo) {
+	t.mu.Lock()
+	p := t.dns
+	t.dns = nil
+	t.mu.Unlock()
+	t.end(p, info.Err,
+		attribute.Int("dns.answers", len(info.Addrs)),
+		attribute.Bool("dns.coalesced", info.Coalesced))
+}
+
+func (t *clientPhaseTracer) connectStart(network, addr string) {
+	p := t.begin(phaseConnect, peerAttributes(network, addr)...)
+	t.mu.Lock()
+	t.connects[network+" "+addr] = p
+	t.mu.Unlock()
+}
+
+func (t *clientPhaseTracer) connectDone(network, addr string, err error) {
+	key := network + " " + addr
+	t.mu.Lock()
+	p := t.connects[key]
+	delete(t.connects, key)
+	t.mu.Unlock()
+	t.end(p, err)
+}
+
+func (t *clientPhaseTracer) tlsStart() {
+	p := t.begin(phaseTLS)
+	t.mu.Lock()
+	t.tls = p
+	t.mu.Unlock()
+}
+
+func (t *clientPhaseTracer) tlsDone(state tls.ConnectionState, err error) {
+	t.mu.Lock()
+	p := t.tls
+	t.tls = nil
+	t.mu.Unlock()
+	var attrs []attribute.KeyValue
+	if err == nil {
+		attrs = append(attrs,
+			attribute.String("tls.protocol.version", strings.TrimPrefix(tls.VersionName(state.Version), "TLS ")),
+			attribute.Bool("tls.resumed", state.DidResume),
+			attribute.String("tls.next_protocol", state.NegotiatedProtocol),
+		)
+	}
+	t.end(p, err, attrs...)
+}
+
+// peerAttributes describe the address a connect is made to
+func peerAttributes(network, addr string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("network.transport", network)}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return append(attrs, attribute.String("network.peer.address", host), attribute.String("network.peer.port", port))
+	}
+	return append(attrs, attribute.String("network.peer.address", addr))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Outcomes of the compression of a response, the result label of
+// http_compression_responses_total
+const (
+	compressionCompressed = "compressed"
+	compressionTooSmall   = "too_small"
+	compressionSkipped    = "not_compressible"
+)
+
+// compressionRatioBuckets range from highly repetitive JSON, which gzip
+// shrinks twentyfold, to bodies that do not shrink at all
+var compressionRatioBuckets = []float64{0.05, 0.1, 0.15, 0.2, 0.3, 0.4, 0.5, 0.6, 0.8, 1}
+
+var (
+	compressionResponses metric.Int64Counter
+	compressionRatio     metric.Float64Histogram
+	compressionSaved     metric.Int64Counter
+
+	promCompressionResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_compression_responses_total",
+			Help: "Responses to clients accepting gzip, by endpoint and result",
+		},
+		[]string{"endpoint", "result"},
+	)
+	promCompressionRatio = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_compression_ratio",
+			Help:    "Compressed size over uncompressed size of gzip responses",
+			Buckets: compressionRatioBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	promCompressionSaved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_compression_saved_bytes_total",
+			Help: "Response bytes saved by gzip",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func registerCompressionMetrics() error {
+	var err error
+	if compressionResponses, err = meter.Int64Counter("http_compression_responses_total",
+		metric.WithDescription("Responses to clients accepting gzip, by endpoint and result: compressed, too_small or not_compressible")); err != nil {
+		return err
+	}
+	if compressionRatio, err = meter.Float64Histogram("http_compression_ratio",
+		metric.WithDescription("Compressed size over uncompressed size of gzip responses, by endpoint"),
+		metric.WithExplicitBucketBoundaries(compressionRatioBuckets...)); err != nil {
+		return err
+	}
+	if compressionSaved, err = meter.Int64Counter("http_compression_saved_bytes_total",
+		metric.WithDescription("Response bytes saved by gzip, by endpoint"),
+		metric.WithUnit("By")); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promCompressionResponses, promCompressionRatio, promCompressionSaved)
+	}
+	return nil
+}
+
+// compressionMiddleware gzips the JSON responses of clients that accept
+// it, once the body reaches the configured minimum size: below it, the
+// gzip header and the CPU cost outweigh the savings. Other content types,
+// such as event streams, pass through untouched, as do upgrades. It sits
+// outside the RED metrics and the access log, so their response sizes are
+// the uncompressed ones, and the ratio tells what reached the wire.
+func compressionMiddleware(next http.Handler, c compressionConfig) http.Handler {
+	if !c.Enabled {
+		return next
+	}
+	pool := &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, c.Level)
+		return gz
+	}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: c.MinSize, pool: pool}
+		next.ServeHTTP(gw, r)
+		gw.finish()
+
+		if gw.result == "" {
+			return
+		}
+		route := routeOf(r.Pattern)
+		if gw.result == compressionCompressed && gw.raw > 0 {
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.Bool("http.response.compressed", true),
+				attribute.Float64("http.response.compression_ratio", float64(gw.wire.n)/float64(gw.raw)),
+			)
+		}
+		if route != "" {
+			recordCompression(r.Context(), route, gw)
+		}
+	})
+}
+
+func recordCompression(ctx context.Context, route string, gw *gzipResponseWriter) {
+	endpoint := attribute.String("endpoint", route)
+	compressionResponses.Add(ctx, 1, metric.WithAttributes(endpoint, attribute.String("result", gw.result)))
+	if !prometheusBridge {
+		promCompressionResponses.WithLabelValues(route, gw.result).Inc()
+	}
+	if gw.result != compressionCompressed || gw.raw == 0 {
+		return
+	}
+	ratio := float64(gw.wire.n) / float64(gw.raw)
+	compressionRatio.Record(ctx, ratio, metric.WithAttributes(endpoint))
+	if saved := gw.raw - gw.wire.n; saved > 0 {
+		compressionSaved.Add(ctx, saved, metric.WithAttributes(endpoint))
+		if !prometheusBridge {
+			promCompressionSaved.WithLabelValues(route).Add(float64(saved))
+		}
+	}
+	if !prometheusBridge {
+		promCompressionRatio.WithLabelValues(route).Observe(ratio)
+	}
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+// without refusing it with q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// compressible reports whether a response with these headers is JSON
+// that nothing has encoded yet
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// gzipResponseWriter holds back the status and the first bytes of a
+// response until it knows whether to compress it: when the body is JSON
+// and reaches minSize
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	pool    *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+	// raw counts the bytes the handler wrote, wire the compressed bytes
+	raw    int64
+	wire   countingWriter
+	result string
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints go out at once
+	if g.decided || code < 200 {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		if !g.compressible() {
+			g.passThrough(compressionSkipped)
+		} else {
+			g.buf = append(g.buf, b...)
+			if len(g.buf) >= g.minSize {
+				return len(b), g.compress()
+			}
+			return len(b), nil
+		}
+	}
+	if g.gz != nil {
+		g.raw += int64(len(b))
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// compressible reports whether the response the handler is writing could
+// be compressed, were it large enough
+func (g *gzipResponseWriter) compressible() bool {
+	return g.status != http.StatusNoContent && g.status != http.StatusNotModified && compressible(g.Header())
+}
+
+// compress sends the headers of a gzip response and the held back bytes
+func (g *gzipResponseWriter) compress() error {
+	g.decided, g.result = true, compressionCompressed
+	h := g.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	g.writeStatus()
+
+	g.wire.w = g.ResponseWriter
+	g.gz = g.pool.Get().(*gzip.Writer)
+	g.gz.Reset(&g.wire)
+	g.raw = int64(len(g.buf))
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// passThrough sends the response as the handler wrote it
+func (g *gzipResponseWriter) passThrough(result string) {
+	g.decided, g.result = true, result
+	if result == compressionTooSmall {
+		g.Header().Add("Vary", "Accept-Encoding")
+	}
+	g.writeStatus()
+	if len(g.buf) > 0 {
+		g.ResponseWriter.Write(g.buf)
+		g.buf = nil
+	}
+}
+
+func (g *gzipResponseWriter) writeStatus() {
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+}
+
+// finish sends whatever is held back once the handler returns, and ends
+// the gzip stream
+func (g *gzipResponseWriter) finish() {
+	switch {
+	case g.gz != nil:
+		g.gz.Close()
+		g.gz.Reset(io.Discard)
+		g.pool.Put(g.gz)
+		g.gz = nil
+	case !g.decided && len(g.buf) > 0:
+		g.passThrough(compressionTooSmall)
+	case !g.decided:
+		// No body at all: nothing to count
+		g.decided = true
+		g.writeStatus()
+	}
+}
+
+// Flush sends what is held back, compressed or not, so streaming handlers
+// work through the middleware. A response flushed before it is decided
+// goes out as is: too small if it could have been compressed, not
+// compressible otherwise, as with an event stream flushing its headers.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		result := compressionSkipped
+		if g.compressible() {
+			result = compressionTooSmall
+		}
+		g.passThrough(result)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection or set deadlines
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
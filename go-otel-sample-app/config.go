@@ -0,0 +1,1611 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"go-otel-sample-app/pkg/telemetry"
+)
+
+// config is the application configuration. loadConfig builds it from the
+// defaults, then the YAML file named by CONFIG_FILE (typically a ConfigMap
+// mounted into the pod), then the environment variables, which win so
+// deployments that only set variables keep working. The logging, sampling,
+// chaos and flags sections are reloaded when the file changes; everything
+// else is read once at startup.
+//
+// The OTEL_EXPORTER_*, OTEL_PROPAGATORS and resource variables are not part
+// of it: they configure the OTel SDK the same way as in any other service.
+type config struct {
+	Role        serviceRole             `yaml:"role"`
+	Server      serverConfig            `yaml:"server"`
+	RateLimit   rateLimitConfig         `yaml:"rate_limit"`
+	Concurrency concurrencyConfig       `yaml:"concurrency"`
+	Auth        authConfig              `yaml:"auth"`
+	WebSocket   websocketConfig         `yaml:"websocket"`
+	SSE         sseConfig               `yaml:"sse"`
+	Logging     loggingConfig           `yaml:"logging"`
+	Sampling    samplingConfig          `yaml:"sampling"`
+	Chaos       map[string]chaosRule    `yaml:"chaos"`
+	Flags       map[string]flagRule     `yaml:"flags"`
+	SLO         map[string]sloObjective `yaml:"slo"`
+	Jobs        map[string]jobConfig    `yaml:"jobs"`
+	Metrics     metricsConfig           `yaml:"metrics"`
+	Downstream  downstreamConfig        `yaml:"downstream"`
+	Fanout      fanoutConfig            `yaml:"fanout"`
+	Synthetic   syntheticConfig         `yaml:"synthetic"`
+	Database    databaseConfig          `yaml:"database"`
+	Redis       redisConfig             `yaml:"redis"`
+	DynamoDB    dynamoDBConfig          `yaml:"dynamodb"`
+	AWSDemo     awsDemoConfig           `yaml:"aws_demo"`
+	SQS         sqsConfig               `yaml:"sqs"`
+	SNS         snsConfig               `yaml:"sns"`
+	Tasks       taskPoolConfig          `yaml:"tasks"`
+	AsyncJobs   asyncJobConfig          `yaml:"async_jobs"`
+	Kafka       kafkaConfig             `yaml:"kafka"`
+	Simulation  simulationConfig        `yaml:"simulation"`
+	Profiling   profilingConfig         `yaml:"profiling"`
+	Baggage     baggageConfig           `yaml:"baggage"`
+	Tenancy     tenancyConfig           `yaml:"tenancy"`
+	Redaction   redactionConfig         `yaml:"redaction"`
+	Export      exportConfig            `yaml:"export"`
+}
+
+type serverConfig struct {
+	Port      string `yaml:"port"`
+	GRPCPort  string `yaml:"grpc_port"`
+	PprofPort string `yaml:"pprof_port"`
+	// ReadHeaderTimeout bounds the time a client may take to send the
+	// request headers, ReadTimeout the whole request including the body
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds the time from the end of the request headers to
+	// the end of the response, so it must outlast the slowest handler
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// IdleTimeout is how long a keep-alive connection waits for the next
+	// request; keep it above the load balancer's idle timeout (60s on an
+	// ALB), or the ALB may reuse a connection the app is closing and
+	// answer 502
+	IdleTimeout            time.Duration   `yaml:"idle_timeout"`
+	TLS                    serverTLSConfig `yaml:"tls"`
+	ShutdownReadinessDelay time.Duration   `yaml:"shutdown_readiness_delay"`
+	ShutdownTimeout        time.Duration   `yaml:"shutdown_timeout"`
+	// TelemetryFlushTimeout bounds the final export of telemetry and of
+	// the log output, after the drain
+	TelemetryFlushTimeout time.Duration `yaml:"telemetry_flush_timeout"`
+	// DebugTraceHeader honors X-Debug-Trace: 1, which forces the request
+	// to be sampled and logged at debug level
+	DebugTraceHeader bool `yaml:"debug_trace_header"`
+	// TraceResponse returns the trace ID in the X-Trace-Id and
+	// traceresponse headers and in error bodies
+	TraceResponse bool `yaml:"trace_response"`
+	// Admin moves the operational endpoints to an internal port
+	Admin adminServerConfig `yaml:"admin"`
+	// Compression gzips JSON responses for clients that accept it
+	Compression compressionConfig `yaml:"compression"`
+	// RequestTimeout answers 504 to requests that run out of time
+	RequestTimeout requestTimeoutConfig `yaml:"request_timeout"`
+	// OpenAPI checks requests, and optionally responses, against the spec
+	OpenAPI openAPIConfig `yaml:"openapi"`
+}
+
+// openAPIConfig sets what is checked against api/openapi.yaml. Validating
+// responses costs a copy of every body and only logs, so it is meant for
+// development and tests.
+type openAPIConfig struct {
+	Validate          bool `yaml:"validate"`
+	ValidateResponses bool `yaml:"validate_responses"`
+}
+
+// requestTimeoutConfig sets how long requests may take: Routes by route,
+// Default for the others. 0 sets no timeout.
+type requestTimeoutConfig struct {
+	Default time.Duration            `yaml:"default"`
+	Routes  map[string]time.Duration `yaml:"routes"`
+}
+
+// compressionConfig turns on gzip for JSON responses of at least MinSize
+// bytes, at a compress/gzip Level from -2 (Huffman only) to 9 (best)
+type compressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"min_size"`
+	Level   int  `yaml:"level"`
+}
+
+// adminServerConfig serves /metrics, pprof and the /admin endpoints on a
+// port of their own, with timeouts of their own, when Port is set
+type adminServerConfig struct {
+	Port              string        `yaml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout must outlast the longest CPU profile or execution trace
+	// collected through pprof
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+}
+
+// serverTLSConfig turns on TLS, and with it HTTP/2, for the HTTP server
+type serverTLSConfig struct {
+	// CertFile and KeyFile are PEM files, e.g. from a cert-manager Secret
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// SelfSigned generates a certificate at startup when no files are set
+	SelfSigned bool `yaml:"self_signed"`
+}
+
+type rateLimitConfig struct {
+	// RequestsPerSecond is the sustained rate allowed per client; 0
+	// disables rate limiting
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst is the number of requests a client can make at once
+	Burst int `yaml:"burst"`
+	// Routes are the route prefixes that are limited
+	Routes []string `yaml:"routes"`
+	// TrustForwardedFor identifies clients by the address an ALB or other
+	// proxy appended to X-Forwarded-For rather than the peer address
+	TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+}
+
+// concurrencyConfig caps the requests in flight per route
+type concurrencyConfig struct {
+	// Limits maps routes, as registered on the mux, to the most requests
+	// they serve at once; routes not listed are not limited
+	Limits map[string]int `yaml:"limits"`
+}
+
+type authConfig struct {
+	// APIKeys are the keys accepted in the X-API-Key header
+	APIKeys []string  `yaml:"api_keys"`
+	JWT     jwtConfig `yaml:"jwt"`
+	// Routes are the route prefixes that require authentication
+	Routes []string `yaml:"routes"`
+}
+
+type jwtConfig struct {
+	// Secret verifies HS256, HS384 and HS512 tokens
+	Secret string `yaml:"secret"`
+	// PublicKeyFile is a PEM RSA or ECDSA public key verifying RS*, PS* or
+	// ES* tokens; it takes precedence over Secret
+	PublicKeyFile string `yaml:"public_key_file"`
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+type websocketConfig struct {
+	// PushInterval is how often an event is pushed to each /ws client
+	PushInterval time.Duration `yaml:"push_interval"`
+}
+
+type sseConfig struct {
+	// Interval is how often a stats event is sent on each /events stream
+	Interval time.Duration `yaml:"interval"`
+}
+
+type loggingConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Components overrides the level of the http, background, telemetry
+	// or access logs
+	Components map[string]string `yaml:"components"`
+	// Output is stdout, file or forward; only the levels are reloadable
+	Output   string              `yaml:"output"`
+	File     logFileConfig       `yaml:"file"`
+	Forward  fluentForwardConfig `yaml:"forward"`
+	Sampling logSamplingConfig   `yaml:"sampling"`
+	Access   accessLogConfig     `yaml:"access"`
+	// TraceLinks adds a console link to the trace of error records
+	TraceLinks traceLinkConfig `yaml:"trace_links"`
+}
+
+// traceLinkConfig chooses the console of the trace_link attribute
+type traceLinkConfig struct {
+	// Backend is none, xray, cloudwatch or template
+	Backend string `yaml:"backend"`
+	// Region of the X-Ray and CloudWatch consoles; defaults to AWS_REGION
+	Region string `yaml:"region"`
+	// Template is the link of the template backend, e.g. to Grafana, with
+	// {trace_id} or {xray_trace_id} in place of the trace ID
+	Template string `yaml:"template"`
+}
+
+// accessLogConfig writes one access log record per request
+type accessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SkipRoutes are route prefixes whose requests are not logged
+	SkipRoutes []string `yaml:"skip_routes"`
+}
+
+// logSamplingConfig thins out identical records of the listed components:
+// in every interval the first are logged, then one in thereafter
+type logSamplingConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Components []string      `yaml:"components"`
+	Interval   time.Duration `yaml:"interval"`
+	First      int           `yaml:"first"`
+	Thereafter int           `yaml:"thereafter"`
+}
+
+// logFileConfig is the rotating log file of the file output
+type logFileConfig struct {
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+	MaxAgeDays int    `yaml:"max_age_days"`
+	Compress   bool   `yaml:"compress"`
+}
+
+// fluentForwardConfig is the Fluent Bit or Fluentd in_forward listener of
+// the forward output
+type fluentForwardConfig struct {
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+type samplingConfig struct {
+	// Sampler takes the OTEL_TRACES_SAMPLER names, e.g. parentbased_traceidratio
+	Sampler string `yaml:"sampler"`
+	// Ratio applies to the traceidratio samplers
+	Ratio        float64  `yaml:"ratio"`
+	IgnoreRoutes []string `yaml:"ignore_routes"`
+	// Remote applies to the jaeger_remote samplers
+	Remote remoteSamplingConfig `yaml:"remote"`
+}
+
+// remoteSamplingConfig points the jaeger_remote samplers at a Jaeger remote
+// sampling endpoint, such as the collector's jaegerremotesampling extension
+type remoteSamplingConfig struct {
+	Endpoint        string        `yaml:"endpoint"`
+	PollingInterval time.Duration `yaml:"polling_interval"`
+	// InitialRatio is sampled until the first strategy arrives
+	InitialRatio float64 `yaml:"initial_ratio"`
+}
+
+type metricsConfig struct {
+	PrometheusBridge bool                      `yaml:"prometheus_bridge"`
+	HistogramBuckets map[string][]float64      `yaml:"histogram_buckets"`
+	AttributeLimits  map[string]attributeLimit `yaml:"attribute_limits"`
+	RemoteWrite      remoteWriteConfig         `yaml:"remote_write"`
+}
+
+// attributeLimit caps the attributes of an instrument: only the keys in
+// Allow are kept, all of them when it is empty, and each key keeps at most
+// MaxValues distinct values, without limit when it is 0
+type attributeLimit struct {
+	Allow     []string `yaml:"allow"`
+	MaxValues int      `yaml:"max_values"`
+}
+
+type remoteWriteConfig struct {
+	// URL is the remote write endpoint, e.g. an AMP workspace's
+	// .../api/v1/remote_write; empty disables remote write
+	URL string `yaml:"url"`
+	// Region signs the requests; it defaults to the SDK's region
+	Region   string        `yaml:"region"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type downstreamConfig struct {
+	URLs    []string      `yaml:"urls"`
+	Timeout time.Duration `yaml:"timeout"`
+	// HTTPTrace records the DNS lookup, connect and TLS handshake of each
+	// call as child spans ("spans"), as events on the client span
+	// ("events"), or not at all ("off")
+	HTTPTrace string `yaml:"httptrace"`
+	// MaxIdleConnsPerHost is how many connections to a host are kept for
+	// reuse; Go's default of 2 makes a busy client dial and close
+	// connections instead. MaxConnsPerHost caps the connections to a host,
+	// with calls past it waiting for one; 0 sets no cap.
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	Breaker             breakerConfig `yaml:"breaker"`
+	Retry               retryConfig   `yaml:"retry"`
+}
+
+// retryConfig retries downstream calls that failed with an error, a
+// timeout, or a 502, 503 or 504
+type retryConfig struct {
+	// MaxAttempts counts the first attempt; 1 disables retries
+	MaxAttempts int `yaml:"max_attempts"`
+	// The wait before retry n is InitialBackoff * Multiplier^(n-1), at
+	// most MaxBackoff, and with Jitter a random duration below that
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	Jitter         bool          `yaml:"jitter"`
+}
+
+// breakerConfig sets up a circuit breaker per downstream host
+type breakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Failures is the number of consecutive failed calls that opens the
+	// breaker, failures being errors and 5xx answers
+	Failures int `yaml:"failures"`
+	// OpenTimeout is how long calls are short-circuited before trial calls
+	// are let through
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+	// HalfOpenRequests is the number of trial calls, all of which must
+	// succeed to close the breaker
+	HalfOpenRequests int `yaml:"half_open_requests"`
+}
+
+// fanoutConfig shapes the branches of /api/fanout
+type fanoutConfig struct {
+	// Branches is the number of branches when the request sets no n, and
+	// MaxBranches the most a request may ask for
+	Branches    int `yaml:"branches"`
+	MaxBranches int `yaml:"max_branches"`
+	// Concurrency limits the branches running at once; 0 runs them all
+	// together
+	Concurrency int `yaml:"concurrency"`
+	// BranchErrorRate is the share of simulated branches that fail, which
+	// cancels the branches still running
+	BranchErrorRate float64 `yaml:"branch_error_rate"`
+}
+
+// syntheticConfig bounds what /api/slow and /api/fail can be asked for
+type syntheticConfig struct {
+	// MaxDelay is the longest delay /api/slow accepts
+	MaxDelay time.Duration `yaml:"max_delay"`
+	// FailCodes are the status codes /api/fail may answer with; empty
+	// allows any 4xx or 5xx
+	FailCodes []int `yaml:"fail_codes"`
+	// MaxUploadBytes is the largest payload /api/upload accepts
+	MaxUploadBytes int64 `yaml:"max_upload_bytes"`
+}
+
+type databaseConfig struct {
+	URL          string `yaml:"url"`
+	MaxOpenConns int    `yaml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+}
+
+type redisConfig struct {
+	URL      string        `yaml:"url"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+type dynamoDBConfig struct {
+	Table string `yaml:"table"`
+}
+
+// awsDemoConfig enables /api/aws, which calls AWS with the pod's credentials
+type awsDemoConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// S3Bucket, when set, is listed after the identity check
+	S3Bucket string `yaml:"s3_bucket"`
+}
+
+type sqsConfig struct {
+	QueueURL string `yaml:"queue_url"`
+	Workers  int    `yaml:"workers"`
+}
+
+type snsConfig struct {
+	TopicARN string `yaml:"topic_arn"`
+}
+
+type kafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	GroupID string   `yaml:"group_id"`
+	TLS     bool     `yaml:"tls"`
+}
+
+// taskPoolConfig sizes the in-memory worker pool that processes the
+// simulated tasks of /api and /admin/tasks
+type taskPoolConfig struct {
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+}
+
+// asyncJobConfig bounds the asynchronous jobs of /api/jobs
+type asyncJobConfig struct {
+	// MaxInProgress is the most jobs running at once; more are rejected
+	MaxInProgress int `yaml:"max_in_progress"`
+	// MaxDuration is the longest duration_ms a submission may ask for
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// Retention is how long a finished job can still be polled
+	Retention   time.Duration `yaml:"retention"`
+	FailureRate float64       `yaml:"failure_rate"`
+}
+
+type simulationConfig struct {
+	MemoryLeakMBPerSecond  float64        `yaml:"memory_leak_mb_per_second"`
+	GoroutineLeakPerSecond float64        `yaml:"goroutine_leak_per_second"`
+	Sessions               sessionConfig  `yaml:"sessions"`
+	Scenario               scenarioConfig `yaml:"scenario"`
+}
+
+// sessionConfig drives the synthetic user sessions behind active_users
+type sessionConfig struct {
+	// TargetUsers is the number of users each region settles around; 0
+	// disables the simulation
+	TargetUsers int `yaml:"target_users"`
+	// MeanDuration is the average session length, which sets the login
+	// and logout churn: TargetUsers / MeanDuration logins per second
+	MeanDuration time.Duration `yaml:"mean_duration"`
+	Regions      []string      `yaml:"regions"`
+}
+
+// scenarioConfig drives the traffic scenario engine, which shapes
+// background traffic sent to the app itself and degrades routes during
+// scheduled incidents
+type scenarioConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// BackgroundRPS is the rate of the background traffic at the diurnal
+	// mean; 0 sends none, and the incidents only affect outside traffic
+	BackgroundRPS float64 `yaml:"background_rps"`
+	// Endpoints are "[METHOD] path" entries, as for the load generator
+	Endpoints []string         `yaml:"endpoints"`
+	Diurnal   diurnalConfig    `yaml:"diurnal"`
+	Bursts    burstConfig      `yaml:"bursts"`
+	Incidents []incidentConfig `yaml:"incidents"`
+}
+
+// diurnalConfig is a sine wave on the background traffic, between
+// 1-Amplitude and 1+Amplitude times BackgroundRPS. Peak is the offset of
+// the maximum into each Period, counted from the Unix epoch, so with a 24h
+// period it is the time of day in UTC and every replica peaks together.
+type diurnalConfig struct {
+	Period    time.Duration `yaml:"period"`
+	Amplitude float64       `yaml:"amplitude"`
+	Peak      time.Duration `yaml:"peak"`
+}
+
+// burstConfig multiplies the background traffic by Multiplier for
+// Duration, with bursts Interval apart on average; a zero Interval
+// disables them
+type burstConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Duration   time.Duration `yaml:"duration"`
+	Multiplier float64       `yaml:"multiplier"`
+}
+
+// incidentConfig degrades Route for Duration, starting Start into every
+// Every counted from the Unix epoch: the route fails at least ErrorRate of
+// its requests, with ErrorStatus, and its injected latency is multiplied by
+// LatencyFactor, unless 0
+type incidentConfig struct {
+	Name          string        `yaml:"name"`
+	Route         string        `yaml:"route"`
+	Every         time.Duration `yaml:"every"`
+	Start         time.Duration `yaml:"start"`
+	Duration      time.Duration `yaml:"duration"`
+	ErrorRate     float64       `yaml:"error_rate"`
+	ErrorStatus   int           `yaml:"error_status"`
+	LatencyFactor float64       `yaml:"latency_factor"`
+}
+
+func (l loggingConfig) validate() error {
+	for component, value := range l.Components {
+		if !slices.Contains(logComponentNames, component) {
+			return fmt.Errorf("unknown log component %q, expected one of %v", component, logComponentNames)
+		}
+		if _, err := parseLogLevel(value); err != nil {
+			return fmt.Errorf("log component %s: %w", component, err)
+		}
+	}
+	if s := l.Sampling; s.Enabled {
+		for _, component := range s.Components {
+			if !slices.Contains(logComponentNames, component) {
+				return fmt.Errorf("unknown log sampling component %q, expected one of %v", component, logComponentNames)
+			}
+		}
+		if s.Interval <= 0 || s.First < 0 || s.Thereafter < 1 {
+			return errors.New("log sampling needs a positive interval, first at least 0 and thereafter at least 1")
+		}
+	}
+	switch l.Output {
+	case logOutputStdout:
+	case logOutputFile:
+		if l.File.Path == "" || l.File.MaxSizeMB < 1 || l.File.MaxBackups < 0 || l.File.MaxAgeDays < 0 {
+			return errors.New("log file needs a path and a max_size_mb of at least 1, and max_backups and max_age_days must not be negative")
+		}
+	case logOutputForward:
+		if _, _, err := net.SplitHostPort(l.Forward.Address); err != nil {
+			return fmt.Errorf("invalid log forward address %q: %w", l.Forward.Address, err)
+		}
+		if l.Forward.Tag == "" {
+			return errors.New("log forward tag must be set")
+		}
+	default:
+		return fmt.Errorf("invalid log output %q: expected stdout, file or forward", l.Output)
+	}
+	return l.TraceLinks.validate()
+}
+
+func (t traceLinkConfig) validate() error {
+	switch t.Backend {
+	case traceLinkNone:
+	case traceLinkXRay, traceLinkCloudWatch:
+		if t.region() == "" {
+			return fmt.Errorf("%s trace links need a region: set LOG_TRACE_LINK_REGION or AWS_REGION", t.Backend)
+		}
+	case traceLinkTemplate:
+		if !strings.Contains(t.Template, "{trace_id}") && !strings.Contains(t.Template, "{xray_trace_id}") {
+			return errors.New("template trace links need a template with {trace_id} or {xray_trace_id}")
+		}
+	default:
+		return fmt.Errorf("invalid trace link backend %q, expected one of %v", t.Backend, traceLinkBackends)
+	}
+	return nil
+}
+
+func (s scenarioConfig) validate() error {
+	if s.BackgroundRPS < 0 {
+		return fmt.Errorf("background_rps must not be negative, got %v", s.BackgroundRPS)
+	}
+	if s.BackgroundRPS > 0 {
+		if _, err := parseLoadgenEndpoints(strings.Join(s.Endpoints, ",")); err != nil {
+			return err
+		}
+	}
+	if d := s.Diurnal; d.Period < 0 || d.Amplitude < 0 || d.Amplitude >= 1 {
+		return errors.New("diurnal period must not be negative, and amplitude must be at least 0 and below 1")
+	}
+	if b := s.Bursts; b.Interval < 0 || (b.Interval > 0 && (b.Duration <= 0 || b.Multiplier <= 0)) {
+		return errors.New("bursts need a positive duration and multiplier")
+	}
+	names := make(map[string]bool)
+	for _, incident := range s.Incidents {
+		if incident.Name == "" || names[incident.Name] {
+			return fmt.Errorf("incident names must be set and unique, got %q", incident.Name)
+		}
+		names[incident.Name] = true
+		if err := incident.validate(); err != nil {
+			return fmt.Errorf("incident %s: %w", incident.Name, err)
+		}
+	}
+	return nil
+}
+
+func (i incidentConfig) validate() error {
+	if !strings.HasPrefix(i.Route, "/") {
+		return fmt.Errorf("route %q must start with /", i.Route)
+	}
+	if i.Every <= 0 || i.Duration <= 0 || i.Duration > i.Every {
+		return errors.New("every and duration must be positive, and duration at most every")
+	}
+	if i.Start < 0 || i.Start >= i.Every {
+		return errors.New("start must be between 0 and every")
+	}
+	if i.ErrorRate < 0 || i.ErrorRate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1, got %v", i.ErrorRate)
+	}
+	if i.ErrorStatus != 0 && (i.ErrorStatus < 400 || i.ErrorStatus > 599) {
+		return fmt.Errorf("error_status must be a 4xx or 5xx code, got %d", i.ErrorStatus)
+	}
+	if i.LatencyFactor < 0 {
+		return fmt.Errorf("latency_factor must not be negative, got %v", i.LatencyFactor)
+	}
+	return nil
+}
+
+type profilingConfig struct {
+	ServerAddress        string        `yaml:"server_address"`
+	TenantID             string        `yaml:"tenant_id"`
+	BasicAuthUser        string        `yaml:"basic_auth_user"`
+	BasicAuthPassword    string        `yaml:"basic_auth_password"`
+	UploadRate           time.Duration `yaml:"upload_rate"`
+	MutexProfileFraction int           `yaml:"mutex_profile_fraction"`
+	BlockProfileRate     int           `yaml:"block_profile_rate"`
+}
+
+type baggageConfig struct {
+	// SpanKeys are the baggage members copied onto every span
+	SpanKeys []string `yaml:"span_keys"`
+	// MetricKeys are the baggage members added to the request metrics
+	MetricKeys []string `yaml:"metric_keys"`
+}
+
+// tenancyConfig puts the tenant of each request in the baggage as
+// tenant.id, to be copied onto spans and metrics through the baggage keys
+type tenancyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Header carries the tenant ID of requests without tenant.id in their
+	// baggage
+	Header string `yaml:"header"`
+	// Tenants are the known tenant IDs; any other is recorded as
+	// "unknown". Empty accepts every tenant ID, unbounded in the metrics.
+	Tenants []string `yaml:"tenants"`
+	// RejectUnknown answers 403 to requests of unknown tenants
+	RejectUnknown bool `yaml:"reject_unknown"`
+}
+
+// redactionConfig scrubs personal data and secrets from logs and span
+// attributes before they leave the app
+type redactionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Keys are attribute keys whose values are always redacted, matched
+	// case-insensitively against the whole key or its last dotted part
+	Keys []string `yaml:"keys"`
+	// Patterns are regular expressions whose matches are redacted from
+	// any string value
+	Patterns []string `yaml:"patterns"`
+}
+
+type exportConfig struct {
+	// Signals are the signals recorded through OTel, any of traces, metrics
+	// and logs; the others are left to the no-op providers
+	Signals []string `yaml:"signals"`
+	// Exporter is otlp, stdout, for running without a collector, or none;
+	// OTEL_<SIGNAL>_EXPORTER overrides it per signal
+	Exporter string `yaml:"exporter"`
+	// Retry applies to the OTLP exporters of all three signals
+	Retry otlpRetryConfig `yaml:"retry"`
+	Spool spoolConfig     `yaml:"spool"`
+	// SpanBatch and LogBatch tune the batch processors in front of the span
+	// and log exporters, MetricReader the periodic reads of the metric
+	// exporters
+	SpanBatch    batchConfig  `yaml:"span_batch"`
+	LogBatch     batchConfig  `yaml:"log_batch"`
+	MetricReader readerConfig `yaml:"metric_reader"`
+	SpanLimits   spanLimits   `yaml:"span_limits"`
+	// Targets are further OTLP backends every signal they list is also
+	// exported to
+	Targets []otlpTargetConfig `yaml:"targets"`
+}
+
+// spanLimits bounds what a single span can hold; whatever is beyond is
+// dropped, and longer string values are truncated. A negative value means
+// no limit. It has the fields of sdktrace.SpanLimits, in the same order, so
+// it converts to it directly.
+type spanLimits struct {
+	AttributeValueLengthLimit   int `yaml:"attribute_value_length"`
+	AttributeCountLimit         int `yaml:"attribute_count"`
+	EventCountLimit             int `yaml:"event_count"`
+	LinkCountLimit              int `yaml:"link_count"`
+	AttributePerEventCountLimit int `yaml:"attribute_per_event_count"`
+	AttributePerLinkCountLimit  int `yaml:"attribute_per_link_count"`
+}
+
+// otlpTargetConfig has the fields of telemetry.OTLPTarget, in the same
+// order, so it converts to it directly
+type otlpTargetConfig struct {
+	Name string `yaml:"name"`
+	// Endpoint is the collector's URL, e.g.
+	// http://otel-collector.legacy:4317
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is grpc (the default) or http/protobuf
+	Protocol string            `yaml:"protocol"`
+	Headers  map[string]string `yaml:"headers"`
+	// Signals defaults to traces, metrics and logs
+	Signals []string `yaml:"signals"`
+}
+
+// spoolConfig enables the disk spool of the OTLP exporters
+type spoolConfig struct {
+	// Dir holds the batches the collector could not take; empty disables
+	// the spool
+	Dir       string `yaml:"dir"`
+	MaxSizeMB int    `yaml:"max_size_mb"`
+}
+
+// batchConfig has the fields of telemetry.BatchConfig, in the same order,
+// so it converts to it directly
+type batchConfig struct {
+	// MaxQueueSize is the number of items buffered for export; more are
+	// dropped
+	MaxQueueSize       int `yaml:"max_queue_size"`
+	MaxExportBatchSize int `yaml:"max_export_batch_size"`
+	// ScheduleDelay is the longest an item waits in the queue before its
+	// batch is exported
+	ScheduleDelay time.Duration `yaml:"schedule_delay"`
+	ExportTimeout time.Duration `yaml:"export_timeout"`
+}
+
+// readerConfig has the fields of telemetry.ReaderConfig, in the same order,
+// so it converts to it directly
+type readerConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// otlpRetryConfig has the fields of telemetry.RetryConfig, in the same
+// order, so it converts to it directly
+type otlpRetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// InitialInterval is the wait after the first failed export; it
+	// doubles, with jitter, up to MaxInterval
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	// MaxElapsedTime bounds the retries of one batch, after which it is
+	// dropped
+	MaxElapsedTime time.Duration `yaml:"max_elapsed_time"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Role: roleAll,
+		Server: serverConfig{
+			Port:                   "8080",
+			GRPCPort:               "9090",
+			PprofPort:              "6060",
+			ReadHeaderTimeout:      5 * time.Second,
+			ReadTimeout:            30 * time.Second,
+			WriteTimeout:           60 * time.Second,
+			IdleTimeout:            120 * time.Second,
+			ShutdownReadinessDelay: 5 * time.Second,
+			ShutdownTimeout:        20 * time.Second,
+			TelemetryFlushTimeout:  5 * time.Second,
+			DebugTraceHeader:       true,
+			TraceResponse:          true,
+			Admin: adminServerConfig{
+				ReadHeaderTimeout: 5 * time.Second,
+				ReadTimeout:       10 * time.Second,
+				WriteTimeout:      90 * time.Second,
+				IdleTimeout:       120 * time.Second,
+			},
+			Compression: compressionConfig{
+				MinSize: 1024,
+				Level:   gzip.DefaultCompression,
+			},
+			RequestTimeout: requestTimeoutConfig{Routes: map[string]time.Duration{}},
+			OpenAPI:        openAPIConfig{Validate: true},
+		},
+		Concurrency: concurrencyConfig{Limits: map[string]int{}},
+		RateLimit: rateLimitConfig{
+			Burst:  20,
+			Routes: []string{"/api"},
+		},
+		Auth:      authConfig{Routes: []string{"/api"}},
+		WebSocket: websocketConfig{PushInterval: 5 * time.Second},
+		SSE:       sseConfig{Interval: 5 * time.Second},
+		Logging: loggingConfig{
+			Level:  "info",
+			Output: logOutputStdout,
+			File: logFileConfig{
+				Path:       "/var/log/app/app.log",
+				MaxSizeMB:  100,
+				MaxBackups: 3,
+				MaxAgeDays: 7,
+			},
+			Forward: fluentForwardConfig{
+				Address: "127.0.0.1:24224",
+				Tag:     "go-otel-sample-app",
+			},
+			Sampling: logSamplingConfig{
+				Components: []string{logComponentHTTP, logComponentBackground},
+				Interval:   time.Second,
+				First:      10,
+				Thereafter: 100,
+			},
+			Access: accessLogConfig{
+				Enabled:    true,
+				SkipRoutes: []string{"/livez", "/readyz"},
+			},
+			TraceLinks: traceLinkConfig{Backend: traceLinkNone},
+		},
+		Sampling: samplingConfig{
+			Sampler: "parentbased_always_on",
+			Ratio:   1,
+			Remote: remoteSamplingConfig{
+				Endpoint:        "http://localhost:5778/sampling",
+				PollingInterval: time.Minute,
+				InitialRatio:    0.001,
+			},
+		},
+		Metrics: metricsConfig{
+			HistogramBuckets: defaultHistogramBuckets(),
+			AttributeLimits:  defaultAttributeLimits(),
+			RemoteWrite:      remoteWriteConfig{Interval: 30 * time.Second},
+		},
+		Downstream: downstreamConfig{
+			Timeout:             2 * time.Second,
+			HTTPTrace:           clientTraceSpans,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			Breaker: breakerConfig{
+				Enabled:          true,
+				Failures:         5,
+				OpenTimeout:      10 * time.Second,
+				HalfOpenRequests: 1,
+			},
+			Retry: retryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     2 * time.Second,
+				Multiplier:     2,
+				Jitter:         true,
+			},
+		},
+		Fanout: fanoutConfig{
+			Branches:        5,
+			MaxBranches:     32,
+			BranchErrorRate: 0.02,
+		},
+		Synthetic: syntheticConfig{
+			MaxDelay:       10 * time.Second,
+			MaxUploadBytes: 10 << 20,
+		},
+		Database: databaseConfig{
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+		},
+		Redis: redisConfig{CacheTTL: 30 * time.Second},
+		SQS:   sqsConfig{Workers: 4},
+		Tasks: taskPoolConfig{Workers: 4, QueueSize: 100},
+		AsyncJobs: asyncJobConfig{
+			MaxInProgress: 50,
+			MaxDuration:   5 * time.Minute,
+			Retention:     time.Hour,
+			FailureRate:   0.05,
+		},
+		Kafka: kafkaConfig{
+			Topic:   "go-otel-requests",
+			GroupID: "go-otel-sample-app",
+		},
+		Baggage: baggageConfig{
+			SpanKeys:   []string{"user.tier", "session.id", tenantKey},
+			MetricKeys: []string{"user.tier", tenantKey},
+		},
+		Tenancy: tenancyConfig{
+			Enabled: true,
+			Header:  "X-Tenant-Id",
+			Tenants: []string{"acme", "globex", "initech"},
+		},
+		Redaction: redactionConfig{
+			Keys: []string{"password", "secret", "token", "api_key", "authorization", "cookie", "email"},
+			Patterns: []string{
+				// Email addresses
+				`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+				// Bearer tokens and JWTs
+				`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`,
+				`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+				// AWS access key IDs
+				`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+			},
+		},
+		Simulation: simulationConfig{
+			Sessions: sessionConfig{
+				TargetUsers:  100,
+				MeanDuration: 10 * time.Minute,
+				Regions:      []string{"us-west-2"},
+			},
+			Scenario: scenarioConfig{
+				BackgroundRPS: 5,
+				Endpoints:     []string{"GET /api", "GET /api/orders", "POST /api/orders"},
+				Diurnal: diurnalConfig{
+					Period:    24 * time.Hour,
+					Amplitude: 0.6,
+					Peak:      14 * time.Hour,
+				},
+				Bursts: burstConfig{
+					Interval:   time.Hour,
+					Duration:   2 * time.Minute,
+					Multiplier: 3,
+				},
+				Incidents: []incidentConfig{{
+					Name:          "api-degradation",
+					Route:         "/api",
+					Every:         3 * time.Hour,
+					Start:         time.Hour,
+					Duration:      10 * time.Minute,
+					ErrorRate:     0.3,
+					ErrorStatus:   http.StatusServiceUnavailable,
+					LatencyFactor: 4,
+				}},
+			},
+		},
+		Profiling: profilingConfig{
+			UploadRate:           15 * time.Second,
+			MutexProfileFraction: 5,
+			BlockProfileRate:     10000,
+		},
+		// The OTLP exporters' own defaults
+		Export: exportConfig{
+			Signals:  []string{"traces", "metrics", "logs"},
+			Exporter: telemetry.ExporterOTLP,
+			Retry:    otlpRetryConfig(telemetry.DefaultRetryConfig),
+			Spool:    spoolConfig{MaxSizeMB: 256},
+			// The SDK's defaults
+			SpanBatch: batchConfig{
+				MaxQueueSize:       2048,
+				MaxExportBatchSize: 512,
+				ScheduleDelay:      5 * time.Second,
+				ExportTimeout:      30 * time.Second,
+			},
+			LogBatch: batchConfig{
+				MaxQueueSize:       2048,
+				MaxExportBatchSize: 512,
+				ScheduleDelay:      time.Second,
+				ExportTimeout:      30 * time.Second,
+			},
+			MetricReader: readerConfig{Interval: time.Minute, Timeout: 30 * time.Second},
+			// The SDK's defaults, with no limit on the length of values
+			SpanLimits: spanLimits{
+				AttributeValueLengthLimit:   -1,
+				AttributeCountLimit:         128,
+				EventCountLimit:             128,
+				LinkCountLimit:              128,
+				AttributePerEventCountLimit: 128,
+				AttributePerLinkCountLimit:  128,
+			},
+		},
+	}
+}
+
+// loadConfig reads path, when set, on top of the defaults and applies the
+// environment variables
+func loadConfig(path string) (*config, error) {
+	c := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		// Reject misspelled keys instead of silently ignoring them
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	// Chaos rules from the file replace the defaults rather than being
+	// merged with them, so a route can be left out to disable its faults
+	if c.Chaos == nil {
+		c.Chaos = defaultChaosRules()
+	}
+	if c.Flags == nil {
+		c.Flags = defaultFlagRules()
+	}
+	if c.SLO == nil {
+		c.SLO = defaultSLOs()
+	}
+	if c.Jobs == nil {
+		c.Jobs = defaultJobs()
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) applyEnv() error {
+	c.Role = serviceRole(getEnv("SERVICE_ROLE", string(c.Role)))
+	c.Server.Port = getEnv("PORT", c.Server.Port)
+	c.Server.GRPCPort = getEnv("GRPC_PORT", c.Server.GRPCPort)
+	c.Server.PprofPort = getEnv("PPROF_PORT", c.Server.PprofPort)
+	c.Server.ReadHeaderTimeout = getEnvDuration("HTTP_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout)
+	c.Server.ReadTimeout = getEnvDuration("HTTP_READ_TIMEOUT", c.Server.ReadTimeout)
+	c.Server.WriteTimeout = getEnvDuration("HTTP_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	c.Server.IdleTimeout = getEnvDuration("HTTP_IDLE_TIMEOUT", c.Server.IdleTimeout)
+	c.Server.TLS.CertFile = getEnv("TLS_CERT_FILE", c.Server.TLS.CertFile)
+	c.Server.TLS.KeyFile = getEnv("TLS_KEY_FILE", c.Server.TLS.KeyFile)
+	c.Server.TLS.SelfSigned = getEnvBool("TLS_SELF_SIGNED", c.Server.TLS.SelfSigned)
+	c.WebSocket.PushInterval = getEnvDuration("WS_PUSH_INTERVAL", c.WebSocket.PushInterval)
+	c.SSE.Interval = getEnvDuration("SSE_INTERVAL", c.SSE.Interval)
+	c.Server.ShutdownReadinessDelay = getEnvDuration("SHUTDOWN_READINESS_DELAY", c.Server.ShutdownReadinessDelay)
+	c.Server.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+	c.Server.TelemetryFlushTimeout = getEnvDuration("TELEMETRY_FLUSH_TIMEOUT", c.Server.TelemetryFlushTimeout)
+	c.Server.DebugTraceHeader = getEnvBool("DEBUG_TRACE_HEADER", c.Server.DebugTraceHeader)
+	c.Server.TraceResponse = getEnvBool("TRACE_RESPONSE", c.Server.TraceResponse)
+	c.Server.Admin.Port = getEnv("ADMIN_PORT", c.Server.Admin.Port)
+	c.Server.Admin.ReadHeaderTimeout = getEnvDuration("ADMIN_READ_HEADER_TIMEOUT", c.Server.Admin.ReadHeaderTimeout)
+	c.Server.Admin.ReadTimeout = getEnvDuration("ADMIN_READ_TIMEOUT", c.Server.Admin.ReadTimeout)
+	c.Server.Admin.WriteTimeout = getEnvDuration("ADMIN_WRITE_TIMEOUT", c.Server.Admin.WriteTimeout)
+	c.Server.Admin.IdleTimeout = getEnvDuration("ADMIN_IDLE_TIMEOUT", c.Server.Admin.IdleTimeout)
+	c.Server.Compression.Enabled = getEnvBool("HTTP_COMPRESSION_ENABLED", c.Server.Compression.Enabled)
+	c.Server.Compression.MinSize = getEnvInt("HTTP_COMPRESSION_MIN_SIZE", c.Server.Compression.MinSize)
+	c.Server.Compression.Level = getEnvInt("HTTP_COMPRESSION_LEVEL", c.Server.Compression.Level)
+	c.Server.RequestTimeout.Default = getEnvDuration("REQUEST_TIMEOUT", c.Server.RequestTimeout.Default)
+	if err := parseRouteTimeouts(getEnv("REQUEST_TIMEOUTS", ""), c.Server.RequestTimeout.Routes); err != nil {
+		return err
+	}
+	c.Server.OpenAPI.Validate = getEnvBool("OPENAPI_VALIDATE", c.Server.OpenAPI.Validate)
+	c.Server.OpenAPI.ValidateResponses = getEnvBool("OPENAPI_VALIDATE_RESPONSES", c.Server.OpenAPI.ValidateResponses)
+
+	c.RateLimit.RequestsPerSecond = getEnvFloat("RATE_LIMIT_RPS", c.RateLimit.RequestsPerSecond)
+	c.RateLimit.Burst = getEnvInt("RATE_LIMIT_BURST", c.RateLimit.Burst)
+	if routes := getEnv("RATE_LIMIT_ROUTES", ""); routes != "" {
+		c.RateLimit.Routes = splitList(routes)
+	}
+	c.RateLimit.TrustForwardedFor = getEnvBool("RATE_LIMIT_TRUST_FORWARDED_FOR", c.RateLimit.TrustForwardedFor)
+	if err := parseConcurrencyLimits(getEnv("CONCURRENCY_LIMITS", ""), c.Concurrency.Limits); err != nil {
+		return err
+	}
+
+	if keys := getEnv("AUTH_API_KEYS", ""); keys != "" {
+		c.Auth.APIKeys = splitList(keys)
+	}
+	c.Auth.JWT.Secret = getEnv("AUTH_JWT_SECRET", c.Auth.JWT.Secret)
+	c.Auth.JWT.PublicKeyFile = getEnv("AUTH_JWT_PUBLIC_KEY_FILE", c.Auth.JWT.PublicKeyFile)
+	c.Auth.JWT.Issuer = getEnv("AUTH_JWT_ISSUER", c.Auth.JWT.Issuer)
+	c.Auth.JWT.Audience = getEnv("AUTH_JWT_AUDIENCE", c.Auth.JWT.Audience)
+	if routes := getEnv("AUTH_ROUTES", ""); routes != "" {
+		c.Auth.Routes = splitList(routes)
+	}
+
+	c.Logging.Level = getEnv("LOG_LEVEL", c.Logging.Level)
+	for _, component := range logComponentNames {
+		if value := getEnv("LOG_LEVEL_"+strings.ToUpper(component), ""); value != "" {
+			if c.Logging.Components == nil {
+				c.Logging.Components = make(map[string]string)
+			}
+			c.Logging.Components[component] = value
+		}
+	}
+	c.Logging.Sampling.Enabled = getEnvBool("LOG_SAMPLING_ENABLED", c.Logging.Sampling.Enabled)
+	if value := getEnv("LOG_SAMPLING_COMPONENTS", ""); value != "" {
+		c.Logging.Sampling.Components = splitList(value)
+	}
+	c.Logging.Sampling.Interval = getEnvDuration("LOG_SAMPLING_INTERVAL", c.Logging.Sampling.Interval)
+	c.Logging.Sampling.First = getEnvInt("LOG_SAMPLING_FIRST", c.Logging.Sampling.First)
+	c.Logging.Sampling.Thereafter = getEnvInt("LOG_SAMPLING_THEREAFTER", c.Logging.Sampling.Thereafter)
+	c.Logging.Access.Enabled = getEnvBool("ACCESS_LOG_ENABLED", c.Logging.Access.Enabled)
+	if routes := getEnv("ACCESS_LOG_SKIP_ROUTES", ""); routes != "" {
+		c.Logging.Access.SkipRoutes = splitList(routes)
+	}
+	c.Logging.Output = getEnv("LOG_OUTPUT", c.Logging.Output)
+	c.Logging.File.Path = getEnv("LOG_FILE_PATH", c.Logging.File.Path)
+	c.Logging.File.MaxSizeMB = getEnvInt("LOG_FILE_MAX_SIZE_MB", c.Logging.File.MaxSizeMB)
+	c.Logging.File.MaxBackups = getEnvInt("LOG_FILE_MAX_BACKUPS", c.Logging.File.MaxBackups)
+	c.Logging.File.MaxAgeDays = getEnvInt("LOG_FILE_MAX_AGE_DAYS", c.Logging.File.MaxAgeDays)
+	c.Logging.File.Compress = getEnvBool("LOG_FILE_COMPRESS", c.Logging.File.Compress)
+	c.Logging.Forward.Address = getEnv("LOG_FORWARD_ADDRESS", c.Logging.Forward.Address)
+	c.Logging.Forward.Tag = getEnv("LOG_FORWARD_TAG", c.Logging.Forward.Tag)
+	c.Logging.TraceLinks.Backend = getEnv("LOG_TRACE_LINKS", c.Logging.TraceLinks.Backend)
+	c.Logging.TraceLinks.Region = getEnv("LOG_TRACE_LINK_REGION", c.Logging.TraceLinks.Region)
+	c.Logging.TraceLinks.Template = getEnv("LOG_TRACE_LINK_TEMPLATE", c.Logging.TraceLinks.Template)
+
+	c.Sampling.Sampler = strings.ToLower(getEnv("OTEL_TRACES_SAMPLER", c.Sampling.Sampler))
+	if arg := getEnv("OTEL_TRACES_SAMPLER_ARG", ""); arg != "" && strings.HasSuffix(c.Sampling.Sampler, "traceidratio") {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: expected a ratio between 0 and 1", arg)
+		}
+		c.Sampling.Ratio = ratio
+	}
+	if arg := getEnv("OTEL_TRACES_SAMPLER_ARG", ""); arg != "" && strings.HasSuffix(c.Sampling.Sampler, "jaeger_remote") {
+		if err := c.Sampling.Remote.parseArg(arg); err != nil {
+			return fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: %w", arg, err)
+		}
+	}
+	if routes := getEnv("TRACES_SAMPLER_IGNORE_ROUTES", ""); routes != "" {
+		c.Sampling.IgnoreRoutes = splitList(routes)
+	}
+
+	c.Metrics.PrometheusBridge = getEnvBool("METRICS_PROMETHEUS_BRIDGE", c.Metrics.PrometheusBridge)
+	if err := parseHistogramBuckets(getEnv("METRICS_HISTOGRAM_BUCKETS", ""), c.Metrics.HistogramBuckets); err != nil {
+		return err
+	}
+	if err := parseAttributeLimits(getEnv("METRICS_ATTRIBUTE_LIMITS", ""), c.Metrics.AttributeLimits); err != nil {
+		return err
+	}
+	if schedules := getEnv("JOB_SCHEDULES", ""); schedules != "" {
+		if c.Jobs == nil {
+			c.Jobs = defaultJobs()
+		}
+		if err := parseJobSchedules(schedules, c.Jobs); err != nil {
+			return err
+		}
+	}
+	c.Metrics.RemoteWrite.URL = getEnv("METRICS_REMOTE_WRITE_URL", c.Metrics.RemoteWrite.URL)
+	c.Metrics.RemoteWrite.Region = getEnv("METRICS_REMOTE_WRITE_REGION", c.Metrics.RemoteWrite.Region)
+	c.Metrics.RemoteWrite.Interval = getEnvDuration("METRICS_REMOTE_WRITE_INTERVAL", c.Metrics.RemoteWrite.Interval)
+
+	if urls := getEnv("DOWNSTREAM_URLS", ""); urls != "" {
+		c.Downstream.URLs = splitList(urls)
+	}
+	c.Downstream.Timeout = getEnvDuration("DOWNSTREAM_TIMEOUT", c.Downstream.Timeout)
+	c.Downstream.HTTPTrace = getEnv("DOWNSTREAM_HTTPTRACE", c.Downstream.HTTPTrace)
+	c.Downstream.MaxIdleConnsPerHost = getEnvInt("DOWNSTREAM_MAX_IDLE_CONNS_PER_HOST", c.Downstream.MaxIdleConnsPerHost)
+	c.Downstream.MaxConnsPerHost = getEnvInt("DOWNSTREAM_MAX_CONNS_PER_HOST", c.Downstream.MaxConnsPerHost)
+	c.Downstream.IdleConnTimeout = getEnvDuration("DOWNSTREAM_IDLE_CONN_TIMEOUT", c.Downstream.IdleConnTimeout)
+	c.Downstream.Breaker.Enabled = getEnvBool("DOWNSTREAM_BREAKER_ENABLED", c.Downstream.Breaker.Enabled)
+	c.Downstream.Breaker.Failures = getEnvInt("DOWNSTREAM_BREAKER_FAILURES", c.Downstream.Breaker.Failures)
+	c.Downstream.Breaker.OpenTimeout = getEnvDuration("DOWNSTREAM_BREAKER_OPEN_TIMEOUT", c.Downstream.Breaker.OpenTimeout)
+	c.Downstream.Breaker.HalfOpenRequests = getEnvInt("DOWNSTREAM_BREAKER_HALF_OPEN_REQUESTS", c.Downstream.Breaker.HalfOpenRequests)
+	c.Downstream.Retry.MaxAttempts = getEnvInt("DOWNSTREAM_RETRY_MAX_ATTEMPTS", c.Downstream.Retry.MaxAttempts)
+	c.Downstream.Retry.InitialBackoff = getEnvDuration("DOWNSTREAM_RETRY_INITIAL_BACKOFF", c.Downstream.Retry.InitialBackoff)
+	c.Downstream.Retry.MaxBackoff = getEnvDuration("DOWNSTREAM_RETRY_MAX_BACKOFF", c.Downstream.Retry.MaxBackoff)
+	c.Downstream.Retry.Multiplier = getEnvFloat("DOWNSTREAM_RETRY_MULTIPLIER", c.Downstream.Retry.Multiplier)
+	c.Downstream.Retry.Jitter = getEnvBool("DOWNSTREAM_RETRY_JITTER", c.Downstream.Retry.Jitter)
+
+	c.Database.URL = getEnv("DATABASE_URL", c.Database.URL)
+	c.Database.MaxOpenConns = getEnvInt("DATABASE_MAX_OPEN_CONNS", c.Database.MaxOpenConns)
+	c.Database.MaxIdleConns = getEnvInt("DATABASE_MAX_IDLE_CONNS", c.Database.MaxIdleConns)
+
+	c.Redis.URL = getEnv("REDIS_URL", c.Redis.URL)
+	c.Redis.CacheTTL = getEnvDuration("REDIS_CACHE_TTL", c.Redis.CacheTTL)
+
+	c.Fanout.Branches = getEnvInt("FANOUT_BRANCHES", c.Fanout.Branches)
+	c.Fanout.MaxBranches = getEnvInt("FANOUT_MAX_BRANCHES", c.Fanout.MaxBranches)
+	c.Fanout.Concurrency = getEnvInt("FANOUT_CONCURRENCY", c.Fanout.Concurrency)
+	c.Fanout.BranchErrorRate = getEnvFloat("FANOUT_BRANCH_ERROR_RATE", c.Fanout.BranchErrorRate)
+
+	c.Synthetic.MaxDelay = getEnvDuration("SYNTHETIC_MAX_DELAY", c.Synthetic.MaxDelay)
+	c.Synthetic.MaxUploadBytes = int64(getEnvInt("SYNTHETIC_MAX_UPLOAD_BYTES", int(c.Synthetic.MaxUploadBytes)))
+	if value := getEnv("SYNTHETIC_FAIL_CODES", ""); value != "" {
+		c.Synthetic.FailCodes = nil
+		for _, entry := range splitList(value) {
+			code, err := strconv.Atoi(entry)
+			if err != nil {
+				return fmt.Errorf("invalid SYNTHETIC_FAIL_CODES entry %q", entry)
+			}
+			c.Synthetic.FailCodes = append(c.Synthetic.FailCodes, code)
+		}
+	}
+
+	c.DynamoDB.Table = getEnv("DYNAMODB_TABLE", c.DynamoDB.Table)
+	c.AWSDemo.Enabled = getEnvBool("AWS_DEMO_ENABLED", c.AWSDemo.Enabled)
+	c.AWSDemo.S3Bucket = getEnv("AWS_DEMO_S3_BUCKET", c.AWSDemo.S3Bucket)
+
+	c.SQS.QueueURL = getEnv("SQS_QUEUE_URL", c.SQS.QueueURL)
+	c.SQS.Workers = getEnvInt("SQS_WORKERS", c.SQS.Workers)
+	c.SNS.TopicARN = getEnv("SNS_TOPIC_ARN", c.SNS.TopicARN)
+	c.Tasks.Workers = getEnvInt("TASK_WORKERS", c.Tasks.Workers)
+	c.Tasks.QueueSize = getEnvInt("TASK_QUEUE_SIZE", c.Tasks.QueueSize)
+	c.AsyncJobs.MaxInProgress = getEnvInt("ASYNC_JOB_MAX_IN_PROGRESS", c.AsyncJobs.MaxInProgress)
+	c.AsyncJobs.MaxDuration = getEnvDuration("ASYNC_JOB_MAX_DURATION", c.AsyncJobs.MaxDuration)
+	c.AsyncJobs.Retention = getEnvDuration("ASYNC_JOB_RETENTION", c.AsyncJobs.Retention)
+	c.AsyncJobs.FailureRate = getEnvFloat("ASYNC_JOB_FAILURE_RATE", c.AsyncJobs.FailureRate)
+
+	if brokers := getEnv("KAFKA_BROKERS", ""); brokers != "" {
+		c.Kafka.Brokers = splitList(brokers)
+	}
+	c.Kafka.Topic = getEnv("KAFKA_TOPIC", c.Kafka.Topic)
+	c.Kafka.GroupID = getEnv("KAFKA_GROUP_ID", c.Kafka.GroupID)
+	c.Kafka.TLS = getEnvBool("KAFKA_TLS", c.Kafka.TLS)
+
+	c.Simulation.MemoryLeakMBPerSecond = getEnvFloat("MEMORY_LEAK_MB_PER_SECOND", c.Simulation.MemoryLeakMBPerSecond)
+	c.Simulation.GoroutineLeakPerSecond = getEnvFloat("GOROUTINE_LEAK_PER_SECOND", c.Simulation.GoroutineLeakPerSecond)
+	c.Simulation.Sessions.TargetUsers = getEnvInt("SESSION_TARGET_USERS", c.Simulation.Sessions.TargetUsers)
+	c.Simulation.Sessions.MeanDuration = getEnvDuration("SESSION_MEAN_DURATION", c.Simulation.Sessions.MeanDuration)
+	if value := getEnv("SESSION_REGIONS", ""); value != "" {
+		c.Simulation.Sessions.Regions = splitList(value)
+	}
+	c.Simulation.Scenario.Enabled = getEnvBool("SCENARIO_ENABLED", c.Simulation.Scenario.Enabled)
+	c.Simulation.Scenario.BackgroundRPS = getEnvFloat("SCENARIO_BACKGROUND_RPS", c.Simulation.Scenario.BackgroundRPS)
+	c.Simulation.Scenario.Diurnal.Period = getEnvDuration("SCENARIO_DIURNAL_PERIOD", c.Simulation.Scenario.Diurnal.Period)
+	c.Simulation.Scenario.Diurnal.Amplitude = getEnvFloat("SCENARIO_DIURNAL_AMPLITUDE", c.Simulation.Scenario.Diurnal.Amplitude)
+	c.Simulation.Scenario.Bursts.Interval = getEnvDuration("SCENARIO_BURST_INTERVAL", c.Simulation.Scenario.Bursts.Interval)
+
+	if keys := getEnv("BAGGAGE_SPAN_KEYS", ""); keys != "" {
+		c.Baggage.SpanKeys = splitList(keys)
+	}
+	if keys := getEnv("BAGGAGE_METRIC_KEYS", ""); keys != "" {
+		c.Baggage.MetricKeys = splitList(keys)
+	}
+	c.Tenancy.Enabled = getEnvBool("TENANCY_ENABLED", c.Tenancy.Enabled)
+	c.Tenancy.Header = getEnv("TENANCY_HEADER", c.Tenancy.Header)
+	if tenants := getEnv("TENANCY_TENANTS", ""); tenants != "" {
+		c.Tenancy.Tenants = splitList(tenants)
+	}
+	c.Tenancy.RejectUnknown = getEnvBool("TENANCY_REJECT_UNKNOWN", c.Tenancy.RejectUnknown)
+
+	c.Redaction.Enabled = getEnvBool("REDACTION_ENABLED", c.Redaction.Enabled)
+	if keys := getEnv("REDACTION_KEYS", ""); keys != "" {
+		c.Redaction.Keys = splitList(keys)
+	}
+
+	c.Profiling.ServerAddress = getEnv("PYROSCOPE_SERVER_ADDRESS", c.Profiling.ServerAddress)
+	c.Profiling.TenantID = getEnv("PYROSCOPE_TENANT_ID", c.Profiling.TenantID)
+	c.Profiling.BasicAuthUser = getEnv("PYROSCOPE_BASIC_AUTH_USER", c.Profiling.BasicAuthUser)
+	c.Profiling.BasicAuthPassword = getEnv("PYROSCOPE_BASIC_AUTH_PASSWORD", c.Profiling.BasicAuthPassword)
+	c.Profiling.UploadRate = getEnvDuration("PYROSCOPE_UPLOAD_RATE", c.Profiling.UploadRate)
+	c.Profiling.MutexProfileFraction = getEnvInt("PROFILING_MUTEX_FRACTION", c.Profiling.MutexProfileFraction)
+	c.Profiling.BlockProfileRate = getEnvInt("PROFILING_BLOCK_RATE", c.Profiling.BlockProfileRate)
+
+	if value := getEnv("FEATURE_FLAGS", ""); value != "" {
+		// Entries override single flags of the file or the defaults
+		if c.Flags == nil {
+			c.Flags = defaultFlagRules()
+		}
+		if err := parseFlagRules(value, c.Flags); err != nil {
+			return err
+		}
+	}
+
+	// TELEMETRY_SIGNALS=none leaves all three to the no-op providers, like
+	// OTEL_SDK_DISABLED=true
+	if value := getEnv("TELEMETRY_SIGNALS", ""); value == "none" {
+		c.Export.Signals = []string{}
+	} else if value != "" {
+		c.Export.Signals = splitList(value)
+	}
+	c.Export.Exporter = getEnv("TELEMETRY_EXPORTER", c.Export.Exporter)
+	c.Export.Retry.Enabled = getEnvBool("OTLP_RETRY_ENABLED", c.Export.Retry.Enabled)
+	c.Export.Retry.InitialInterval = getEnvDuration("OTLP_RETRY_INITIAL_INTERVAL", c.Export.Retry.InitialInterval)
+	c.Export.Retry.MaxInterval = getEnvDuration("OTLP_RETRY_MAX_INTERVAL", c.Export.Retry.MaxInterval)
+	c.Export.Retry.MaxElapsedTime = getEnvDuration("OTLP_RETRY_MAX_ELAPSED_TIME", c.Export.Retry.MaxElapsedTime)
+	if value := getEnv("OTLP_EXTRA_TARGETS", ""); value != "" {
+		// Entries replace the targets of the file
+		targets, err := parseOTLPTargets(value)
+		if err != nil {
+			return err
+		}
+		c.Export.Targets = targets
+	}
+	c.Export.Spool.Dir = getEnv("OTLP_SPOOL_DIR", c.Export.Spool.Dir)
+	c.Export.Spool.MaxSizeMB = getEnvInt("OTLP_SPOOL_MAX_SIZE_MB", c.Export.Spool.MaxSizeMB)
+	// The SDK's own variables, which would otherwise lose to the file
+	c.Export.SpanBatch.applyEnv("OTEL_BSP_")
+	c.Export.LogBatch.applyEnv("OTEL_BLRP_")
+	c.Export.MetricReader.Interval = getEnvMillis("OTEL_METRIC_EXPORT_INTERVAL", c.Export.MetricReader.Interval)
+	c.Export.MetricReader.Timeout = getEnvMillis("OTEL_METRIC_EXPORT_TIMEOUT", c.Export.MetricReader.Timeout)
+	c.Export.SpanLimits.applyEnv()
+	return nil
+}
+
+func (c *config) validate() error {
+	if err := c.Role.validate(); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(c.Logging.Level); err != nil {
+		return err
+	}
+	if err := c.Logging.validate(); err != nil {
+		return err
+	}
+	if _, err := newSampler(c.Sampling); err != nil {
+		return err
+	}
+	if _, err := newRedactor(c.Redaction); err != nil {
+		return err
+	}
+	if s := c.Server; s.ReadHeaderTimeout < 0 || s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		return errors.New("server timeouts must not be negative")
+	}
+	if c.Server.TelemetryFlushTimeout <= 0 {
+		return errors.New("telemetry flush timeout must be positive")
+	}
+	if a := c.Server.Admin; a.ReadHeaderTimeout < 0 || a.ReadTimeout < 0 || a.WriteTimeout < 0 || a.IdleTimeout < 0 {
+		return errors.New("admin server timeouts must not be negative")
+	}
+	if p := c.Server.Admin.Port; p != "" && (p == c.Server.Port || p == c.Server.GRPCPort) {
+		return fmt.Errorf("admin port %s is already used by the HTTP or gRPC server", p)
+	}
+	if m := c.Downstream.HTTPTrace; m != clientTraceSpans && m != clientTraceEvents && m != clientTraceOff {
+		return fmt.Errorf("invalid downstream httptrace %q, expected spans, events or off", m)
+	}
+	if d := c.Downstream; d.MaxIdleConnsPerHost < 0 || d.MaxConnsPerHost < 0 || d.IdleConnTimeout < 0 {
+		return errors.New("downstream connection pool limits must not be negative")
+	}
+	if b := c.Downstream.Breaker; b.Enabled && (b.Failures <= 0 || b.OpenTimeout <= 0 || b.HalfOpenRequests <= 0) {
+		return errors.New("circuit breaker failures, open timeout and half-open requests must be positive")
+	}
+	if r := c.Downstream.Retry; r.MaxAttempts < 1 || r.InitialBackoff < 0 || r.MaxBackoff < r.InitialBackoff || r.Multiplier < 1 {
+		return errors.New("retry max attempts must be at least 1, multiplier at least 1, and backoffs not negative with the max at least the initial")
+	}
+	if c.Server.RequestTimeout.Default < 0 {
+		return errors.New("request timeout must not be negative")
+	}
+	for route, d := range c.Server.RequestTimeout.Routes {
+		if d < 0 {
+			return fmt.Errorf("request timeout of %s must not be negative, got %s", route, d)
+		}
+	}
+	if c.Server.Compression.MinSize < 0 {
+		return errors.New("compression min size must not be negative")
+	}
+	if l := c.Server.Compression.Level; l < gzip.HuffmanOnly || l > gzip.BestCompression {
+		return fmt.Errorf("compression level %d is not between %d and %d", l, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if t := c.Server.TLS; (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("TLS needs both a certificate and a key file")
+	}
+	if c.WebSocket.PushInterval <= 0 {
+		return errors.New("websocket push_interval must be positive")
+	}
+	if f := c.Fanout; f.Branches < 1 || f.MaxBranches < f.Branches || f.Concurrency < 0 {
+		return errors.New("fanout branches must be at least 1 and at most max_branches, and concurrency must not be negative")
+	}
+	if r := c.Fanout.BranchErrorRate; r < 0 || r > 1 {
+		return fmt.Errorf("fanout branch_error_rate must be between 0 and 1, got %v", r)
+	}
+	if a := c.AsyncJobs; a.MaxInProgress < 1 || a.MaxDuration <= 0 || a.Retention <= 0 {
+		return errors.New("async_jobs max_in_progress must be at least 1, and max_duration and retention positive")
+	}
+	if r := c.AsyncJobs.FailureRate; r < 0 || r > 1 {
+		return fmt.Errorf("async_jobs failure_rate must be between 0 and 1, got %v", r)
+	}
+	if c.Synthetic.MaxDelay <= 0 {
+		return errors.New("synthetic max_delay must be positive")
+	}
+	if c.Synthetic.MaxUploadBytes <= 0 {
+		return errors.New("synthetic max_upload_bytes must be positive")
+	}
+	for _, code := range c.Synthetic.FailCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("synthetic fail_codes must be 4xx or 5xx codes, got %d", code)
+		}
+	}
+	if c.Tasks.Workers < 1 || c.Tasks.QueueSize < 1 {
+		return errors.New("tasks workers and queue_size must be at least 1")
+	}
+	if c.SSE.Interval <= 0 {
+		return errors.New("sse interval must be positive")
+	}
+	for route, limit := range c.Concurrency.Limits {
+		if limit < 1 {
+			return fmt.Errorf("concurrency limit of %s must be at least 1, got %d", route, limit)
+		}
+	}
+	if r := c.RateLimit; r.RequestsPerSecond < 0 || (r.RequestsPerSecond > 0 && r.Burst < 1) {
+		return errors.New("rate limit requests_per_second must not be negative, and burst must be at least 1")
+	}
+	for route, rule := range c.Chaos {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("chaos route %q must start with /", route)
+		}
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("chaos rule for %s: %w", route, err)
+		}
+	}
+	for key, rule := range c.Flags {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("flag %s: %w", key, err)
+		}
+	}
+	for name, objective := range c.SLO {
+		if err := objective.validate(); err != nil {
+			return fmt.Errorf("slo %s: %w", name, err)
+		}
+	}
+	for name, job := range c.Jobs {
+		if _, ok := jobTasks[name]; !ok {
+			return fmt.Errorf("unknown job %q", name)
+		}
+		if err := job.validate(); err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+	}
+	for name, boundaries := range c.Metrics.HistogramBuckets {
+		if err := checkBucketBoundaries(name, boundaries); err != nil {
+			return err
+		}
+	}
+	for name, limit := range c.Metrics.AttributeLimits {
+		if limit.MaxValues < 0 {
+			return fmt.Errorf("attribute max_values for %s must not be negative", name)
+		}
+	}
+	if c.Metrics.RemoteWrite.URL != "" && c.Metrics.RemoteWrite.Interval <= 0 {
+		return errors.New("remote write interval must be positive")
+	}
+	if c.Tenancy.Enabled && c.Tenancy.Header == "" {
+		return errors.New("tenancy needs a tenant header")
+	}
+	if s := c.Simulation.Sessions; s.TargetUsers > 0 && (s.MeanDuration <= 0 || len(s.Regions) == 0) {
+		return errors.New("simulated sessions need a positive mean_duration and at least one region")
+	}
+	if s := c.Simulation.Scenario; s.Enabled {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("scenario: %w", err)
+		}
+	}
+	for _, signal := range c.Export.Signals {
+		switch signal {
+		case "traces", "metrics", "logs":
+		default:
+			return fmt.Errorf("unknown telemetry signal %q: expected traces, metrics or logs", signal)
+		}
+	}
+	switch c.Export.Exporter {
+	case telemetry.ExporterOTLP, "stdout", telemetry.ExporterConsole, telemetry.ExporterNone:
+	default:
+		return fmt.Errorf("unknown telemetry exporter %q: expected otlp, stdout or none", c.Export.Exporter)
+	}
+	if r := c.Export.Retry; r.Enabled {
+		if r.InitialInterval <= 0 || r.MaxInterval < r.InitialInterval || r.MaxElapsedTime <= 0 {
+			return errors.New("export retry intervals must be positive, with max_interval at least initial_interval")
+		}
+	}
+	if c.Export.Spool.Dir != "" && c.Export.Spool.MaxSizeMB <= 0 {
+		return errors.New("export spool max_size_mb must be positive")
+	}
+	for name, b := range map[string]batchConfig{"span_batch": c.Export.SpanBatch, "log_batch": c.Export.LogBatch} {
+		if b.MaxQueueSize <= 0 || b.MaxExportBatchSize <= 0 || b.ScheduleDelay <= 0 || b.ExportTimeout <= 0 {
+			return fmt.Errorf("export %s sizes and durations must be positive", name)
+		}
+		if b.MaxExportBatchSize > b.MaxQueueSize {
+			return fmt.Errorf("export %s max_export_batch_size must not exceed max_queue_size", name)
+		}
+	}
+	if r := c.Export.MetricReader; r.Interval <= 0 || r.Timeout <= 0 {
+		return errors.New("export metric_reader interval and timeout must be positive")
+	}
+	return nil
+}
+
+// applyEnv reads the OTEL_BSP_* or OTEL_BLRP_* variables named by prefix
+func (b *batchConfig) applyEnv(prefix string) {
+	b.MaxQueueSize = getEnvInt(prefix+"MAX_QUEUE_SIZE", b.MaxQueueSize)
+	b.MaxExportBatchSize = getEnvInt(prefix+"MAX_EXPORT_BATCH_SIZE", b.MaxExportBatchSize)
+	b.ScheduleDelay = getEnvMillis(prefix+"SCHEDULE_DELAY", b.ScheduleDelay)
+	b.ExportTimeout = getEnvMillis(prefix+"EXPORT_TIMEOUT", b.ExportTimeout)
+}
+
+// applyEnv reads the SDK's span limit variables; the OTEL_SPAN_* ones win
+// over the general OTEL_ATTRIBUTE_* ones
+func (l *spanLimits) applyEnv() {
+	l.AttributeValueLengthLimit = getEnvInt("OTEL_ATTRIBUTE_VALUE_LENGTH_LIMIT", l.AttributeValueLengthLimit)
+	l.AttributeValueLengthLimit = getEnvInt("OTEL_SPAN_ATTRIBUTE_VALUE_LENGTH_LIMIT", l.AttributeValueLengthLimit)
+	l.AttributeCountLimit = getEnvInt("OTEL_ATTRIBUTE_COUNT_LIMIT", l.AttributeCountLimit)
+	l.AttributeCountLimit = getEnvInt("OTEL_SPAN_ATTRIBUTE_COUNT_LIMIT", l.AttributeCountLimit)
+	l.EventCountLimit = getEnvInt("OTEL_SPAN_EVENT_COUNT_LIMIT", l.EventCountLimit)
+	l.LinkCountLimit = getEnvInt("OTEL_SPAN_LINK_COUNT_LIMIT", l.LinkCountLimit)
+	l.AttributePerEventCountLimit = getEnvInt("OTEL_EVENT_ATTRIBUTE_COUNT_LIMIT", l.AttributePerEventCountLimit)
+	l.AttributePerLinkCountLimit = getEnvInt("OTEL_LINK_ATTRIBUTE_COUNT_LIMIT", l.AttributePerLinkCountLimit)
+}
+
+// parseOTLPTargets parses OTLP_EXTRA_TARGETS entries such as
+// "legacy=http://otel-collector.legacy:4317", which export every signal over
+// gRPC; the config file sets the protocol, headers and signals
+func parseOTLPTargets(value string) ([]otlpTargetConfig, error) {
+	var targets []otlpTargetConfig
+	for _, entry := range splitList(value) {
+		name, endpoint, ok := strings.Cut(entry, "=")
+		name, endpoint = strings.TrimSpace(name), strings.TrimSpace(endpoint)
+		if !ok || name == "" || endpoint == "" {
+			return nil, fmt.Errorf("invalid OTLP_EXTRA_TARGETS entry %q: expected name=endpoint", entry)
+		}
+		targets = append(targets, otlpTargetConfig{Name: name, Endpoint: endpoint})
+	}
+	return targets, nil
+}
+
+// parseLogLevel accepts the slog level names, case-insensitively, and
+// "warning" as written by the stdout handler
+func parseLogLevel(value string) (slog.Level, error) {
+	if strings.EqualFold(value, "warning") {
+		value = "warn"
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", value)
+	}
+	return level, nil
+}
+
+// applyReloadable puts the reloadable sections of c into effect. Sections
+// equal to those of prev are left alone, so for example chaos rules
+// changed through /admin/chaos survive an unrelated log level change.
+func (c *config) applyReloadable(prev *config) {
+	if prev == nil || c.Logging.Level != prev.Logging.Level || !maps.Equal(c.Logging.Components, prev.Logging.Components) {
+		logLevels.replace(c.Logging)
+	}
+	if prev == nil || !reflect.DeepEqual(c.Sampling, prev.Sampling) {
+		sampler, _ := newSampler(c.Sampling)
+		activeSampler.set(sampler)
+	}
+	if prev == nil || !reflect.DeepEqual(c.Chaos, prev.Chaos) {
+		chaos.replace(c.Chaos)
+	}
+	if prev == nil || !reflect.DeepEqual(c.Flags, prev.Flags) {
+		flags.replace(c.Flags)
+	}
+}
+
+// restartRequired reports whether c differs from prev outside the
+// reloadable sections
+func (c *config) restartRequired(prev *config) bool {
+	a, b := *c, *prev
+	a.Logging.Level, b.Logging.Level = "", ""
+	a.Logging.Components, b.Logging.Components = nil, nil
+	a.Sampling, b.Sampling = samplingConfig{}, samplingConfig{}
+	a.Chaos, b.Chaos = nil, nil
+	a.Flags, b.Flags = nil, nil
+	return !reflect.DeepEqual(a, b)
+}
+
+// withReloadable returns a copy of c with the reloadable sections of next
+func (c *config) withReloadable(next *config) *config {
+	merged := *c
+	merged.Logging.Level = next.Logging.Level
+	merged.Logging.Components = next.Logging.Components
+	merged.Sampling = next.Sampling
+	merged.Chaos = next.Chaos
+	merged.Flags = next.Flags
+	return &merged
+}
+
+// watchConfig reloads path whenever it changes until ctx is done. The
+// directory is watched rather than the file: the kubelet updates a mounted
+// ConfigMap by swapping a symlink next to it, which a watch on the file
+// itself would miss.
+func watchConfig(ctx context.Context, path string, current *config) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		// The kubelet and editors produce bursts of events for one update
+		var reload <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				reload = time.After(200 * time.Millisecond)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Config watcher error", "path", path, "error", err)
+			case <-reload:
+				reload = nil
+				current = reloadConfig(path, current)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadConfig loads path and applies it, returning the configuration now
+// in effect. An invalid file keeps the current configuration.
+func reloadConfig(path string, current *config) *config {
+	next, err := loadConfig(path)
+	if err != nil {
+		logger.Error("Config reload failed, keeping the current configuration", "path", path, "error", err)
+		return current
+	}
+	if reflect.DeepEqual(next, current) {
+		return current
+	}
+
+	next.applyReloadable(current)
+	logger.Info("Configuration reloaded",
+		"path", path,
+		"log_level", next.Logging.Level,
+		"sampler", next.Sampling.Sampler,
+		"sampler_ratio", next.Sampling.Ratio,
+		"chaos_routes", len(next.Chaos),
+	)
+	if effective := effectiveConfig.Load(); effective != nil {
+		effectiveConfig.Store(effective.withReloadable(next))
+	}
+	if next.restartRequired(current) {
+		restartPending.Store(true)
+		logger.Warn("Configuration changes outside logging, sampling, chaos and flags take effect after a restart", "path", path)
+	}
+	return next
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
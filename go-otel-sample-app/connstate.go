@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// connTracker follows the connections of the HTTP server through its
+// ConnState hook. Connections piling up in the new state point at slow
+// clients that open a connection and send nothing, or only part of the
+// headers; many active ones at handlers that don't return; and a high rate
+// of accepted connections with few idle ones at clients or a load balancer
+// not reusing connections.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]http.ConnState
+	counts   map[http.ConnState]int64
+	accepted int64
+}
+
+// trackedConnStates are the states a connection can be counted in; closed
+// and hijacked connections are no longer the server's
+var trackedConnStates = []http.ConnState{http.StateNew, http.StateActive, http.StateIdle}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:  make(map[net.Conn]http.ConnState),
+		counts: make(map[http.ConnState]int64),
+	}
+}
+
+// hook is the http.Server ConnState callback
+func (t *connTracker) hook(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.conns[conn]; ok {
+		t.counts[prev]--
+	}
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, conn)
+	default:
+		if state == http.StateNew {
+			t.accepted++
+		}
+		t.conns[conn] = state
+		t.counts[state]++
+	}
+}
+
+func (t *connTracker) snapshot() (counts map[http.ConnState]int64, accepted int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.counts), t.accepted
+}
+
+// register exports the connection counts as http.server.open_connections by
+// http.connection.state and http.server.connections.accepted over OTLP,
+// and as http_server_connections{state} and
+// http_server_connections_accepted_total on /metrics
+func (t *connTracker) register() error {
+	open, err := meter.Int64ObservableUpDownCounter("http.server.open_connections",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Open connections of the HTTP server by state"))
+	if err != nil {
+		return err
+	}
+	accepted, err := meter.Int64ObservableCounter("http.server.connections.accepted",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Connections accepted by the HTTP server"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		counts, total := t.snapshot()
+		for _, state := range trackedConnStates {
+			o.ObserveInt64(open, counts[state], metric.WithAttributes(
+				attribute.String("http.connection.state", state.String())))
+		}
+		o.ObserveInt64(accepted, total)
+		return nil
+	}, open, accepted)
+	if err != nil {
+		return err
+	}
+
+	if !prometheusBridge {
+		for _, state := range trackedConnStates {
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "http_server_connections",
+				Help:        "Open connections of the HTTP server by state",
+				ConstLabels: prometheus.Labels{"state": state.String()},
+			}, func() float64 {
+				counts, _ := t.snapshot()
+				return float64(counts[state])
+			}))
+		}
+		promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "http_server_connections_accepted_total",
+			Help: "Connections accepted by the HTTP server",
+		}, func() float64 {
+			_, total := t.snapshot()
+			return float64(total)
+		}))
+	}
+	return nil
+}
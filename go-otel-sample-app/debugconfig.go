@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// effectiveConfig is the configuration in effect: the one loaded at
+	// startup, with the reloadable sections of the last reload
+	effectiveConfig atomic.Pointer[config]
+	// restartPending is set once a reload changed sections that only take
+	// effect after a restart
+	restartPending atomic.Bool
+)
+
+// dsnPassword matches the password of a key=value connection string, e.g.
+// "host=db user=app password=s3cret"
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// registerConfigDebug serves GET /debug/config on mux, the admin mux: the
+// fully resolved configuration, defaults, file and environment variables
+// merged, as YAML with the keys of the configuration file. Secrets are
+// masked, so it can be shared when troubleshooting a deployment.
+func registerConfigDebug(mux *http.ServeMux, path string, cfg *config) {
+	effectiveConfig.Store(cfg)
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		out, err := yaml.Marshal(struct {
+			ConfigFile     string            `yaml:"config_file"`
+			RestartPending bool              `yaml:"restart_pending"`
+			OTelEnv        map[string]string `yaml:"otel_env"`
+			Config         config            `yaml:"config"`
+		}{
+			ConfigFile:     path,
+			RestartPending: restartPending.Load(),
+			OTelEnv:        otelEnv(),
+			Config:         effectiveConfig.Load().masked(),
+		})
+		if err != nil {
+			writeError(r.Context(), w, http.StatusInternalServerError, "encoding configuration: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(out)
+	})
+}
+
+// masked returns a copy of c with its secrets replaced by redactedValue and
+// the passwords in its URLs masked; c is left as it is
+func (c *config) masked() config {
+	m := *c
+	m.Auth.APIKeys = maskAll(c.Auth.APIKeys)
+	m.Auth.JWT.Secret = maskString(c.Auth.JWT.Secret)
+	m.Profiling.BasicAuthPassword = maskString(c.Profiling.BasicAuthPassword)
+	m.Database.URL = maskURL(c.Database.URL)
+	m.Redis.URL = maskURL(c.Redis.URL)
+	m.Metrics.RemoteWrite.URL = maskURL(c.Metrics.RemoteWrite.URL)
+	m.Downstream.URLs = make([]string, len(c.Downstream.URLs))
+	for i, u := range c.Downstream.URLs {
+		m.Downstream.URLs[i] = maskURL(u)
+	}
+	// Headers of OTLP targets usually carry an API key or a token
+	m.Export.Targets = slices.Clone(c.Export.Targets)
+	for i, target := range m.Export.Targets {
+		m.Export.Targets[i].Endpoint = maskURL(target.Endpoint)
+		m.Export.Targets[i].Headers = maps.Clone(target.Headers)
+		for name := range target.Headers {
+			m.Export.Targets[i].Headers[name] = redactedValue
+		}
+	}
+	return m
+}
+
+func maskString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+func maskAll(values []string) []string {
+	masked := make([]string, len(values))
+	for i := range values {
+		masked[i] = redactedValue
+	}
+	return masked
+}
+
+// maskURL masks the password of a URL, or of a key=value connection string
+func maskURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return dsnPassword.ReplaceAllString(s, "${1}"+redactedValue)
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	// Redacted() would escape the brackets of redactedValue
+	return strings.Replace(u.String(), url.QueryEscape(redactedValue), redactedValue, 1)
+}
+
+// otelEnv returns the OTEL_* variables, which configure the SDK outside
+// the configuration, with the headers masked
+func otelEnv() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, "OTEL_") {
+			continue
+		}
+		if strings.Contains(name, "HEADERS") {
+			value = maskString(value)
+		}
+		env[name] = value
+	}
+	return env
+}
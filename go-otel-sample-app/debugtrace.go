@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// debugTraceHeader asks for a full trace of one request: with the value 1,
+// the request is sampled whatever the sampler says, and its logs are
+// written down to debug level whatever the log levels say
+const debugTraceHeader = "X-Debug-Trace"
+
+type debugTraceKey struct{}
+
+// debugTraceMiddleware marks requests carrying X-Debug-Trace: 1 before the
+// server span starts, so the sampler finds the mark in the parent context.
+// The sampled flag then travels in traceparent, and downstream services
+// keep the trace too. Anyone who can reach the app can force traces, so
+// strip the header at the ingress of public endpoints, or turn it off.
+func debugTraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(debugTraceHeader) == "1" {
+			r = r.WithContext(context.WithValue(r.Context(), debugTraceKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// debugTraceForced reports whether ctx belongs to a request that asked for
+// a debug trace
+func debugTraceForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(debugTraceKey{}).(bool)
+	return forced
+}
+
+// forceSample samples the spans of debug trace requests, tagged with
+// debug.forced so they can be told apart from the sampled ones
+func forceSample(p sdktrace.SamplingParameters) (sdktrace.SamplingResult, bool) {
+	if !debugTraceForced(p.ParentContext) {
+		return sdktrace.SamplingResult{}, false
+	}
+	return sdktrace.SamplingResult{
+		Decision:   sdktrace.RecordAndSample,
+		Attributes: []attribute.KeyValue{attribute.Bool("debug.forced", true)},
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}, true
+}
@@ -10,8 +10,9 @@ echo "Building and pushing Go OTEL sample app..."
 # Login to ECR
 aws ecr get-login-password --region $REGION | docker login --username AWS --password-stdin $ECR_URI
 
-# Build and push
-docker build -t go-otel-sample-app .
+# Build and push, stamping the binary with the commit it was built from
+BUILD_ARGS="--build-arg GIT_COMMIT=$(git rev-parse HEAD 2>/dev/null) --build-arg VERSION=$(git describe --tags --always 2>/dev/null) --build-arg BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+docker build $BUILD_ARGS -t go-otel-sample-app .
 docker tag go-otel-sample-app:latest $ECR_URI:latest
-docker buildx build --platform linux/amd64 --push -t $ECR_URI:latest .
+docker buildx build $BUILD_ARGS --platform linux/amd64 --push -t $ECR_URI:latest .
 echo "Pushing image to ECR..."
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// downstreamURLs are called by /api on every request. Point them at
+	// another deployment of this app (for example its /dependency endpoint)
+	// to get multi-service traces and an X-Ray service map.
+	downstreamURLs []string
+
+	// downstreamClient injects the trace context into outgoing requests and
+	// records a client span for each call
+	downstreamClient = &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+
+	// downstreamPool is the connection pool under downstreamClient
+	downstreamPool *clientPool
+)
+
+// loadDownstreams sets the downstream URLs, the per-call timeout, the
+// connection pool and how the network phases of the calls are traced
+func loadDownstreams(c downstreamConfig) {
+	downstreamURLs = c.URLs
+	downstreamClient.Timeout = c.Timeout
+	downstreamPool = newClientPool(c)
+	downstreamBreakers = newBreakerSet(c.Breaker)
+	downstreamRetry = c.Retry
+	var opts []otelhttp.Option
+	if trace := newClientTrace(c.HTTPTrace); trace != nil {
+		opts = append(opts, otelhttp.WithClientTrace(trace))
+	}
+	downstreamClient.Transport = otelhttp.NewTransport(resendCountTransport{base: downstreamPool}, opts...)
+}
+
+// callDownstreams calls every configured downstream concurrently and
+// returns the failures joined together
+func callDownstreams(ctx context.Context) error {
+	if len(downstreamURLs) == 0 {
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "call_downstreams",
+		trace.WithAttributes(attribute.Int("downstream.count", len(downstreamURLs))))
+	defer span.End()
+
+	errs := make([]error, len(downstreamURLs))
+	var wg sync.WaitGroup
+	for i, url := range downstreamURLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = callDownstream(ctx, url)
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "downstream call failed")
+	}
+	return err
+}
+
+// callDownstream calls url under a call_downstream span, whose children
+// are the client spans of the attempts, each of them first checked by the
+// host's circuit breaker
+func callDownstream(ctx context.Context, url string) error {
+	ctx, span := tracer.Start(ctx, "call_downstream",
+		trace.WithAttributes(attribute.String("downstream.url", url)))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		failSpan(span, err, "invalid downstream URL")
+		return err
+	}
+	// Downstream logs then carry the same request ID
+	if id := requestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	breaker := downstreamBreakers.get(req.URL.Host)
+	err = retryCall(ctx, req.URL.Host, func(ctx context.Context) error {
+		if breaker != nil {
+			if err := breaker.allow(ctx); err != nil {
+				return fmt.Errorf("%s: %w", url, err)
+			}
+		}
+		err := doDownstream(req.WithContext(ctx), url)
+		if breaker != nil {
+			breaker.record(ctx, err)
+		}
+		return err
+	})
+	if err != nil {
+		failSpan(span, err, "downstream call failed")
+	}
+	return err
+}
+
+func doDownstream(req *http.Request, url string) error {
+	resp, err := downstreamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 500 {
+		return &downstreamStatusError{url: url, code: resp.StatusCode, status: resp.Status}
+	}
+	return nil
+}
+
+// dependencyHandler stands in for a backing service. Its default chaos rule
+// adds a short random delay and occasional 503s, so traces through it look
+// like calls to a real dependency.
+func dependencyHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "dependency_request")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status": "ok"}`)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxDrillMinutes       = 240
+	defaultDrillMinutes   = 10
+	defaultDrillErrorRate = 0.3
+	defaultDrillRoute     = "/api"
+	// drillIncidentName is the scenario.incident attribute of the requests
+	// a drill hits
+	drillIncidentName = "alert-drill"
+)
+
+// drill is the alert drill registry behind /admin/drill
+var drill = &alertDrill{}
+
+// alertDrill runs at most one alert drill at a time: an incident started
+// on demand that fails a share of a route's requests for a set time, long
+// enough to fire the error-rate and burn-rate alerts, and then recovers on
+// its own.
+type alertDrill struct {
+	mu     sync.RWMutex
+	active *drillRun
+}
+
+// drillRun is a drill in progress. Its span lasts as long as the drill, so
+// the trace view shows it as a bar to line up with the failing requests.
+type drillRun struct {
+	incident  incidentConfig
+	startedAt time.Time
+	span      trace.Span
+	timer     *time.Timer
+}
+
+// drillStatus is the JSON answer of /admin/drill
+type drillStatus struct {
+	Active    bool    `json:"active"`
+	Route     string  `json:"route,omitempty"`
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	Status    int     `json:"error_status,omitempty"`
+	StartedAt string  `json:"started_at,omitempty"`
+	EndsAt    string  `json:"ends_at,omitempty"`
+	TraceID   string  `json:"trace_id,omitempty"`
+}
+
+func (d *alertDrill) status() drillStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	run := d.active
+	if run == nil {
+		return drillStatus{}
+	}
+	return drillStatus{
+		Active:    true,
+		Route:     run.incident.Route,
+		ErrorRate: run.incident.ErrorRate,
+		Status:    run.incident.ErrorStatus,
+		StartedAt: run.startedAt.Format(time.RFC3339),
+		EndsAt:    run.startedAt.Add(run.incident.Duration).Format(time.RFC3339),
+		TraceID:   run.span.SpanContext().TraceID().String(),
+	}
+}
+
+// start begins a drill of incident unless one is already running
+func (d *alertDrill) start(ctx context.Context, incident incidentConfig) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != nil {
+		return false
+	}
+
+	// The span outlives the request, so it covers the whole drill
+	_, span := tracer.Start(ctx, "alert_drill", trace.WithAttributes(
+		attribute.String("alert_drill.route", incident.Route),
+		attribute.Float64("alert_drill.error_rate", incident.ErrorRate),
+		attribute.Int("alert_drill.error_status", incident.ErrorStatus),
+		attribute.Int64("alert_drill.duration_seconds", int64(incident.Duration.Seconds())),
+	))
+	span.AddEvent("alert_drill.started")
+	run := &drillRun{incident: incident, startedAt: time.Now(), span: span}
+	run.timer = time.AfterFunc(incident.Duration, func() { d.end(run, "expired") })
+	d.active = run
+
+	logger.WarnContext(trace.ContextWithSpan(ctx, span), "Alert drill started",
+		"route", incident.Route,
+		"error_rate", incident.ErrorRate,
+		"error_status", incident.ErrorStatus,
+		"duration", incident.Duration.String(),
+		"ends_at", run.startedAt.Add(incident.Duration).Format(time.RFC3339),
+	)
+	return true
+}
+
+// end stops run, unless another drill has replaced it, and reports whether
+// it did
+func (d *alertDrill) end(run *drillRun, reason string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if run == nil || d.active != run {
+		return false
+	}
+	d.active = nil
+	run.timer.Stop()
+
+	run.span.AddEvent("alert_drill.ended", trace.WithAttributes(attribute.String("alert_drill.reason", reason)))
+	logger.WarnContext(trace.ContextWithSpan(context.Background(), run.span), "Alert drill ended",
+		"route", run.incident.Route,
+		"reason", reason,
+		"elapsed", time.Since(run.startedAt).Round(time.Second).String(),
+	)
+	run.span.End()
+	return true
+}
+
+// cancel ends the drill in progress, if any, for reason
+func (d *alertDrill) cancel(reason string) bool {
+	d.mu.RLock()
+	run := d.active
+	d.mu.RUnlock()
+	return d.end(run, reason)
+}
+
+// adjust applies a drill in progress on route on top of the rule and
+// incident the scenario engine chose, like scenarioEngine.adjust
+func (d *alertDrill) adjust(route string, rule chaosRule, incident string, ok bool) (chaosRule, string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.active == nil || d.active.incident.Route != route {
+		return rule, incident, ok
+	}
+	return d.active.incident.apply(rule), drillIncidentName, true
+}
+
+// registerDrillAdmin serves the alert drill:
+//
+//	GET    /admin/drill   the drill in progress, if any
+//	POST   /admin/drill   start a drill, e.g. ?minutes=15&error_rate=0.3&route=/api
+//	DELETE /admin/drill   end the drill early
+//
+// error_status (default 500) and latency_factor are also accepted. A drill
+// already in progress is answered with 409.
+func registerDrillAdmin(mux *http.ServeMux) error {
+	if _, err := meter.Int64ObservableGauge(
+		"alert_drill_active",
+		metric.WithDescription("Whether an alert drill is in progress"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			if s := drill.status(); s.Active {
+				o.Observe(1, metric.WithAttributes(attribute.String("endpoint", s.Route)))
+			} else {
+				o.Observe(0)
+			}
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+
+	mux.HandleFunc("GET /admin/drill", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, drill.status())
+	})
+	mux.HandleFunc("POST /admin/drill", drillHandler)
+	mux.HandleFunc("DELETE /admin/drill", func(w http.ResponseWriter, r *http.Request) {
+		if !drill.cancel("canceled") {
+			writeError(r.Context(), w, http.StatusNotFound, "no alert drill in progress")
+			return
+		}
+		writeJSON(w, http.StatusOK, drill.status())
+	})
+	return nil
+}
+
+func drillHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	incident := incidentConfig{
+		Name:        drillIncidentName,
+		Route:       defaultDrillRoute,
+		ErrorRate:   defaultDrillErrorRate,
+		ErrorStatus: http.StatusInternalServerError,
+	}
+	minutes := defaultDrillMinutes
+	var err error
+	if value := query.Get("minutes"); value != "" {
+		if minutes, err = strconv.Atoi(value); err != nil || minutes < 1 || minutes > maxDrillMinutes {
+			writeError(r.Context(), w, http.StatusBadRequest, "minutes must be between 1 and "+strconv.Itoa(maxDrillMinutes))
+			return
+		}
+	}
+	if value := query.Get("route"); value != "" {
+		incident.Route = value
+	}
+	if value := query.Get("error_rate"); value != "" {
+		if incident.ErrorRate, err = strconv.ParseFloat(value, 64); err != nil {
+			writeError(r.Context(), w, http.StatusBadRequest, "error_rate must be a number")
+			return
+		}
+	}
+	if value := query.Get("error_status"); value != "" {
+		if incident.ErrorStatus, err = strconv.Atoi(value); err != nil {
+			writeError(r.Context(), w, http.StatusBadRequest, "error_status must be an HTTP status code")
+			return
+		}
+	}
+	if value := query.Get("latency_factor"); value != "" {
+		if incident.LatencyFactor, err = strconv.ParseFloat(value, 64); err != nil {
+			writeError(r.Context(), w, http.StatusBadRequest, "latency_factor must be a number")
+			return
+		}
+	}
+	incident.Duration = time.Duration(minutes) * time.Minute
+	// A drill runs once, which validates as a single period
+	incident.Every = incident.Duration
+	if err := incident.validate(); err != nil {
+		writeError(r.Context(), w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.HasPrefix(incident.Route, "/admin/") {
+		writeError(r.Context(), w, http.StatusBadRequest, "admin routes cannot be drilled")
+		return
+	}
+
+	if !drill.start(r.Context(), incident) {
+		writeError(r.Context(), w, http.StatusConflict, "an alert drill is already in progress")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, drill.status())
+}
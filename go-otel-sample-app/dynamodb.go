@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiRequestTTL bounds how long records written by /api are kept; the table
+// needs TTL enabled on the expires_at attribute
+const apiRequestTTL = 24 * time.Hour
+
+// requestTable records every successful /api request in DynamoDB when
+// DYNAMODB_TABLE is set, and is nil otherwise
+var requestTable *dynamoRequestTable
+
+// dynamoRequestTable writes /api request records to a DynamoDB table whose
+// partition key is the string attribute request_id. Each item also holds
+// the trace ID of the request.
+type dynamoRequestTable struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func newDynamoRequestTable(cfg aws.Config, table string) *dynamoRequestTable {
+	return &dynamoRequestTable{
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+	}
+}
+
+// persistRequest stores the /api request in DynamoDB when a table is
+// configured
+func persistRequest(ctx context.Context, requestID string) error {
+	if requestTable == nil {
+		return nil
+	}
+	return requestTable.put(ctx, requestID, "/api")
+}
+
+func (t *dynamoRequestTable) put(ctx context.Context, requestID, endpoint string) error {
+	now := time.Now()
+	_, err := t.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(t.table),
+		Item: map[string]types.AttributeValue{
+			"request_id": &types.AttributeValueMemberS{Value: requestID},
+			"trace_id":   &types.AttributeValueMemberS{Value: trace.SpanContextFromContext(ctx).TraceID().String()},
+			"endpoint":   &types.AttributeValueMemberS{Value: endpoint},
+			"created_at": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(apiRequestTTL).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+// dynamoDBAttributes adds aws.dynamodb.table_names to DynamoDB spans
+func dynamoDBAttributes(_ context.Context, in middleware.InitializeInput) []attribute.KeyValue {
+	var table *string
+	switch params := in.Parameters.(type) {
+	case *dynamodb.PutItemInput:
+		table = params.TableName
+	case *dynamodb.GetItemInput:
+		table = params.TableName
+	case *dynamodb.QueryInput:
+		table = params.TableName
+	case *dynamodb.DescribeTableInput:
+		table = params.TableName
+	}
+	if table == nil {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.AWSDynamoDBTableNames(*table)}
+}
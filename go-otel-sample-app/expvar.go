@@ -0,0 +1,69 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// Counters published on /debug/vars next to the cmdline and memstats
+// variables of the expvar package. They duplicate a few of the metrics for
+// tools that read expvar, such as expvarmon or the expvar receiver of the
+// OpenTelemetry Collector, without a Prometheus or OTLP client.
+var (
+	// expvarRequests counts the requests to registered routes by route,
+	// expvarResponses by status class
+	expvarRequests  = expvar.NewMap("http_requests")
+	expvarResponses = expvar.NewMap("http_responses")
+	// expvarDownstreamCalls counts the downstream calls by outcome
+	expvarDownstreamCalls = expvar.NewMap("downstream_calls")
+
+	processStart = time.Now()
+
+	publishExpvars sync.Once
+)
+
+// runtimeSamples are the runtime/metrics read for the runtime variable
+var runtimeSamples = []struct{ name, key string }{
+	{"/sched/goroutines:goroutines", "goroutines"},
+	{"/sched/gomaxprocs:threads", "gomaxprocs"},
+	{"/gc/cycles/total:gc-cycles", "gc_cycles"},
+	{"/gc/heap/goal:bytes", "heap_goal_bytes"},
+	{"/gc/heap/live:bytes", "heap_live_bytes"},
+	{"/memory/classes/total:bytes", "memory_total_bytes"},
+}
+
+// runtimeVars returns the key runtime stats. Unlike the memstats variable,
+// reading them does not stop the world.
+func runtimeVars() any {
+	samples := make([]metrics.Sample, len(runtimeSamples))
+	for i, s := range runtimeSamples {
+		samples[i].Name = s.name
+	}
+	metrics.Read(samples)
+
+	vars := map[string]any{
+		"go_version":     runtime.Version(),
+		"num_cpu":        runtime.NumCPU(),
+		"uptime_seconds": time.Since(processStart).Seconds(),
+	}
+	for i, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			vars[runtimeSamples[i].key] = s.Value.Uint64()
+		}
+	}
+	return vars
+}
+
+// registerExpvar adds /debug/vars to mux. The runtime and build variables
+// are published on the first call; expvar panics on a second.
+func registerExpvar(mux *http.ServeMux) {
+	publishExpvars.Do(func() {
+		expvar.Publish("runtime", expvar.Func(runtimeVars))
+		expvar.Publish("build", expvar.Func(func() any { return build }))
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// Branch results of /api/fanout
+const (
+	fanoutSuccess  = "success"
+	fanoutFailure  = "failure"
+	fanoutCanceled = "canceled"
+)
+
+// fanoutBranchLatency is the simulated work of a branch without
+// downstreams, spread wide so every waterfall looks different
+var fanoutBranchLatency = latencyModel{LatencyMs: 5, LatencyJitterMs: 40, Distribution: "lognormal", LatencyShape: 0.8}
+
+var (
+	fanoutBranchDuration metric.Float64Histogram
+
+	// promFanoutBranchDuration is created by registerFanout once the
+	// configured bucket boundaries are known
+	promFanoutBranchDuration *prometheus.HistogramVec
+)
+
+// fanoutBranch is one branch in the answer of /api/fanout
+type fanoutBranch struct {
+	Branch     int    `json:"branch"`
+	Target     string `json:"target"`
+	Result     string `json:"result"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// fanoutAPI serves /api/fanout?n=N, which runs N branches in parallel
+// with errgroup, each in its own child span, for wide trace waterfalls.
+// With downstreams configured each branch calls one of them in turn;
+// otherwise it simulates a sub-task. The first failing branch cancels the
+// others, which end with a canceled span, as a real fan-out would.
+type fanoutAPI struct {
+	cfg fanoutConfig
+}
+
+// registerFanout serves /api/fanout and records the branch latencies in
+// fanout_branch_duration_seconds
+func registerFanout(mux *http.ServeMux, c fanoutConfig) error {
+	var err error
+	fanoutBranchDuration, err = meter.Float64Histogram("fanout_branch_duration_seconds",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of the /api/fanout branches by branch, target and result"))
+	if err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promFanoutBranchDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "fanout_branch_duration_seconds",
+				Help:    "Duration of the /api/fanout branches by branch, target and result",
+				Buckets: histogramBuckets["fanout_branch_duration_seconds"],
+			},
+			[]string{"branch", "target", "result"},
+		)
+		promRegistry.MustRegister(promFanoutBranchDuration)
+	}
+	mux.Handle("GET /api/fanout", fanoutAPI{cfg: c})
+	return nil
+}
+
+func (h fanoutAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "fanout_request")
+	defer span.End()
+	log := requestLogger(r, "/api/fanout")
+
+	n := h.cfg.Branches
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 1 || n > h.cfg.MaxBranches {
+			writeError(ctx, w, http.StatusBadRequest, "n must be between 1 and "+strconv.Itoa(h.cfg.MaxBranches))
+			return
+		}
+	}
+	span.SetAttributes(attribute.Int("fanout.branches", n))
+
+	g, gctx := errgroup.WithContext(ctx)
+	if h.cfg.Concurrency > 0 {
+		g.SetLimit(h.cfg.Concurrency)
+	}
+	branches := make([]fanoutBranch, n)
+	for i := range n {
+		g.Go(func() error {
+			return h.runBranch(gctx, i, &branches[i])
+		})
+	}
+	err := g.Wait()
+
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusBadGateway
+		failSpan(span, err, "fan-out branch failed")
+		log.ErrorContext(ctx, "Fan-out branch failed", "status_code", code, "branches", n, "error", err)
+		writeError(ctx, w, code, "Bad gateway")
+	} else {
+		writeJSON(w, code, map[string]any{
+			"branches":   branches,
+			"request_id": requestIDFromContext(ctx),
+		})
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+}
+
+// runBranch runs branch i and fills in its result
+func (h fanoutAPI) runBranch(ctx context.Context, i int, result *fanoutBranch) error {
+	target := "simulated"
+	if len(downstreamURLs) > 0 {
+		target = downstreamURLs[i%len(downstreamURLs)]
+	}
+	ctx, span := tracer.Start(ctx, "fanout_branch", trace.WithAttributes(
+		attribute.Int("fanout.branch", i),
+		attribute.String("fanout.target", target),
+	))
+	defer span.End()
+
+	start := time.Now()
+	// A branch waiting for a concurrency slot may only start once another
+	// has failed
+	err := ctx.Err()
+	if err == nil && target == "simulated" {
+		err = h.simulateBranch(ctx, i)
+	} else if err == nil {
+		err = callDownstream(ctx, target)
+	}
+	duration := time.Since(start)
+
+	outcome := fanoutSuccess
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Another branch failed first; this one didn't fail by itself
+		outcome = fanoutCanceled
+		span.SetStatus(codes.Error, "canceled")
+	case err != nil:
+		outcome = fanoutFailure
+		failSpan(span, err, "branch failed")
+	}
+	span.SetAttributes(attribute.String("fanout.result", outcome))
+	*result = fanoutBranch{Branch: i, Target: target, Result: outcome, DurationMs: duration.Milliseconds()}
+	recordFanoutBranch(ctx, i, target, outcome, duration)
+	return err
+}
+
+// simulateBranch stands in for a sub-task, failing at the configured rate
+func (h fanoutAPI) simulateBranch(ctx context.Context, i int) error {
+	timer := time.NewTimer(fanoutBranchLatency.sample())
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if rand.Float64() < h.cfg.BranchErrorRate {
+		return fmt.Errorf("branch %d: simulated sub-task failure", i)
+	}
+	return nil
+}
+
+func recordFanoutBranch(ctx context.Context, branch int, target, result string, duration time.Duration) {
+	fanoutBranchDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.Int("branch", branch),
+		attribute.String("target", target),
+		attribute.String("result", result),
+	))
+	if !prometheusBridge {
+		promFanoutBranchDuration.WithLabelValues(strconv.Itoa(branch), target, result).Observe(duration.Seconds())
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Flags gating the fault injection. Turning one off, or rolling it out to
+// a share of sessions, shows in traces and metrics which requests a change
+// of behavior reached.
+const (
+	flagChaosErrors  = "chaos-errors"
+	flagChaosLatency = "chaos-latency"
+)
+
+// flagProviderName is reported as feature_flag.provider_name
+const flagProviderName = "config"
+
+// Evaluation reasons, named as in OpenFeature
+const (
+	flagReasonStatic   = "STATIC"
+	flagReasonSplit    = "SPLIT"
+	flagReasonDisabled = "DISABLED"
+	flagReasonDefault  = "DEFAULT"
+)
+
+// flagRule configures one boolean feature flag. An enabled flag is on for
+// the share of targeting keys given by rollout, between 0 and 1, or for
+// all of them when rollout is omitted.
+type flagRule struct {
+	Enabled bool    `json:"enabled" yaml:"enabled"`
+	Rollout float64 `json:"rollout,omitempty" yaml:"rollout"`
+}
+
+func (r flagRule) validate() error {
+	if r.Rollout < 0 || r.Rollout > 1 {
+		return fmt.Errorf("rollout must be between 0 and 1, got %v", r.Rollout)
+	}
+	return nil
+}
+
+// defaultFlagRules keep fault injection on, as it was before it was gated
+// by flags. The flags section of the config file replaces them.
+func defaultFlagRules() map[string]flagRule {
+	return map[string]flagRule{
+		flagChaosErrors:  {Enabled: true},
+		flagChaosLatency: {Enabled: true},
+	}
+}
+
+// parseFlagRules applies FEATURE_FLAGS entries such as
+// "chaos-errors=off,chaos-latency=0.25" to rules: "on", "off", or the
+// rollout of an enabled flag
+func parseFlagRules(value string, rules map[string]flagRule) error {
+	for _, entry := range splitList(value) {
+		key, setting, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid FEATURE_FLAGS entry %q: expected key=on|off|<rollout>", entry)
+		}
+		switch setting = strings.TrimSpace(setting); setting {
+		case "on":
+			rules[key] = flagRule{Enabled: true}
+		case "off":
+			rules[key] = flagRule{}
+		default:
+			rollout, err := strconv.ParseFloat(setting, 64)
+			if err != nil {
+				return fmt.Errorf("invalid FEATURE_FLAGS entry %q: expected key=on|off|<rollout>", entry)
+			}
+			rules[key] = flagRule{Enabled: true, Rollout: rollout}
+		}
+	}
+	return nil
+}
+
+// flags holds the feature flag rules. They change when the config file is
+// reloaded.
+var flags = &flagRegistry{rules: defaultFlagRules()}
+
+var flagEvaluations metric.Int64Counter
+
+type flagRegistry struct {
+	mu    sync.RWMutex
+	rules map[string]flagRule
+}
+
+func (f *flagRegistry) get(key string) (flagRule, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	rule, ok := f.rules[key]
+	return rule, ok
+}
+
+func (f *flagRegistry) snapshot() map[string]flagRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return maps.Clone(f.rules)
+}
+
+func (f *flagRegistry) replace(rules map[string]flagRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = maps.Clone(rules)
+}
+
+// flagEvaluation holds the details of one evaluation, as OpenFeature
+// reports them
+type flagEvaluation struct {
+	key     string
+	value   bool
+	variant string
+	reason  string
+}
+
+// evaluate resolves key for the request in ctx. Unknown flags get
+// defaultValue.
+func (f *flagRegistry) evaluate(ctx context.Context, key string, defaultValue bool) flagEvaluation {
+	eval := flagEvaluation{key: key}
+	rule, ok := f.get(key)
+	switch {
+	case !ok:
+		eval.value, eval.reason = defaultValue, flagReasonDefault
+	case !rule.Enabled:
+		eval.reason = flagReasonDisabled
+	case rule.Rollout == 0 || rule.Rollout == 1:
+		eval.value, eval.reason = true, flagReasonStatic
+	default:
+		eval.value, eval.reason = rolloutBucket(ctx, key) < rule.Rollout, flagReasonSplit
+	}
+	eval.variant = "off"
+	if eval.value {
+		eval.variant = "on"
+	}
+	return eval
+}
+
+// rolloutBucket maps the targeting key of the request to [0, 1), the same
+// for every evaluation of key. The targeting key is the session.id baggage
+// member, so a session sees one variant throughout, or else the trace ID,
+// so all services in a trace agree.
+func rolloutBucket(ctx context.Context, key string) float64 {
+	target := baggage.FromContext(ctx).Member("session.id").Value()
+	if target == "" {
+		target = trace.SpanContextFromContext(ctx).TraceID().String()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key + "/" + target))
+	return float64(h.Sum64()) / (math.MaxUint64 + 1.0)
+}
+
+// boolFlag evaluates key and records the evaluation as a feature_flag event
+// on the current span and in feature_flag_evaluations_total, so traces show
+// which variant a request got and dashboards show how a rollout progresses
+func boolFlag(ctx context.Context, key string, defaultValue bool) bool {
+	eval := flags.evaluate(ctx, key, defaultValue)
+	trace.SpanFromContext(ctx).AddEvent("feature_flag", trace.WithAttributes(
+		semconv.FeatureFlagKey(eval.key),
+		semconv.FeatureFlagProviderName(flagProviderName),
+		semconv.FeatureFlagVariant(eval.variant),
+		attribute.String("feature_flag.evaluation.reason", eval.reason),
+	))
+	flagEvaluations.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("flag", eval.key),
+		attribute.String("variant", eval.variant),
+		attribute.String("reason", eval.reason),
+	))
+	return eval.value
+}
+
+// registerFlagAdmin creates the evaluation counter and serves the current
+// flag rules on GET /admin/flags
+func registerFlagAdmin(mux *http.ServeMux) error {
+	var err error
+	flagEvaluations, err = meter.Int64Counter(
+		"feature_flag_evaluations_total",
+		metric.WithDescription("Number of feature flag evaluations by flag, variant and reason"),
+	)
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("GET /admin/flags", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, flags.snapshot())
+	})
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	orderv1 "go-otel-sample-app/gen/order/v1"
+)
+
+//go:generate protoc -I proto --go_out=gen --go_opt=paths=source_relative --go-grpc_out=gen --go-grpc_opt=paths=source_relative order/v1/order.proto
+
+// orderServer implements order.v1.OrderService on top of an orderStore
+type orderServer struct {
+	orderv1.UnimplementedOrderServiceServer
+	store orderStore
+}
+
+func (s *orderServer) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.Order, error) {
+	order, err := newOrder(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.store.CreateOrder(ctx, order); err != nil {
+		logger.ErrorContext(ctx, "Failed to store order", "error", err)
+		return nil, status.Error(codes.Internal, "failed to store order")
+	}
+
+	logger.InfoContext(ctx, "Order created",
+		"order_id", order.Id,
+		"customer_id", order.CustomerId,
+		"total_cents", order.TotalCents,
+	)
+	return order, nil
+}
+
+func (s *orderServer) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.Order, error) {
+	order, err := s.store.GetOrder(ctx, req.Id)
+	if errors.Is(err, errOrderNotFound) {
+		return nil, status.Errorf(codes.NotFound, "order %q not found", req.Id)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to load order", "order_id", req.Id, "error", err)
+		return nil, status.Error(codes.Internal, "failed to load order")
+	}
+	return order, nil
+}
+
+func (s *orderServer) ListOrders(ctx context.Context, req *orderv1.ListOrdersRequest) (*orderv1.ListOrdersResponse, error) {
+	pageSize, err := normalizePageSize(int(req.PageSize))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	orders, err := s.store.ListOrders(ctx, pageSize)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to list orders", "error", err)
+		return nil, status.Error(codes.Internal, "failed to list orders")
+	}
+	return &orderv1.ListOrdersResponse{Orders: orders}, nil
+}
+
+// grpcServer serves OrderService, the standard gRPC health service (used by
+// Kubernetes gRPC probes) and server reflection (for grpcurl) on GRPC_PORT
+type grpcServer struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+func newGRPCServer(store orderStore) *grpcServer {
+	// The stats handler creates a server span per RPC, continuing the trace
+	// from the incoming metadata, and records rpc.server.* metrics
+	server := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+
+	orderv1.RegisterOrderServiceServer(server, &orderServer{store: store})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(orderv1.OrderService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return &grpcServer{server: server, health: healthServer}
+}
+
+// serve blocks until the server stops
+func (s *grpcServer) serve(port string) error {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(lis)
+}
+
+// setNotServing makes health checks report NOT_SERVING, the gRPC
+// equivalent of /readyz failing during shutdown
+func (s *grpcServer) setNotServing() {
+	s.health.Shutdown()
+}
+
+// shutdown waits for in-flight RPCs to finish until ctx expires, after
+// which remaining RPCs are cancelled
+func (s *grpcServer) shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
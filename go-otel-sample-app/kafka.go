@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestEvents streams /api requests through Kafka when KAFKA_BROKERS is
+// set, and is nil otherwise
+var requestEvents *kafkaEvents
+
+// kafkaEvents produces request events to a topic and consumes them again in
+// a consumer group, as a separate service would
+type kafkaEvents struct {
+	writer *kafka.Writer
+	reader *kafka.Reader
+	topic  string
+	group  string
+
+	processingDuration metric.Float64Histogram
+
+	// lag holds the latest consumer lag per partition for the
+	// kafka_consumer_lag gauge
+	lagMu sync.Mutex
+	lag   map[int]int64
+
+	done chan struct{}
+}
+
+// requestEvent is the JSON value of the events produced by /api
+type requestEvent struct {
+	RequestID string    `json:"request_id"`
+	Endpoint  string    `json:"endpoint"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newKafkaEvents connects to brokers, e.g. the bootstrap brokers of an
+// Amazon MSK cluster. With useTLS the client talks to the TLS listener
+// (port 9094 on MSK). Without consume it only produces, and doesn't join
+// the consumer group, where it would be assigned partitions it never reads.
+func newKafkaEvents(brokers []string, topic, group string, useTLS, consume bool) (*kafkaEvents, error) {
+	e := &kafkaEvents{
+		topic: topic,
+		group: group,
+		lag:   map[int]int64{},
+		done:  make(chan struct{}),
+	}
+
+	var err error
+	e.processingDuration, err = meter.Float64Histogram(
+		"kafka_message_processing_duration_seconds",
+		metric.WithDescription("Time spent processing a consumed Kafka message"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = meter.Int64ObservableGauge(
+		"kafka_consumer_lag",
+		metric.WithDescription("Messages in the partition not yet consumed by the consumer group"),
+		metric.WithInt64Callback(e.observeLag),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if useTLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	e.writer = &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+		// The request waits for the write, so do not hold messages back
+		// to fill a batch
+		BatchTimeout:           10 * time.Millisecond,
+		RequiredAcks:           kafka.RequireOne,
+		AllowAutoTopicCreation: true,
+		Transport:              &kafka.Transport{TLS: tlsConfig},
+	}
+	if consume {
+		e.reader = kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        brokers,
+			GroupID:        group,
+			Topic:          topic,
+			CommitInterval: time.Second,
+			Dialer:         &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true, TLS: tlsConfig},
+		})
+	}
+	return e, nil
+}
+
+// produceRequest sends an event for the /api request when Kafka is
+// configured
+func produceRequest(ctx context.Context, requestID string) error {
+	if requestEvents == nil {
+		return nil
+	}
+	return requestEvents.produce(ctx, requestEvent{
+		RequestID: requestID,
+		Endpoint:  "/api",
+		CreatedAt: time.Now(),
+	})
+}
+
+// produce writes event under a producer span whose context is carried in
+// the W3C traceparent and tracestate record headers
+func (e *kafkaEvents) produce(ctx context.Context, event requestEvent) error {
+	ctx, span := tracer.Start(ctx, e.topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(e.topic),
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingKafkaMessageKey(event.RequestID),
+		),
+	)
+	defer span.End()
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := kafka.Message{Key: []byte(event.RequestID), Value: value}
+	otel.GetTextMapPropagator().Inject(ctx, (*kafkaHeaderCarrier)(&msg.Headers))
+
+	if err := e.writer.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "produce failed")
+		return err
+	}
+	return nil
+}
+
+// start consumes the topic until ctx is cancelled; wait blocks until the
+// consumer has committed its offsets and both clients are closed
+func (e *kafkaEvents) start(ctx context.Context) {
+	if e.reader == nil {
+		close(e.done)
+		return
+	}
+	go func() {
+		defer close(e.done)
+		e.consume(ctx)
+	}()
+}
+
+func (e *kafkaEvents) wait() error {
+	<-e.done
+	if e.reader == nil {
+		return e.writer.Close()
+	}
+	return errors.Join(e.reader.Close(), e.writer.Close())
+}
+
+func (e *kafkaEvents) consume(ctx context.Context) {
+	for {
+		msg, err := e.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			backgroundLogger.WarnContext(ctx, "Failed to fetch Kafka message", "topic", e.topic, "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		e.process(ctx, msg)
+
+		if err := e.reader.CommitMessages(context.WithoutCancel(ctx), msg); err != nil {
+			backgroundLogger.WarnContext(ctx, "Failed to commit Kafka offset",
+				"topic", e.topic,
+				"partition", msg.Partition,
+				"offset", msg.Offset,
+				"error", err,
+			)
+		}
+	}
+}
+
+// process handles one message in a consumer span that continues the trace
+// of the request that produced it. Unlike SQS batches, each Kafka message
+// is processed on its own, so the consumer span is a child of the producer
+// span and the whole flow shows up as one trace.
+func (e *kafkaEvents) process(ctx context.Context, msg kafka.Message) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, (*kafkaHeaderCarrier)(&msg.Headers))
+	ctx, span := tracer.Start(ctx, e.topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(e.topic),
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingKafkaConsumerGroup(e.group),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key)),
+		),
+	)
+	defer span.End()
+
+	// HighWaterMark is the offset of the next message to be written, so
+	// everything after this message is still waiting to be consumed
+	e.lagMu.Lock()
+	e.lag[msg.Partition] = max(msg.HighWaterMark-msg.Offset-1, 0)
+	e.lagMu.Unlock()
+
+	start := time.Now()
+	status := "success"
+	if err := e.handle(ctx, msg); err != nil {
+		// The offset is committed anyway: a poison message must not block
+		// the partition
+		status = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "processing failed")
+		backgroundLogger.ErrorContext(ctx, "Failed to process Kafka message",
+			"topic", e.topic,
+			"partition", msg.Partition,
+			"offset", msg.Offset,
+			"error", err,
+		)
+	}
+
+	e.processingDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("topic", e.topic),
+		attribute.String("status", status),
+	))
+}
+
+// handle simulates the work of a downstream consumer of request events
+func (e *kafkaEvents) handle(ctx context.Context, msg kafka.Message) error {
+	var event requestEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return err
+	}
+
+	time.Sleep(consumerLatency.sample())
+	if rand.Float32() < 0.02 {
+		return errors.New("simulated processing failure")
+	}
+
+	backgroundLogger.InfoContext(ctx, "Kafka event processed",
+		"topic", e.topic,
+		"request_id", event.RequestID,
+		"end_to_end_ms", time.Since(event.CreatedAt).Milliseconds(),
+	)
+	return nil
+}
+
+func (e *kafkaEvents) observeLag(_ context.Context, o metric.Int64Observer) error {
+	e.lagMu.Lock()
+	defer e.lagMu.Unlock()
+	for partition, lag := range e.lag {
+		o.Observe(lag, metric.WithAttributes(
+			attribute.String("topic", e.topic),
+			attribute.String("consumer_group", e.group),
+			attribute.Int("partition", partition),
+		))
+	}
+	return nil
+}
+
+// kafkaHeaderCarrier lets propagators read and write Kafka record headers
+type kafkaHeaderCarrier []kafka.Header
+
+var _ propagation.TextMapCarrier = (*kafkaHeaderCarrier)(nil)
+
+func (c *kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range *c {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *kafkaHeaderCarrier) Set(key, value string) {
+	for i, h := range *c {
+		if h.Key == key {
+			(*c)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c *kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, len(*c))
+	for i, h := range *c {
+		keys[i] = h.Key
+	}
+	return keys
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// maxSimulatedLatency caps a single draw, as a heavy-tailed distribution
+// occasionally produces delays no client would wait for
+const maxSimulatedLatency = 30 * time.Second
+
+// latencyModel describes simulated latency: latency_ms plus a random part
+// drawn from distribution with scale latency_jitter_ms:
+//
+//   - uniform: between 0 and latency_jitter_ms
+//   - normal: standard deviation latency_jitter_ms, never below -latency_ms
+//   - exponential: mean latency_jitter_ms, which gives a long tail
+//   - lognormal: median latency_jitter_ms, with latency_shape as sigma
+//     (default 0.5); most requests sit near the median and a few are many
+//     times slower, as in most production latency histograms
+//   - pareto: at least latency_jitter_ms, with latency_shape as alpha
+//     (default 1.5); the lower alpha, the heavier the tail
+//
+// On top, spike_rate of the draws get spike_ms more, like the GC pauses,
+// cold caches and retries behind the p99 of a real service.
+type latencyModel struct {
+	LatencyMs       float64 `json:"latency_ms" yaml:"latency_ms"`
+	LatencyJitterMs float64 `json:"latency_jitter_ms" yaml:"latency_jitter_ms"`
+	Distribution    string  `json:"distribution,omitempty" yaml:"distribution"`
+	LatencyShape    float64 `json:"latency_shape,omitempty" yaml:"latency_shape"`
+	SpikeRate       float64 `json:"spike_rate,omitempty" yaml:"spike_rate"`
+	SpikeMs         float64 `json:"spike_ms,omitempty" yaml:"spike_ms"`
+}
+
+func (m latencyModel) validate() error {
+	if m.LatencyMs < 0 || m.LatencyJitterMs < 0 || m.SpikeMs < 0 {
+		return errors.New("latency_ms, latency_jitter_ms and spike_ms must not be negative")
+	}
+	if m.LatencyShape < 0 {
+		return fmt.Errorf("latency_shape must not be negative, got %v", m.LatencyShape)
+	}
+	if m.SpikeRate < 0 || m.SpikeRate > 1 {
+		return fmt.Errorf("spike_rate must be between 0 and 1, got %v", m.SpikeRate)
+	}
+	switch m.Distribution {
+	case "", "uniform", "normal", "exponential", "lognormal", "pareto":
+		return nil
+	}
+	return fmt.Errorf("unknown distribution %q: expected uniform, normal, exponential, lognormal or pareto", m.Distribution)
+}
+
+// sample draws one latency
+func (m latencyModel) sample() time.Duration {
+	ms := m.LatencyMs
+	switch m.Distribution {
+	case "normal":
+		ms += rand.NormFloat64() * m.LatencyJitterMs
+	case "exponential":
+		ms += rand.ExpFloat64() * m.LatencyJitterMs
+	case "lognormal":
+		sigma := m.LatencyShape
+		if sigma == 0 {
+			sigma = 0.5
+		}
+		ms += m.LatencyJitterMs * math.Exp(sigma*rand.NormFloat64())
+	case "pareto":
+		alpha := m.LatencyShape
+		if alpha == 0 {
+			alpha = 1.5
+		}
+		// 1-Float64 is in (0, 1], so the power never divides by zero
+		ms += m.LatencyJitterMs * math.Pow(1-rand.Float64(), -1/alpha)
+	default:
+		ms += rand.Float64() * m.LatencyJitterMs
+	}
+	if m.SpikeRate > 0 && rand.Float64() < m.SpikeRate {
+		ms += m.SpikeMs
+	}
+	return min(time.Duration(math.Max(ms, 0)*float64(time.Millisecond)), maxSimulatedLatency)
+}
+
+// Latency of the simulated work behind the handlers and consumers, which
+// has no chaos rule of its own
+var (
+	// cacheMissLatency is the slow path of /api when its result isn't
+	// cached
+	cacheMissLatency = latencyModel{LatencyMs: 20, LatencyJitterMs: 60, Distribution: "lognormal", SpikeRate: 0.01, SpikeMs: 400}
+	// taskLatency is a task submitted to the worker pool by /api
+	taskLatency = latencyModel{LatencyMs: 30, LatencyJitterMs: 80, Distribution: "lognormal", LatencyShape: 0.6}
+	// consumerLatency is the processing of an SQS message or Kafka event
+	consumerLatency = latencyModel{LatencyMs: 10, LatencyJitterMs: 60, Distribution: "lognormal", SpikeRate: 0.02, SpikeMs: 1000}
+)
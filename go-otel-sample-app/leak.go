@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// leakTick is how often the memory leak allocates its next chunk
+const leakTick = 100 * time.Millisecond
+
+// memoryLeak retains memory at a configurable rate so container memory
+// climbs towards its limit, for demos of Container Insights, memory alerts
+// and OOMKilled pods
+var memoryLeak = &memoryLeaker{}
+
+type memoryLeaker struct {
+	mu        sync.Mutex
+	mbPerSec  float64
+	chunks    [][]byte
+	retained  int64
+	stop      chan struct{}
+	startedAt time.Time
+}
+
+// goroutineLeak starts goroutines at a configurable rate that block forever
+// on a channel, for "find the leak" exercises with the goroutine runtime
+// metrics and pprof
+var goroutineLeak = &goroutineLeaker{}
+
+type goroutineLeaker struct {
+	mu        sync.Mutex
+	perSec    float64
+	leaked    atomic.Int64
+	stop      chan struct{}
+	release   chan struct{}
+	startedAt time.Time
+}
+
+// memoryLeakStatus is the JSON form of the leak state
+type memoryLeakStatus struct {
+	MBPerSecond   float64 `json:"mb_per_second"`
+	RetainedBytes int64   `json:"retained_bytes"`
+	StartedAt     string  `json:"started_at,omitempty"`
+}
+
+// start begins leaking mbPerSec megabytes per second, replacing any
+// previous rate but keeping what has already been retained
+func (m *memoryLeaker) start(mbPerSec float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+	}
+	m.mbPerSec = mbPerSec
+	m.stop = make(chan struct{})
+	m.startedAt = time.Now()
+
+	go m.run(m.stop, int(mbPerSec*(1<<20)*leakTick.Seconds()))
+}
+
+func (m *memoryLeaker) run(stop chan struct{}, chunkSize int) {
+	ticker := time.NewTicker(leakTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		chunk := make([]byte, chunkSize)
+		// Touch every page so the memory is resident and counts towards
+		// the container's working set, not just its virtual size
+		for i := 0; i < len(chunk); i += os.Getpagesize() {
+			chunk[i] = 1
+		}
+
+		m.mu.Lock()
+		// A reset may have happened while the chunk was being allocated
+		if m.stop == stop {
+			m.chunks = append(m.chunks, chunk)
+			m.retained += int64(chunkSize)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// reset stops the leak and gives the retained memory back to the OS
+func (m *memoryLeaker) reset() {
+	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	m.mbPerSec = 0
+	m.chunks = nil
+	m.retained = 0
+	m.mu.Unlock()
+
+	debug.FreeOSMemory()
+}
+
+func (m *memoryLeaker) status() memoryLeakStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := memoryLeakStatus{MBPerSecond: m.mbPerSec, RetainedBytes: m.retained}
+	if m.stop != nil {
+		s.StartedAt = m.startedAt.Format(time.RFC3339)
+	}
+	return s
+}
+
+func (m *memoryLeaker) observe(_ context.Context, o metric.Int64Observer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o.Observe(m.retained)
+	return nil
+}
+
+// goroutineLeakStatus is the JSON form of the goroutine leak state
+type goroutineLeakStatus struct {
+	PerSecond float64 `json:"per_second"`
+	Leaked    int64   `json:"leaked"`
+	StartedAt string  `json:"started_at,omitempty"`
+}
+
+// start begins leaking perSec goroutines per second, replacing any previous
+// rate but keeping the goroutines already leaked
+func (g *goroutineLeaker) start(perSec float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != nil {
+		close(g.stop)
+	}
+	if g.release == nil {
+		g.release = make(chan struct{})
+	}
+	g.perSec = perSec
+	g.stop = make(chan struct{})
+	g.startedAt = time.Now()
+
+	go g.run(g.stop, g.release, time.Duration(float64(time.Second)/perSec))
+}
+
+func (g *goroutineLeaker) run(stop, release chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			g.leaked.Add(1)
+			go leakedGoroutine(release, &g.leaked)
+		}
+	}
+}
+
+// leakedGoroutine waits on a channel that is only closed by a reset. It is
+// a named function so it stands out in goroutine profiles.
+func leakedGoroutine(release <-chan struct{}, leaked *atomic.Int64) {
+	<-release
+	leaked.Add(-1)
+}
+
+// reset stops the leak and lets every leaked goroutine exit
+func (g *goroutineLeaker) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+	if g.release != nil {
+		close(g.release)
+		g.release = nil
+	}
+	g.perSec = 0
+}
+
+func (g *goroutineLeaker) status() goroutineLeakStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := goroutineLeakStatus{PerSecond: g.perSec, Leaked: g.leaked.Load()}
+	if g.stop != nil {
+		s.StartedAt = g.startedAt.Format(time.RFC3339)
+	}
+	return s
+}
+
+// registerLeakAdmin serves the leak toggles and starts a leak right away
+// when the simulation configuration sets a rate:
+//
+//	GET    /admin/leak/memory       current rate and retained bytes
+//	PUT    /admin/leak/memory       start leaking {"mb_per_second": 5}
+//	DELETE /admin/leak/memory       stop leaking and release the memory
+//	GET    /admin/leak/goroutines   current rate and leaked goroutines
+//	PUT    /admin/leak/goroutines   start leaking {"per_second": 10}
+//	DELETE /admin/leak/goroutines   stop leaking and end the leaked goroutines
+func registerLeakAdmin(mux *http.ServeMux, c simulationConfig) error {
+	if _, err := meter.Int64ObservableGauge(
+		"memory_leak_retained_bytes",
+		metric.WithDescription("Memory deliberately retained by the simulated leak"),
+		metric.WithUnit("By"),
+		metric.WithInt64Callback(memoryLeak.observe),
+	); err != nil {
+		return err
+	}
+
+	if _, err := meter.Int64ObservableGauge(
+		"goroutine_leak_active",
+		metric.WithDescription("Goroutines deliberately blocked by the simulated leak"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(goroutineLeak.leaked.Load())
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+
+	if rate := c.MemoryLeakMBPerSecond; rate > 0 {
+		memoryLeak.start(rate)
+		logger.Warn("Simulated memory leak started", "mb_per_second", rate)
+	}
+
+	if rate := c.GoroutineLeakPerSecond; rate > 0 {
+		goroutineLeak.start(rate)
+		logger.Warn("Simulated goroutine leak started", "per_second", rate)
+	}
+
+	mux.HandleFunc("GET /admin/leak/memory", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, memoryLeak.status())
+	})
+	mux.HandleFunc("PUT /admin/leak/memory", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MBPerSecond float64 `json:"mb_per_second"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeError(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+		if req.MBPerSecond <= 0 || req.MBPerSecond > 1024 {
+			writeError(r.Context(), w, http.StatusBadRequest, "mb_per_second must be between 0 and 1024")
+			return
+		}
+		memoryLeak.start(req.MBPerSecond)
+		logger.WarnContext(r.Context(), "Simulated memory leak started", "mb_per_second", req.MBPerSecond)
+		writeJSON(w, http.StatusOK, memoryLeak.status())
+	})
+	mux.HandleFunc("DELETE /admin/leak/memory", func(w http.ResponseWriter, r *http.Request) {
+		released := memoryLeak.status().RetainedBytes
+		memoryLeak.reset()
+		logger.InfoContext(r.Context(), "Simulated memory leak stopped", "released_bytes", released)
+		writeJSON(w, http.StatusOK, memoryLeak.status())
+	})
+
+	mux.HandleFunc("GET /admin/leak/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, goroutineLeak.status())
+	})
+	mux.HandleFunc("PUT /admin/leak/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PerSecond float64 `json:"per_second"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeError(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+		if req.PerSecond <= 0 || req.PerSecond > 10000 {
+			writeError(r.Context(), w, http.StatusBadRequest, "per_second must be between 0 and 10000")
+			return
+		}
+		goroutineLeak.start(req.PerSecond)
+		logger.WarnContext(r.Context(), "Simulated goroutine leak started", "per_second", req.PerSecond)
+		writeJSON(w, http.StatusOK, goroutineLeak.status())
+	})
+	mux.HandleFunc("DELETE /admin/leak/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		released := goroutineLeak.status().Leaked
+		goroutineLeak.reset()
+		logger.InfoContext(r.Context(), "Simulated goroutine leak stopped", "released_goroutines", released)
+		writeJSON(w, http.StatusOK, goroutineLeak.status())
+	})
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// loadgenEndpoint is one request the load generator sends, given as
+// "[METHOD] path", e.g. "POST /api/orders"
+type loadgenEndpoint struct {
+	method string
+	path   string
+}
+
+// loadgen drives a target deployment of this app at a configurable rate so
+// dashboards have data without installing hey or k6 in the cluster
+type loadgen struct {
+	target      string
+	endpoints   []loadgenEndpoint
+	rps         float64
+	concurrency int
+	duration    time.Duration
+	rampUp      time.Duration
+	client      *http.Client
+	// headers are sent with every request, e.g. credentials
+	headers http.Header
+	// scale, when set, multiplies rps, e.g. by the traffic scenario
+	scale func() float64
+	// progress is how often the counters are logged; 0 logs them only
+	// when the run ends
+	progress time.Duration
+
+	sent, success, clientErrors, serverErrors, failed, dropped atomic.Int64
+	latencyNanos                                               atomic.Int64
+}
+
+// runLoadgen parses the loadgen flags, which default to the LOADGEN_*
+// environment variables so the same image can run as a load generator with
+// MODE=loadgen, and sends requests until the duration elapses or the
+// process is interrupted
+func runLoadgen(args []string) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	target := fs.String("target", getEnv("LOADGEN_TARGET", "http://localhost:8080"), "base URL of the app under load")
+	endpoints := fs.String("endpoints", getEnv("LOADGEN_ENDPOINTS", "GET /api,GET /api/orders,POST /api/orders"), "comma-separated \"[METHOD] path\" entries, picked at random for each request")
+	rps := fs.Float64("rps", getEnvFloat("LOADGEN_RPS", 10), "requests per second once ramped up")
+	concurrency := fs.Int("concurrency", getEnvInt("LOADGEN_CONCURRENCY", 20), "maximum requests in flight; requests beyond it are dropped")
+	duration := fs.Duration("duration", getEnvDuration("LOADGEN_DURATION", 0), "how long to run; 0 runs until interrupted")
+	rampUp := fs.Duration("ramp-up", getEnvDuration("LOADGEN_RAMP_UP", 30*time.Second), "time to ramp linearly from 1 rps to -rps")
+	timeout := fs.Duration("timeout", getEnvDuration("LOADGEN_TIMEOUT", 10*time.Second), "per-request timeout")
+	apiKey := fs.String("api-key", getEnv("LOADGEN_API_KEY", ""), "key sent in X-API-Key when the target requires authentication")
+	token := fs.String("token", getEnv("LOADGEN_TOKEN", ""), "JWT sent as a bearer token when the target requires authentication")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *rps <= 0 {
+		return fmt.Errorf("rps must be positive, got %v", *rps)
+	}
+	if *concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive, got %d", *concurrency)
+	}
+	parsed, err := parseLoadgenEndpoints(*endpoints)
+	if err != nil {
+		return err
+	}
+
+	l := &loadgen{
+		target:      strings.TrimRight(*target, "/"),
+		endpoints:   parsed,
+		rps:         *rps,
+		concurrency: *concurrency,
+		duration:    *duration,
+		rampUp:      *rampUp,
+		headers:     make(http.Header),
+		progress:    10 * time.Second,
+		client: &http.Client{
+			Timeout: *timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: *concurrency,
+			},
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if l.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.duration)
+		defer cancel()
+	}
+
+	if *apiKey != "" {
+		l.headers.Set(apiKeyHeader, *apiKey)
+	}
+	if *token != "" {
+		l.headers.Set("Authorization", "Bearer "+*token)
+	}
+
+	l.run(ctx)
+	return nil
+}
+
+func parseLoadgenEndpoints(value string) ([]loadgenEndpoint, error) {
+	var endpoints []loadgenEndpoint
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Fields(entry)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			endpoints = append(endpoints, loadgenEndpoint{method: http.MethodGet, path: fields[0]})
+		case 2:
+			endpoints = append(endpoints, loadgenEndpoint{method: strings.ToUpper(fields[0]), path: fields[1]})
+		default:
+			return nil, fmt.Errorf("invalid endpoint %q: expected \"[METHOD] path\"", entry)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints configured")
+	}
+	return endpoints, nil
+}
+
+// rateAt is the target request rate after elapsed, ramping linearly over
+// rampUp and never below 1 rps, so the first request is not delayed
+func (l *loadgen) rateAt(elapsed time.Duration) float64 {
+	rps := l.rps
+	if l.scale != nil {
+		rps *= l.scale()
+	}
+	if l.rampUp <= 0 || elapsed >= l.rampUp {
+		return rps
+	}
+	return max(rps*elapsed.Seconds()/l.rampUp.Seconds(), min(rps, 1))
+}
+
+func (l *loadgen) run(ctx context.Context) {
+	logger.Info("Load generator starting",
+		"target", l.target,
+		"rps", l.rps,
+		"concurrency", l.concurrency,
+		"duration", l.duration.String(),
+		"ramp_up", l.rampUp.String(),
+	)
+
+	start := time.Now()
+	inFlight := make(chan struct{}, l.concurrency)
+	var wg sync.WaitGroup
+
+	if l.progress > 0 {
+		go func() {
+			report := time.NewTicker(l.progress)
+			defer report.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-report.C:
+					l.log("Load generator progress", time.Since(start))
+				}
+			}
+		}()
+	}
+
+	next := start
+	for {
+		next = next.Add(time.Duration(float64(time.Second) / l.rateAt(time.Since(start))))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			wg.Wait()
+			l.log("Load generator finished", time.Since(start))
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				l.send(l.endpoints[rand.Intn(len(l.endpoints))])
+			}()
+		default:
+			// The target is slower than the configured rate allows for
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// send makes one request. It deliberately ignores ctx so requests that are
+// in flight when the run ends still complete and are counted.
+func (l *loadgen) send(e loadgenEndpoint) {
+	l.sent.Add(1)
+
+	req, err := e.newRequest(context.Background(), l.target)
+	if err != nil {
+		l.failed.Add(1)
+		return
+	}
+	req.Header.Set("baggage", randomBaggage())
+	req.Header.Set("X-Tenant-Id", randomTenant())
+	for name, values := range l.headers {
+		req.Header[name] = values
+	}
+
+	start := time.Now()
+	resp, err := l.client.Do(req)
+	if err != nil {
+		l.failed.Add(1)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	l.latencyNanos.Add(int64(time.Since(start)))
+
+	switch {
+	case resp.StatusCode >= 500:
+		l.serverErrors.Add(1)
+	case resp.StatusCode >= 400:
+		l.clientErrors.Add(1)
+	default:
+		l.success.Add(1)
+	}
+}
+
+// newRequest builds the request of e against target, with a random order
+// for POST /api/orders and a random payload for POST /api/upload
+func (e loadgenEndpoint) newRequest(ctx context.Context, target string) (*http.Request, error) {
+	var body io.Reader
+	contentType := "application/json"
+	switch {
+	case e.method == http.MethodPost && e.path == "/api/orders":
+		body = bytes.NewReader(randomOrderJSON())
+	case e.method == http.MethodPost && e.path == "/api/upload":
+		body = bytes.NewReader(randomPayload())
+		contentType = "application/octet-stream"
+	}
+	req, err := http.NewRequestWithContext(ctx, e.method, target+e.path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+func (l *loadgen) log(msg string, elapsed time.Duration) {
+	sent := l.sent.Load()
+	var avgLatency time.Duration
+	if answered := sent - l.failed.Load(); answered > 0 {
+		avgLatency = time.Duration(l.latencyNanos.Load() / answered)
+	}
+	logger.Info(msg,
+		"elapsed", elapsed.Round(time.Second).String(),
+		"target_rps", l.rateAt(elapsed),
+		"achieved_rps", float64(sent)/elapsed.Seconds(),
+		"sent", sent,
+		"success", l.success.Load(),
+		"client_errors", l.clientErrors.Load(),
+		"server_errors", l.serverErrors.Load(),
+		"failed", l.failed.Load(),
+		"dropped", l.dropped.Load(),
+		"avg_latency_ms", avgLatency.Milliseconds(),
+	)
+}
+
+// randomBaggage plays a mix of users, mostly on the free tier, so the
+// baggage attributes have something to group by
+func randomBaggage() string {
+	tiers := []string{"free", "free", "free", "pro", "pro", "enterprise"}
+	return fmt.Sprintf("user.tier=%s,session.id=session-%d", tiers[rand.Intn(len(tiers))], rand.Intn(500))
+}
+
+// randomTenant spreads requests over the default tenants, one of them
+// much busier than the others, with a few from a tenant nobody knows
+func randomTenant() string {
+	tenants := []string{"acme", "acme", "acme", "acme", "globex", "globex", "initech", "hooli"}
+	return tenants[rand.Intn(len(tenants))]
+}
+
+// randomPayload returns 1 KB to 1 MB of random bytes, mostly small, as
+// uploads from a mix of clients would be
+func randomPayload() []byte {
+	payload := make([]byte, 1<<(10+rand.Intn(11)))
+	crand.Read(payload)
+	return payload
+}
+
+// randomOrderJSON builds a CreateOrderRequest for POST /api/orders
+func randomOrderJSON() []byte {
+	skus := []string{"SKU-APPLE", "SKU-BANANA", "SKU-CHERRY", "SKU-DATE", "SKU-ELDERBERRY"}
+	var items []string
+	for i := rand.Intn(3); i >= 0; i-- {
+		items = append(items, fmt.Sprintf(`{"sku":%q,"quantity":%d,"unit_price_cents":%d}`,
+			skus[rand.Intn(len(skus))], rand.Intn(5)+1, rand.Intn(5000)+100))
+	}
+	return fmt.Appendf(nil, `{"customer_id":"customer-%d","items":[%s]}`,
+		rand.Intn(1000), strings.Join(items, ","))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logLevel is the minimum level of both log paths for records without a
+// component of their own level. It is set from the logging configuration
+// and changes when the config file is reloaded or through /admin/log-level.
+var logLevel = new(slog.LevelVar)
+
+// allLevels lets the handlers wrapped by levelHandler accept every record
+const allLevels = slog.Level(math.MinInt)
+
+// logger writes JSON to stdout for kubectl logs and Fluent Bit, or to the
+// configured log output, and emits the same records through the OTel log
+// pipeline once telemetry is set up
+var logger = outputLogger
+
+// outputLogger writes to the log output only
+var outputLogger = newLogger(newJSONHandler())
+
+// initLogging attaches the OTel log bridge. It must run after the global
+// LoggerProvider has been installed by initTelemetry.
+func initLogging() {
+	logger = newLogger(fanoutHandler{
+		newJSONHandler(),
+		newOTelHandler("go-otel-sample-app"),
+	})
+	// Route anything still using the standard log package through slog
+	slog.SetDefault(logger)
+}
+
+// newLogger filters the records of h by the level of their component, then
+// through the log sampler, redacts the remaining ones and links errors to
+// their trace
+func newLogger(h slog.Handler) *slog.Logger {
+	return slog.New(levelHandler{next: samplingHandler{next: redactHandler{next: traceLinkHandler{next: h}}}})
+}
+
+// requestLogger returns a logger that tags every record with the endpoint
+// and HTTP method, so both log paths can be filtered on those fields, and
+// with the http component. Records of debug trace requests get debug_trace.
+func requestLogger(r *http.Request, endpoint string) *slog.Logger {
+	log := logger.With(
+		"component", logComponentHTTP,
+		"endpoint", endpoint,
+		"method", r.Method,
+	)
+	if debugTraceForced(r.Context()) {
+		log = log.With("debug_trace", true)
+	}
+	if tenant := tenantFromContext(r.Context()); tenant != "" {
+		log = log.With("tenant_id", tenant)
+	}
+	return log
+}
+
+// fatal logs err and exits; used for startup failures
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newJSONHandler writes to logOutput. It keeps the field names of the
+// original hand-written JSON logs (timestamp, level, message) and adds
+// trace_id/span_id from the context.
+func newJSONHandler() slog.Handler {
+	return traceContextHandler{slog.NewJSONHandler(logOutput, &slog.HandlerOptions{
+		Level: allLevels,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				a.Key = "timestamp"
+			case slog.MessageKey:
+				a.Key = "message"
+			case slog.LevelKey:
+				level := a.Value.Any().(slog.Level)
+				if level == slog.LevelWarn {
+					a.Value = slog.StringValue("warning")
+				} else {
+					a.Value = slog.StringValue(strings.ToLower(level.String()))
+				}
+			}
+			return a
+		},
+	})}
+}
+
+// traceContextHandler adds the active trace and span IDs to each record so
+// stdout logs can be joined with traces in CloudWatch or Loki, and the
+// request ID so they can be found from a customer report
+type traceContextHandler struct {
+	slog.Handler
+}
+
+func (h traceContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceContextHandler) WithGroup(name string) slog.Handler {
+	return traceContextHandler{h.Handler.WithGroup(name)}
+}
+
+// fanoutHandler sends every record to all of its handlers
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// otelHandler bridges slog to the OTel Logs API. Records keep their
+// severity and attributes, and the SDK stamps them with the trace and span
+// IDs found in the context passed to InfoContext, ErrorContext, etc.
+type otelHandler struct {
+	logger otellog.Logger
+	attrs  []otellog.KeyValue
+	prefix string
+}
+
+func newOTelHandler(name string) otelHandler {
+	return otelHandler{logger: global.GetLoggerProvider().Logger(name)}
+}
+
+func (h otelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.Enabled(ctx, otellog.EnabledParameters{Severity: severity(level)})
+}
+
+func (h otelHandler) Handle(ctx context.Context, r slog.Record) error {
+	var record otellog.Record
+	record.SetTimestamp(r.Time)
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(severity(r.Level))
+	record.SetSeverityText(r.Level.String())
+	record.SetBody(otellog.StringValue(r.Message))
+	record.AddAttributes(h.attrs...)
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttributes(otellog.String("request_id", id))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if kv, ok := h.convertAttr(a); ok {
+			record.AddAttributes(kv)
+		}
+		return true
+	})
+	h.logger.Emit(ctx, record)
+	return nil
+}
+
+func (h otelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	converted := make([]otellog.KeyValue, 0, len(h.attrs)+len(attrs))
+	converted = append(converted, h.attrs...)
+	for _, a := range attrs {
+		if kv, ok := h.convertAttr(a); ok {
+			converted = append(converted, kv)
+		}
+	}
+	return otelHandler{logger: h.logger, attrs: converted, prefix: h.prefix}
+}
+
+// WithGroup flattens groups into dotted attribute keys, the usual OTel
+// attribute naming style
+func (h otelHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return otelHandler{logger: h.logger, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func (h otelHandler) convertAttr(a slog.Attr) (otellog.KeyValue, bool) {
+	if a.Equal(slog.Attr{}) {
+		return otellog.KeyValue{}, false
+	}
+	return otellog.KeyValue{Key: h.prefix + a.Key, Value: convertValue(a.Value)}, true
+}
+
+func convertValue(v slog.Value) otellog.Value {
+	switch v = v.Resolve(); v.Kind() {
+	case slog.KindString:
+		return otellog.StringValue(v.String())
+	case slog.KindInt64:
+		return otellog.Int64Value(v.Int64())
+	case slog.KindUint64:
+		if u := v.Uint64(); u <= math.MaxInt64 {
+			return otellog.Int64Value(int64(u))
+		}
+		return otellog.StringValue(v.String())
+	case slog.KindFloat64:
+		return otellog.Float64Value(v.Float64())
+	case slog.KindBool:
+		return otellog.BoolValue(v.Bool())
+	case slog.KindDuration:
+		return otellog.Int64Value(v.Duration().Nanoseconds())
+	case slog.KindTime:
+		return otellog.Int64Value(v.Time().UnixNano())
+	case slog.KindGroup:
+		group := v.Group()
+		kvs := make([]otellog.KeyValue, 0, len(group))
+		for _, a := range group {
+			kvs = append(kvs, otellog.KeyValue{Key: a.Key, Value: convertValue(a.Value)})
+		}
+		return otellog.MapValue(kvs...)
+	default:
+		return convertAny(v.Any())
+	}
+}
+
+// convertAny keeps maps and slices structured, so a field such as the
+// readiness check results arrives as a map attribute that backends can
+// query directly instead of a Go-formatted string
+func convertAny(val any) otellog.Value {
+	switch val := val.(type) {
+	case nil:
+		return otellog.Value{}
+	case error:
+		return otellog.StringValue(val.Error())
+	case []byte:
+		return otellog.BytesValue(val)
+	case fmt.Stringer:
+		return otellog.StringValue(val.String())
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		kvs := make([]otellog.KeyValue, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			kvs = append(kvs, otellog.KeyValue{
+				Key:   iter.Key().String(),
+				Value: convertValue(slog.AnyValue(iter.Value().Interface())),
+			})
+		}
+		return otellog.MapValue(kvs...)
+	case reflect.Slice, reflect.Array:
+		values := make([]otellog.Value, rv.Len())
+		for i := range values {
+			values[i] = convertValue(slog.AnyValue(rv.Index(i).Interface()))
+		}
+		return otellog.SliceValue(values...)
+	}
+	return otellog.StringValue(fmt.Sprint(val))
+}
+
+// severity maps slog levels onto the OTel severity scale, where DEBUG, INFO,
+// WARN and ERROR start at 5, 9, 13 and 17 respectively
+func severity(level slog.Level) otellog.Severity {
+	return otellog.Severity(level + 9)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Log components, each with its own level. A record belongs to the
+// component named by its component attribute; records without one follow
+// the global level.
+const (
+	logComponentHTTP       = "http"
+	logComponentBackground = "background"
+	logComponentTelemetry  = "telemetry"
+	logComponentAccess     = "access"
+)
+
+var logComponentNames = []string{logComponentHTTP, logComponentBackground, logComponentTelemetry, logComponentAccess}
+
+// Loggers of the background and telemetry components; requestLogger tags
+// the records of the http component. The telemetry records stay out of the
+// OTel log pipeline they report on, where an export failure would feed
+// more exports, and the SDK's own warnings would re-enter the SDK.
+var (
+	backgroundLogger = slog.New(componentHandler{logComponentBackground, func() *slog.Logger { return logger }})
+	telemetryLogger  = slog.New(componentHandler{logComponentTelemetry, func() *slog.Logger { return outputLogger }})
+)
+
+// logLevels holds the per-component levels set by the configuration and
+// through /admin/log-level
+var logLevels = &logLevelRegistry{}
+
+// logLevelRegistry keeps the level of each component that does not follow
+// the global logLevel
+type logLevelRegistry struct {
+	mu         sync.RWMutex
+	components map[string]slog.Level
+	// configured are the levels from the configuration, which a reset
+	// through the admin API goes back to
+	configuredLevel      slog.Level
+	configuredComponents map[string]slog.Level
+}
+
+// level returns the minimum level of component, or the global level for
+// components without their own
+func (l *logLevelRegistry) level(component string) slog.Level {
+	if component != "" {
+		l.mu.RLock()
+		level, ok := l.components[component]
+		l.mu.RUnlock()
+		if ok {
+			return level
+		}
+	}
+	return logLevel.Level()
+}
+
+// replace installs the configured levels, discarding changes made through
+// the admin API. c must be valid.
+func (l *logLevelRegistry) replace(c loggingConfig) {
+	level, _ := parseLogLevel(c.Level)
+	components := make(map[string]slog.Level, len(c.Components))
+	for name, value := range c.Components {
+		components[name], _ = parseLogLevel(value)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configuredLevel = level
+	l.configuredComponents = components
+	l.components = maps.Clone(components)
+	logLevel.Set(level)
+}
+
+func (l *logLevelRegistry) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components = maps.Clone(l.configuredComponents)
+	logLevel.Set(l.configuredLevel)
+}
+
+// set changes the level of component, or the global level when component
+// is empty
+func (l *logLevelRegistry) set(component string, level slog.Level) {
+	if component == "" {
+		logLevel.Set(level)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.components == nil {
+		l.components = make(map[string]slog.Level)
+	}
+	l.components[component] = level
+}
+
+// unset makes component follow the global level again
+func (l *logLevelRegistry) unset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.components, component)
+}
+
+// logLevelStatus is the JSON answer of /admin/log-level
+type logLevelStatus struct {
+	Level string `json:"level"`
+	// Components holds the effective level of every component
+	Components map[string]string `json:"components"`
+	// Overrides are the components that do not follow the global level
+	Overrides []string `json:"overrides"`
+}
+
+func (l *logLevelRegistry) status() logLevelStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := logLevelStatus{
+		Level:      levelName(logLevel.Level()),
+		Components: make(map[string]string, len(logComponentNames)),
+		Overrides:  slices.Sorted(maps.Keys(l.components)),
+	}
+	for _, name := range logComponentNames {
+		level, ok := l.components[name]
+		if !ok {
+			level = logLevel.Level()
+		}
+		s.Components[name] = levelName(level)
+	}
+	return s
+}
+
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// levelHandler filters records by the level of their component, which it
+// learns from a component attribute added with Logger.With, and lets every
+// record of a debug trace request through. The handlers it wraps accept
+// every level.
+type levelHandler struct {
+	next      slog.Handler
+	component string
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (level >= logLevels.level(h.component) || debugTraceForced(ctx)) && h.next.Enabled(ctx, level)
+}
+
+func (h levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{next: h.next.WithAttrs(attrs), component: componentOf(attrs, h.component)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{next: h.next.WithGroup(name), component: h.component}
+}
+
+// componentOf returns the component named in attrs, or current if there is
+// none
+func componentOf(attrs []slog.Attr, current string) string {
+	for _, a := range attrs {
+		if a.Key == "component" && a.Value.Kind() == slog.KindString {
+			current = a.Value.String()
+		}
+	}
+	return current
+}
+
+// componentHandler tags records with its component and hands them to the
+// logger returned by base. It calls base on every record, so the component
+// loggers can be created before initLogging replaces the loggers.
+type componentHandler struct {
+	component string
+	base      func() *slog.Logger
+}
+
+func (c componentHandler) handler() slog.Handler {
+	return c.base().Handler().WithAttrs([]slog.Attr{slog.String("component", c.component)})
+}
+
+func (c componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return c.handler().Enabled(ctx, level)
+}
+
+func (c componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return c.handler().Handle(ctx, r)
+}
+
+func (c componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return c.handler().WithAttrs(attrs)
+}
+
+func (c componentHandler) WithGroup(name string) slog.Handler {
+	return c.handler().WithGroup(name)
+}
+
+// registerLogLevelAdmin serves the log levels:
+//
+//	GET    /admin/log-level               global and per-component levels
+//	PUT    /admin/log-level               set the global level, {"level": "debug"}
+//	PUT    /admin/log-level/{component}   set the level of http, background or telemetry
+//	DELETE /admin/log-level/{component}   make the component follow the global level
+//	DELETE /admin/log-level               go back to the configured levels
+//
+// Changes last until the next restart or a reload that changes the
+// logging levels.
+func registerLogLevelAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/log-level", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, logLevels.status())
+	})
+	mux.HandleFunc("PUT /admin/log-level", setLogLevelHandler)
+	mux.HandleFunc("PUT /admin/log-level/{component}", setLogLevelHandler)
+	mux.HandleFunc("DELETE /admin/log-level/{component}", func(w http.ResponseWriter, r *http.Request) {
+		component := r.PathValue("component")
+		if !slices.Contains(logComponentNames, component) {
+			writeError(r.Context(), w, http.StatusNotFound, unknownComponentMessage(component))
+			return
+		}
+		logLevels.unset(component)
+		logger.InfoContext(r.Context(), "Log level override removed", "log_component", component)
+		writeJSON(w, http.StatusOK, logLevels.status())
+	})
+	mux.HandleFunc("DELETE /admin/log-level", func(w http.ResponseWriter, r *http.Request) {
+		logLevels.reset()
+		logger.InfoContext(r.Context(), "Log levels reset to the configuration")
+		writeJSON(w, http.StatusOK, logLevels.status())
+	})
+}
+
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	component := r.PathValue("component")
+	if component != "" && !slices.Contains(logComponentNames, component) {
+		writeError(r.Context(), w, http.StatusNotFound, unknownComponentMessage(component))
+		return
+	}
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	level, err := parseLogLevel(req.Level)
+	if err != nil {
+		writeError(r.Context(), w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logLevels.set(component, level)
+	// Logged at warn, so the change is visible whatever the new level
+	logger.WarnContext(r.Context(), "Log level changed", "log_component", component, "log_level", levelName(level))
+	writeJSON(w, http.StatusOK, logLevels.status())
+}
+
+func unknownComponentMessage(component string) string {
+	return fmt.Sprintf("unknown log component %q, expected one of %v", component, logComponentNames)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Log outputs selectable through LOG_OUTPUT
+const (
+	logOutputStdout  = "stdout"
+	logOutputFile    = "file"
+	logOutputForward = "forward"
+)
+
+const (
+	// forwardBufferSize is the number of records held while Fluent Bit is
+	// slow or away; further records are dropped
+	forwardBufferSize = 4096
+	// forwardRetryInterval is how long records are dropped after a failed
+	// connection before the next attempt
+	forwardRetryInterval = 5 * time.Second
+	forwardDialTimeout   = 2 * time.Second
+	forwardWriteTimeout  = 5 * time.Second
+)
+
+// logOutput is where the JSON log lines go: stdout by default, or the sink
+// chosen by setLogOutput. The OTel log pipeline is not affected.
+var logOutput io.Writer = os.Stdout
+
+// setLogOutput switches the JSON logs to the configured output, for log
+// pipelines that do not read container stdout: a rotating file on a volume
+// shared with a Fluent Bit sidecar, or the Fluent Forward protocol to a
+// Fluent Bit listening in the pod or on the node.
+func setLogOutput(c loggingConfig) {
+	switch c.Output {
+	case logOutputFile:
+		logOutput = &lumberjack.Logger{
+			Filename:   c.File.Path,
+			MaxSize:    c.File.MaxSizeMB,
+			MaxBackups: c.File.MaxBackups,
+			MaxAge:     c.File.MaxAgeDays,
+			Compress:   c.File.Compress,
+		}
+	case logOutputForward:
+		logOutput = newFluentForwardWriter(c.Forward.Address, c.Forward.Tag)
+	default:
+		return
+	}
+	outputLogger = newLogger(newJSONHandler())
+	logger = outputLogger
+}
+
+// closeLogOutput flushes the log output, waiting at most until ctx is done
+func closeLogOutput(ctx context.Context) {
+	switch w := logOutput.(type) {
+	case *lumberjack.Logger:
+		w.Close()
+	case *fluentForwardWriter:
+		w.close(ctx)
+	}
+}
+
+// fluentForwardWriter sends each JSON log line to Fluent Bit or Fluentd as
+// a Forward protocol message, [tag, time, record] in MessagePack. Lines are
+// queued and sent by a single goroutine, so logging never waits on the
+// network; when the collector is unreachable, records are dropped and the
+// gap is reported on stderr once the connection is back.
+type fluentForwardWriter struct {
+	address string
+	tag     string
+	records chan []byte
+	// overflow counts the lines dropped because the queue was full
+	overflow atomic.Int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newFluentForwardWriter(address, tag string) *fluentForwardWriter {
+	f := &fluentForwardWriter{
+		address: address,
+		tag:     tag,
+		records: make(chan []byte, forwardBufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Write queues one log line. The JSON handler writes each record with a
+// single call and reuses p afterwards, so it is copied.
+func (f *fluentForwardWriter) Write(p []byte) (int, error) {
+	select {
+	case f.records <- bytes.Clone(p):
+	default:
+		f.overflow.Add(1)
+	}
+	return len(p), nil
+}
+
+func (f *fluentForwardWriter) run() {
+	defer close(f.done)
+	s := forwardSender{address: f.address}
+	defer s.disconnect()
+	for {
+		select {
+		case line := <-f.records:
+			f.send(&s, line)
+		case <-f.stop:
+			// Flush what is queued, then stop
+			for {
+				select {
+				case line := <-f.records:
+					f.send(&s, line)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (f *fluentForwardWriter) send(s *forwardSender, line []byte) {
+	s.dropped += int(f.overflow.Swap(0))
+	msg, err := encodeForwardMessage(f.tag, line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log forwarding: cannot encode record: %v\n", err)
+		return
+	}
+	s.send(msg)
+}
+
+// close flushes the queued lines, waiting at most until ctx is done. Lines
+// written afterwards are not sent.
+func (f *fluentForwardWriter) close(ctx context.Context) {
+	close(f.stop)
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+	}
+}
+
+// forwardSender owns the connection of a fluentForwardWriter
+type forwardSender struct {
+	address   string
+	conn      net.Conn
+	retryAt   time.Time
+	dropped   int
+	connected bool
+}
+
+func (s *forwardSender) send(msg []byte) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil && !s.connect() {
+			break
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout))
+		if _, err := s.conn.Write(msg); err == nil {
+			return
+		} else if attempt == 1 {
+			s.fail(err)
+		}
+		// The collector may have closed an idle connection; reconnect once
+		s.disconnect()
+	}
+	s.dropped++
+}
+
+func (s *forwardSender) connect() bool {
+	if time.Now().Before(s.retryAt) {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", s.address, forwardDialTimeout)
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	s.conn = conn
+	if s.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "log forwarding to %s resumed, %d records dropped\n", s.address, s.dropped)
+		s.dropped = 0
+	}
+	s.connected = true
+	return true
+}
+
+func (s *forwardSender) fail(err error) {
+	s.disconnect()
+	s.retryAt = time.Now().Add(forwardRetryInterval)
+	// Report the first failure of an outage only
+	if s.connected || s.dropped == 0 {
+		fmt.Fprintf(os.Stderr, "log forwarding to %s failed, dropping records until it is back: %v\n", s.address, err)
+	}
+	s.connected = false
+}
+
+func (s *forwardSender) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// encodeForwardMessage turns a JSON log line into a Forward protocol
+// message in Message Mode. The time is an EventTime, which keeps the
+// nanoseconds that Fluent Bit would otherwise truncate to seconds.
+func encodeForwardMessage(tag string, line []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var record map[string]any
+	if err := dec.Decode(&record); err != nil {
+		return nil, err
+	}
+	normalizeNumbers(record)
+
+	ts := time.Now()
+	if value, ok := record["timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			ts = parsed
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	if err := enc.EncodeArrayLen(3); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(tag); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeExtHeader(0, 8); err != nil {
+		return nil, err
+	}
+	var eventTime [8]byte
+	binary.BigEndian.PutUint32(eventTime[:4], uint32(ts.Unix()))
+	binary.BigEndian.PutUint32(eventTime[4:], uint32(ts.Nanosecond()))
+	buf.Write(eventTime[:])
+	if err := enc.Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeNumbers replaces the json.Numbers of v with int64 or float64, so
+// status codes and durations arrive as integers rather than strings
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = normalizeNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = normalizeNumbers(value)
+		}
+	}
+	return v
+}
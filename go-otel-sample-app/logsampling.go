@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// logSamplerMaxKeys bounds the identical-record counters kept; beyond it,
+// counters that have nothing left to report are forgotten
+const logSamplerMaxKeys = 1000
+
+// logSampler thins out repetitive logs when log sampling is enabled, and
+// is nil otherwise
+var logSampler *logSamplerState
+
+var promLogsDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_dropped_total",
+		Help: "Log records dropped by log sampling",
+	},
+	[]string{"component", "level"},
+)
+
+// logSampleKey identifies identical records: same component, level and
+// message, whatever their attributes
+type logSampleKey struct {
+	component string
+	level     slog.Level
+	message   string
+}
+
+type logSampleCounter struct {
+	start time.Time
+	// seen counts the records since start
+	seen int
+	// suppressed counts the records dropped since the last one logged
+	suppressed int64
+}
+
+// logSamplerState samples each kind of record like zap's sampler: in every
+// interval the first records are logged, then one in thereafter. A logged
+// record carries the number of identical records dropped before it in a
+// log_suppressed attribute, so the volume stays visible.
+type logSamplerState struct {
+	cfg logSamplingConfig
+
+	mu       sync.Mutex
+	counters map[logSampleKey]*logSampleCounter
+	dropped  map[logSampleKey]int64
+}
+
+func newLogSampler(c logSamplingConfig) *logSamplerState {
+	if !c.Enabled {
+		return nil
+	}
+	return &logSamplerState{
+		cfg:      c,
+		counters: make(map[logSampleKey]*logSampleCounter),
+		dropped:  make(map[logSampleKey]int64),
+	}
+}
+
+// sample reports whether r is logged, and if so how many identical records
+// were dropped before it. Records of components not sampled always are.
+func (s *logSamplerState) sample(component string, r slog.Record) (bool, int64) {
+	if s == nil || !slices.Contains(s.cfg.Components, component) {
+		return true, 0
+	}
+	key := logSampleKey{component: component, level: r.Level, message: r.Message}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		if len(s.counters) >= logSamplerMaxKeys {
+			s.prune(r.Time)
+		}
+		c = &logSampleCounter{start: r.Time}
+		s.counters[key] = c
+	}
+	if r.Time.Sub(c.start) >= s.cfg.Interval {
+		c.start = r.Time
+		c.seen = 0
+	}
+	c.seen++
+	if c.seen <= s.cfg.First || (c.seen-s.cfg.First)%s.cfg.Thereafter == 0 {
+		suppressed := c.suppressed
+		c.suppressed = 0
+		return true, suppressed
+	}
+	c.suppressed++
+	s.dropped[key]++
+	promLogsDropped.WithLabelValues(component, levelName(r.Level)).Inc()
+	return false, 0
+}
+
+// prune forgets the counters of past intervals with no dropped records to
+// report
+func (s *logSamplerState) prune(now time.Time) {
+	for key, c := range s.counters {
+		if c.suppressed == 0 && now.Sub(c.start) >= s.cfg.Interval {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// observe reports the dropped records by component and level
+func (s *logSamplerState) observe(o metric.Int64Observer) {
+	totals := make(map[[2]string]int64)
+	s.mu.Lock()
+	for key, n := range s.dropped {
+		totals[[2]string{key.component, levelName(key.level)}] += n
+	}
+	s.mu.Unlock()
+	for labels, n := range totals {
+		o.Observe(n, metric.WithAttributes(
+			attribute.String("component", labels[0]),
+			attribute.String("level", labels[1]),
+		))
+	}
+}
+
+// registerLogSamplingMetrics counts the records dropped by log sampling in
+// logs_dropped_total
+func registerLogSamplingMetrics() error {
+	if logSampler == nil {
+		return nil
+	}
+	if _, err := meter.Int64ObservableCounter("logs_dropped_total",
+		metric.WithDescription("Log records dropped by log sampling, by component and level"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			logSampler.observe(o)
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promLogsDropped)
+	}
+	return nil
+}
+
+// samplingHandler drops the records that the log sampler rejects, before
+// they reach any log path, and adds log_suppressed to the others. The
+// records of debug trace requests are all kept.
+type samplingHandler struct {
+	next      slog.Handler
+	component string
+}
+
+func (h samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if debugTraceForced(ctx) {
+		return h.next.Handle(ctx, r)
+	}
+	keep, suppressed := logSampler.sample(h.component, r)
+	if !keep {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int64("log_suppressed", suppressed))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return samplingHandler{next: h.next.WithAttrs(attrs), component: componentOf(attrs, h.component)}
+}
+
+func (h samplingHandler) WithGroup(name string) slog.Handler {
+	return samplingHandler{next: h.next.WithGroup(name), component: h.component}
+}
@@ -2,33 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"os"
-
+	"os/signal"
+	"slices"
+	"strconv"
+	"syscall"
 	"time"
-	"encoding/json"
-	"sync/atomic"
 
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	otelpyroscope "github.com/grafana/otel-profiling-go"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/log/global"
-	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
-	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/codes"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel/trace"
-	"runtime"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+
+	"go-otel-sample-app/pkg/telemetry"
 )
 
 var (
@@ -36,77 +35,110 @@ var (
 	meter          metric.Meter
 	requestCounter metric.Int64Counter
 	requestLatency metric.Float64Histogram
-	activeUsers    metric.Int64UpDownCounter
-	// Counters for tracking actual requests
-	healthRequests int64
-	apiRequests    int64
-	metricsRequests int64
-	errorRequests  int64
+	requestSize    metric.Int64Histogram
+	responseSize   metric.Int64Histogram
 )
 
-func initTelemetry() func() {
+func initTelemetry(cfg *config) func(context.Context) error {
 	ctx := context.Background()
 
-	// Create resource
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			attribute.String("service.name", "go-otel-sample-app"),
-			attribute.String("service.version", "1.0.0"),
-			attribute.String("environment", getEnv("ENVIRONMENT", "development")),
-		),
-	)
+	resourceOpts, err := resourceOptions()
 	if err != nil {
-		log.Fatal("Failed to create resource:", err)
+		fatal("Failed to create resource", err)
 	}
-
-	// Setup tracing
-	traceExporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "localhost:4317")),
-		otlptracegrpc.WithInsecure(),
-	)
+	propagator, err := newPropagator()
 	if err != nil {
-		log.Fatal("Failed to create trace exporter:", err)
+		fatal("Failed to configure propagators", err)
 	}
 
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
-		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tracerProvider)
+	tracerOptions := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(activeSampler),
+		sdktrace.WithSpanProcessor(baggageSpanProcessor{keys: cfg.Baggage.SpanKeys}),
+		sdktrace.WithRawSpanLimits(sdktrace.SpanLimits(cfg.Export.SpanLimits)),
+		sdktrace.WithSpanProcessor(spanLimitProcessor{}),
+	}
+	if useXRayIDs() {
+		tracerOptions = append(tracerOptions, sdktrace.WithIDGenerator(xray.NewIDGenerator()))
+	}
 
-	// Setup metrics
-	metricExporter, err := otlpmetricgrpc.New(ctx,
-		otlpmetricgrpc.WithEndpoint(getEnv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "localhost:4317")),
-		otlpmetricgrpc.WithInsecure(),
-	)
-	if err != nil {
-		log.Fatal("Failed to create metric exporter:", err)
+	meterOptions := []sdkmetric.Option{sdkmetric.WithView(metricViews()...)}
+	// Optionally expose the same OTel instruments on /metrics by attaching
+	// the Prometheus exporter as a second reader on the shared registry
+	if prometheusBridge {
+		promExporter, err := otelprom.New(
+			otelprom.WithRegisterer(promRegistry),
+			otelprom.WithoutScopeInfo(),
+		)
+		if err != nil {
+			fatal("Failed to create Prometheus exporter", err)
+		}
+		meterOptions = append(meterOptions, sdkmetric.WithReader(promExporter))
 	}
 
-	meterProvider := sdkmetric.NewMeterProvider(
-		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
-		sdkmetric.WithResource(res),
-	)
-	otel.SetMeterProvider(meterProvider)
+	// OTEL_METRICS_EXPORTER=none, honoured by telemetry.New, leaves metrics
+	// to /metrics and remote write, for clusters without a collector
+	telemetryOpts := []telemetry.Option{
+		telemetry.WithServiceName(serviceName),
+		telemetry.WithServiceVersion(serviceVersion),
+		telemetry.WithResourceOptions(resourceOpts...),
+		telemetry.WithExporter(cfg.Export.Exporter),
+		telemetry.WithRetry(telemetry.RetryConfig(cfg.Export.Retry)),
+		telemetry.WithSpanBatch(telemetry.BatchConfig(cfg.Export.SpanBatch)),
+		telemetry.WithLogBatch(telemetry.BatchConfig(cfg.Export.LogBatch)),
+		telemetry.WithReader(telemetry.ReaderConfig(cfg.Export.MetricReader)),
+		telemetry.WithPropagator(propagator),
+		telemetry.WithLogger(telemetryLogger),
+		telemetry.WithTracerProviderOptions(tracerOptions...),
+		telemetry.WithMeterProviderOptions(meterOptions...),
+	}
+	// Signals left out of export.signals keep the global no-op providers,
+	// which record nothing, so the overhead of each signal can be measured
+	for name, without := range map[string]telemetry.Option{
+		"traces":  telemetry.WithoutTraces(),
+		"metrics": telemetry.WithoutMetrics(),
+		"logs":    telemetry.WithoutLogs(),
+	} {
+		if !slices.Contains(cfg.Export.Signals, name) {
+			telemetryOpts = append(telemetryOpts, without)
+		}
+	}
+	for _, target := range cfg.Export.Targets {
+		telemetryOpts = append(telemetryOpts, telemetry.WithOTLPTarget(telemetry.OTLPTarget(target)))
+	}
+	if spool := cfg.Export.Spool; spool.Dir != "" {
+		telemetryOpts = append(telemetryOpts, telemetry.WithSpool(spool.Dir, int64(spool.MaxSizeMB)<<20))
+	}
+	if activeRedactor != nil {
+		telemetryOpts = append(telemetryOpts, telemetry.WithSpanRedactor(activeRedactor.attribute))
+	}
+	tel, err := telemetry.New(ctx, telemetryOpts...)
+	if err != nil {
+		fatal("Failed to initialize telemetry", err)
+	}
+	serviceName = tel.ServiceName
+	if !prometheusBridge {
+		registerPipelineMetrics(tel)
+	}
 
-	// Setup logs
-	logExporter, err := otlploggrpc.New(ctx,
-		otlploggrpc.WithEndpoint(getEnv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", "localhost:4317")),
-		otlploggrpc.WithInsecure(),
-	)
+	// Continuous profiling, the fourth signal. The wrapped provider labels
+	// CPU samples with the local root span ID and stamps that span with
+	// pyroscope.profile.id, so a trace links to the profile of its request.
+	profiler, err := startProfiler(cfg.Profiling, tel.Resource)
 	if err != nil {
-		log.Fatal("Failed to create log exporter:", err)
+		fatal("Failed to start profiler", err)
+	}
+	if profiler != nil && tel.TracerProvider != nil {
+		otel.SetTracerProvider(otelpyroscope.NewTracerProvider(tel.TracerProvider))
 	}
 
-	loggerProvider := sdklog.NewLoggerProvider(
-		sdklog.WithResource(res),
-		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
-	)
-	global.SetLoggerProvider(loggerProvider)
+	if err := startRuntimeMetrics(); err != nil {
+		fatal("Failed to start runtime metrics", err)
+	}
+	initLogging()
 
 	// Create tracer and meter
-	tracer = otel.Tracer("go-otel-sample-app")
-	meter = otel.Meter("go-otel-sample-app")
+	tracer = tel.Tracer("go-otel-sample-app", trace.WithSchemaURL(semconv.SchemaURL))
+	meter = tel.Meter("go-otel-sample-app", metric.WithSchemaURL(semconv.SchemaURL))
 
 	// Create metrics
 	requestCounter, _ = meter.Int64Counter(
@@ -119,203 +151,187 @@ func initTelemetry() func() {
 		metric.WithDescription("HTTP request latency in seconds"),
 	)
 
-	activeUsers, _ = meter.Int64UpDownCounter(
-		"active_users",
-		metric.WithDescription("Number of active users"),
+	requestSize, _ = meter.Int64Histogram(
+		"http_request_size_bytes",
+		metric.WithDescription("HTTP request body size in bytes"),
+		metric.WithUnit("By"),
+	)
+
+	responseSize, _ = meter.Int64Histogram(
+		"http_response_size_bytes",
+		metric.WithDescription("HTTP response body size in bytes"),
+		metric.WithUnit("By"),
 	)
 
-	return func() {
-		tracerProvider.Shutdown(ctx)
-		meterProvider.Shutdown(ctx)
-		loggerProvider.Shutdown(ctx)
+	telemetryReady.Store(true)
+
+	return func(ctx context.Context) error {
+		err := tel.Shutdown(ctx)
+		if profiler != nil {
+			err = errors.Join(err, profiler.Stop())
+		}
+		return err
+	}
+}
+
+// registerPipelineMetrics mirrors the telemetry package's pipeline and
+// spool metrics on /metrics: the OTLP metric exporter may be the part that
+// is down. The pipelines are fixed once telemetry.New returns, so each is
+// looked up by its position.
+func registerPipelineMetrics(tel *telemetry.Telemetry) {
+	for i, s := range tel.PipelineStats() {
+		labels := prometheus.Labels{"signal": s.Signal, "exporter": s.Exporter}
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "telemetry_exporter_up",
+			Help:        "Whether an exporter of a signal exists and its last export succeeded (1) or not (0)",
+			ConstLabels: labels,
+		}, func() float64 {
+			if tel.PipelineStats()[i].Up {
+				return 1
+			}
+			return 0
+		}))
+		promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "telemetry_export_failures_total",
+			Help:        "Exports that failed after the exporter's retries by signal and exporter",
+			ConstLabels: labels,
+		}, func() float64 { return float64(tel.PipelineStats()[i].ExportFailures) }))
+		reasons := map[string]func(telemetry.PipelineStats) uint64{
+			"export_failed": func(s telemetry.PipelineStats) uint64 { return s.ExportDropped },
+		}
+		// Metrics don't go through a batch queue
+		if s.Signal != "metrics" {
+			reasons["queue_full"] = func(s telemetry.PipelineStats) uint64 { return s.QueueDropped }
+		}
+		for reason, value := range reasons {
+			promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "telemetry_dropped_total",
+				Help:        "Spans, data points and log records lost by the telemetry pipeline by signal, exporter and reason",
+				ConstLabels: prometheus.Labels{"signal": s.Signal, "exporter": s.Exporter, "reason": reason},
+			}, func() float64 { return float64(value(tel.PipelineStats()[i])) }))
+		}
+	}
+
+	for signal := range tel.SpoolStats() {
+		labels := prometheus.Labels{"signal": signal}
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "telemetry_spool_bytes",
+			Help:        "Size of the batches waiting in the spool by signal",
+			ConstLabels: labels,
+		}, func() float64 { return float64(tel.SpoolStats()[signal].Bytes) }))
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "telemetry_spool_batches",
+			Help:        "Batches waiting in the spool by signal",
+			ConstLabels: labels,
+		}, func() float64 { return float64(tel.SpoolStats()[signal].Batches) }))
+		results := map[string]func(telemetry.SpoolStats) uint64{
+			"spooled":  func(s telemetry.SpoolStats) uint64 { return s.Spooled },
+			"replayed": func(s telemetry.SpoolStats) uint64 { return s.Replayed },
+			"full":     func(s telemetry.SpoolStats) uint64 { return s.Full },
+			"rejected": func(s telemetry.SpoolStats) uint64 { return s.Rejected },
+		}
+		for result, value := range results {
+			promRegistry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "telemetry_spool_batches_total",
+				Help:        "Batches spooled, replayed, refused by the full spool or rejected on replay by signal and result",
+				ConstLabels: prometheus.Labels{"signal": signal, "result": result},
+			}, func() float64 { return float64(value(tel.SpoolStats()[signal])) }))
+		}
 	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := tracer.Start(r.Context(), "health_check")
+	_, span := tracer.Start(r.Context(), "health_check")
 	defer span.End()
 
-	start := time.Now()
-	
-	// Log the request
-	logData, _ := json.Marshal(map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"level": "info",
-		"message": "Health check requested",
-		"endpoint": "/health",
-		"method": r.Method,
-		"trace_id": span.SpanContext().TraceID().String(),
-	})
-	log.Printf("%s", logData)
-	
-
-	
-	requestCounter.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/health"),
-		attribute.String("status", "200"),
-	))
-	
-	// Increment actual counter
-	atomic.AddInt64(&healthRequests, 1)
-
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, `{"status": "healthy", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
-
-	duration := time.Since(start).Seconds()
-	requestLatency.Record(ctx, duration, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/health"),
-	))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
 }
 
 func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, span := tracer.Start(r.Context(), "get_metrics")
+	_, span := tracer.Start(r.Context(), "get_metrics")
 	defer span.End()
 
-	start := time.Now()
-	
-	// Log the metrics request
-	logData, _ := json.Marshal(map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"level": "info",
-		"message": "Metrics endpoint accessed",
-		"endpoint": "/metrics",
-		"method": r.Method,
-		"trace_id": span.SpanContext().TraceID().String(),
-	})
-	log.Printf("%s", logData)
-
-	requestCounter.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/metrics"),
-		attribute.String("status", "200"),
-	))
-	
-	// Increment actual counter
-	atomic.AddInt64(&metricsRequests, 1)
-
-	// Simulate some business metrics
-	users := rand.Intn(100) + 50
-	activeUsers.Add(ctx, int64(users), metric.WithAttributes(
-		attribute.String("region", "us-west-2"),
-	))
-
-	// Return Prometheus format metrics with actual counters
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	
-	// Get current counter values
-	healthCount := atomic.LoadInt64(&healthRequests)
-	apiCount := atomic.LoadInt64(&apiRequests)
-	metricsCount := atomic.LoadInt64(&metricsRequests)
-	errorCount := atomic.LoadInt64(&errorRequests)
-	
-	// Get system metrics
-	cpuPercent, _ := cpu.Percent(0, false)
-	memStats := &runtime.MemStats{}
-	runtime.ReadMemStats(memStats)
-	vmem, _ := mem.VirtualMemory()
-	
-	fmt.Fprintf(w, `# HELP http_requests_total Total HTTP requests
-# TYPE http_requests_total counter
-http_requests_total{method="GET",endpoint="/health",status="200"} %d
-http_requests_total{method="GET",endpoint="/api",status="200"} %d
-http_requests_total{method="GET",endpoint="/api",status="500"} %d
-http_requests_total{method="GET",endpoint="/metrics",status="200"} %d
-
-# HELP active_users Active users
-# TYPE active_users gauge
-active_users{region="us-west-2"} %d
-
-# HELP go_cpu_usage_percent CPU usage percentage
-# TYPE go_cpu_usage_percent gauge
-go_cpu_usage_percent{app="go-otel-sample-app"} %.2f
-
-# HELP go_memory_usage_bytes Memory usage in bytes
-# TYPE go_memory_usage_bytes gauge
-go_memory_usage_bytes{app="go-otel-sample-app"} %d
-
-# HELP go_memory_usage_percent Memory usage percentage
-# TYPE go_memory_usage_percent gauge
-go_memory_usage_percent{app="go-otel-sample-app"} %.2f
-`, healthCount, apiCount, errorCount, metricsCount, users, cpuPercent[0], memStats.Alloc, vmem.UsedPercent)
-
-	duration := time.Since(start).Seconds()
-	requestLatency.Record(ctx, duration, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/metrics"),
-	))
+	// Exemplars are only part of the OpenMetrics format, which Prometheus
+	// and the ADOT collector negotiate through the Accept header
+	promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}).ServeHTTP(w, r)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
 }
 
 func apiHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tracer.Start(r.Context(), "api_request")
 	defer span.End()
 
-	start := time.Now()
-	
-	// Log the request
-	logData, _ := json.Marshal(map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"level": "info",
-		"message": "API request received",
-		"endpoint": "/api",
-		"method": r.Method,
-		"trace_id": span.SpanContext().TraceID().String(),
-	})
-	log.Printf("%s", logData)
-
-	// Simulate some processing time
-	time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
-
-	status := "200"
-	if rand.Float32() < 0.1 { // 10% error rate
-		status = "500"
-		// Log error
-		errorData, _ := json.Marshal(map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
-			"level": "error",
-			"message": "Internal server error occurred",
-			"endpoint": "/api",
-			"status_code": 500,
-			"trace_id": span.SpanContext().TraceID().String(),
-		})
-		log.Printf("%s", errorData)
-		// Increment error counter
-		atomic.AddInt64(&errorRequests, 1)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, `{"error": "Internal server error"}`)
+	log := requestLogger(r, "/api")
+
+	// Simulate some processing time; latency and errors on top of it come
+	// from the chaos rules
+	simulateWork(ctx)
+
+	// The persisted record and events are keyed by the request ID, so they
+	// can be found from a customer report as well as from the trace
+	requestID := requestIDFromContext(ctx)
+	code := http.StatusOK
+	var message string
+	if err := callDownstreams(ctx); err != nil {
+		// A short-circuited downstream is known to be down: the app is
+		// unavailable rather than given a bad answer
+		code, message = http.StatusBadGateway, "Bad gateway"
+		if errors.Is(err, errBreakerOpen) {
+			code, message = http.StatusServiceUnavailable, "Service unavailable"
+		}
+		failSpan(span, err, "downstream call failed")
+		log.ErrorContext(ctx, "Downstream call failed",
+			"status_code", code,
+			"error", err,
+		)
+		writeError(ctx, w, code, message)
+	} else if err := persistRequest(ctx, requestID); err != nil {
+		code = http.StatusInternalServerError
+		failSpan(span, err, "persisting request failed")
+		log.ErrorContext(ctx, "Failed to persist request",
+			"status_code", code,
+			"error", err,
+		)
+		writeError(ctx, w, code, "Internal server error")
+	} else if err := publishRequest(ctx, requestID); err != nil {
+		code = http.StatusInternalServerError
+		failSpan(span, err, "publishing request failed")
+		log.ErrorContext(ctx, "Failed to publish request",
+			"status_code", code,
+			"error", err,
+		)
+		writeError(ctx, w, code, "Internal server error")
+	} else if err := produceRequest(ctx, requestID); err != nil {
+		code = http.StatusInternalServerError
+		failSpan(span, err, "producing request event failed")
+		log.ErrorContext(ctx, "Failed to produce request event",
+			"status_code", code,
+			"error", err,
+		)
+		writeError(ctx, w, code, "Internal server error")
 	} else {
-		// Log success
-		successData, _ := json.Marshal(map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
-			"level": "info",
-			"message": "API request processed successfully",
-			"endpoint": "/api",
-			"status_code": 200,
-			"trace_id": span.SpanContext().TraceID().String(),
-		})
-		log.Printf("%s", successData)
-		// Increment API counter
-		atomic.AddInt64(&apiRequests, 1)
+		submitTask(ctx)
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{
 			"message": "Hello from Go OTEL app!",
 			"request_id": "%s",
 			"timestamp": "%s"
-		}`, span.SpanContext().TraceID().String(), time.Now().Format(time.RFC3339))
+		}`, requestID, time.Now().Format(time.RFC3339))
 	}
 
-	requestCounter.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/api"),
-		attribute.String("status", status),
-	))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+}
 
-	duration := time.Since(start).Seconds()
-	requestLatency.Record(ctx, duration, metric.WithAttributes(
-		attribute.String("method", r.Method),
-		attribute.String("endpoint", "/api"),
-	))
+// failSpan records err on span and marks it failed, so the trace shows up
+// as an error in X-Ray and Jaeger instead of only through its status code
+func failSpan(span trace.Span, err error, description string) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, description)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -325,65 +341,523 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// Background log generator
-func generateBackgroundLogs() {
-	for {
-		time.Sleep(time.Duration(rand.Intn(10)+5) * time.Second)
-		
-		logTypes := []string{"info", "warning", "error"}
-		messages := []string{
-			"Background task completed",
-			"Database connection pool status check",
-			"Cache cleanup operation",
-			"Memory usage within normal range",
-			"High CPU usage detected",
-			"Slow query detected",
-			"Connection timeout occurred",
-		}
-		
-		logType := logTypes[rand.Intn(len(logTypes))]
-		message := messages[rand.Intn(len(messages))]
-		
-		logData, _ := json.Marshal(map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
-			"level": logType,
-			"message": message,
-			"service": "go-otel-sample-app",
-			"background_task": true,
-		})
-		log.Printf("%s", logData)
+// getEnvDuration parses a Go duration string (e.g. "30s") from the
+// environment, falling back to defaultValue when unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
 	}
+	return defaultValue
+}
+
+// getEnvBool parses a boolean ("true", "1", "false", ...) from the
+// environment, falling back to defaultValue when unset or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getEnvInt parses an integer from the environment, falling back to
+// defaultValue when unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvMillis parses a number of milliseconds, the unit of the OTel SDK's
+// variables, from the environment, falling back to defaultValue when unset
+// or invalid
+func getEnvMillis(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return time.Duration(n) * time.Millisecond
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
 }
 
 func main() {
-	shutdown := initTelemetry()
-	defer shutdown()
-	
-	// Start background log generation
-	go generateBackgroundLogs()
+	runCLI(os.Args[1:])
+}
+
+// serve runs the app until it receives SIGINT or SIGTERM
+func serve(configFile string) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	setLogOutput(cfg.Logging)
+	logSampler = newLogSampler(cfg.Logging.Sampling)
+	traceLinker = newTraceLinks(cfg.Logging.TraceLinks)
+	activeRedactor, _ = newRedactor(cfg.Redaction)
+	cfg.applyReloadable(nil)
+
+	prometheusBridge = cfg.Metrics.PrometheusBridge
+	histogramBuckets = cfg.Metrics.HistogramBuckets
+	attributeLimiters = newAttributeLimiters(cfg.Metrics.AttributeLimits)
+	baggageMetricKeys = cfg.Baggage.MetricKeys
+	loadDownstreams(cfg.Downstream)
+	serviceName = cfg.Role.serviceName()
+
+	shutdownTelemetry := initTelemetry(cfg)
+	initPrometheus()
+	if err := registerSystemMetrics(); err != nil {
+		fatal("Failed to register system metrics", err)
+	}
+	if err := registerBuildInfo(); err != nil {
+		fatal("Failed to register build info", err)
+	}
+	if err := registerLogSamplingMetrics(); err != nil {
+		fatal("Failed to register log sampling metrics", err)
+	}
+	if err := registerSpanLimitMetrics(); err != nil {
+		fatal("Failed to register span limit metrics", err)
+	}
+	if err := registerCardinalityMetrics(); err != nil {
+		fatal("Failed to register cardinality metrics", err)
+	}
+	if err := registerCompressionMetrics(); err != nil {
+		fatal("Failed to register compression metrics", err)
+	}
+	if err := registerClientTraceMetrics(); err != nil {
+		fatal("Failed to register outbound call metrics", err)
+	}
+	if err := downstreamPool.register(); err != nil {
+		fatal("Failed to register client connection pool metrics", err)
+	}
+	if err := registerBreakerMetrics(); err != nil {
+		fatal("Failed to register circuit breaker metrics", err)
+	}
+	if err := registerRetryMetrics(); err != nil {
+		fatal("Failed to register retry metrics", err)
+	}
+	sessions, err := newSessionSimulator(cfg.Simulation.Sessions)
+	if err != nil {
+		fatal("Failed to start session simulation", err)
+	}
+	scenario, err = newScenarioEngine(cfg.Simulation.Scenario)
+	if err != nil {
+		fatal("Failed to start traffic scenario", err)
+	}
+	if err := registerCgroupMetrics(); err != nil {
+		fatal("Failed to register container metrics", err)
+	}
+	if err := initSLOs(cfg.SLO); err != nil {
+		fatal("Failed to register SLO metrics", err)
+	}
+
+	// AWS integrations share one SDK configuration and credential chain
+	var remoteWrite *remoteWriter
+	var awsDemo *awsDemoAPI
+	if cfg.DynamoDB.Table != "" || cfg.SQS.QueueURL != "" || cfg.Metrics.RemoteWrite.URL != "" || cfg.SNS.TopicARN != "" || cfg.AWSDemo.Enabled {
+		awsCfg, err := loadAWSConfig(context.Background())
+		if err != nil {
+			fatal("Failed to load AWS configuration", err)
+		}
+		if cfg.DynamoDB.Table != "" {
+			requestTable = newDynamoRequestTable(awsCfg, cfg.DynamoDB.Table)
+		}
+		if cfg.SQS.QueueURL != "" {
+			requestQueue, err = newSQSQueue(awsCfg, cfg.SQS.QueueURL)
+			if err != nil {
+				fatal("Failed to configure SQS client", err)
+			}
+		}
+		if cfg.SNS.TopicARN != "" {
+			orderTopic, err = newSNSTopic(awsCfg, cfg.SNS.TopicARN)
+			if err != nil {
+				fatal("Failed to configure SNS client", err)
+			}
+		}
+		if cfg.Metrics.RemoteWrite.URL != "" {
+			remoteWrite, err = newRemoteWriter(awsCfg, cfg.Metrics.RemoteWrite, promRegistry)
+			if err != nil {
+				fatal("Failed to configure Prometheus remote write", err)
+			}
+		}
+		if cfg.AWSDemo.Enabled {
+			awsDemo = newAWSDemoAPI(awsCfg, cfg.AWSDemo)
+		}
+	}
+
+	if len(cfg.Kafka.Brokers) > 0 {
+		requestEvents, err = newKafkaEvents(cfg.Kafka.Brokers, cfg.Kafka.Topic, cfg.Kafka.GroupID, cfg.Kafka.TLS, cfg.Role.consumes())
+		if err != nil {
+			fatal("Failed to configure Kafka client", err)
+		}
+	}
+
+	if cfg.Redis.URL != "" {
+		apiCache, err = newRedisCache(context.Background(), cfg.Redis.URL, cfg.Redis.CacheTTL)
+		if err != nil {
+			fatal("Failed to connect to Redis", err)
+		}
+		// Not a readiness check: the cache is optional and /api keeps working
+		// without it
+		defer apiCache.close()
+	}
+
+	// Orders live in PostgreSQL when DATABASE_URL is set, in memory otherwise
+	var store orderStore = newMemoryOrderStore()
+	if cfg.Database.URL != "" {
+		pgStore, err := newPostgresOrderStore(context.Background(), cfg.Database)
+		if err != nil {
+			fatal("Failed to connect to PostgreSQL", err)
+		}
+		defer pgStore.close()
+		registerReadinessCheck("postgres", pgStore.ping)
+		store = pgStore
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/metrics", metricsHandler)
-	mux.HandleFunc("/api", apiHandler)
+	// With an admin port, /metrics, pprof and the /admin endpoints move to
+	// a mux of their own. The probes and /version stay on both, for load
+	// balancer health checks on the traffic port.
+	adminMux := mux
+	if cfg.Server.Admin.Port != "" {
+		adminMux = http.NewServeMux()
+		registerPprof(adminMux)
+		registerProbes(adminMux)
+		adminMux.HandleFunc("GET /version", versionHandler)
+	}
+	registerProbes(mux)
+	mux.HandleFunc("GET /version", versionHandler)
+	registerDocs(mux)
+	adminMux.HandleFunc("/metrics", metricsHandler)
+	if cfg.Role.servesAPI() {
+		mux.HandleFunc("/api", apiHandler)
+		if err := registerFanout(mux, cfg.Fanout); err != nil {
+			fatal("Failed to register fan-out endpoint", err)
+		}
+		syntheticAPI{cfg: cfg.Synthetic}.register(mux)
+		uploadAPI{maxBytes: cfg.Synthetic.MaxUploadBytes}.register(mux)
+		oversizedAPI{limits: cfg.Export.SpanLimits}.register(mux)
+		users, err := newUsersAPI()
+		if err != nil {
+			fatal("Failed to register users API", err)
+		}
+		users.register(mux)
+		if awsDemo != nil {
+			awsDemo.register(mux)
+		}
+	}
+	asyncJobAPI, err := newAsyncJobs(cfg.AsyncJobs)
+	if err != nil {
+		fatal("Failed to register async job API", err)
+	}
+	if cfg.Role.servesAPI() {
+		asyncJobAPI.register(mux)
+	}
+	if cfg.Role.servesOrders() {
+		mux.HandleFunc("/dependency", dependencyHandler)
+		ordersAPI{store: store}.register(mux)
+	}
+	if err := registerChaosAdmin(adminMux); err != nil {
+		fatal("Failed to register chaos admin API", err)
+	}
+	if err := registerFlagAdmin(adminMux); err != nil {
+		fatal("Failed to register feature flag admin API", err)
+	}
+	if err := registerLeakAdmin(adminMux, cfg.Simulation); err != nil {
+		fatal("Failed to register leak admin API", err)
+	}
+	if err := registerDrillAdmin(adminMux); err != nil {
+		fatal("Failed to register alert drill admin API", err)
+	}
+	registerLogLevelAdmin(adminMux)
+	registerConfigDebug(adminMux, configFile, cfg)
+	if err := registerPanicAdmin(adminMux); err != nil {
+		fatal("Failed to register panic admin API", err)
+	}
+	if err := registerBurnAdmin(adminMux); err != nil {
+		fatal("Failed to register CPU burn admin API", err)
+	}
+	tasks, err = newTaskPool(cfg.Tasks)
+	if err != nil {
+		fatal("Failed to create worker pool", err)
+	}
+	registerTaskAdmin(adminMux)
+	wsHub, err := registerWebSocket(mux, cfg.WebSocket)
+	if err != nil {
+		fatal("Failed to register WebSocket endpoint", err)
+	}
+	events, err := registerEventStream(mux, cfg.SSE)
+	if err != nil {
+		fatal("Failed to register event stream endpoint", err)
+	}
+
+	limiter, err := newRateLimiter(cfg.RateLimit)
+	if err != nil {
+		fatal("Failed to configure rate limiting", err)
+	}
+	bulkhead, err := newBulkhead(cfg.Concurrency)
+	if err != nil {
+		fatal("Failed to configure concurrency limits", err)
+	}
+	timeouts, err := newTimeoutLimiter(cfg.Server.RequestTimeout)
+	if err != nil {
+		fatal("Failed to configure request timeouts", err)
+	}
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		fatal("Failed to load OpenAPI spec", err)
+	}
+	validator, err := newOpenAPIValidator(spec, cfg.Server.OpenAPI)
+	if err != nil {
+		fatal("Failed to configure OpenAPI validation", err)
+	}
+
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		fatal("Failed to configure authentication", err)
+	}
+	tenants := newTenantResolver(cfg.Tenancy)
+
+	// The middleware around the mux, outermost first:
+	//   - baggage, complete before requests and faults are counted
+	//   - request ID, in the access log and the error bodies of every layer below
+	//   - compression, outside the access log and the RED metrics, which see
+	//     the uncompressed responses
+	//   - access log
+	//   - RED metrics, which count the rejections of the layers below them
+	//   - panic recovery, so panics count as 500s
+	//   - rate limiting, first to reject, so it also slows down guessing
+	//     credentials
+	//   - concurrency limits, shedding load before any work is spent on it
+	//   - authentication
+	//   - tenants, unknown ones rejected once authenticated
+	//   - request timeouts, which include the injected latency, so it can
+	//     push requests past them
+	//   - OpenAPI validation, turning away requests before any fault is
+	//     injected into them
+	//   - fault injection
+	// The OTEL HTTP instrumentation wraps them all, so injected latency and
+	// errors show up in the server spans.
+	layers := []func(http.Handler) http.Handler{
+		func(next http.Handler) http.Handler { return baggageMiddleware(next, cfg.Baggage.SpanKeys, tenants) },
+		requestIDMiddleware,
+		func(next http.Handler) http.Handler { return compressionMiddleware(next, cfg.Server.Compression) },
+		func(next http.Handler) http.Handler { return accessLogMiddleware(next, cfg.Logging.Access) },
+		redMiddleware,
+		recoverMiddleware,
+		limiter.middleware,
+		bulkhead.middleware,
+		auth.middleware,
+		tenants.middleware,
+		timeouts.middleware,
+		validator.middleware,
+	}
+	handler := chaosMiddleware(mux)
+	for i := len(layers) - 1; i >= 0; i-- {
+		handler = layers[i](handler)
+	}
+	handler = newServerHandler(mux, handler)
+	// X-Debug-Trace is read before otelhttp, whose sampler decides on the
+	// server span
+	if cfg.Server.DebugTraceHeader {
+		handler = debugTraceMiddleware(handler)
+	}
+	traceResponse = cfg.Server.TraceResponse
+
+	port := cfg.Server.Port
+	conns := newConnTracker()
+	if err := conns.register(); err != nil {
+		fatal("Failed to register connection metrics", err)
+	}
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ConnState:         conns.hook,
+		// Timeouts and malformed requests are otherwise reported on stderr
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	server.RegisterOnShutdown(wsHub.shutdown)
+	server.RegisterOnShutdown(events.shutdown)
+	server.TLSConfig, err = newServerTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		fatal("Failed to configure TLS", err)
+	}
+
+	grpcPort := cfg.Server.GRPCPort
+	grpcSrv := newGRPCServer(store)
+	// pprof is served on the admin port when there is one
+	internalServer := newPprofServer(cfg.Server.PprofPort)
+	if cfg.Server.Admin.Port != "" {
+		internalServer = newAdminServer(cfg.Server.Admin, cfg.Logging.Access, adminMux)
+	}
+
+	// Kubernetes sends SIGTERM on pod termination; SIGINT covers local runs
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Wrap with OTEL HTTP instrumentation
-	handler := otelhttp.NewHandler(mux, "go-otel-sample-app")
+	if configFile != "" {
+		if err := watchConfig(ctx, configFile, cfg); err != nil {
+			fatal("Failed to watch configuration file", err)
+		}
+	}
 
-	port := getEnv("PORT", "8080")
-	
 	// Log application startup
-	startupData, _ := json.Marshal(map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"level": "info",
-		"message": "Go OTEL sample app starting",
-		"port": port,
-		"service": "go-otel-sample-app",
-		"version": "1.0.0",
-	})
-	log.Printf("%s", startupData)
-	
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatal("Server failed to start:", err)
-	}
-}
\ No newline at end of file
+	logger.Info("Go OTEL sample app starting",
+		"port", port,
+		"grpc_port", grpcPort,
+		"pprof_port", cfg.Server.PprofPort,
+		"admin_port", cfg.Server.Admin.Port,
+		"config_file", configFile,
+		"tls", server.TLSConfig != nil,
+		"service", serviceName,
+		"role", cfg.Role,
+		"version", serviceVersion,
+		"git_commit", build.shortCommit(),
+		"go_version", build.GoVersion,
+	)
+
+	// Workers stop polling on the shutdown signal and finish the messages
+	// they already received
+	if requestQueue != nil && cfg.Role.consumes() {
+		requestQueue.startWorkers(ctx, cfg.SQS.Workers)
+	}
+	if requestEvents != nil {
+		requestEvents.start(ctx)
+	}
+	if remoteWrite != nil {
+		remoteWrite.start(ctx)
+	}
+	if sessions != nil {
+		sessions.start(ctx)
+	}
+	scheduledJobs := cfg.Jobs
+	if !cfg.Role.consumes() {
+		scheduledJobs = nil
+	}
+	jobs, err := newScheduler(ctx, scheduledJobs)
+	if err != nil {
+		fatal("Failed to schedule jobs", err)
+	}
+	jobs.start()
+	tasks.start()
+
+	serverErr := make(chan error, 3)
+	go func() {
+		if server.TLSConfig != nil {
+			// The certificate is already in TLSConfig
+			serverErr <- server.ListenAndServeTLS("", "")
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+	if cfg.Role.servesOrders() {
+		go func() {
+			serverErr <- grpcSrv.serve(grpcPort)
+		}()
+	}
+	go func() {
+		serverErr <- internalServer.ListenAndServe()
+	}()
+	if scenario != nil {
+		scenario.start(ctx, port, server.TLSConfig != nil, cfg.Auth)
+	}
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server failed to start", err)
+		}
+	case <-ctx.Done():
+	}
+
+	// Report not-ready first and keep serving for a moment so the endpoints
+	// controller removes this pod from the Service before connections close
+	shuttingDown.Store(true)
+	grpcSrv.setNotServing()
+	readinessDelay := cfg.Server.ShutdownReadinessDelay
+	drainTimeout := cfg.Server.ShutdownTimeout
+	logger.Info("Shutdown signal received, draining in-flight requests",
+		"readiness_delay", readinessDelay.String(),
+		"drain_timeout", drainTimeout.String(),
+		"telemetry_flush_timeout", cfg.Server.TelemetryFlushTimeout.String(),
+		"service", serviceName,
+	)
+	time.Sleep(readinessDelay)
+
+	// Stop accepting new connections and wait for in-flight requests, the
+	// workers and the jobs to finish, all within the drain timeout
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Server shutdown did not complete cleanly", "error", err)
+	}
+	if err := grpcSrv.shutdown(shutdownCtx); err != nil {
+		logger.Warn("gRPC server shutdown did not complete cleanly", "error", err)
+	}
+	if err := internalServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Admin server shutdown did not complete cleanly", "error", err)
+	}
+	if requestQueue != nil {
+		workersDone := make(chan struct{})
+		go func() {
+			requestQueue.wait()
+			close(workersDone)
+		}()
+		select {
+		case <-workersDone:
+		case <-shutdownCtx.Done():
+			logger.Warn("SQS workers did not stop before the drain timeout")
+		}
+	}
+	if requestEvents != nil {
+		if err := requestEvents.wait(); err != nil {
+			logger.Warn("Kafka client shutdown did not complete cleanly", "error", err)
+		}
+	}
+	// Push the final values before the meter provider shuts down
+	if remoteWrite != nil {
+		if err := remoteWrite.shutdown(shutdownCtx); err != nil {
+			logger.Warn("Final Prometheus remote write failed", "error", err)
+		}
+	}
+	if err := tasks.stop(shutdownCtx); err != nil {
+		logger.Warn("Worker pool did not drain its queue before the drain timeout")
+	}
+	if err := asyncJobAPI.shutdown(shutdownCtx); err != nil {
+		logger.Warn("Async jobs did not record their outcome before the drain timeout")
+	}
+	if err := jobs.stop(shutdownCtx); err != nil {
+		logger.Warn("Scheduled jobs did not stop before the drain timeout")
+	}
+	// End the drill span so it is exported with the rest
+	drill.cancel("shutdown")
+
+	// Then flush the final spans, metrics and logs with time of their own,
+	// which a slow request that used up the drain timeout cannot take away
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), cfg.Server.TelemetryFlushTimeout)
+	defer cancelFlush()
+	if err := shutdownTelemetry(flushCtx); err != nil {
+		logger.Warn("Telemetry shutdown did not complete cleanly", "error", err)
+	}
+	closeLogOutput(flushCtx)
+}
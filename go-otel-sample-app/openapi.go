@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// openAPISpec is the contract of the REST API, served at /docs/openapi.yaml
+// for load tools and synthetic monitors to be generated from
+//
+//go:embed api/openapi.yaml
+var openAPISpec []byte
+
+// maxValidatedResponse is the largest response body checked against the
+// spec; larger ones are passed through unchecked
+const maxValidatedResponse = 1 << 20
+
+var (
+	openAPIValidationFailures metric.Int64Counter
+
+	promOpenAPIValidationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "openapi_validation_failures_total",
+			Help: "Requests and responses that did not match the OpenAPI spec",
+		},
+		[]string{"endpoint", "direction"},
+	)
+)
+
+// openAPIValidator checks requests, and optionally responses, against the
+// embedded spec. Requests that do not match are answered with 400 before
+// they reach the handler; responses that do not match are logged and
+// counted, since by then the client has them.
+type openAPIValidator struct {
+	spec      *openapi3.T
+	responses bool
+}
+
+// loadOpenAPISpec parses and validates the embedded spec, so a broken
+// contract fails the startup rather than every request
+func loadOpenAPISpec() (*openapi3.T, error) {
+	spec, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI spec: %w", err)
+	}
+	if err := spec.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+	return spec, nil
+}
+
+// newOpenAPIValidator returns nil when validation is disabled
+func newOpenAPIValidator(spec *openapi3.T, c openAPIConfig) (*openAPIValidator, error) {
+	if !c.Validate {
+		return nil, nil
+	}
+	// The schema dumped into every error message would end up in the
+	// problem details returned to clients
+	openapi3.SchemaErrorDetailsDisabled = true
+	var err error
+	if openAPIValidationFailures, err = meter.Int64Counter("openapi_validation_failures_total",
+		metric.WithDescription("Requests and responses that did not match the OpenAPI spec, by endpoint and direction")); err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promOpenAPIValidationFailures)
+	}
+	return &openAPIValidator{spec: spec, responses: c.ValidateResponses}, nil
+}
+
+// route returns the operation of the spec serving r, looked up by the
+// pattern of the mux route that matched it; nil for routes the spec leaves
+// out, such as the admin endpoints, /ws and /events
+func (v *openAPIValidator) route(r *http.Request) (*routers.Route, map[string]string) {
+	path := routeOf(r.Pattern)
+	item := v.spec.Paths.Find(path)
+	if item == nil {
+		return nil, nil
+	}
+	op := item.GetOperation(r.Method)
+	if op == nil {
+		return nil, nil
+	}
+	return &routers.Route{Spec: v.spec, Path: path, PathItem: item, Method: r.Method, Operation: op}, pathValues(path, r.URL.Path)
+}
+
+// pathValues returns the wildcards of route, e.g. {id}, as they appear in
+// path. The middleware runs outside the mux, which only sets the values of
+// r.PathValue on the request it hands to the handler.
+func pathValues(route, path string) map[string]string {
+	values := make(map[string]string)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range strings.Split(strings.Trim(route, "/"), "/") {
+		name, ok := strings.CutPrefix(segment, "{")
+		if !ok || i >= len(segments) {
+			continue
+		}
+		name = strings.TrimSuffix(name, "}")
+		if name, ok = strings.CutSuffix(name, "..."); ok {
+			values[name] = strings.Join(segments[i:], "/")
+			continue
+		}
+		values[name] = segments[i]
+	}
+	return values
+}
+
+// middleware validates the requests of the routes in the spec. Security is
+// left to authenticator.middleware, and bodies other than JSON, such as
+// those of /api/upload, are not read. A nil validator checks nothing.
+func (v *openAPIValidator) middleware(next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params := v.route(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				ExcludeRequestBody: !hasJSONBody(route.Operation),
+			},
+		}
+		if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+			msg := validationMessage(err)
+			v.recordFailure(ctx, route.Path, "request", msg)
+			requestLogger(r, route.Path).WarnContext(ctx, "Request does not match the OpenAPI spec",
+				"status_code", http.StatusBadRequest,
+				"error", msg,
+			)
+			writeError(ctx, w, http.StatusBadRequest, msg)
+			return
+		}
+		if !v.responses || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &responseCapture{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.overflow {
+			return
+		}
+		status := rw.code
+		if status == 0 {
+			status = http.StatusOK
+		}
+		out := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 status,
+			Header:                 w.Header(),
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		}
+		out.SetBodyBytes(rw.body.Bytes())
+		if err := openapi3filter.ValidateResponse(ctx, out); err != nil {
+			msg := validationMessage(err)
+			v.recordFailure(ctx, route.Path, "response", msg)
+			requestLogger(r, route.Path).WarnContext(ctx, "Response does not match the OpenAPI spec",
+				"status_code", status,
+				"error", msg,
+			)
+		}
+	})
+}
+
+func (v *openAPIValidator) recordFailure(ctx context.Context, route, direction, msg string) {
+	trace.SpanFromContext(ctx).AddEvent("openapi.validation_failed", trace.WithAttributes(
+		attribute.String("openapi.direction", direction),
+		attribute.String("error.message", msg),
+	))
+	openAPIValidationFailures.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("endpoint", route),
+		attribute.String("direction", direction),
+	))
+	if !prometheusBridge {
+		promOpenAPIValidationFailures.WithLabelValues(route, direction).Inc()
+	}
+}
+
+// hasJSONBody reports whether op takes a JSON request body, the only kind
+// the validator decodes
+func hasJSONBody(op *openapi3.Operation) bool {
+	// Content.Get would also match the */* of /api/upload
+	return op.RequestBody != nil && op.RequestBody.Value != nil &&
+		op.RequestBody.Value.Content["application/json"] != nil
+}
+
+// validationMessage shortens the errors of the validator to their first
+// line, the one that names the parameter or field at fault
+func validationMessage(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.Parameter != nil {
+		err = fmt.Errorf("%s parameter %q: %w", reqErr.Parameter.In, reqErr.Parameter.Name, reqErr.Err)
+	}
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	return msg
+}
+
+// responseCapture copies what the handler writes, up to
+// maxValidatedResponse, to check it once the handler returns
+type responseCapture struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	code     int
+	overflow bool
+}
+
+func (rc *responseCapture) WriteHeader(code int) {
+	if rc.code == 0 {
+		rc.code = code
+	}
+	rc.ResponseWriter.WriteHeader(code)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if !rc.overflow {
+		if rc.body.Len()+len(b) > maxValidatedResponse {
+			rc.overflow = true
+			rc.body.Reset()
+		} else {
+			rc.body.Write(b)
+		}
+	}
+	return rc.ResponseWriter.Write(b)
+}
+
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
+// swaggerUIVersion is the release of swagger-ui-dist the /docs page loads
+const swaggerUIVersion = "5.17.14"
+
+var swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Go OTEL Sample App API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "openapi.yaml", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// registerDocs serves the spec at /docs/openapi.yaml and Swagger UI at
+// /docs. The UI is loaded from unpkg by the browser, so the page needs
+// internet access but the image carries no assets.
+func registerDocs(mux *http.ServeMux) {
+	mux.HandleFunc("GET /docs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("GET /docs/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	})
+	mux.HandleFunc("GET /docs/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+}
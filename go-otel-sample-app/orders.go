@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	orderv1 "go-otel-sample-app/gen/order/v1"
+)
+
+var (
+	// errOrderNotFound is returned by an orderStore when no order has the
+	// requested ID
+	errOrderNotFound = errors.New("order not found")
+	// errInvalidOrder wraps validation failures of a CreateOrderRequest
+	errInvalidOrder = errors.New("invalid order")
+	// errOrderConflict is returned when an update or delete was based on a
+	// version of the order that has since changed
+	errOrderConflict = errors.New("order was modified by another request")
+)
+
+// newOrder validates req and builds the order to store, shared by the gRPC
+// and HTTP APIs
+func newOrder(req *orderv1.CreateOrderRequest) (*orderv1.Order, error) {
+	if req.CustomerId == "" {
+		return nil, fmt.Errorf("%w: customer_id is required", errInvalidOrder)
+	}
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("%w: at least one item is required", errInvalidOrder)
+	}
+
+	var total int64
+	for _, item := range req.Items {
+		if item.Sku == "" || item.Quantity <= 0 || item.UnitPriceCents < 0 {
+			return nil, fmt.Errorf("%w: invalid item %q", errInvalidOrder, item.Sku)
+		}
+		total += int64(item.Quantity) * item.UnitPriceCents
+	}
+
+	return &orderv1.Order{
+		Id:         uuid.NewString(),
+		CustomerId: req.CustomerId,
+		Items:      req.Items,
+		TotalCents: total,
+		// PostgreSQL keeps microseconds, and the ETag of a stored order
+		// must not change when it is read back
+		CreateTime: timestamppb.New(time.Now().Truncate(time.Microsecond)),
+	}, nil
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// normalizePageSize applies the default and maximum to a requested number
+// of orders, where 0 means the default
+func normalizePageSize(n int) (int, error) {
+	switch {
+	case n < 0:
+		return 0, errors.New("page size must not be negative")
+	case n == 0:
+		return defaultPageSize, nil
+	case n > maxPageSize:
+		return maxPageSize, nil
+	}
+	return n, nil
+}
+
+// orderStore persists orders for the gRPC OrderService and /api/orders
+type orderStore interface {
+	CreateOrder(ctx context.Context, order *orderv1.Order) error
+	GetOrder(ctx context.Context, id string) (*orderv1.Order, error)
+	// ListOrders returns up to limit orders, newest first
+	ListOrders(ctx context.Context, limit int) ([]*orderv1.Order, error)
+	// UpdateOrder calls update with a copy of the stored order and saves
+	// the copy unless update returns an error. The read and the write are
+	// atomic, so update can check that the order is still the version the
+	// client saw.
+	UpdateOrder(ctx context.Context, id string, update func(order *orderv1.Order) error) (*orderv1.Order, error)
+	// DeleteOrder removes the order if check, when given, accepts it
+	DeleteOrder(ctx context.Context, id string, check func(order *orderv1.Order) error) error
+}
+
+// memoryOrderStore keeps orders in process memory. Each replica has its
+// own copy, which is enough for a demo.
+type memoryOrderStore struct {
+	mu     sync.RWMutex
+	orders map[string]*orderv1.Order
+	// ids holds order IDs in creation order
+	ids []string
+}
+
+func newMemoryOrderStore() *memoryOrderStore {
+	return &memoryOrderStore{orders: map[string]*orderv1.Order{}}
+}
+
+func (s *memoryOrderStore) CreateOrder(_ context.Context, order *orderv1.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[order.Id] = order
+	s.ids = append(s.ids, order.Id)
+	return nil
+}
+
+func (s *memoryOrderStore) GetOrder(_ context.Context, id string) (*orderv1.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	return order, nil
+}
+
+func (s *memoryOrderStore) ListOrders(_ context.Context, limit int) ([]*orderv1.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders := make([]*orderv1.Order, 0, min(limit, len(s.ids)))
+	for i := len(s.ids) - 1; i >= 0 && len(orders) < limit; i-- {
+		orders = append(orders, s.orders[s.ids[i]])
+	}
+	return orders, nil
+}
+
+func (s *memoryOrderStore) UpdateOrder(_ context.Context, id string, update func(order *orderv1.Order) error) (*orderv1.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.orders[id]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	// Readers may still be encoding the stored order, so it is replaced
+	// rather than changed in place
+	order := proto.Clone(stored).(*orderv1.Order)
+	if err := update(order); err != nil {
+		return nil, err
+	}
+	s.orders[id] = order
+	return order, nil
+}
+
+func (s *memoryOrderStore) DeleteOrder(_ context.Context, id string, check func(order *orderv1.Order) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return errOrderNotFound
+	}
+	if check != nil {
+		if err := check(order); err != nil {
+			return err
+		}
+	}
+	delete(s.orders, id)
+	s.ids = slices.DeleteFunc(s.ids, func(stored string) bool { return stored == id })
+	return nil
+}
+
+// ordersAPI serves the order store over HTTP as JSON, using the same
+// snake_case field names as the gRPC API. Single orders carry an ETag, and
+// PUT and DELETE honor If-Match, so two clients editing the same order get
+// a 409 instead of silently overwriting each other.
+type ordersAPI struct {
+	store orderStore
+}
+
+var ordersJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+func (a ordersAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/orders", a.list)
+	mux.HandleFunc("POST /api/orders", a.create)
+	mux.HandleFunc("GET /api/orders/{id}", a.get)
+	mux.HandleFunc("PUT /api/orders/{id}", a.update)
+	mux.HandleFunc("DELETE /api/orders/{id}", a.delete)
+}
+
+func (a ordersAPI) list(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "list_orders")
+	defer span.End()
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	limit, err := normalizePageSize(limit)
+	if err != nil {
+		a.respond(ctx, w, http.StatusBadRequest, nil, err)
+		return
+	}
+
+	var orders []*orderv1.Order
+	err = inStore(ctx, "list", func(ctx context.Context) (err error) {
+		orders, err = a.store.ListOrders(ctx, limit)
+		return err
+	})
+	if err != nil {
+		a.fail(ctx, w, r, "list orders", err)
+		return
+	}
+	a.respond(ctx, w, http.StatusOK, &orderv1.ListOrdersResponse{Orders: orders}, nil)
+}
+
+func (a ordersAPI) create(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "create_order")
+	defer span.End()
+
+	order, err := decodeOrder(ctx, r)
+	if err != nil {
+		a.respond(ctx, w, http.StatusBadRequest, nil, err)
+		return
+	}
+	span.SetAttributes(attribute.String("order.id", order.Id))
+
+	err = inStore(ctx, "create", func(ctx context.Context) error {
+		return a.store.CreateOrder(ctx, order)
+	})
+	if err != nil {
+		a.fail(ctx, w, r, "store order", err)
+		return
+	}
+
+	requestLogger(r, "/api/orders").InfoContext(ctx, "Order created",
+		"order_id", order.Id,
+		"customer_id", order.CustomerId,
+		"total_cents", order.TotalCents,
+	)
+	publishOrderEvent(ctx, orderCreated, order)
+	a.respond(ctx, w, http.StatusCreated, order, nil)
+}
+
+func (a ordersAPI) get(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "get_order")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("order.id", id))
+
+	var order *orderv1.Order
+	err := inStore(ctx, "get", func(ctx context.Context) (err error) {
+		order, err = a.store.GetOrder(ctx, id)
+		return err
+	})
+	if err != nil {
+		a.fail(ctx, w, r, "load order", err)
+		return
+	}
+	a.respond(ctx, w, http.StatusOK, order, nil)
+}
+
+// update replaces the customer and items of an order from a body shaped
+// like the one POST takes; the ID and creation time are kept
+func (a ordersAPI) update(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "update_order")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("order.id", id))
+
+	next, err := decodeOrder(ctx, r)
+	if err != nil {
+		a.respond(ctx, w, http.StatusBadRequest, nil, err)
+		return
+	}
+
+	check := ifMatch(r)
+	var order *orderv1.Order
+	err = inStore(ctx, "update", func(ctx context.Context) (err error) {
+		order, err = a.store.UpdateOrder(ctx, id, func(order *orderv1.Order) error {
+			if err := check(order); err != nil {
+				return err
+			}
+			order.CustomerId = next.CustomerId
+			order.Items = next.Items
+			order.TotalCents = next.TotalCents
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		a.fail(ctx, w, r, "update order", err)
+		return
+	}
+
+	requestLogger(r, "/api/orders/{id}").InfoContext(ctx, "Order updated",
+		"order_id", order.Id,
+		"customer_id", order.CustomerId,
+		"total_cents", order.TotalCents,
+	)
+	publishOrderEvent(ctx, orderUpdated, order)
+	a.respond(ctx, w, http.StatusOK, order, nil)
+}
+
+func (a ordersAPI) delete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "delete_order")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("order.id", id))
+
+	err := inStore(ctx, "delete", func(ctx context.Context) error {
+		return a.store.DeleteOrder(ctx, id, ifMatch(r))
+	})
+	if err != nil {
+		a.fail(ctx, w, r, "delete order", err)
+		return
+	}
+
+	requestLogger(r, "/api/orders/{id}").InfoContext(ctx, "Order deleted", "order_id", id)
+	publishOrderEvent(ctx, orderDeleted, &orderv1.Order{Id: id})
+	a.respond(ctx, w, http.StatusNoContent, nil, nil)
+}
+
+// decodeOrder reads and validates a CreateOrderRequest body in its own
+// span, so rejected requests stand out in a trace
+func decodeOrder(ctx context.Context, r *http.Request) (*orderv1.Order, error) {
+	_, span := tracer.Start(ctx, "validate_order")
+	defer span.End()
+
+	order, err := func() (*orderv1.Order, error) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		var req orderv1.CreateOrderRequest
+		if err := protojson.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidOrder, err)
+		}
+		return newOrder(&req)
+	}()
+	if err != nil {
+		// A bad request is the client's error, so the status stays unset
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("order.items", len(order.Items)))
+	return order, nil
+}
+
+// inStore runs fn in a child span named after the store operation, so
+// traces show persistence next to validation under the handler span.
+// Missing and changed orders leave the span status unset.
+func inStore(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "order_store."+operation)
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil && !errors.Is(err, errOrderNotFound) && !errors.Is(err, errOrderConflict) {
+		failSpan(span, err, operation+" failed")
+	}
+	return err
+}
+
+// orderETag identifies the current version of an order. It is derived from
+// the content, so stores need no version column.
+func orderETag(order *orderv1.Order) string {
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(order)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// ifMatch returns a check that fails with errOrderConflict unless the order
+// still has the ETag sent in If-Match. Without the header, or with "*",
+// every version is accepted.
+func ifMatch(r *http.Request) func(order *orderv1.Order) error {
+	want := r.Header.Get("If-Match")
+	return func(order *orderv1.Order) error {
+		if want != "" && want != "*" && want != orderETag(order) {
+			return errOrderConflict
+		}
+		return nil
+	}
+}
+
+// fail answers a request whose store call returned err: 404 for a missing
+// order, 409 for one changed since the client read it, and otherwise 500
+// with the error logged and recorded on the handler span
+func (a ordersAPI) fail(ctx context.Context, w http.ResponseWriter, r *http.Request, action string, err error) {
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		a.respond(ctx, w, http.StatusNotFound, nil, err)
+	case errors.Is(err, errOrderConflict):
+		a.respond(ctx, w, http.StatusConflict, nil, err)
+	default:
+		failSpan(trace.SpanFromContext(ctx), err, action+" failed")
+		log := requestLogger(r, routeOf(r.Pattern))
+		if id := r.PathValue("id"); id != "" {
+			log = log.With("order_id", id)
+		}
+		log.ErrorContext(ctx, "Failed to "+action, "error", err)
+		a.respond(ctx, w, http.StatusInternalServerError, nil, errors.New("failed to "+action))
+	}
+}
+
+// respond writes either msg or err as JSON, or no body when both are nil.
+// Orders get an ETag header.
+func (a ordersAPI) respond(ctx context.Context, w http.ResponseWriter, code int, msg proto.Message, err error) {
+	trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPResponseStatusCode(code))
+	if order, ok := msg.(*orderv1.Order); ok {
+		w.Header().Set("ETag", orderETag(order))
+	}
+	if err != nil {
+		writeError(ctx, w, code, err.Error())
+		return
+	}
+	if msg != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(code)
+	if msg != nil {
+		body, _ := ordersJSON.Marshal(msg)
+		w.Write(body)
+	}
+}
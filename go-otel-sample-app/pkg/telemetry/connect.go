@@ -0,0 +1,193 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Backoff between attempts to create an exporter; variables so tests can
+// shorten them
+var (
+	connectInitialBackoff = time.Second
+	connectMaxBackoff     = time.Minute
+)
+
+// connector creates the exporter of a signal without holding up New: when
+// creation fails it is retried in the background with exponential backoff,
+// and exports fail until it succeeds. It also records the result of every
+// export in the signal's pipeline; the OTLP exporters connect lazily, so an
+// unreachable collector shows up there rather than as a creation error.
+type connector[E interface{ Shutdown(context.Context) error }] struct {
+	signal string
+
+	mu        sync.RWMutex
+	exporter  E
+	connected bool
+
+	p    *pipeline
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func connect[E interface{ Shutdown(context.Context) error }](signal string, p *pipeline, create func(context.Context) (E, error)) *connector[E] {
+	ctx, stop := context.WithCancel(context.Background())
+	c := &connector[E]{signal: signal, p: p, stop: stop, done: make(chan struct{})}
+	exporter, err := create(ctx)
+	if err == nil {
+		c.set(exporter)
+		close(c.done)
+		return c
+	}
+	otel.Handle(fmt.Errorf("creating %s exporter, retrying in the background: %w", signal, err))
+
+	go func() {
+		defer close(c.done)
+		backoff := connectInitialBackoff
+		for {
+			// Jitter over the upper half of the backoff, so replicas started
+			// together don't retry in lockstep
+			wait := backoff/2 + rand.N(backoff/2+1)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			exporter, err := create(ctx)
+			if err == nil {
+				c.set(exporter)
+				return
+			}
+			otel.Handle(fmt.Errorf("creating %s exporter: %w", signal, err))
+			backoff = min(backoff*2, connectMaxBackoff)
+		}
+	}()
+	return c
+}
+
+func (c *connector[E]) set(exporter E) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exporter, c.connected = exporter, true
+	c.p.up.Store(true)
+}
+
+// get returns the exporter, or an error while it doesn't exist yet
+func (c *connector[E]) get() (E, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.connected {
+		return c.exporter, fmt.Errorf("%s exporter not created yet", c.signal)
+	}
+	return c.exporter, nil
+}
+
+// record notes the result of an export of items spans, data points or log
+// records, and returns err marked as a failed export. An export the spool
+// took in its place succeeded, but the exporter doesn't count as up.
+func (c *connector[E]) record(err error, items int) error {
+	c.p.up.Store(err == nil && !c.p.spooling.Load())
+	if err == nil {
+		return nil
+	}
+	c.p.exportFailures.Add(1)
+	c.p.exportDropped.Add(uint64(items))
+	msg := err.Error()
+	c.p.lastError.Store(&msg)
+	return exportError{signal: c.signal, err: err}
+}
+
+// shutdown stops retrying and shuts the exporter down, if it was created
+func (c *connector[E]) shutdown(ctx context.Context) error {
+	c.stop()
+	<-c.done
+	c.p.up.Store(false)
+	if exporter, err := c.get(); err == nil {
+		return exporter.Shutdown(ctx)
+	}
+	return nil
+}
+
+// spanExporter exports through the connector's span exporter
+type spanExporter struct {
+	*connector[sdktrace.SpanExporter]
+}
+
+func (e spanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	exporter, err := e.get()
+	if err != nil {
+		return e.record(err, len(spans))
+	}
+	return e.record(exporter.ExportSpans(ctx, spans), len(spans))
+}
+
+func (e spanExporter) Shutdown(ctx context.Context) error {
+	return e.shutdown(ctx)
+}
+
+// metricExporter exports through the connector's metric exporter. The
+// periodic reader asks for the temporality and aggregation up front, so
+// they come from the configuration rather than the exporter.
+type metricExporter struct {
+	*connector[sdkmetric.Exporter]
+	temporality sdkmetric.TemporalitySelector
+}
+
+func (e metricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return e.temporality(kind)
+}
+
+func (e metricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e metricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	exporter, err := e.get()
+	if err != nil {
+		return e.record(err, dataPoints(rm))
+	}
+	return e.record(exporter.Export(ctx, rm), dataPoints(rm))
+}
+
+func (e metricExporter) ForceFlush(ctx context.Context) error {
+	if exporter, err := e.get(); err == nil {
+		return exporter.ForceFlush(ctx)
+	}
+	return nil
+}
+
+func (e metricExporter) Shutdown(ctx context.Context) error {
+	return e.shutdown(ctx)
+}
+
+// logExporter exports through the connector's log exporter
+type logExporter struct {
+	*connector[sdklog.Exporter]
+}
+
+func (e logExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	exporter, err := e.get()
+	if err != nil {
+		return e.record(err, len(records))
+	}
+	return e.record(exporter.Export(ctx, records), len(records))
+}
+
+func (e logExporter) ForceFlush(ctx context.Context) error {
+	if exporter, err := e.get(); err == nil {
+		return exporter.ForceFlush(ctx)
+	}
+	return nil
+}
+
+func (e logExporter) Shutdown(ctx context.Context) error {
+	return e.shutdown(ctx)
+}
@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingSpanExporter fails every export, like one whose collector is down
+type failingSpanExporter struct{}
+
+func (failingSpanExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error {
+	return errors.New("connection refused")
+}
+
+func (failingSpanExporter) Shutdown(context.Context) error { return nil }
+
+func TestConnectRetriesInBackground(t *testing.T) {
+	initial := connectInitialBackoff
+	connectInitialBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { connectInitialBackoff = initial })
+
+	var attempts atomic.Int32
+	conn := connect("traces", &pipeline{}, func(context.Context) (sdktrace.SpanExporter, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("collector not resolvable")
+		}
+		return tracetest.NewInMemoryExporter(), nil
+	})
+	exporter := spanExporter{conn}
+	defer exporter.Shutdown(context.Background())
+
+	if err := exporter.ExportSpans(context.Background(), nil); err == nil {
+		t.Errorf("export succeeded before the exporter was created")
+	}
+	if conn.p.up.Load() {
+		t.Errorf("up before the exporter was created")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !conn.p.up.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("exporter not created after %d attempts", attempts.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := exporter.ExportSpans(context.Background(), nil); err != nil {
+		t.Errorf("export after creation: %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("attempts = %d, want 3", n)
+	}
+}
+
+func TestExporterUpGauge(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	tel, err := New(context.Background(),
+		WithSpanExporter(failingSpanExporter{}),
+		WithMetricReader(reader),
+		WithoutLogs(),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+
+	if stats := tel.PipelineStats(); len(stats) != 1 || !stats[0].Up {
+		t.Errorf("PipelineStats = %+v, want traces up before the first export", stats)
+	}
+	_, span := tel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	tel.ForceFlush(context.Background())
+
+	gauge, ok := collectMetric(t, reader, "telemetry_exporter_up").(metricdata.Gauge[int64])
+	if !ok || len(gauge.DataPoints) != 1 {
+		t.Fatalf("telemetry_exporter_up = %+v, want one data point", gauge)
+	}
+	point := gauge.DataPoints[0]
+	if signal, _ := point.Attributes.Value(attribute.Key("signal")); signal.AsString() != "traces" || point.Value != 0 {
+		t.Errorf("telemetry_exporter_up{signal=%q} = %d, want traces down", signal.AsString(), point.Value)
+	}
+}
@@ -0,0 +1,29 @@
+package telemetry
+
+import (
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// consoleWriter receives the console exporters' output, one JSON document
+// per batch; a variable so tests can capture it
+var consoleWriter io.Writer = os.Stdout
+
+func newConsoleTraceExporter() (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(consoleWriter))
+}
+
+func newConsoleMetricExporter() (sdkmetric.Exporter, error) {
+	return stdoutmetric.New(stdoutmetric.WithWriter(consoleWriter))
+}
+
+func newConsoleLogExporter() (sdklog.Exporter, error) {
+	return stdoutlog.New(stdoutlog.WithWriter(consoleWriter))
+}
@@ -0,0 +1,216 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLPTarget is an OTLP backend exported to next to the exporters named by
+// OTEL_<SIGNAL>_EXPORTER, e.g. a second collector during a migration. It
+// takes the timeout, compression, retries and metric temporality of the
+// OTEL_EXPORTER_OTLP_* configuration.
+type OTLPTarget struct {
+	// Name labels the target's pipeline metrics, e.g. exporter="amp-west"
+	Name string
+	// Endpoint is the collector's URL; https enables TLS with the system
+	// roots. Over HTTP the signal's path, e.g. /v1/traces, is appended.
+	Endpoint string
+	// Protocol is grpc (the default) or http/protobuf
+	Protocol string
+	Headers  map[string]string
+	// Signals limits the target to some of traces, metrics and logs; empty
+	// means all three
+	Signals []string
+}
+
+func (target OTLPTarget) validate() error {
+	switch target.Name {
+	case "", ExporterOTLP, ExporterConsole, ExporterNone, exporterCustom:
+		return fmt.Errorf("OTLP target name %q is empty or reserved", target.Name)
+	}
+	for _, signal := range target.Signals {
+		if !slices.Contains([]string{"traces", "metrics", "logs"}, signal) {
+			return fmt.Errorf("OTLP target %s: unknown signal %q", target.Name, signal)
+		}
+	}
+	return nil
+}
+
+func (target OTLPTarget) includes(signal string) bool {
+	return len(target.Signals) == 0 || slices.Contains(target.Signals, signal)
+}
+
+// otlpConfig resolves the exporter settings of the target for a signal
+// ("TRACES", "METRICS" or "LOGS")
+func (target OTLPTarget) otlpConfig(signal string, retry RetryConfig) (otlpConfig, error) {
+	cfg, err := loadOTLPConfig(signal, retry)
+	if err != nil {
+		return otlpConfig{}, err
+	}
+	protocol := target.Protocol
+	if protocol == "" {
+		protocol = protocolGRPC
+	}
+	if protocol != protocolGRPC && protocol != protocolHTTPProtobuf {
+		return otlpConfig{}, fmt.Errorf("unsupported OTLP protocol %q", protocol)
+	}
+	value := target.Endpoint
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return otlpConfig{}, fmt.Errorf("invalid OTLP endpoint %q", target.Endpoint)
+	}
+
+	cfg.protocol, cfg.endpoint, cfg.headers = protocol, u.Host, target.Headers
+	cfg.urlPath = strings.TrimSuffix(u.Path, "/") + "/v1/" + strings.ToLower(signal)
+	cfg.tlsConfig = nil
+	if u.Scheme == "https" {
+		cfg.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return cfg, nil
+}
+
+// exporterFactory creates one of the exporters of a signal
+type exporterFactory[E any] struct {
+	// name labels the exporter's pipeline: otlp, console, custom or the
+	// name of an OTLPTarget
+	name   string
+	create func(context.Context) (E, error)
+	// temporality is only used by the metric exporters
+	temporality sdkmetric.TemporalitySelector
+}
+
+// newFactories resolves the exporters of signal: those named by
+// OTEL_<SIGNAL>_EXPORTER, then the OTLP targets that include the signal.
+// The spool only covers the exporter configured by OTEL_EXPORTER_OTLP_*.
+func newFactories[E any](t *Telemetry, c *config, signal string, newOTLP func(context.Context, otlpConfig) (E, error), newConsole func() (E, error)) ([]exporterFactory[E], error) {
+	env := strings.ToUpper(signal)
+	names, err := exporterNames(env, c.exporter)
+	if err != nil {
+		return nil, err
+	}
+	var factories []exporterFactory[E]
+	for _, name := range names {
+		switch name {
+		case ExporterOTLP:
+			cfg, err := loadOTLPConfig(env, c.retry)
+			if err != nil {
+				return nil, fmt.Errorf("loading %s exporter config: %w", signal, err)
+			}
+			if t.spool != nil {
+				if err := t.spool.attach(signal, &cfg, t.pipeline(signal, ExporterOTLP)); err != nil {
+					return nil, err
+				}
+			}
+			factories = append(factories, otlpFactory(name, cfg, newOTLP))
+		case ExporterConsole:
+			factories = append(factories, exporterFactory[E]{
+				name:        name,
+				create:      func(context.Context) (E, error) { return newConsole() },
+				temporality: sdkmetric.DefaultTemporalitySelector,
+			})
+		}
+	}
+	for _, target := range c.targets {
+		if !target.includes(signal) {
+			continue
+		}
+		cfg, err := target.otlpConfig(env, c.retry)
+		if err != nil {
+			return nil, fmt.Errorf("OTLP target %s: %w", target.Name, err)
+		}
+		factories = append(factories, otlpFactory(target.Name, cfg, newOTLP))
+	}
+	return factories, nil
+}
+
+func otlpFactory[E any](name string, cfg otlpConfig, newOTLP func(context.Context, otlpConfig) (E, error)) exporterFactory[E] {
+	return exporterFactory[E]{
+		name:        name,
+		create:      func(ctx context.Context) (E, error) { return newOTLP(ctx, cfg) },
+		temporality: cfg.temporality,
+	}
+}
+
+// exporterNames reads OTEL_<SIGNAL>_EXPORTER, a comma-separated list
+// defaulting to defaultName. none, alone or in the list, adds no exporter;
+// the provider is still created, so readers and processors added through
+// the options, such as a Prometheus reader, keep working.
+func exporterNames(signal, defaultName string) ([]string, error) {
+	name := "OTEL_" + signal + "_EXPORTER"
+	var names []string
+	for _, exporter := range strings.Split(getEnv(name, defaultName), ",") {
+		switch exporter = strings.ToLower(strings.TrimSpace(exporter)); exporter {
+		case ExporterNone:
+		case ExporterOTLP, ExporterConsole, "stdout":
+			if exporter == "stdout" {
+				exporter = ExporterConsole
+			}
+			if !slices.Contains(names, exporter) {
+				names = append(names, exporter)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported %s %q: expected otlp, console or none", name, exporter)
+		}
+	}
+	return names, nil
+}
+
+// fanOut runs export for each of n exporters in parallel, so a slow backend
+// delays the batch but doesn't hold up the others' exports
+func fanOut(n int, export func(i int) error) error {
+	if n == 1 {
+		return export(0)
+	}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = export(i)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// spanExporters exports each batch of the span processor to every exporter
+// of the signal
+type spanExporters []spanExporter
+
+func (e spanExporters) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return fanOut(len(e), func(i int) error { return e[i].ExportSpans(ctx, spans) })
+}
+
+func (e spanExporters) Shutdown(ctx context.Context) error {
+	return fanOut(len(e), func(i int) error { return e[i].Shutdown(ctx) })
+}
+
+// logExporters exports each batch of the log processor to every exporter
+// of the signal
+type logExporters []logExporter
+
+func (e logExporters) Export(ctx context.Context, records []sdklog.Record) error {
+	return fanOut(len(e), func(i int) error { return e[i].Export(ctx, records) })
+}
+
+func (e logExporters) ForceFlush(ctx context.Context) error {
+	return fanOut(len(e), func(i int) error { return e[i].ForceFlush(ctx) })
+}
+
+func (e logExporters) Shutdown(ctx context.Context) error {
+	return fanOut(len(e), func(i int) error { return e[i].Shutdown(ctx) })
+}
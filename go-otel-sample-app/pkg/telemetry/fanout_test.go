@@ -0,0 +1,99 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExporterNames(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: []string{"otlp"}},
+		{value: "otlp, stdout", want: []string{"otlp", "console"}},
+		{value: "console,console", want: []string{"console"}},
+		{value: "none", want: nil},
+		{value: "otlp,zipkin", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("OTEL_TRACES_EXPORTER", tt.value)
+		got, err := exporterNames("TRACES", ExporterOTLP)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("exporterNames(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("exporterNames(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFanOutToTargets(t *testing.T) {
+	var out bytes.Buffer
+	writer := consoleWriter
+	consoleWriter = &out
+	t.Cleanup(func() { consoleWriter = writer })
+
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			received.Add(1)
+		}
+	}))
+	defer collector.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "console")
+	t.Setenv("OTEL_METRICS_EXPORTER", "none")
+	t.Setenv("OTEL_LOGS_EXPORTER", "none")
+	tel, err := New(context.Background(),
+		WithOTLPTarget(OTLPTarget{Name: "secondary", Endpoint: collector.URL, Protocol: protocolHTTPProtobuf, Signals: []string{"traces", "logs"}}),
+		WithOTLPTarget(OTLPTarget{Name: "broken", Endpoint: rejecting.URL, Protocol: protocolHTTPProtobuf, Signals: []string{"traces"}}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+
+	_, span := tel.Tracer("test").Start(context.Background(), "fanned-out")
+	span.End()
+	tel.ForceFlush(context.Background())
+
+	if !strings.Contains(out.String(), `"Name":"fanned-out"`) {
+		t.Errorf("console exporter did not get the span")
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("secondary target got %d requests, want 1", n)
+	}
+
+	up := map[string]bool{}
+	for _, s := range tel.PipelineStats() {
+		up[s.Signal+"/"+s.Exporter] = s.Up
+	}
+	want := map[string]bool{"traces/console": true, "traces/secondary": true, "traces/broken": false, "logs/secondary": true}
+	if len(up) != len(want) {
+		t.Fatalf("pipelines = %v, want %v", up, want)
+	}
+	for name, wantUp := range want {
+		if up[name] != wantUp {
+			t.Errorf("%s up = %v, want %v", name, up[name], wantUp)
+		}
+	}
+
+	if _, err := New(context.Background(), WithOTLPTarget(OTLPTarget{Name: "otlp", Endpoint: collector.URL})); err == nil {
+		t.Errorf("New accepted a target named otlp")
+	}
+}
@@ -0,0 +1,273 @@
+package telemetry
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Option configures New
+type Option func(*config)
+
+type config struct {
+	serviceName     string
+	serviceVersion  string
+	resourceOptions []resource.Option
+
+	traces  bool
+	metrics bool
+	logs    bool
+
+	// exporter is the default of OTEL_<SIGNAL>_EXPORTER
+	exporter string
+	targets  []OTLPTarget
+	// Exporters replacing the ones configured from the environment
+	spanExporter sdktrace.SpanExporter
+	metricReader sdkmetric.Reader
+	logExporter  sdklog.Exporter
+	retry        RetryConfig
+	spanBatch    BatchConfig
+	logBatch     BatchConfig
+	reader       ReaderConfig
+	// spoolDir enables the spool when set
+	spoolDir      string
+	spoolMaxBytes int64
+	// spanRedactor rewrites span attributes before export when set
+	spanRedactor AttributeRedactor
+
+	tracerProviderOptions []sdktrace.TracerProviderOption
+	meterProviderOptions  []sdkmetric.Option
+	loggerProviderOptions []sdklog.LoggerProviderOption
+
+	propagator propagation.TextMapPropagator
+	logger     *slog.Logger
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		serviceVersion: buildVersion(),
+		exporter:       ExporterOTLP,
+		traces:         true,
+		metrics:        true,
+		logs:           true,
+		retry:          DefaultRetryConfig,
+		propagator: propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithServiceName sets the default service.name; OTEL_SERVICE_NAME and a
+// service.name in OTEL_RESOURCE_ATTRIBUTES still override it. Without it
+// the name is unknown_service:<executable>, as in the other OTel SDKs.
+func WithServiceName(name string) Option {
+	return func(c *config) {
+		c.serviceName = name
+	}
+}
+
+// WithServiceVersion sets service.version and the version of the scopes
+// returned by Tracer and Meter. It defaults to the module version or VCS
+// revision the binary was built from.
+func WithServiceVersion(version string) Option {
+	return func(c *config) {
+		c.serviceVersion = version
+	}
+}
+
+// WithResourceOptions adds attributes and detectors to the resource. They
+// are applied after the SDK's host, OS, process and container detectors
+// and before OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME.
+func WithResourceOptions(opts ...resource.Option) Option {
+	return func(c *config) {
+		c.resourceOptions = append(c.resourceOptions, opts...)
+	}
+}
+
+// WithoutTraces leaves tracing to the global no-op tracer provider
+func WithoutTraces() Option {
+	return func(c *config) {
+		c.traces = false
+	}
+}
+
+// WithoutMetrics leaves metrics to the global no-op meter provider
+func WithoutMetrics() Option {
+	return func(c *config) {
+		c.metrics = false
+	}
+}
+
+// WithoutLogs leaves logs to the global no-op logger provider
+func WithoutLogs() Option {
+	return func(c *config) {
+		c.logs = false
+	}
+}
+
+// WithExporter sets the exporter of the signals whose
+// OTEL_<SIGNAL>_EXPORTER is unset: ExporterOTLP (the default),
+// ExporterConsole or ExporterNone
+func WithExporter(name string) Option {
+	return func(c *config) {
+		c.exporter = name
+	}
+}
+
+// WithOTLPTarget exports the signals of target to a further OTLP backend,
+// next to the exporters named by OTEL_<SIGNAL>_EXPORTER. Spans and log
+// records go through one batch processor per signal whose batches are
+// exported to every exporter in parallel; metrics get a reader per
+// exporter.
+func WithOTLPTarget(target OTLPTarget) Option {
+	return func(c *config) {
+		c.targets = append(c.targets, target)
+	}
+}
+
+// WithSpanExporter exports spans to e instead of the OTLP exporter
+// configured by OTEL_EXPORTER_OTLP_*, e.g. to an in-memory exporter in
+// tests
+func WithSpanExporter(e sdktrace.SpanExporter) Option {
+	return func(c *config) {
+		c.spanExporter = e
+	}
+}
+
+// WithMetricReader reads metrics with r instead of a periodic reader on
+// the OTLP exporter configured by OTEL_EXPORTER_OTLP_*. Use
+// WithMeterProviderOptions to add a reader next to the OTLP one.
+func WithMetricReader(r sdkmetric.Reader) Option {
+	return func(c *config) {
+		c.metricReader = r
+	}
+}
+
+// WithLogExporter exports logs to e instead of the OTLP exporter configured
+// by OTEL_EXPORTER_OTLP_*
+func WithLogExporter(e sdklog.Exporter) Option {
+	return func(c *config) {
+		c.logExporter = e
+	}
+}
+
+// WithRetry sets the retry policy of the OTLP exporters (default:
+// DefaultRetryConfig)
+func WithRetry(retry RetryConfig) Option {
+	return func(c *config) {
+		c.retry = retry
+	}
+}
+
+// BatchConfig tunes the batch span or log processor. Zero fields keep the
+// SDK's values, which OTEL_BSP_* and OTEL_BLRP_* set.
+type BatchConfig struct {
+	// MaxQueueSize is the number of items buffered for export; more are
+	// dropped
+	MaxQueueSize       int
+	MaxExportBatchSize int
+	// ScheduleDelay is the longest an item waits in the queue before its
+	// batch is exported
+	ScheduleDelay time.Duration
+	ExportTimeout time.Duration
+}
+
+// ReaderConfig tunes the periodic metric readers. Zero fields keep the
+// SDK's values, which OTEL_METRIC_EXPORT_INTERVAL and
+// OTEL_METRIC_EXPORT_TIMEOUT set.
+type ReaderConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// WithSpanBatch tunes the batch span processor feeding the span exporters
+func WithSpanBatch(batch BatchConfig) Option {
+	return func(c *config) {
+		c.spanBatch = batch
+	}
+}
+
+// WithLogBatch tunes the batch log processor feeding the log exporters
+func WithLogBatch(batch BatchConfig) Option {
+	return func(c *config) {
+		c.logBatch = batch
+	}
+}
+
+// WithReader tunes the periodic readers of the metric exporters; it has
+// no effect on a WithMetricReader reader
+func WithReader(reader ReaderConfig) Option {
+	return func(c *config) {
+		c.reader = reader
+	}
+}
+
+// WithSpool makes the OTLP exporters write the batches the collector cannot
+// take to files in dir, up to maxBytes in total, and replay them once it
+// is back. Batches left in dir by a previous process are replayed too, so
+// a volume that outlives the pod keeps them across a node's loss.
+func WithSpool(dir string, maxBytes int64) Option {
+	return func(c *config) {
+		c.spoolDir, c.spoolMaxBytes = dir, maxBytes
+	}
+}
+
+// WithSpanRedactor passes the attributes of every span and span event
+// through redact before they are exported, e.g. to remove personal data.
+// Sampling and span processors still see the original attributes.
+func WithSpanRedactor(redact AttributeRedactor) Option {
+	return func(c *config) {
+		c.spanRedactor = redact
+	}
+}
+
+// WithTracerProviderOptions adds options such as a sampler, span processors
+// or an ID generator to the tracer provider
+func WithTracerProviderOptions(opts ...sdktrace.TracerProviderOption) Option {
+	return func(c *config) {
+		c.tracerProviderOptions = append(c.tracerProviderOptions, opts...)
+	}
+}
+
+// WithMeterProviderOptions adds options such as views or further readers
+// to the meter provider
+func WithMeterProviderOptions(opts ...sdkmetric.Option) Option {
+	return func(c *config) {
+		c.meterProviderOptions = append(c.meterProviderOptions, opts...)
+	}
+}
+
+// WithLoggerProviderOptions adds options such as further processors to the
+// logger provider
+func WithLoggerProviderOptions(opts ...sdklog.LoggerProviderOption) Option {
+	return func(c *config) {
+		c.loggerProviderOptions = append(c.loggerProviderOptions, opts...)
+	}
+}
+
+// WithPropagator sets the global propagator (default: W3C trace context
+// and baggage)
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+// WithLogger sets the logger of the SDK's errors and of the periodic
+// warnings about lost telemetry (default: slog.Default at the time of
+// logging)
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
@@ -0,0 +1,436 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// OTLP transport protocols, as named by OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	protocolGRPC         = "grpc"
+	protocolHTTPProtobuf = "http/protobuf"
+)
+
+// otlpConfig holds the exporter settings for a single signal
+type otlpConfig struct {
+	protocol string
+	// endpoint is the collector's host:port
+	endpoint string
+	// urlPath is only used over HTTP, e.g. /v1/traces
+	urlPath string
+	headers map[string]string
+	// tlsConfig is nil when exporting over plaintext
+	tlsConfig *tls.Config
+	// temporality is only used by the metric exporter
+	temporality sdkmetric.TemporalitySelector
+	// timeout bounds a single export request
+	timeout time.Duration
+	// compression is "gzip" or "none"
+	compression string
+	retry       RetryConfig
+	// dialOptions and httpClient are set by the spool to intercept the
+	// exporter's requests
+	dialOptions []grpc.DialOption
+	httpClient  *http.Client
+}
+
+// RetryConfig has the fields of the exporters' RetryConfig types, in the
+// same order, so it converts to each of them directly
+type RetryConfig struct {
+	Enabled bool
+	// InitialInterval is the wait after the first failed export; it
+	// doubles, with jitter, up to MaxInterval
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// MaxElapsedTime bounds the retries of one batch, after which it is
+	// dropped
+	MaxElapsedTime time.Duration
+}
+
+// DefaultRetryConfig is the OTLP exporters' own retry policy
+var DefaultRetryConfig = RetryConfig{
+	Enabled:         true,
+	InitialInterval: 5 * time.Second,
+	MaxInterval:     30 * time.Second,
+	MaxElapsedTime:  time.Minute,
+}
+
+// loadOTLPConfig resolves the exporter settings for a signal ("TRACES",
+// "METRICS" or "LOGS"). Per-signal variables override the shared
+// OTEL_EXPORTER_OTLP_* ones, and the default endpoint follows the
+// protocol's well-known collector port. The retry settings come from
+// WithRetry, as the specification defines no variables for them.
+func loadOTLPConfig(signal string, retry RetryConfig) (otlpConfig, error) {
+	protocol := otlpEnv(signal, "PROTOCOL", protocolGRPC)
+
+	endpoint, err := otlpEndpoint(signal, protocol)
+	if err != nil {
+		return otlpConfig{}, err
+	}
+
+	headers, err := parseOTLPHeaders(otlpEnv(signal, "HEADERS", ""))
+	if err != nil {
+		return otlpConfig{}, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+
+	tlsConfig, err := loadOTLPTLSConfig(signal, endpoint.Scheme)
+	if err != nil {
+		return otlpConfig{}, err
+	}
+
+	// The specification gives the timeout in milliseconds
+	timeoutMS, err := strconv.Atoi(otlpEnv(signal, "TIMEOUT", "10000"))
+	if err != nil || timeoutMS <= 0 {
+		return otlpConfig{}, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_TIMEOUT for %s: expected a positive number of milliseconds", strings.ToLower(signal))
+	}
+
+	compression := strings.ToLower(otlpEnv(signal, "COMPRESSION", "none"))
+	if compression != "gzip" && compression != "none" {
+		return otlpConfig{}, fmt.Errorf("unsupported OTEL_EXPORTER_OTLP_COMPRESSION %q: expected gzip or none", compression)
+	}
+
+	cfg := otlpConfig{
+		protocol:    protocol,
+		endpoint:    endpoint.Host,
+		urlPath:     endpoint.Path,
+		headers:     headers,
+		tlsConfig:   tlsConfig,
+		timeout:     time.Duration(timeoutMS) * time.Millisecond,
+		compression: compression,
+		retry:       retry,
+	}
+	if signal == "METRICS" {
+		cfg.temporality, err = temporalitySelector(getEnv("OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE", "cumulative"))
+		if err != nil {
+			return otlpConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// temporalitySelector maps OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE
+// to the per-instrument temporality from the OTLP exporter specification.
+// AMP and Prometheus expect cumulative sums; CloudWatch (EMF and Container
+// Insights) expects deltas. Up-down counters stay cumulative in every mode
+// because their deltas cannot be summed back into a meaningful value.
+func temporalitySelector(preference string) (sdkmetric.TemporalitySelector, error) {
+	switch strings.ToLower(preference) {
+	case "cumulative":
+		return sdkmetric.DefaultTemporalitySelector, nil
+	case "delta":
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter,
+				sdkmetric.InstrumentKindObservableCounter,
+				sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			}
+			return metricdata.CumulativeTemporality
+		}, nil
+	case "lowmemory":
+		// Synchronous instruments only, so the SDK can drop their state after
+		// each export
+		return func(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+			switch kind {
+			case sdkmetric.InstrumentKindCounter,
+				sdkmetric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			}
+			return metricdata.CumulativeTemporality
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE %q", preference)
+	}
+}
+
+// otlpEndpoint resolves the endpoint URL of a signal as the OTLP exporter
+// specification describes it: OTEL_EXPORTER_OTLP_<SIGNAL>_ENDPOINT is used
+// as is, while OTEL_EXPORTER_OTLP_ENDPOINT is a base URL to which HTTP
+// exporters append the signal path. A bare host:port, which this app used to
+// require in the per-signal variables, is still accepted.
+func otlpEndpoint(signal, protocol string) (*url.URL, error) {
+	signalPath := "/v1/" + strings.ToLower(signal)
+
+	value := getEnv("OTEL_EXPORTER_OTLP_"+signal+"_ENDPOINT", "")
+	perSignal := value != ""
+	if !perSignal {
+		value = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4317")
+		if protocol == protocolHTTPProtobuf && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+			value = "http://localhost:4318"
+		}
+	}
+
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+		// A bare host:port has no path, so HTTP uses the default one
+		perSignal = false
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q for %s", value, strings.ToLower(signal))
+	}
+
+	if protocol == protocolHTTPProtobuf && !perSignal {
+		u.Path = strings.TrimSuffix(u.Path, "/") + signalPath
+	} else if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// parseOTLPHeaders parses the W3C Baggage-like format of
+// OTEL_EXPORTER_OTLP_HEADERS, e.g. "api-key=secret,tenant=team%20a"
+func parseOTLPHeaders(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	headers := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("entry %q is not key=value", entry)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", entry, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+// otlpEnv reads OTEL_EXPORTER_OTLP_<SIGNAL>_<NAME>, then
+// OTEL_EXPORTER_OTLP_<NAME>, then falls back to defaultValue
+func otlpEnv(signal, name, defaultValue string) string {
+	return getEnv("OTEL_EXPORTER_OTLP_"+signal+"_"+name,
+		getEnv("OTEL_EXPORTER_OTLP_"+name, defaultValue))
+}
+
+// loadOTLPTLSConfig builds the TLS settings for exporting to a collector
+// such as ADOT. Configuring a CA bundle or client certificate enables TLS;
+// adding the client certificate and key enables mTLS. With nothing set the
+// exporter stays on plaintext, which suits an in-cluster collector, unless
+// the endpoint URL uses the https scheme.
+func loadOTLPTLSConfig(signal, scheme string) (*tls.Config, error) {
+	caFile := otlpEnv(signal, "CERTIFICATE", "")
+	certFile := otlpEnv(signal, "CLIENT_CERTIFICATE", "")
+	keyFile := otlpEnv(signal, "CLIENT_KEY", "")
+	serverName := otlpEnv(signal, "TLS_SERVER_NAME", "")
+
+	insecure := caFile == "" && certFile == "" && serverName == "" && scheme != "https"
+	if value := otlpEnv(signal, "INSECURE", ""); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_INSECURE value %q: %w", value, err)
+		}
+		insecure = parsed
+	}
+	if insecure {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	// Without a CA bundle the system roots are used, which covers public
+	// endpoints and collectors fronted by an ACM certificate
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading OTLP CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading OTLP client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func newTraceExporter(ctx context.Context, cfg otlpConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.protocol {
+	case protocolGRPC:
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(cfg.endpoint),
+			otlptracegrpc.WithHeaders(cfg.headers),
+			otlptracegrpc.WithTimeout(cfg.timeout),
+			otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig(cfg.retry)),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(cfg.tlsConfig)))
+		} else {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			// Registers the gzip codec with gRPC as a side effect
+			opts = append(opts, otlptracegrpc.WithCompressor("gzip"))
+		}
+		for _, opt := range cfg.dialOptions {
+			opts = append(opts, otlptracegrpc.WithDialOption(opt))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case protocolHTTPProtobuf:
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(cfg.endpoint),
+			otlptracehttp.WithURLPath(cfg.urlPath),
+			otlptracehttp.WithHeaders(cfg.headers),
+			otlptracehttp.WithTimeout(cfg.timeout),
+			otlptracehttp.WithRetry(otlptracehttp.RetryConfig(cfg.retry)),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(cfg.tlsConfig))
+		} else {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+		}
+		if cfg.httpClient != nil {
+			opts = append(opts, otlptracehttp.WithHTTPClient(cfg.httpClient))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", cfg.protocol)
+	}
+}
+
+func newMetricExporter(ctx context.Context, cfg otlpConfig) (sdkmetric.Exporter, error) {
+	switch cfg.protocol {
+	case protocolGRPC:
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.endpoint),
+			otlpmetricgrpc.WithHeaders(cfg.headers),
+			otlpmetricgrpc.WithTimeout(cfg.timeout),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig(cfg.retry)),
+			otlpmetricgrpc.WithTemporalitySelector(cfg.temporality),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(cfg.tlsConfig)))
+		} else {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			// Registers the gzip codec with gRPC as a side effect
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		for _, opt := range cfg.dialOptions {
+			opts = append(opts, otlpmetricgrpc.WithDialOption(opt))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case protocolHTTPProtobuf:
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.endpoint),
+			otlpmetrichttp.WithURLPath(cfg.urlPath),
+			otlpmetrichttp.WithHeaders(cfg.headers),
+			otlpmetrichttp.WithTimeout(cfg.timeout),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig(cfg.retry)),
+			otlpmetrichttp.WithTemporalitySelector(cfg.temporality),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(cfg.tlsConfig))
+		} else {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+		if cfg.httpClient != nil {
+			opts = append(opts, otlpmetrichttp.WithHTTPClient(cfg.httpClient))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", cfg.protocol)
+	}
+}
+
+func newLogExporter(ctx context.Context, cfg otlpConfig) (sdklog.Exporter, error) {
+	switch cfg.protocol {
+	case protocolGRPC:
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(cfg.endpoint),
+			otlploggrpc.WithHeaders(cfg.headers),
+			otlploggrpc.WithTimeout(cfg.timeout),
+			otlploggrpc.WithRetry(otlploggrpc.RetryConfig(cfg.retry)),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(cfg.tlsConfig)))
+		} else {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			// Registers the gzip codec with gRPC as a side effect
+			opts = append(opts, otlploggrpc.WithCompressor("gzip"))
+		}
+		for _, opt := range cfg.dialOptions {
+			opts = append(opts, otlploggrpc.WithDialOption(opt))
+		}
+		return otlploggrpc.New(ctx, opts...)
+	case protocolHTTPProtobuf:
+		opts := []otlploghttp.Option{
+			otlploghttp.WithEndpoint(cfg.endpoint),
+			otlploghttp.WithURLPath(cfg.urlPath),
+			otlploghttp.WithHeaders(cfg.headers),
+			otlploghttp.WithTimeout(cfg.timeout),
+			otlploghttp.WithRetry(otlploghttp.RetryConfig(cfg.retry)),
+		}
+		if cfg.tlsConfig != nil {
+			opts = append(opts, otlploghttp.WithTLSClientConfig(cfg.tlsConfig))
+		} else {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		if cfg.compression == "gzip" {
+			opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+		}
+		if cfg.httpClient != nil {
+			opts = append(opts, otlploghttp.WithHTTPClient(cfg.httpClient))
+		}
+		return otlploghttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", cfg.protocol)
+	}
+}
+
+// getEnv returns the value of key, or defaultValue when it is unset or empty
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
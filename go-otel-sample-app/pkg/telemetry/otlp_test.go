@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		protocol  string
+		wantHost  string
+		wantPath  string
+		wantError bool
+	}{
+		{
+			name:     "grpc default",
+			protocol: protocolGRPC,
+			wantHost: "localhost:4317",
+			wantPath: "/",
+		},
+		{
+			name:     "http default",
+			protocol: protocolHTTPProtobuf,
+			wantHost: "localhost:4318",
+			wantPath: "/v1/traces",
+		},
+		{
+			name:     "http base URL gets the signal path",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "https://collector:4318/otlp/"},
+			protocol: protocolHTTPProtobuf,
+			wantHost: "collector:4318",
+			wantPath: "/otlp/v1/traces",
+		},
+		{
+			name:     "per-signal URL is used as is",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/custom"},
+			protocol: protocolHTTPProtobuf,
+			wantHost: "collector:4318",
+			wantPath: "/custom",
+		},
+		{
+			name:     "bare host:port",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "collector:4318"},
+			protocol: protocolHTTPProtobuf,
+			wantHost: "collector:4318",
+			wantPath: "/v1/traces",
+		},
+		{
+			name:      "no host",
+			env:       map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://"},
+			protocol:  protocolGRPC,
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			u, err := otlpEndpoint("TRACES", tt.protocol)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("otlpEndpoint = %v, want an error", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("otlpEndpoint: %v", err)
+			}
+			if u.Host != tt.wantHost || u.Path != tt.wantPath {
+				t.Errorf("otlpEndpoint = %s%s, want %s%s", u.Host, u.Path, tt.wantHost, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseOTLPHeaders(t *testing.T) {
+	headers, err := parseOTLPHeaders(" api-key=secret , tenant=team%20a,")
+	if err != nil {
+		t.Fatalf("parseOTLPHeaders: %v", err)
+	}
+	if len(headers) != 2 || headers["api-key"] != "secret" || headers["tenant"] != "team a" {
+		t.Errorf("headers = %v", headers)
+	}
+	if _, err := parseOTLPHeaders("novalue"); err == nil {
+		t.Errorf("parseOTLPHeaders accepted an entry without =")
+	}
+}
+
+func TestLoadOTLPConfig(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "2500")
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_COMPRESSION", "GZIP")
+	cfg, err := loadOTLPConfig("METRICS", DefaultRetryConfig)
+	if err != nil {
+		t.Fatalf("loadOTLPConfig: %v", err)
+	}
+	if cfg.timeout != 2500*time.Millisecond || cfg.compression != "gzip" || cfg.temporality == nil {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "0")
+	if _, err := loadOTLPConfig("METRICS", DefaultRetryConfig); err == nil {
+		t.Errorf("loadOTLPConfig accepted a zero timeout")
+	}
+}
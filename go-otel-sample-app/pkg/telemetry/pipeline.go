@@ -0,0 +1,359 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// pipelineReportInterval is how often losses are summarized in a warning
+const pipelineReportInterval = time.Minute
+
+// pipeline is the health of one exporter of a signal
+type pipeline struct {
+	signal string
+	// exporter is otlp, console, custom (an exporter passed as an option) or
+	// the name of an OTLPTarget
+	exporter string
+	up       atomic.Bool
+	// spooling is set while the exporter's requests go to the spool
+	// rather than the collector
+	spooling atomic.Bool
+	// queueDropped items never reached the exporter because the batch
+	// processor's queue was full
+	queueDropped atomic.Uint64
+	// exportDropped items were in batches whose export failed after the
+	// exporter's retries
+	exportDropped  atomic.Uint64
+	exportFailures atomic.Uint64
+	lastError      atomic.Pointer[string]
+
+	// reported is the snapshot of the last warning
+	reported PipelineStats
+}
+
+// queued reports whether the signal goes through a batch processor's
+// queue; metrics are exported straight from the periodic reader
+func (p *pipeline) queued() bool {
+	return p.signal != "metrics"
+}
+
+// PipelineStats describes the pipeline of one exporter of a signal: whether
+// the exporter is up, and what the pipeline has lost since New
+type PipelineStats struct {
+	Signal   string
+	Exporter string
+	// Up is true when the exporter exists and its last export succeeded;
+	// until the first export a created exporter counts as up
+	Up bool
+	// QueueDropped is shared by the exporters of a signal, which are fed
+	// by the same batch processor
+	QueueDropped   uint64
+	ExportDropped  uint64
+	ExportFailures uint64
+}
+
+func (p *pipeline) stats() PipelineStats {
+	return PipelineStats{
+		Signal:         p.signal,
+		Exporter:       p.exporter,
+		Up:             p.up.Load(),
+		QueueDropped:   p.queueDropped.Load(),
+		ExportDropped:  p.exportDropped.Load(),
+		ExportFailures: p.exportFailures.Load(),
+	}
+}
+
+// exportError marks an error as a failed export, which the error handler
+// leaves to the periodic warning rather than logging every batch
+type exportError struct {
+	signal string
+	err    error
+}
+
+func (e exportError) Error() string { return e.signal + " export failed: " + e.err.Error() }
+func (e exportError) Unwrap() error { return e.err }
+
+// PipelineStats reports the state of every exporter set up by this
+// package, by signal and in the order they were configured
+func (t *Telemetry) PipelineStats() []PipelineStats {
+	stats := make([]PipelineStats, len(t.pipelines))
+	for i, p := range t.pipelines {
+		stats[i] = p.stats()
+	}
+	return stats
+}
+
+// registerPipelineMetrics reports the pipelines' health as
+// telemetry_exporter_up, telemetry_dropped_total and
+// telemetry_export_failures_total
+func (t *Telemetry) registerPipelineMetrics() error {
+	m := t.MeterProvider.Meter(instrumentationName)
+	up, err := m.Int64ObservableGauge(
+		"telemetry_exporter_up",
+		metric.WithDescription("Whether an exporter of a signal exists and its last export succeeded (1) or not (0)"),
+	)
+	if err != nil {
+		return err
+	}
+	dropped, err := m.Int64ObservableCounter(
+		"telemetry_dropped_total",
+		metric.WithDescription("Spans, data points and log records lost by the telemetry pipeline by signal, exporter and reason"),
+	)
+	if err != nil {
+		return err
+	}
+	failures, err := m.Int64ObservableCounter(
+		"telemetry_export_failures_total",
+		metric.WithDescription("Exports that failed after the exporter's retries by signal and exporter"),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = m.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, p := range t.pipelines {
+			s := p.stats()
+			attrs := []attribute.KeyValue{attribute.String("signal", s.Signal), attribute.String("exporter", s.Exporter)}
+			var v int64
+			if s.Up {
+				v = 1
+			}
+			o.ObserveInt64(up, v, metric.WithAttributes(attrs...))
+			if p.queued() {
+				o.ObserveInt64(dropped, int64(s.QueueDropped), metric.WithAttributes(
+					append(attrs, attribute.String("reason", "queue_full"))...))
+			}
+			o.ObserveInt64(dropped, int64(s.ExportDropped), metric.WithAttributes(
+				append(attrs, attribute.String("reason", "export_failed"))...))
+			o.ObserveInt64(failures, int64(s.ExportFailures), metric.WithAttributes(attrs...))
+		}
+		return nil
+	}, up, dropped, failures)
+	if err != nil || t.spool == nil {
+		return err
+	}
+	return t.registerSpoolMetrics(m)
+}
+
+// registerSpoolMetrics reports the spool as telemetry_spool_bytes,
+// telemetry_spool_batches and telemetry_spool_batches_total
+func (t *Telemetry) registerSpoolMetrics(m metric.Meter) error {
+	size, err := m.Int64ObservableGauge(
+		"telemetry_spool_bytes",
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of the batches waiting in the spool by signal"),
+	)
+	if err != nil {
+		return err
+	}
+	batches, err := m.Int64ObservableGauge(
+		"telemetry_spool_batches",
+		metric.WithDescription("Batches waiting in the spool by signal"),
+	)
+	if err != nil {
+		return err
+	}
+	total, err := m.Int64ObservableCounter(
+		"telemetry_spool_batches_total",
+		metric.WithDescription("Batches spooled, replayed, refused by the full spool or rejected on replay by signal and result"),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = m.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for signal, s := range t.spool.stats() {
+			o.ObserveInt64(size, s.Bytes, metric.WithAttributes(attribute.String("signal", signal)))
+			o.ObserveInt64(batches, s.Batches, metric.WithAttributes(attribute.String("signal", signal)))
+			for result, v := range map[string]uint64{
+				"spooled":  s.Spooled,
+				"replayed": s.Replayed,
+				"full":     s.Full,
+				"rejected": s.Rejected,
+			} {
+				o.ObserveInt64(total, int64(v), metric.WithAttributes(
+					attribute.String("signal", signal), attribute.String("result", result)))
+			}
+		}
+		return nil
+	}, size, batches, total)
+	return err
+}
+
+// reportPipelines warns about every signal that lost data since the last
+// report, so an unhealthy pipeline shows up in the logs without a warning
+// per failed batch
+func (t *Telemetry) reportPipelines() {
+	for _, p := range t.pipelines {
+		s := p.stats()
+		last := p.reported
+		p.reported = s
+		if s.QueueDropped == last.QueueDropped && s.ExportDropped == last.ExportDropped && s.ExportFailures == last.ExportFailures {
+			continue
+		}
+		attrs := []any{"signal", s.Signal, "exporter", s.Exporter}
+		if p.queued() {
+			attrs = append(attrs, "dropped_queue_full", s.QueueDropped-last.QueueDropped)
+		}
+		attrs = append(attrs,
+			"dropped_export_failed", s.ExportDropped-last.ExportDropped,
+			"export_failures", s.ExportFailures-last.ExportFailures,
+		)
+		if err := p.lastError.Load(); err != nil {
+			attrs = append(attrs, "last_error", *err)
+		}
+		t.logger().Warn("Telemetry pipeline is losing data", attrs...)
+	}
+}
+
+// runPipelineReports reports every interval until stop is closed
+func (t *Telemetry) runPipelineReports(stop <-chan struct{}) {
+	ticker := time.NewTicker(pipelineReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.reportPipelines()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleError is the global OTel error handler. Failed exports are counted
+// and summarized by reportPipelines; anything else is logged as it comes.
+func (t *Telemetry) handleError(err error) {
+	var exportErr exportError
+	if errors.As(err, &exportErr) {
+		t.logger().Debug("OpenTelemetry export failed", "signal", exportErr.signal, "error", exportErr.err)
+		return
+	}
+	t.logger().Warn("OpenTelemetry SDK error", "error", err)
+}
+
+// sdkLogSink receives the SDK's internal log messages. The batch span and
+// log processors report queue drops nowhere else: the span processor puts
+// its running total on the debug message of every export, the log
+// processor warns with the number dropped since its previous message.
+// There is one processor per signal, so the drops count against every
+// exporter of the signal. Other warnings are passed on to the logger.
+type sdkLogSink struct {
+	t *Telemetry
+	// spansDropped is the span processor's last reported total
+	spansDropped atomic.Uint64
+}
+
+func (s *sdkLogSink) Init(logr.RuntimeInfo) {}
+
+func (s *sdkLogSink) Enabled(int) bool { return true }
+
+func (s *sdkLogSink) Info(level int, msg string, keysAndValues ...any) {
+	switch msg {
+	case "exporting spans":
+		if total, ok := sdkLogValue(keysAndValues, "total_dropped"); ok {
+			if last := s.spansDropped.Swap(total); total > last {
+				s.t.queueDropped("traces", total-last)
+			}
+		}
+	case "dropped log records":
+		if dropped, ok := sdkLogValue(keysAndValues, "dropped"); ok {
+			s.t.queueDropped("logs", dropped)
+		}
+	default:
+		// Warnings are V(1); info and debug messages are only for
+		// debugging the SDK
+		if level <= 1 {
+			s.t.logger().Warn(msg, keysAndValues...)
+		} else {
+			s.t.logger().Debug(msg, keysAndValues...)
+		}
+	}
+}
+
+func (s *sdkLogSink) Error(err error, msg string, keysAndValues ...any) {
+	s.t.logger().Error(msg, append(keysAndValues, "error", err)...)
+}
+
+func (s *sdkLogSink) WithValues(...any) logr.LogSink { return s }
+
+func (s *sdkLogSink) WithName(string) logr.LogSink { return s }
+
+// sdkLogValue returns the unsigned integer value of key
+func sdkLogValue(keysAndValues []any, key string) (uint64, bool) {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] != key {
+			continue
+		}
+		switch v := keysAndValues[i+1].(type) {
+		case uint32:
+			return uint64(v), true
+		case uint64:
+			return v, true
+		case int:
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+// queueDropped counts n items dropped by the batch processor of signal
+func (t *Telemetry) queueDropped(signal string, n uint64) {
+	for _, p := range t.pipelines {
+		if p.signal == signal {
+			p.queueDropped.Add(n)
+		}
+	}
+}
+
+// installSDKHooks routes the SDK's errors and internal log messages to the
+// pipelines and the logger
+func (t *Telemetry) installSDKHooks() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(t.handleError))
+	otel.SetLogger(logr.New(&sdkLogSink{t: t}))
+}
+
+// dataPoints counts the data points of rm, the items of a metric export
+func dataPoints(rm *metricdata.ResourceMetrics) int {
+	n := 0
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				n += len(data.DataPoints)
+			case metricdata.Gauge[float64]:
+				n += len(data.DataPoints)
+			case metricdata.Sum[int64]:
+				n += len(data.DataPoints)
+			case metricdata.Sum[float64]:
+				n += len(data.DataPoints)
+			case metricdata.Histogram[int64]:
+				n += len(data.DataPoints)
+			case metricdata.Histogram[float64]:
+				n += len(data.DataPoints)
+			case metricdata.ExponentialHistogram[int64]:
+				n += len(data.DataPoints)
+			case metricdata.ExponentialHistogram[float64]:
+				n += len(data.DataPoints)
+			case metricdata.Summary:
+				n += len(data.DataPoints)
+			}
+		}
+	}
+	return n
+}
+
+// logger is the WithLogger logger, or the default one at the time of the
+// call, so a default set after New is used
+func (t *Telemetry) logger() *slog.Logger {
+	if t.log != nil {
+		return t.log
+	}
+	return slog.Default()
+}
@@ -0,0 +1,92 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestExportFailuresAreCountedAndReported(t *testing.T) {
+	var out bytes.Buffer
+	reader := sdkmetric.NewManualReader()
+	tel, err := New(context.Background(),
+		WithSpanExporter(failingSpanExporter{}),
+		WithMetricReader(reader),
+		WithoutLogs(),
+		WithLogger(slog.New(slog.NewTextHandler(&out, nil))),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+
+	for range 3 {
+		_, span := tel.Tracer("test").Start(context.Background(), "work")
+		span.End()
+	}
+	tel.ForceFlush(context.Background())
+
+	want := PipelineStats{Signal: "traces", Exporter: "custom", ExportDropped: 3, ExportFailures: 1}
+	if got := tel.PipelineStats()[0]; got != want {
+		t.Errorf("PipelineStats = %+v, want %+v", got, want)
+	}
+
+	sum, ok := collectMetric(t, reader, "telemetry_dropped_total").(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("telemetry_dropped_total is not a sum")
+	}
+	found := false
+	for _, point := range sum.DataPoints {
+		if reason, _ := point.Attributes.Value(attribute.Key("reason")); reason.AsString() != "export_failed" {
+			continue
+		}
+		found = true
+		if point.Value != 3 {
+			t.Errorf("telemetry_dropped_total{reason=export_failed} = %d, want 3", point.Value)
+		}
+	}
+	if !found {
+		t.Errorf("telemetry_dropped_total has no reason=export_failed data point")
+	}
+
+	tel.reportPipelines()
+	if log := out.String(); !strings.Contains(log, "Telemetry pipeline is losing data") ||
+		!strings.Contains(log, "dropped_export_failed=3") ||
+		!strings.Contains(log, `last_error="connection refused"`) {
+		t.Errorf("report = %q", log)
+	}
+
+	// Nothing new to report
+	out.Reset()
+	tel.reportPipelines()
+	if out.Len() != 0 {
+		t.Errorf("second report = %q, want nothing", out.String())
+	}
+}
+
+func TestSDKLogSinkCountsQueueDrops(t *testing.T) {
+	tel := &Telemetry{}
+	traces, logs := tel.pipeline("traces", "otlp"), tel.pipeline("logs", "otlp")
+	sink := &sdkLogSink{t: tel}
+
+	// The span processor reports its running total on every export
+	sink.Info(8, "exporting spans", "count", 10, "total_dropped", uint32(4))
+	sink.Info(8, "exporting spans", "count", 10, "total_dropped", uint32(4))
+	sink.Info(8, "exporting spans", "count", 10, "total_dropped", uint32(9))
+	if got := traces.queueDropped.Load(); got != 9 {
+		t.Errorf("spans dropped = %d, want 9", got)
+	}
+
+	// The log processor reports the records dropped since its last message
+	sink.Info(1, "dropped log records", "dropped", uint64(5))
+	sink.Info(1, "dropped log records", "dropped", uint64(2))
+	if got := logs.queueDropped.Load(); got != 7 {
+		t.Errorf("log records dropped = %d, want 7", got)
+	}
+}
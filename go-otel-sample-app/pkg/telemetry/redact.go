@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// AttributeRedactor returns kv with any sensitive value removed, or kv
+// unchanged
+type AttributeRedactor func(kv attribute.KeyValue) attribute.KeyValue
+
+// redactingExporter passes spans to the exporters with the attributes of
+// the spans and their events redacted. It sits in front of the exporters,
+// so the spool only ever holds redacted spans too.
+type redactingExporter struct {
+	sdktrace.SpanExporter
+	redact AttributeRedactor
+}
+
+func (e redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		redacted[i] = newRedactedSpan(s, e.redact)
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan is a span with redacted attributes. Spans cannot be changed
+// once ended, so the redacted copies are returned in place of the originals.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	attributes []attribute.KeyValue
+	events     []sdktrace.Event
+}
+
+func newRedactedSpan(s sdktrace.ReadOnlySpan, redact AttributeRedactor) redactedSpan {
+	var events []sdktrace.Event
+	for _, event := range s.Events() {
+		event.Attributes = redactAttributes(event.Attributes, redact)
+		events = append(events, event)
+	}
+	return redactedSpan{
+		ReadOnlySpan: s,
+		attributes:   redactAttributes(s.Attributes(), redact),
+		events:       events,
+	}
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue { return s.attributes }
+func (s redactedSpan) Events() []sdktrace.Event         { return s.events }
+
+func redactAttributes(attrs []attribute.KeyValue, redact AttributeRedactor) []attribute.KeyValue {
+	if len(attrs) == 0 {
+		return attrs
+	}
+	redacted := make([]attribute.KeyValue, len(attrs))
+	for i, kv := range attrs {
+		redacted[i] = redact(kv)
+	}
+	return redacted
+}
@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// detectionTimeout bounds resource detection; detectors that query a
+// metadata endpoint such as IMDS would otherwise hang where it is
+// unreachable
+const detectionTimeout = 5 * time.Second
+
+// newResource describes the process: the service attributes, the SDK's
+// host, OS, process and container detectors, the caller's options, and
+// finally OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME, so values injected
+// by the OTel Operator win as in any other OTel SDK.
+//
+// The resource carries the schema URL of the semantic conventions the SDK
+// detectors follow. A detector failing, or one reporting a different
+// schema, doesn't stop the process: the partial or schemaless resource is
+// still used and the error goes to the global OTel error handler.
+func newResource(ctx context.Context, c *config) (*resource.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, detectionTimeout)
+	defer cancel()
+
+	name := c.serviceName
+	if name == "" {
+		name = "unknown_service:" + filepath.Base(os.Args[0])
+	}
+	attrs := []attribute.KeyValue{semconv.ServiceName(name)}
+	if c.serviceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(c.serviceVersion))
+	}
+
+	opts := []resource.Option{
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(attrs...),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithOS(),
+		resource.WithProcess(),
+		resource.WithContainer(),
+	}
+	opts = append(opts, c.resourceOptions...)
+	// OTEL_SERVICE_NAME takes precedence over a service.name in
+	// OTEL_RESOURCE_ATTRIBUTES
+	opts = append(opts, resource.WithFromEnv())
+
+	res, err := resource.New(ctx, opts...)
+	if errors.Is(err, resource.ErrPartialResource) || errors.Is(err, resource.ErrSchemaURLConflict) {
+		otel.Handle(err)
+		err = nil
+	}
+	return res, err
+}
+
+// buildVersion is the version of the main module, or the VCS revision it
+// was built from when it has none, as for `go build` in a checkout
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return s.Value[:12]
+		}
+	}
+	return ""
+}
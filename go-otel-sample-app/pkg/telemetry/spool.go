@@ -0,0 +1,542 @@
+package telemetry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor used by replays
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// spoolReplayInterval is how often the spool tries to replay its batches
+// when no export succeeds in between; a variable so tests can shorten it
+var spoolReplayInterval = 5 * time.Second
+
+// spoolSignals are the signals a spool keeps batches for, in replay order
+var spoolSignals = []string{"traces", "metrics", "logs"}
+
+// spool keeps the OTLP requests the collector could not take in files, one
+// per batch, and replays them oldest first once it takes requests again.
+// It sits in the exporters' transport: a request that fails with a
+// retryable error is written to disk and reported to the exporter as
+// delivered, so the batch survives the exporter's retries running out and
+// the process restarting. The files hold the signal's protobuf Export
+// request, whatever the protocol, and are named
+// <signal>-<unix nanoseconds>-<sequence>.pb so they sort by age.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	signals map[string]*spoolSignal
+	seq     uint64
+
+	// kick asks for a replay before the next tick
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type spoolSignal struct {
+	p *pipeline
+	// send delivers a spooled request; nil until the signal's exporter is
+	// configured to spool
+	send  func(ctx context.Context, body []byte) error
+	close func()
+	stats SpoolStats
+}
+
+// SpoolStats describes the spooled batches of a signal. Bytes and Batches
+// are what is on disk now, the rest counts batches since New.
+type SpoolStats struct {
+	Bytes   int64
+	Batches int64
+	// Spooled batches were written to disk after an export failed
+	Spooled uint64
+	// Replayed batches were delivered from disk
+	Replayed uint64
+	// Full batches were not spooled because the spool was at its size
+	// limit; they failed like any export
+	Full uint64
+	// Rejected batches were deleted because the collector refused them on
+	// replay with a non-retryable error
+	Rejected uint64
+}
+
+// spoolRejected marks a replay the collector refused for good
+type spoolRejected struct {
+	err error
+}
+
+func (e spoolRejected) Error() string { return e.err.Error() }
+func (e spoolRejected) Unwrap() error { return e.err }
+
+// newSpool opens the spool in dir, counting the batches a previous process
+// left there so they are replayed first, and starts the replay loop
+func newSpool(dir string, maxBytes int64) (*spool, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("spool size limit must be positive, got %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		signals:  map[string]*spoolSignal{},
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, signal := range spoolSignals {
+		s.signals[signal] = &spoolSignal{}
+		names, err := s.files(signal)
+		if err != nil {
+			return nil, fmt.Errorf("reading spool directory: %w", err)
+		}
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			s.signals[signal].stats.Bytes += info.Size()
+			s.signals[signal].stats.Batches++
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// files lists the spooled batches of signal, oldest first
+func (s *spool) files(signal string) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name := e.Name(); e.Type().IsRegular() && strings.HasPrefix(name, signal+"-") && strings.HasSuffix(name, ".pb") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// write spools body, a serialized Export request of signal. It reports
+// false when the batch was not spooled, leaving the export failed.
+func (s *spool) write(signal string, body []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.signals[signal]
+	var size int64
+	for _, other := range s.signals {
+		size += other.stats.Bytes
+	}
+	if size+int64(len(body)) > s.maxBytes {
+		ss.stats.Full++
+		return false
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%s-%020d-%010d.pb", signal, time.Now().UnixNano(), s.seq)
+	// Written under a temporary name and renamed, so a crash never leaves a
+	// truncated batch to replay
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, body, 0o640); err != nil {
+		otel.Handle(fmt.Errorf("spooling %s batch: %w", signal, err))
+		os.Remove(tmp)
+		return false
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		otel.Handle(fmt.Errorf("spooling %s batch: %w", signal, err))
+		os.Remove(tmp)
+		return false
+	}
+	ss.stats.Bytes += int64(len(body))
+	ss.stats.Batches++
+	ss.stats.Spooled++
+	if ss.p != nil {
+		ss.p.spooling.Store(true)
+	}
+	return true
+}
+
+// delivered notes a live export of signal that reached the collector and
+// asks for a replay of what it missed before
+func (s *spool) delivered(signal string) {
+	s.mu.Lock()
+	ss := s.signals[signal]
+	pending := ss.stats.Batches > 0
+	if ss.p != nil {
+		ss.p.spooling.Store(false)
+	}
+	s.mu.Unlock()
+	if pending {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *spool) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.kick:
+		case <-s.stop:
+			return
+		}
+		for _, signal := range spoolSignals {
+			s.replay(signal)
+		}
+	}
+}
+
+// replay sends the batches of signal, oldest first, until one fails with a
+// retryable error. A batch is deleted once delivered or rejected for good.
+func (s *spool) replay(signal string) {
+	s.mu.Lock()
+	ss := s.signals[signal]
+	send, pending := ss.send, ss.stats.Batches > 0
+	s.mu.Unlock()
+	if send == nil || !pending {
+		return
+	}
+	names, err := s.files(signal)
+	if err != nil {
+		otel.Handle(fmt.Errorf("reading spool directory: %w", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for _, name := range names {
+		path := filepath.Join(s.dir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			otel.Handle(fmt.Errorf("reading spooled %s batch: %w", signal, err))
+			continue
+		}
+		err = send(ctx, body)
+		var rejected spoolRejected
+		if err != nil && !errors.As(err, &rejected) {
+			return
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			otel.Handle(fmt.Errorf("deleting spooled %s batch: %w", signal, err))
+			return
+		}
+
+		s.mu.Lock()
+		ss.stats.Bytes -= int64(len(body))
+		ss.stats.Batches--
+		if rejected.err != nil {
+			ss.stats.Rejected++
+		} else {
+			ss.stats.Replayed++
+		}
+		s.mu.Unlock()
+		if rejected.err != nil {
+			otel.Handle(fmt.Errorf("collector rejected a spooled %s batch, deleting it: %w", signal, rejected.err))
+		}
+	}
+}
+
+// close stops replaying and closes the replay connections. Batches still on
+// disk are replayed by the next process using the directory.
+func (s *spool) close() {
+	select {
+	case <-s.stop:
+		return
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ss := range s.signals {
+		if ss.close != nil {
+			ss.close()
+		}
+	}
+}
+
+func (s *spool) stats() map[string]SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]SpoolStats, len(s.signals))
+	for signal, ss := range s.signals {
+		stats[signal] = ss.stats
+	}
+	return stats
+}
+
+// attach makes the exporter configured by cfg spool its failed requests,
+// and sets up the replay of signal over the same protocol. The replays use
+// their own connection, so they don't depend on the exporter's lifecycle.
+func (s *spool) attach(signal string, cfg *otlpConfig, p *pipeline) error {
+	var (
+		send    func(context.Context, []byte) error
+		closeFn func()
+	)
+	switch cfg.protocol {
+	case protocolGRPC:
+		creds := insecure.NewCredentials()
+		if cfg.tlsConfig != nil {
+			creds = credentials.NewTLS(cfg.tlsConfig)
+		}
+		conn, err := grpc.NewClient(cfg.endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return fmt.Errorf("creating %s spool client: %w", signal, err)
+		}
+		cfg.dialOptions = append(cfg.dialOptions, grpc.WithChainUnaryInterceptor(s.interceptor(signal)))
+		send = grpcReplay(signal, conn, *cfg)
+		closeFn = func() { conn.Close() }
+	case protocolHTTPProtobuf:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.tlsConfig
+		// The exporter ignores its TLS settings when given a client, so
+		// the transport carries them
+		cfg.httpClient = &http.Client{Transport: &spoolTransport{s: s, signal: signal, base: transport}}
+		send = httpReplay(&http.Client{Transport: transport}, *cfg)
+		closeFn = transport.CloseIdleConnections
+	default:
+		return fmt.Errorf("unsupported OTLP protocol %q", cfg.protocol)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss := s.signals[signal]
+	ss.p, ss.send, ss.close = p, send, closeFn
+	return nil
+}
+
+// interceptor spools the Export requests that fail with a retryable status
+func (s *spool) interceptor(signal string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			s.delivered(signal)
+			return nil
+		}
+		msg, ok := req.(proto.Message)
+		if !ok || !grpcRetryable(err) {
+			return err
+		}
+		body, merr := proto.Marshal(msg)
+		if merr != nil || !s.write(signal, body) {
+			return err
+		}
+		return nil
+	}
+}
+
+// grpcRetryable reports whether a failed export may succeed later. The
+// collector answers a full queue with ResourceExhausted.
+func grpcRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// grpcReplay sends spooled requests of signal over conn
+func grpcReplay(signal string, conn *grpc.ClientConn, cfg otlpConfig) func(context.Context, []byte) error {
+	var callOpts []grpc.CallOption
+	if cfg.compression == "gzip" {
+		callOpts = append(callOpts, grpc.UseCompressor("gzip"))
+	}
+	export := func(ctx context.Context, body []byte) error {
+		switch signal {
+		case "traces":
+			req := &coltracepb.ExportTraceServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				return err
+			}
+			_, err := coltracepb.NewTraceServiceClient(conn).Export(ctx, req, callOpts...)
+			return err
+		case "metrics":
+			req := &colmetricpb.ExportMetricsServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				return err
+			}
+			_, err := colmetricpb.NewMetricsServiceClient(conn).Export(ctx, req, callOpts...)
+			return err
+		default:
+			req := &collogspb.ExportLogsServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				return err
+			}
+			_, err := collogspb.NewLogsServiceClient(conn).Export(ctx, req, callOpts...)
+			return err
+		}
+	}
+	return func(parent context.Context, body []byte) error {
+		ctx, cancel := context.WithTimeout(parent, cfg.timeout)
+		defer cancel()
+		if len(cfg.headers) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(cfg.headers))
+		}
+		err := export(ctx, body)
+		// A replay cut short by close is retried by the next process
+		if err != nil && parent.Err() == nil && !grpcRetryable(err) {
+			return spoolRejected{err}
+		}
+		return err
+	}
+}
+
+// spoolTransport spools the Export requests that fail to connect or are
+// answered with a retryable status, answering the exporter with an empty
+// success in their place
+type spoolTransport struct {
+	s      *spool
+	signal string
+	base   http.RoundTripper
+}
+
+func (t *spoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && !httpRetryable(resp.StatusCode) {
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			t.s.delivered(t.signal)
+		}
+		return resp, nil
+	}
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		if body, err = gunzip(body); err != nil {
+			return resp, err
+		}
+	}
+	if !t.s.write(t.signal, body) {
+		return resp, err
+	}
+	if resp != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// httpRetryable reports whether an export answered with code may succeed
+// later, by the OTLP/HTTP specification
+func httpRetryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// httpReplay posts spooled requests to the exporter's URL
+func httpReplay(client *http.Client, cfg otlpConfig) func(context.Context, []byte) error {
+	scheme := "http"
+	if cfg.tlsConfig != nil {
+		scheme = "https"
+	}
+	url := scheme + "://" + cfg.endpoint + cfg.urlPath
+	return func(ctx context.Context, body []byte) error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+		encoding := ""
+		if cfg.compression == "gzip" {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			if _, err := gz.Write(body); err != nil {
+				return err
+			}
+			if err := gz.Close(); err != nil {
+				return err
+			}
+			body, encoding = buf.Bytes(), "gzip"
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range cfg.headers {
+			req.Header.Set(k, v)
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+			return nil
+		case httpRetryable(resp.StatusCode):
+			return fmt.Errorf("replaying to %s: %s", url, resp.Status)
+		default:
+			return spoolRejected{fmt.Errorf("replaying to %s: %s", url, resp.Status)}
+		}
+	}
+}
+
+func gunzip(body []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
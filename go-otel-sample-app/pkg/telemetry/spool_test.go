@@ -0,0 +1,117 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSpoolSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 10)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	if !s.write("traces", []byte("12345678")) {
+		t.Fatalf("first batch not spooled")
+	}
+	if s.write("logs", []byte("12345678")) {
+		t.Errorf("batch spooled past the size limit")
+	}
+	stats := s.stats()
+	if got := stats["traces"]; got.Bytes != 8 || got.Batches != 1 || got.Spooled != 1 {
+		t.Errorf("traces = %+v, want one 8-byte batch", got)
+	}
+	if got := stats["logs"]; got.Full != 1 || got.Batches != 0 {
+		t.Errorf("logs = %+v, want one batch refused", got)
+	}
+	s.close()
+
+	// A new process finds the batch left behind
+	reopened, err := newSpool(dir, 10)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	defer reopened.close()
+	if got := reopened.stats()["traces"]; got.Bytes != 8 || got.Batches != 1 {
+		t.Errorf("reopened traces = %+v, want the batch left behind", got)
+	}
+}
+
+func TestSpoolReplaysOverHTTP(t *testing.T) {
+	interval := spoolReplayInterval
+	spoolReplayInterval = 10 * time.Millisecond
+	t.Cleanup(func() { spoolReplayInterval = interval })
+
+	var (
+		available atomic.Bool
+		mu        sync.Mutex
+		received  []*coltracepb.ExportTraceServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	t.Setenv("OTEL_METRICS_EXPORTER", "none")
+	t.Setenv("OTEL_LOGS_EXPORTER", "none")
+	tel, err := New(context.Background(), WithSpool(t.TempDir(), 1<<20))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+
+	_, span := tel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	if err := tel.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush with the collector down: %v", err)
+	}
+	if got := tel.SpoolStats()["traces"]; got.Batches != 1 || got.Spooled != 1 {
+		t.Fatalf("traces = %+v, want the batch spooled", got)
+	}
+	if stats := tel.PipelineStats()[0]; stats.Up || stats.ExportDropped != 0 {
+		t.Errorf("PipelineStats = %+v, want traces down while spooling, nothing dropped", stats)
+	}
+
+	available.Store(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for tel.SpoolStats()["traces"].Batches != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("spool not replayed: %+v", tel.SpoolStats()["traces"])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := tel.SpoolStats()["traces"]; got.Replayed != 1 || got.Bytes != 0 {
+		t.Errorf("traces = %+v, want the batch replayed", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("collector received %d requests, want the replayed one", len(received))
+	}
+	if name := received[0].GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0].GetName(); name != "work" {
+		t.Errorf("replayed span = %q, want work", name)
+	}
+}
@@ -0,0 +1,342 @@
+// Package telemetry sets up the OpenTelemetry SDK for traces, metrics and
+// logs: a resource describing the service, a provider per signal exporting
+// over OTLP as configured by the standard OTEL_* variables, and the global
+// providers and propagator. It depends on nothing else in this sample, so
+// it can be copied into another service as it is:
+//
+//	tel, err := telemetry.New(ctx, telemetry.WithServiceName("checkout"))
+//	if err != nil {
+//		return err
+//	}
+//	defer tel.Shutdown(context.Background())
+//	tracer := tel.Tracer("checkout")
+//
+// OTEL_TRACES_EXPORTER, OTEL_METRICS_EXPORTER and OTEL_LOGS_EXPORTER take
+// otlp (the default), console, which writes JSON to stdout for running
+// without a collector, or none, and OTEL_SDK_DISABLED=true turns every
+// signal off.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Telemetry holds the providers set up by New. A provider is nil when its
+// signal is disabled.
+type Telemetry struct {
+	Resource *resource.Resource
+	// ServiceName is the resolved service.name, environment overrides
+	// included
+	ServiceName    string
+	ServiceVersion string
+
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+	LoggerProvider *sdklog.LoggerProvider
+
+	// pipelines has the health of each exporter
+	pipelines []*pipeline
+	// spool is nil unless WithSpool is used
+	spool *spool
+	log   *slog.Logger
+	// stopReports ends the periodic warnings, reportsDone once they ended
+	stopReports chan struct{}
+	reportsDone chan struct{}
+}
+
+// instrumentationName is the scope of the package's own metrics
+const instrumentationName = "go-otel-sample-app/pkg/telemetry"
+
+// Exporters, as named by OTEL_<SIGNAL>_EXPORTER and WithExporter
+const (
+	ExporterOTLP = "otlp"
+	// ExporterConsole writes JSON to stdout; stdout is accepted as an alias
+	ExporterConsole = "console"
+	ExporterNone    = "none"
+)
+
+// exporterCustom names the pipeline of an exporter passed as an option
+const exporterCustom = "custom"
+
+// New builds the resource and the providers and installs them, with the
+// propagator, as the OTel globals. The SDK's errors and internal messages
+// go to the logger, and what the pipelines lose is counted and reported in
+// a warning every minute. An exporter that cannot be created is
+// retried in the background rather than failing New, so the service starts
+// without its collector. Call Shutdown before exiting to flush the last
+// batches.
+func New(ctx context.Context, opts ...Option) (*Telemetry, error) {
+	c := newConfig(opts)
+	names := map[string]bool{}
+	for _, target := range c.targets {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("OTLP target %s is configured twice", target.Name)
+		}
+		names[target.Name] = true
+	}
+	if disabled, _ := strconv.ParseBool(getEnv("OTEL_SDK_DISABLED", "false")); disabled {
+		c.traces, c.metrics, c.logs = false, false, false
+	}
+
+	res, err := newResource(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+	t := &Telemetry{
+		Resource:       res,
+		ServiceVersion: c.serviceVersion,
+		log:            c.logger,
+		stopReports:    make(chan struct{}),
+		reportsDone:    make(chan struct{}),
+	}
+	t.installSDKHooks()
+	go func() {
+		defer close(t.reportsDone)
+		t.runPipelineReports(t.stopReports)
+	}()
+	if name, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+		t.ServiceName = name.AsString()
+	}
+	if c.spoolDir != "" {
+		if t.spool, err = newSpool(c.spoolDir, c.spoolMaxBytes); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+	}
+
+	if c.traces {
+		if t.TracerProvider, err = t.newTracerProvider(c); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+		otel.SetTracerProvider(t.TracerProvider)
+	}
+	if c.metrics {
+		if t.MeterProvider, err = t.newMeterProvider(c); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+		if err := t.registerPipelineMetrics(); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+		otel.SetMeterProvider(t.MeterProvider)
+	}
+	if c.logs {
+		if t.LoggerProvider, err = t.newLoggerProvider(c); err != nil {
+			return nil, errors.Join(err, t.Shutdown(ctx))
+		}
+		global.SetLoggerProvider(t.LoggerProvider)
+	}
+	otel.SetTextMapPropagator(c.propagator)
+	return t, nil
+}
+
+func (t *Telemetry) newTracerProvider(c *config) (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(t.Resource)}
+	factories := []exporterFactory[sdktrace.SpanExporter]{{
+		name:   exporterCustom,
+		create: func(context.Context) (sdktrace.SpanExporter, error) { return c.spanExporter, nil },
+	}}
+	if c.spanExporter == nil {
+		var err error
+		if factories, err = newFactories(t, c, "traces", newTraceExporter, newConsoleTraceExporter); err != nil {
+			return nil, err
+		}
+	}
+	if len(factories) > 0 {
+		exporters := make(spanExporters, len(factories))
+		for i, f := range factories {
+			exporters[i] = spanExporter{connect("traces", t.pipeline("traces", f.name), f.create)}
+		}
+		var exporter sdktrace.SpanExporter = exporters
+		if c.spanRedactor != nil {
+			exporter = redactingExporter{exporter, c.spanRedactor}
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter, c.spanBatch.spanOptions()...))
+	}
+	return sdktrace.NewTracerProvider(append(opts, c.tracerProviderOptions...)...), nil
+}
+
+func (t *Telemetry) newMeterProvider(c *config) (*sdkmetric.MeterProvider, error) {
+	opts := []sdkmetric.Option{sdkmetric.WithResource(t.Resource)}
+	if c.metricReader != nil {
+		opts = append(opts, sdkmetric.WithReader(c.metricReader))
+	} else {
+		factories, err := newFactories(t, c, "metrics", newMetricExporter, newConsoleMetricExporter)
+		if err != nil {
+			return nil, err
+		}
+		// A reader per exporter, as each may want its own temporality
+		for _, f := range factories {
+			conn := connect("metrics", t.pipeline("metrics", f.name), f.create)
+			reader := sdkmetric.NewPeriodicReader(metricExporter{conn, f.temporality}, c.reader.options()...)
+			opts = append(opts, sdkmetric.WithReader(reader))
+		}
+	}
+	return sdkmetric.NewMeterProvider(append(opts, c.meterProviderOptions...)...), nil
+}
+
+func (t *Telemetry) newLoggerProvider(c *config) (*sdklog.LoggerProvider, error) {
+	opts := []sdklog.LoggerProviderOption{sdklog.WithResource(t.Resource)}
+	factories := []exporterFactory[sdklog.Exporter]{{
+		name:   exporterCustom,
+		create: func(context.Context) (sdklog.Exporter, error) { return c.logExporter, nil },
+	}}
+	if c.logExporter == nil {
+		var err error
+		if factories, err = newFactories(t, c, "logs", newLogExporter, newConsoleLogExporter); err != nil {
+			return nil, err
+		}
+	}
+	if len(factories) > 0 {
+		exporters := make(logExporters, len(factories))
+		for i, f := range factories {
+			exporters[i] = logExporter{connect("logs", t.pipeline("logs", f.name), f.create)}
+		}
+		opts = append(opts, sdklog.WithProcessor(sdklog.NewBatchProcessor(exporters, c.logBatch.logOptions()...)))
+	}
+	return sdklog.NewLoggerProvider(append(opts, c.loggerProviderOptions...)...), nil
+}
+
+func (b BatchConfig) spanOptions() []sdktrace.BatchSpanProcessorOption {
+	var opts []sdktrace.BatchSpanProcessorOption
+	if b.MaxQueueSize > 0 {
+		opts = append(opts, sdktrace.WithMaxQueueSize(b.MaxQueueSize))
+	}
+	if b.MaxExportBatchSize > 0 {
+		opts = append(opts, sdktrace.WithMaxExportBatchSize(b.MaxExportBatchSize))
+	}
+	if b.ScheduleDelay > 0 {
+		opts = append(opts, sdktrace.WithBatchTimeout(b.ScheduleDelay))
+	}
+	if b.ExportTimeout > 0 {
+		opts = append(opts, sdktrace.WithExportTimeout(b.ExportTimeout))
+	}
+	return opts
+}
+
+func (b BatchConfig) logOptions() []sdklog.BatchProcessorOption {
+	var opts []sdklog.BatchProcessorOption
+	if b.MaxQueueSize > 0 {
+		opts = append(opts, sdklog.WithMaxQueueSize(b.MaxQueueSize))
+	}
+	if b.MaxExportBatchSize > 0 {
+		opts = append(opts, sdklog.WithExportMaxBatchSize(b.MaxExportBatchSize))
+	}
+	if b.ScheduleDelay > 0 {
+		opts = append(opts, sdklog.WithExportInterval(b.ScheduleDelay))
+	}
+	if b.ExportTimeout > 0 {
+		opts = append(opts, sdklog.WithExportTimeout(b.ExportTimeout))
+	}
+	return opts
+}
+
+func (r ReaderConfig) options() []sdkmetric.PeriodicReaderOption {
+	var opts []sdkmetric.PeriodicReaderOption
+	if r.Interval > 0 {
+		opts = append(opts, sdkmetric.WithInterval(r.Interval))
+	}
+	if r.Timeout > 0 {
+		opts = append(opts, sdkmetric.WithTimeout(r.Timeout))
+	}
+	return opts
+}
+
+// pipeline returns the pipeline of an exporter of signal, creating it on
+// first use
+func (t *Telemetry) pipeline(signal, exporter string) *pipeline {
+	for _, p := range t.pipelines {
+		if p.signal == signal && p.exporter == exporter {
+			return p
+		}
+	}
+	p := &pipeline{signal: signal, exporter: exporter}
+	t.pipelines = append(t.pipelines, p)
+	return p
+}
+
+// Tracer returns a tracer from the global provider whose instrumentation
+// scope carries the service version. The global provider is used, rather
+// than TracerProvider, so a wrapper installed after New, such as a
+// profiler's, applies.
+func (t *Telemetry) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	opts = append([]trace.TracerOption{trace.WithInstrumentationVersion(t.ServiceVersion)}, opts...)
+	return otel.Tracer(name, opts...)
+}
+
+// Meter returns a meter from the global provider whose instrumentation
+// scope carries the service version
+func (t *Telemetry) Meter(name string, opts ...metric.MeterOption) metric.Meter {
+	opts = append([]metric.MeterOption{metric.WithInstrumentationVersion(t.ServiceVersion)}, opts...)
+	return otel.Meter(name, opts...)
+}
+
+// SpoolStats reports the spooled batches of each signal, or nil without
+// WithSpool
+func (t *Telemetry) SpoolStats() map[string]SpoolStats {
+	if t.spool == nil {
+		return nil
+	}
+	return t.spool.stats()
+}
+
+// ForceFlush exports everything buffered by the providers without shutting
+// them down
+func (t *Telemetry) ForceFlush(ctx context.Context) error {
+	var err error
+	if t.TracerProvider != nil {
+		err = errors.Join(err, t.TracerProvider.ForceFlush(ctx))
+	}
+	if t.MeterProvider != nil {
+		err = errors.Join(err, t.MeterProvider.ForceFlush(ctx))
+	}
+	if t.LoggerProvider != nil {
+		err = errors.Join(err, t.LoggerProvider.ForceFlush(ctx))
+	}
+	return err
+}
+
+// Shutdown flushes and stops every provider, even if an earlier one fails,
+// so the final batch of spans, metrics and logs is exported before exit.
+// Losses since the last periodic warning, the final flush's included, are
+// then reported. The spool stops replaying first; what the final flush
+// cannot deliver is still spooled, for the next process to replay.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	select {
+	case <-t.stopReports:
+	default:
+		close(t.stopReports)
+	}
+	<-t.reportsDone
+	defer t.reportPipelines()
+	if t.spool != nil {
+		t.spool.close()
+	}
+
+	var err error
+	if t.TracerProvider != nil {
+		err = errors.Join(err, t.TracerProvider.Shutdown(ctx))
+	}
+	if t.MeterProvider != nil {
+		err = errors.Join(err, t.MeterProvider.Shutdown(ctx))
+	}
+	if t.LoggerProvider != nil {
+		err = errors.Join(err, t.LoggerProvider.Shutdown(ctx))
+	}
+	return err
+}
@@ -0,0 +1,357 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryLogExporter keeps exported log records; the SDK has no in-memory
+// log exporter of its own
+type memoryLogExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryLogExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *memoryLogExporter) Shutdown(context.Context) error   { return nil }
+func (e *memoryLogExporter) ForceFlush(context.Context) error { return nil }
+
+// fixedDetector detects res
+type fixedDetector struct {
+	res *resource.Resource
+}
+
+func (d fixedDetector) Detect(context.Context) (*resource.Resource, error) {
+	return d.res, nil
+}
+
+// newTestTelemetry sets up all three signals with in-memory exporters
+func newTestTelemetry(t *testing.T, opts ...Option) (*Telemetry, *tracetest.InMemoryExporter, *sdkmetric.ManualReader, *memoryLogExporter) {
+	t.Helper()
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "")
+
+	spans := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	logs := &memoryLogExporter{}
+	opts = append([]Option{
+		WithSpanExporter(spans),
+		WithMetricReader(reader),
+		WithLogExporter(logs),
+	}, opts...)
+
+	tel, err := New(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { tel.Shutdown(context.Background()) })
+	return tel, spans, reader, logs
+}
+
+// collectMetric returns the data of the metric called name
+func collectMetric(t *testing.T, reader sdkmetric.Reader, name string) metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m.Data
+			}
+		}
+	}
+	t.Fatalf("no metric %s in %+v", name, rm.ScopeMetrics)
+	return nil
+}
+
+func TestNewExportsAllSignals(t *testing.T) {
+	ctx := context.Background()
+	tel, spans, reader, logs := newTestTelemetry(t, WithServiceName("checkout"), WithServiceVersion("2.3.0"))
+
+	_, span := tel.Tracer("test").Start(ctx, "work")
+	span.End()
+
+	counter, err := tel.Meter("test").Int64Counter("orders_total")
+	if err != nil {
+		t.Fatalf("Int64Counter: %v", err)
+	}
+	counter.Add(ctx, 3)
+
+	var record log.Record
+	record.SetBody(log.StringValue("order placed"))
+	tel.LoggerProvider.Logger("test").Emit(ctx, record)
+
+	if err := tel.ForceFlush(ctx); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+
+	gotSpans := spans.GetSpans()
+	if len(gotSpans) != 1 || gotSpans[0].Name != "work" {
+		t.Fatalf("spans = %v, want one span named work", gotSpans)
+	}
+	if v := gotSpans[0].InstrumentationScope.Version; v != "2.3.0" {
+		t.Errorf("scope version = %q, want 2.3.0", v)
+	}
+	if v, _ := gotSpans[0].Resource.Set().Value(semconv.ServiceNameKey); v.AsString() != "checkout" {
+		t.Errorf("span service.name = %q, want checkout", v.AsString())
+	}
+
+	sum, ok := collectMetric(t, reader, "orders_total").(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 3 {
+		t.Errorf("orders_total = %+v, want a sum of 3", sum)
+	}
+
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	if len(logs.records) != 1 || logs.records[0].Body().AsString() != "order placed" {
+		t.Errorf("logs = %v, want one record with body order placed", logs.records)
+	}
+}
+
+func TestResource(t *testing.T) {
+	tel, _, _, _ := newTestTelemetry(t,
+		WithServiceName("checkout"),
+		WithServiceVersion("2.3.0"),
+		WithResourceOptions(resource.WithAttributes(attribute.String("team", "payments"))),
+	)
+
+	if tel.ServiceName != "checkout" {
+		t.Errorf("ServiceName = %q, want checkout", tel.ServiceName)
+	}
+	if got := tel.Resource.SchemaURL(); got != semconv.SchemaURL {
+		t.Errorf("schema URL = %q, want %q", got, semconv.SchemaURL)
+	}
+	for key, want := range map[attribute.Key]string{
+		semconv.ServiceVersionKey:   "2.3.0",
+		semconv.TelemetrySDKNameKey: "opentelemetry",
+		"team":                      "payments",
+	} {
+		if v, _ := tel.Resource.Set().Value(key); v.AsString() != want {
+			t.Errorf("%s = %q, want %q", key, v.AsString(), want)
+		}
+	}
+}
+
+func TestResourceEnvironmentOverrides(t *testing.T) {
+	t.Setenv("OTEL_SERVICE_NAME", "checkout-canary")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "service.name=ignored,deployment.environment=staging")
+
+	tel, err := New(context.Background(), WithServiceName("checkout"), WithoutTraces(), WithoutMetrics(), WithoutLogs())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if tel.ServiceName != "checkout-canary" {
+		t.Errorf("ServiceName = %q, want checkout-canary", tel.ServiceName)
+	}
+	if v, _ := tel.Resource.Set().Value("deployment.environment"); v.AsString() != "staging" {
+		t.Errorf("deployment.environment = %q, want staging", v.AsString())
+	}
+}
+
+func TestResourceUnknownService(t *testing.T) {
+	tel, _, _, _ := newTestTelemetry(t)
+	if tel.ServiceName != "unknown_service:telemetry.test" {
+		t.Errorf("ServiceName = %q, want unknown_service:telemetry.test", tel.ServiceName)
+	}
+}
+
+func TestSchemaConflictIsNotFatal(t *testing.T) {
+	other := resource.NewWithAttributes("https://opentelemetry.io/schemas/1.4.0", attribute.String("team", "payments"))
+	tel, _, _, _ := newTestTelemetry(t,
+		WithServiceName("checkout"),
+		WithResourceOptions(resource.WithDetectors(fixedDetector{other})),
+	)
+	if got := tel.Resource.SchemaURL(); got != "" {
+		t.Errorf("schema URL = %q, want none after a conflict", got)
+	}
+	if v, _ := tel.Resource.Set().Value("team"); v.AsString() != "payments" {
+		t.Errorf("team = %q, want payments", v.AsString())
+	}
+}
+
+func TestWithoutSignals(t *testing.T) {
+	tel, _, _, _ := newTestTelemetry(t, WithoutTraces(), WithoutLogs())
+	if tel.TracerProvider != nil || tel.LoggerProvider != nil {
+		t.Errorf("providers of disabled signals were created")
+	}
+	if tel.MeterProvider == nil {
+		t.Errorf("meter provider is nil")
+	}
+}
+
+func TestSDKDisabled(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	tel, _, _, _ := newTestTelemetry(t)
+	if tel.TracerProvider != nil || tel.MeterProvider != nil || tel.LoggerProvider != nil {
+		t.Errorf("providers were created with OTEL_SDK_DISABLED=true")
+	}
+	if err := tel.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestExporterNone(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	t.Setenv("OTEL_METRICS_EXPORTER", "none")
+	t.Setenv("OTEL_LOGS_EXPORTER", "none")
+	tel, err := New(context.Background())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+	if tel.TracerProvider == nil || tel.MeterProvider == nil || tel.LoggerProvider == nil {
+		t.Errorf("providers must exist without an exporter")
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+	if _, err := New(context.Background()); err == nil {
+		t.Errorf("New accepted OTEL_TRACES_EXPORTER=zipkin")
+	}
+}
+
+func TestConsoleExporter(t *testing.T) {
+	var out bytes.Buffer
+	writer := consoleWriter
+	consoleWriter = &out
+	t.Cleanup(func() { consoleWriter = writer })
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_METRICS_EXPORTER", "none")
+	t.Setenv("OTEL_LOGS_EXPORTER", "stdout")
+	tel, err := New(context.Background(), WithExporter(ExporterConsole))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, span := tel.Tracer("test").Start(context.Background(), "console-span")
+	span.End()
+	var record log.Record
+	record.SetBody(log.StringValue("console-record"))
+	global.GetLoggerProvider().Logger("test").Emit(context.Background(), record)
+	if err := tel.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	for _, want := range []string{`"Name":"console-span"`, `"console-record"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("console output has no %s:\n%s", want, out.String())
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer the console exporters can write to while
+// the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestBatchAndReaderTuning(t *testing.T) {
+	out := &lockedBuffer{}
+	writer := consoleWriter
+	consoleWriter = out
+	t.Cleanup(func() { consoleWriter = writer })
+
+	t.Setenv("OTEL_METRICS_EXPORTER", "console")
+	spans := tracetest.NewInMemoryExporter()
+	tel, err := New(context.Background(),
+		WithSpanExporter(spans),
+		WithSpanBatch(BatchConfig{ScheduleDelay: 10 * time.Millisecond}),
+		WithReader(ReaderConfig{Interval: 10 * time.Millisecond}),
+		WithoutLogs(),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer tel.Shutdown(context.Background())
+
+	_, span := tel.Tracer("test").Start(context.Background(), "tuned")
+	span.End()
+	counter, _ := tel.Meter("test").Int64Counter("tuned_total")
+	counter.Add(context.Background(), 1)
+
+	// Both are exported by the processor and reader themselves, well before
+	// the SDK's default delay of 5s and interval of 60s
+	deadline := time.Now().Add(2 * time.Second)
+	for len(spans.GetSpans()) == 0 || !strings.Contains(out.String(), `"Name":"tuned_total"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("spans = %d, metrics exported = %v, want both without a flush",
+				len(spans.GetSpans()), strings.Contains(out.String(), "tuned_total"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSpanRedactor(t *testing.T) {
+	ctx := context.Background()
+	redact := func(kv attribute.KeyValue) attribute.KeyValue {
+		if kv.Key == "user.email" {
+			return kv.Key.String("[REDACTED]")
+		}
+		return kv
+	}
+	tel, spans, _, _ := newTestTelemetry(t, WithSpanRedactor(redact))
+
+	_, span := tel.Tracer("test").Start(ctx, "signup")
+	span.SetAttributes(attribute.String("user.email", "jane@example.com"), attribute.Int("attempt", 2))
+	span.AddEvent("retry", trace.WithAttributes(attribute.String("user.email", "jane@example.com")))
+	span.End()
+	if err := tel.ForceFlush(ctx); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+
+	got := spans.GetSpans()
+	if len(got) != 1 {
+		t.Fatalf("spans = %v, want one", got)
+	}
+	attrs := attribute.NewSet(got[0].Attributes...)
+	if v, _ := attrs.Value("user.email"); v.AsString() != "[REDACTED]" {
+		t.Errorf("user.email = %q, want [REDACTED]", v.AsString())
+	}
+	if v, _ := attrs.Value("attempt"); v.AsInt64() != 2 {
+		t.Errorf("attempt = %v, want 2", v.AsInt64())
+	}
+	if len(got[0].Events) != 1 {
+		t.Fatalf("events = %v, want one", got[0].Events)
+	}
+	eventAttrs := attribute.NewSet(got[0].Events[0].Attributes...)
+	if v, _ := eventAttrs.Value("user.email"); v.AsString() != "[REDACTED]" {
+		t.Errorf("event user.email = %q, want [REDACTED]", v.AsString())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	orderv1 "go-otel-sample-app/gen/order/v1"
+)
+
+const createOrdersTable = `CREATE TABLE IF NOT EXISTS orders (
+	id          TEXT PRIMARY KEY,
+	customer_id TEXT NOT NULL,
+	items       JSONB NOT NULL,
+	total_cents BIGINT NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL
+)`
+
+// postgresOrderStore keeps orders in PostgreSQL (for example Amazon RDS or
+// Aurora). Every query goes through otelsql, which records a client span
+// with the statement, the db.sql.latency histogram and pool statistics.
+type postgresOrderStore struct {
+	db *sql.DB
+}
+
+// orderItemRow is the JSON form of an order item in the items column
+type orderItemRow struct {
+	SKU            string `json:"sku"`
+	Quantity       int32  `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+}
+
+func newPostgresOrderStore(ctx context.Context, c databaseConfig) (*postgresOrderStore, error) {
+	db, err := otelsql.Open("pgx", c.URL,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// Keep traces to one span per statement
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	// Exposes db.sql.connection.open, .wait and friends, which show pool
+	// exhaustion before it turns into request latency
+	if err := otelsql.RegisterDBStatsMetrics(db, otelsql.WithAttributes(semconv.DBSystemPostgreSQL)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, createOrdersTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating orders table: %w", err)
+	}
+
+	return &postgresOrderStore{db: db}, nil
+}
+
+func (s *postgresOrderStore) CreateOrder(ctx context.Context, order *orderv1.Order) error {
+	itemsJSON, err := marshalItems(order.Items)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO orders (id, customer_id, items, total_cents, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		order.Id, order.CustomerId, itemsJSON, order.TotalCents, order.CreateTime.AsTime(),
+	)
+	return err
+}
+
+func (s *postgresOrderStore) GetOrder(ctx context.Context, id string) (*orderv1.Order, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, customer_id, items, total_cents, created_at FROM orders WHERE id = $1`, id)
+	order, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errOrderNotFound
+	}
+	return order, err
+}
+
+func (s *postgresOrderStore) ListOrders(ctx context.Context, limit int) ([]*orderv1.Order, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, customer_id, items, total_cents, created_at FROM orders ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*orderv1.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// UpdateOrder locks the row for the read-modify-write, so concurrent
+// updates of one order are applied one after the other
+func (s *postgresOrderStore) UpdateOrder(ctx context.Context, id string, update func(order *orderv1.Order) error) (*orderv1.Order, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := scanOrder(tx.QueryRowContext(ctx,
+		`SELECT id, customer_id, items, total_cents, created_at FROM orders WHERE id = $1 FOR UPDATE`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := update(order); err != nil {
+		return nil, err
+	}
+
+	itemsJSON, err := marshalItems(order.Items)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE orders SET customer_id = $2, items = $3, total_cents = $4 WHERE id = $1`,
+		id, order.CustomerId, itemsJSON, order.TotalCents,
+	); err != nil {
+		return nil, err
+	}
+	return order, tx.Commit()
+}
+
+func (s *postgresOrderStore) DeleteOrder(ctx context.Context, id string, check func(order *orderv1.Order) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := scanOrder(tx.QueryRowContext(ctx,
+		`SELECT id, customer_id, items, total_cents, created_at FROM orders WHERE id = $1 FOR UPDATE`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(order); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM orders WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ping backs the "postgres" readiness check
+func (s *postgresOrderStore) ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *postgresOrderStore) close() error {
+	return s.db.Close()
+}
+
+// marshalItems encodes items for the items column
+func marshalItems(items []*orderv1.OrderItem) ([]byte, error) {
+	rows := make([]orderItemRow, len(items))
+	for i, item := range items {
+		rows[i] = orderItemRow{SKU: item.Sku, Quantity: item.Quantity, UnitPriceCents: item.UnitPriceCents}
+	}
+	return json.Marshal(rows)
+}
+
+func scanOrder(row interface{ Scan(...any) error }) (*orderv1.Order, error) {
+	var (
+		order     orderv1.Order
+		itemsJSON []byte
+		createdAt time.Time
+	)
+	if err := row.Scan(&order.Id, &order.CustomerId, &itemsJSON, &order.TotalCents, &createdAt); err != nil {
+		return nil, err
+	}
+
+	var items []orderItemRow
+	if err := json.Unmarshal(itemsJSON, &items); err != nil {
+		return nil, fmt.Errorf("decoding items of order %s: %w", order.Id, err)
+	}
+	for _, item := range items {
+		order.Items = append(order.Items, &orderv1.OrderItem{
+			Sku:            item.SKU,
+			Quantity:       item.Quantity,
+			UnitPriceCents: item.UnitPriceCents,
+		})
+	}
+	order.CreateTime = timestamppb.New(createdAt)
+	return &order, nil
+}
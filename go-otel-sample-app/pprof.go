@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// newPprofServer serves net/http/pprof on its own port. The port is left
+// out of the Service, so profiles are only reachable with kubectl
+// port-forward and never through the public traffic port:
+//
+//	kubectl port-forward deploy/go-otel-sample-app 6060
+//	go tool pprof http://localhost:6060/debug/pprof/heap
+//
+// Requests are not traced, so collecting a profile leaves no spans behind.
+// With an admin port, the endpoints are served there instead.
+func newPprofServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	registerPprof(mux)
+	return &http.Server{
+		Addr:    ":" + port,
+		Handler: mux,
+	}
+}
+
+// registerPprof adds the net/http/pprof endpoints and the expvar variables
+// to mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	registerExpvar(mux)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// telemetryReady is set once the trace, metric and log providers are
+	// set up; their exporters may still be connecting to the collector
+	telemetryReady atomic.Bool
+	// shuttingDown flips readiness off as soon as SIGTERM is received so the
+	// Service stops routing new traffic before the drain begins
+	shuttingDown atomic.Bool
+
+	readinessMu     sync.RWMutex
+	readinessChecks = map[string]func(context.Context) error{}
+)
+
+// registerReadinessCheck adds a named dependency check to /readyz. Checks
+// should be cheap; they run on every probe with a short timeout.
+func registerReadinessCheck(name string, check func(context.Context) error) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	readinessChecks[name] = check
+}
+
+// registerProbes adds the Kubernetes probes to mux
+func registerProbes(mux *http.ServeMux) {
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/livez", livezHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+}
+
+// livezHandler only reports that the process is able to serve HTTP. It must
+// not depend on anything external, otherwise a collector outage would make
+// the kubelet restart every pod.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "alive",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// readyzHandler reports whether this instance should receive traffic
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	results := map[string]string{}
+	ready := true
+
+	if shuttingDown.Load() {
+		results["shutdown"] = "in progress"
+		ready = false
+	}
+	if telemetryReady.Load() {
+		results["telemetry"] = "ok"
+	} else {
+		results["telemetry"] = "exporters not initialized"
+		ready = false
+	}
+
+	readinessMu.RLock()
+	names := make([]string, 0, len(readinessChecks))
+	for name := range readinessChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := readinessChecks[name](ctx); err != nil {
+			results[name] = err.Error()
+			ready = false
+		} else {
+			results[name] = "ok"
+		}
+	}
+	readinessMu.RUnlock()
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+
+		logger.WarnContext(r.Context(), "Readiness check failed",
+			"endpoint", "/readyz",
+			"checks", results,
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"checks":    results,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
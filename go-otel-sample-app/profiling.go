@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/grafana/pyroscope-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// profileTags are the resource attributes copied onto every profile, so
+// profiles can be filtered the same way as traces, metrics and logs.
+// Pyroscope label names cannot contain dots, hence the underscores.
+var profileTags = []string{
+	"service.version",
+	"service.namespace",
+	"environment",
+	"deployment.environment",
+	"k8s.cluster.name",
+	"host.name",
+	"cloud.region",
+}
+
+// startProfiler pushes CPU, memory, goroutine, mutex and block profiles to
+// a Pyroscope server (or Grafana Alloy's pyroscope.receive_http) when
+// c.ServerAddress is set. Profiles are named after the resource's
+// service.name, which the other three signals share. It returns nil when
+// profiling is disabled.
+func startProfiler(c profilingConfig, res *resource.Resource) (*pyroscope.Profiler, error) {
+	if c.ServerAddress == "" {
+		return nil, nil
+	}
+
+	tags := map[string]string{}
+	for _, key := range profileTags {
+		if value, ok := res.Set().Value(attribute.Key(key)); ok {
+			tags[strings.ReplaceAll(key, ".", "_")] = value.Emit()
+		}
+	}
+
+	// Mutex and block profiles are empty unless the runtime samples them
+	runtime.SetMutexProfileFraction(c.MutexProfileFraction)
+	runtime.SetBlockProfileRate(c.BlockProfileRate)
+
+	profiler, err := pyroscope.Start(pyroscope.Config{
+		ApplicationName:   serviceName,
+		ServerAddress:     c.ServerAddress,
+		TenantID:          c.TenantID,
+		BasicAuthUser:     c.BasicAuthUser,
+		BasicAuthPassword: c.BasicAuthPassword,
+		UploadRate:        c.UploadRate,
+		Tags:              tags,
+		Logger:            pyroscopeLogger{},
+		ProfileTypes: []pyroscope.ProfileType{
+			pyroscope.ProfileCPU,
+			pyroscope.ProfileAllocObjects,
+			pyroscope.ProfileAllocSpace,
+			pyroscope.ProfileInuseObjects,
+			pyroscope.ProfileInuseSpace,
+			pyroscope.ProfileGoroutines,
+			pyroscope.ProfileMutexCount,
+			pyroscope.ProfileMutexDuration,
+			pyroscope.ProfileBlockCount,
+			pyroscope.ProfileBlockDuration,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting profiler: %w", err)
+	}
+
+	logger.Info("Continuous profiling started",
+		"server_address", c.ServerAddress,
+		"application_name", serviceName,
+		"upload_rate", c.UploadRate.String(),
+	)
+	return profiler, nil
+}
+
+// pyroscopeLogger sends the profiler's own messages to slog. Its per-upload
+// info messages are logged at debug level to keep them out of the way.
+type pyroscopeLogger struct{}
+
+func (pyroscopeLogger) Infof(format string, args ...any) {
+	telemetryLogger.Debug(fmt.Sprintf(format, args...), "profiler", "pyroscope")
+}
+
+func (pyroscopeLogger) Debugf(format string, args ...any) {
+	telemetryLogger.Debug(fmt.Sprintf(format, args...), "profiler", "pyroscope")
+}
+
+func (pyroscopeLogger) Errorf(format string, args ...any) {
+	telemetryLogger.Error(fmt.Sprintf(format, args...), "profiler", "pyroscope")
+}
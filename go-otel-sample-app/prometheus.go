@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// prometheusBridge serves the OTel instruments themselves on /metrics via
+// the OTel Prometheus exporter instead of the hand-maintained collectors
+// below, so the scrape and OTLP pipelines report identical values.
+var prometheusBridge bool
+
+// Prometheus collectors served on /metrics. Metric names and labels match
+// the hand-written exposition this replaced so existing Grafana dashboards
+// and Prometheus Adapter rules keep working.
+var (
+	promRegistry = prometheus.NewRegistry()
+
+	promRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total HTTP requests",
+		},
+		[]string{"method", "endpoint", "status", "status_class"},
+	)
+
+	// promLatency and the size histograms are created by initPrometheus
+	// once the configured bucket boundaries are known
+	promLatency      *prometheus.HistogramVec
+	promRequestSize  *prometheus.HistogramVec
+	promResponseSize *prometheus.HistogramVec
+)
+
+func initPrometheus() {
+	// With the bridge enabled these names are already produced by the OTel
+	// exporter, and registering them twice would fail the scrape
+	promLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency in seconds",
+			Buckets: histogramBuckets["http_request_duration_seconds"],
+		},
+		[]string{"method", "endpoint", "status_class"},
+	)
+
+	promRequestSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_size_bytes",
+			Help:    "HTTP request body size in bytes",
+			Buckets: histogramBuckets["http_request_size_bytes"],
+		},
+		[]string{"method", "endpoint"},
+	)
+	promResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "HTTP response body size in bytes",
+			Buckets: histogramBuckets["http_response_size_bytes"],
+		},
+		[]string{"method", "endpoint"},
+	)
+
+	promCacheDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cache_operation_duration_seconds",
+			Help:    "Cache operation latency in seconds",
+			Buckets: histogramBuckets["cache_operation_duration_seconds"],
+		},
+		[]string{"cache", "operation"},
+	)
+
+	if !prometheusBridge {
+		promRegistry.MustRegister(promRequests, promLatency, promRequestSize, promResponseSize)
+		promRegistry.MustRegister(promCacheRequests, promCacheDuration)
+	}
+
+	promRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// recordRequest counts a handled request and records its latency and body
+// sizes, also against the SLOs covering endpoint. It is called by
+// redMiddleware for every request to a registered route.
+func recordRequest(ctx context.Context, method, endpoint, status string, duration time.Duration, requestBytes, responseBytes int64) {
+	class := statusClass(status)
+	attrs := metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("endpoint", endpoint),
+		attribute.String("status_class", class),
+	)
+	baggageAttrs := metric.WithAttributes(baggageAttributes(ctx, baggageMetricKeys)...)
+	requestCounter.Add(ctx, 1, attrs, metric.WithAttributes(attribute.String("status", status)), baggageAttrs)
+	requestLatency.Record(ctx, duration.Seconds(), attrs, baggageAttrs)
+	// Sizes are not split by status class: a body is as large whatever
+	// the outcome, and fewer series keep the histograms affordable
+	sizeAttrs := metric.WithAttributes(attribute.String("method", method), attribute.String("endpoint", endpoint))
+	requestSize.Record(ctx, requestBytes, sizeAttrs, baggageAttrs)
+	responseSize.Record(ctx, responseBytes, sizeAttrs, baggageAttrs)
+	expvarRequests.Add(endpoint, 1)
+	expvarResponses.Add(class, 1)
+	if !prometheusBridge {
+		promRequests.WithLabelValues(method, endpoint, status, class).Inc()
+		promRequestSize.WithLabelValues(method, endpoint).Observe(float64(requestBytes))
+		promResponseSize.WithLabelValues(method, endpoint).Observe(float64(responseBytes))
+		observer := promLatency.WithLabelValues(method, endpoint, class)
+		// Attach the trace as an exemplar so a latency spike in Grafana links
+		// to a trace that was actually kept by the sampler
+		if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+			observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{
+				"trace_id": sc.TraceID().String(),
+				"span_id":  sc.SpanID().String(),
+			})
+		} else {
+			observer.Observe(duration.Seconds())
+		}
+	}
+	recordSLO(ctx, endpoint, status, duration)
+	countLiveRequest(status)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// propagatorNames returns the entries of OTEL_PROPAGATORS, defaulting to
+// W3C trace context plus baggage like the other OTel SDKs
+func propagatorNames() []string {
+	var names []string
+	for _, name := range strings.Split(getEnv("OTEL_PROPAGATORS", "tracecontext,baggage"), ",") {
+		if name = strings.TrimSpace(strings.ToLower(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// newPropagator builds the composite TextMapPropagator named by
+// OTEL_PROPAGATORS. Use "xray" alongside "tracecontext" when requests also
+// pass through AWS services that only understand X-Amzn-Trace-Id, and
+// "b3multi" behind Envoy or App Mesh, whose Zipkin tracer sends the
+// X-B3-* headers.
+func newPropagator() (propagation.TextMapPropagator, error) {
+	var propagators []propagation.TextMapPropagator
+	for _, name := range propagatorNames() {
+		switch name {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case "xray":
+			propagators = append(propagators, xray.Propagator{})
+		case "none":
+		default:
+			return nil, fmt.Errorf("unsupported propagator %q in OTEL_PROPAGATORS", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+// useXRayIDs reports whether trace IDs must be X-Ray compatible, i.e. start
+// with the epoch seconds, so the X-Ray backend accepts them
+func useXRayIDs() bool {
+	for _, name := range propagatorNames() {
+		if name == "xray" {
+			return true
+		}
+	}
+	return false
+}
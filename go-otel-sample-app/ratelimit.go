@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdle is how long a client's bucket is kept after its last
+// request. A full bucket holds no state worth keeping, so this only needs
+// to outlast the time the bucket takes to refill.
+const rateLimitIdle = 5 * time.Minute
+
+var (
+	rateLimited metric.Int64Counter
+
+	promRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Requests rejected with 429 by the rate limiter",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// rateLimiter gives every client a token bucket that refills at
+// RequestsPerSecond and holds Burst tokens. A request to a limited route
+// takes a token, or is answered with 429 Too Many Requests and a
+// Retry-After header telling the client when the next token is due.
+type rateLimiter struct {
+	limit             rate.Limit
+	burst             int
+	routes            []string
+	trustForwardedFor bool
+
+	mu        sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns nil when rate limiting is disabled
+func newRateLimiter(c rateLimitConfig) (*rateLimiter, error) {
+	if c.RequestsPerSecond <= 0 {
+		return nil, nil
+	}
+
+	var err error
+	rateLimited, err = meter.Int64Counter(
+		"rate_limited_requests_total",
+		metric.WithDescription("Requests rejected with 429 by the rate limiter"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promRateLimited)
+	}
+
+	return &rateLimiter{
+		limit:             rate.Limit(c.RequestsPerSecond),
+		burst:             c.Burst,
+		routes:            c.Routes,
+		trustForwardedFor: c.TrustForwardedFor,
+		clients:           make(map[string]*clientBucket),
+		lastSweep:         time.Now(),
+	}, nil
+}
+
+// middleware limits the requests to routes under the configured prefixes
+// and passes everything else through. A nil limiter limits nothing.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r.Pattern)
+		if !routeUnder(route, l.routes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := l.clientKey(r)
+		wait, ok := l.take(client)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Retry-After is in whole seconds, so round up rather than invite an
+		// immediate retry that would be rejected again
+		retryAfter := max(1, int(math.Ceil(wait.Seconds())))
+		ctx := r.Context()
+		trace.SpanFromContext(ctx).AddEvent("rate_limited", trace.WithAttributes(
+			attribute.String("client.address", client),
+			attribute.Int("rate_limit.retry_after_s", retryAfter),
+		))
+		recordRateLimited(ctx, route)
+		requestLogger(r, route).WarnContext(ctx, "Rate limit exceeded",
+			"client_address", client,
+			"retry_after_s", retryAfter,
+		)
+
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(ctx, w, http.StatusTooManyRequests, "rate limit exceeded")
+	})
+}
+
+// clientKey identifies the client by IP address. Behind an ALB or another
+// proxy that appends to X-Forwarded-For, the last entry is the address the
+// proxy saw; earlier entries are set by the client and can't be trusted.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if l.trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			if client := strings.TrimSpace(entries[len(entries)-1]); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// take takes a token from the bucket of client, or reports how long until
+// one is available
+func (l *rateLimiter) take(client string) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = bucket
+	}
+	bucket.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if wait := reservation.DelayFrom(now); wait > 0 {
+		// Rejected requests don't consume tokens
+		reservation.CancelAt(now)
+		return wait, false
+	}
+	return 0, true
+}
+
+func recordRateLimited(ctx context.Context, route string) {
+	rateLimited.Add(ctx, 1, metric.WithAttributes(attribute.String("endpoint", route)))
+	if !prometheusBridge {
+		promRateLimited.WithLabelValues(route).Inc()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	panicsTotal metric.Int64Counter
+
+	promPanics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "panics_total",
+			Help: "Panics recovered while serving requests",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// recoverMiddleware turns a panic in a handler into a 500. net/http would
+// otherwise recover it itself, log the stack to stderr and drop the
+// connection, so the client sees a reset, the RED metrics miss the request
+// and the server span ends without an error. Here the panic is recorded on
+// the span as an exception with its stack trace, logged with the stack, and
+// counted in panics_total.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// A deliberate abort, which net/http handles quietly
+				panic(v)
+			}
+			ctx := r.Context()
+			route := routeOf(r.Pattern)
+			stack := string(debug.Stack())
+			err := fmt.Errorf("panic: %v", v)
+
+			span := trace.SpanFromContext(ctx)
+			span.RecordError(err, trace.WithAttributes(
+				semconv.ExceptionEscaped(false),
+				semconv.ExceptionStacktrace(stack),
+			))
+			span.SetStatus(codes.Error, "panic")
+			recordPanic(ctx, route)
+			requestLogger(r, route).ErrorContext(ctx, "Recovered from panic", "error", err, "stack", stack)
+
+			if rec.status == 0 {
+				writeError(ctx, rec, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func recordPanic(ctx context.Context, route string) {
+	panicsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("endpoint", route)))
+	if !prometheusBridge {
+		promPanics.WithLabelValues(route).Inc()
+	}
+}
+
+// registerPanicAdmin serves POST /admin/panic, which panics in the handler
+// to show a recovered panic end to end. With ?crash=true the panic happens
+// outside any request a second later instead, where nothing recovers it,
+// and the process exits like a real crash, so Kubernetes restarts the
+// container.
+func registerPanicAdmin(mux *http.ServeMux) error {
+	var err error
+	panicsTotal, err = meter.Int64Counter("panics_total",
+		metric.WithDescription("Panics recovered while serving requests, by endpoint"))
+	if err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promPanics)
+	}
+
+	mux.HandleFunc("POST /admin/panic", func(w http.ResponseWriter, r *http.Request) {
+		crash, _ := strconv.ParseBool(r.URL.Query().Get("crash"))
+		if !crash {
+			panic("simulated panic requested through /admin/panic")
+		}
+		logger.ErrorContext(r.Context(), "Simulated crash requested, the process is exiting")
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "crashing"})
+		time.AfterFunc(time.Second, func() {
+			panic("simulated crash requested through /admin/panic")
+		})
+	})
+	return nil
+}
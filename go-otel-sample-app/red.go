@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redMiddleware records the rate, errors and duration of every request
+// served by a registered route in http_requests_total and
+// http_request_duration_seconds, labelled with the route template, method,
+// status and status class, and the body sizes in http_request_size_bytes
+// and http_response_size_bytes and on the server span. Handlers don't
+// record them themselves, so a route added later is covered without
+// further code, and the duration includes injected chaos latency, as a
+// client would see it. Requests that match no route are left out to keep
+// the endpoint label bounded.
+func redMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		next.ServeHTTP(rec, r)
+
+		// A body the handler did not read was still sent; net/http reads
+		// and discards it
+		requestBytes := max(r.ContentLength, body.n)
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			semconv.HTTPRequestBodySize(int(requestBytes)),
+			semconv.HTTPResponseBodySize(int(rec.bytes)),
+		)
+
+		route := routeOf(r.Pattern)
+		if route == "" {
+			return
+		}
+		status := rec.status
+		if status == 0 {
+			// The handler wrote nothing, which net/http answers with 200
+			status = http.StatusOK
+		}
+		recordRequest(r.Context(), r.Method, route, strconv.Itoa(status), time.Since(start), requestBytes, rec.bytes)
+	})
+}
+
+// statusClass groups a status code as 2xx, 3xx, 4xx or 5xx, so error
+// ratios can be computed without listing every code
+func statusClass(status string) string {
+	if len(status) != 3 {
+		return "unknown"
+	}
+	return status[:1] + "xx"
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// statusRecorder captures the status code a handler sends and the size
+// of the body it writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints precede the real one
+	if s.status == 0 && code >= 200 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Hijack lets the /ws handler take over the connection. The 101 Switching
+// Protocols the upgrader writes to the connection itself is recorded as the
+// status.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// redactedValue replaces sensitive values
+const redactedValue = "[REDACTED]"
+
+// activeRedactor scrubs logs and span attributes when redaction is
+// enabled, and is nil otherwise
+var activeRedactor *redactor
+
+// redactor removes personal data and secrets from telemetry inside the
+// app, so nothing sensitive reaches stdout, the collector or the spool. The
+// same scrubbing can be done in the collector with the redaction processor;
+// doing it at the source also covers the JSON logs and every exporter.
+type redactor struct {
+	keys     map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// newRedactor returns nil when redaction is disabled, or an error when a
+// pattern does not compile
+func newRedactor(c redactionConfig) (*redactor, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	r := &redactor{keys: make(map[string]bool, len(c.Keys))}
+	for _, key := range c.Keys {
+		r.keys[strings.ToLower(key)] = true
+	}
+	for _, pattern := range c.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// sensitiveKey reports whether key, or its last dotted part as in
+// http.request.header.authorization, is one of the configured keys
+func (r *redactor) sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	return r.keys[key] || r.keys[key[strings.LastIndex(key, ".")+1:]]
+}
+
+// text replaces the matches of the patterns in s
+func (r *redactor) text(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, redactedValue)
+	}
+	return s
+}
+
+// attribute redacts a span attribute; it is the span redactor of the
+// telemetry package
+func (r *redactor) attribute(kv attribute.KeyValue) attribute.KeyValue {
+	if r.sensitiveKey(string(kv.Key)) {
+		return kv.Key.String(redactedValue)
+	}
+	switch kv.Value.Type() {
+	case attribute.STRING:
+		return kv.Key.String(r.text(kv.Value.AsString()))
+	case attribute.STRINGSLICE:
+		values := kv.Value.AsStringSlice()
+		for i, value := range values {
+			values[i] = r.text(value)
+		}
+		return kv.Key.StringSlice(values)
+	}
+	return kv
+}
+
+// slogAttr redacts a log attribute, including the attributes of groups and
+// the messages of errors
+func (r *redactor) slogAttr(a slog.Attr) slog.Attr {
+	if r.sensitiveKey(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.text(v.String()))
+	case slog.KindGroup:
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(r.slogAttrs(v.Group())...)}
+	case slog.KindAny:
+		// Errors keep their type unless their message has to change
+		if err, ok := v.Any().(error); ok {
+			if msg := r.text(err.Error()); msg != err.Error() {
+				return slog.String(a.Key, msg)
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func (r *redactor) slogAttrs(attrs []slog.Attr) []slog.Attr {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = r.slogAttr(a)
+	}
+	return redacted
+}
+
+// redactHandler scrubs the message and attributes of records before they
+// are written, to stdout and to OTel alike
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	if activeRedactor == nil {
+		return h.next.Handle(ctx, r)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, activeRedactor.text(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(activeRedactor.slogAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs redacts attributes when they are added, as the redactor is set
+// before any logger is derived with With
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if activeRedactor != nil {
+		attrs = activeRedactor.slogAttrs(attrs)
+	}
+	return redactHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{next: h.next.WithGroup(name)}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cacheKeySpace is the number of distinct keys /api looks up. With a few
+// requests per second most keys are cached after the first TTL, which gives
+// a realistic hit ratio that drops whenever the cache is flushed.
+const cacheKeySpace = 100
+
+// apiCache fronts the simulated /api work when REDIS_URL is set, and is nil
+// otherwise
+var apiCache *redisCache
+
+var (
+	cacheRequests metric.Int64Counter
+	cacheDuration metric.Float64Histogram
+
+	promCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Cache lookups by result",
+		},
+		[]string{"cache", "result"},
+	)
+
+	// promCacheDuration is created by initPrometheus once the configured
+	// bucket boundaries are known
+	promCacheDuration *prometheus.HistogramVec
+)
+
+// redisCache is a Redis or ElastiCache for Redis/Valkey cache whose commands
+// are traced by redisTracingHook
+type redisCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// newRedisCache connects to url, e.g. rediss://master.my-cache.abc123.use1.cache.amazonaws.com:6379
+// for an ElastiCache cluster with in-transit encryption
+func newRedisCache(ctx context.Context, url string, ttl time.Duration) (*redisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing REDIS_URL: %w", err)
+	}
+
+	cacheRequests, err = meter.Int64Counter(
+		"cache_requests_total",
+		metric.WithDescription("Cache lookups by result"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cacheDuration, err = meter.Float64Histogram(
+		"cache_operation_duration_seconds",
+		metric.WithDescription("Cache operation latency in seconds"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	client.AddHook(newRedisTracingHook(opts))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisCache{client: client, ttl: ttl}, nil
+}
+
+func (c *redisCache) close() error {
+	return c.client.Close()
+}
+
+// simulateWork stands in for the business logic of /api. With a cache
+// configured, the result of a randomly chosen key is looked up first and
+// the slow path only runs on a miss. Cache failures are logged and fall
+// back to the slow path rather than failing the request. Without a cache
+// the latency of /api comes from its chaos rule alone.
+func simulateWork(ctx context.Context) {
+	if apiCache == nil {
+		return
+	}
+
+	key := "api:result:" + strconv.Itoa(rand.Intn(cacheKeySpace))
+	span := trace.SpanFromContext(ctx)
+
+	start := time.Now()
+	_, err := apiCache.client.Get(ctx, key).Result()
+	recordCacheDuration(ctx, "get", time.Since(start))
+	switch {
+	case err == nil:
+		recordCacheRequest(ctx, "hit")
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		return
+	case errors.Is(err, redis.Nil):
+		recordCacheRequest(ctx, "miss")
+	default:
+		recordCacheRequest(ctx, "error")
+		logger.WarnContext(ctx, "Cache lookup failed", "key", key, "error", err)
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+
+	time.Sleep(cacheMissLatency.sample())
+
+	start = time.Now()
+	err = apiCache.client.Set(ctx, key, time.Now().Format(time.RFC3339Nano), apiCache.ttl).Err()
+	recordCacheDuration(ctx, "set", time.Since(start))
+	if err != nil {
+		logger.WarnContext(ctx, "Cache update failed", "key", key, "error", err)
+	}
+}
+
+func recordCacheRequest(ctx context.Context, result string) {
+	cacheRequests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("cache", "redis"),
+		attribute.String("result", result),
+	))
+	if !prometheusBridge {
+		promCacheRequests.WithLabelValues("redis", result).Inc()
+	}
+}
+
+func recordCacheDuration(ctx context.Context, operation string, duration time.Duration) {
+	cacheDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("cache", "redis"),
+		attribute.String("operation", operation),
+	))
+	if !prometheusBridge {
+		promCacheDuration.WithLabelValues("redis", operation).Observe(duration.Seconds())
+	}
+}
+
+// redisTracingHook records a client span for every Redis command and
+// pipeline, in the spirit of the redisotel package. Only command names are
+// recorded, never keys or values.
+type redisTracingHook struct {
+	attrs []attribute.KeyValue
+}
+
+var _ redis.Hook = redisTracingHook{}
+
+func newRedisTracingHook(opts *redis.Options) redisTracingHook {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemRedis,
+		semconv.DBNamespace(strconv.Itoa(opts.DB)),
+	}
+	if host, port, err := net.SplitHostPort(opts.Addr); err == nil {
+		attrs = append(attrs, semconv.ServerAddress(host))
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, semconv.ServerPort(p))
+		}
+	}
+	return redisTracingHook{attrs: attrs}
+}
+
+func (h redisTracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, span := tracer.Start(ctx, "redis.dial",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...),
+		)
+		defer span.End()
+
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return conn, err
+	}
+}
+
+func (h redisTracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		name := cmd.FullName()
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...),
+			trace.WithAttributes(semconv.DBOperationName(name)),
+		)
+		defer span.End()
+
+		err := next(ctx, cmd)
+		// A missing key is a normal cache miss, not a failure
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func (h redisTracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracer.Start(ctx, "pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.attrs...),
+			trace.WithAttributes(
+				semconv.DBOperationName("pipeline"),
+				attribute.Int("db.redis.num_cmd", len(cmds)),
+			),
+		)
+		defer span.End()
+
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
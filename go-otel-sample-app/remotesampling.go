@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// remoteSamplingTimeout bounds each poll of the strategy endpoint
+	remoteSamplingTimeout = 5 * time.Second
+	// remoteSamplingMaxOperations bounds the lower-bound limiters kept for
+	// operations without their own strategy; beyond it, such operations
+	// only get the default probability
+	remoteSamplingMaxOperations = 2000
+)
+
+// remoteSampler samples with the strategy that the Jaeger remote sampling
+// endpoint serves for this service, such as the jaegerremotesampling
+// extension of the OTel collector. The strategy is polled, so sampling
+// rates, including per-operation rates keyed by span name, are managed
+// centrally and change without a restart or a config reload. Until the
+// first strategy arrives, spans are sampled at the initial ratio.
+type remoteSampler struct {
+	cfg    remoteSamplingConfig
+	client *http.Client
+
+	current atomic.Pointer[sdktrace.Sampler]
+	start   sync.Once
+	stopped sync.Once
+	done    chan struct{}
+}
+
+// parseArg reads OTEL_TRACES_SAMPLER_ARG as the OTel specification defines
+// it for jaeger_remote, e.g.
+// endpoint=http://collector:5778/sampling,pollingIntervalMs=5000,initialSamplingRate=0.25
+func (c *remoteSamplingConfig) parseArg(arg string) error {
+	for _, pair := range splitList(arg) {
+		key, value, _ := strings.Cut(pair, "=")
+		var err error
+		switch strings.TrimSpace(key) {
+		case "endpoint":
+			c.Endpoint = strings.TrimSpace(value)
+		case "pollingIntervalMs":
+			var ms int
+			ms, err = strconv.Atoi(strings.TrimSpace(value))
+			c.PollingInterval = time.Duration(ms) * time.Millisecond
+		case "initialSamplingRate":
+			c.InitialRatio, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		default:
+			return fmt.Errorf("unknown key %q, expected endpoint, pollingIntervalMs or initialSamplingRate", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (c remoteSamplingConfig) validate() error {
+	if u, err := url.Parse(c.Endpoint); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid remote sampling endpoint %q", c.Endpoint)
+	}
+	if c.PollingInterval <= 0 {
+		return errors.New("remote sampling polling_interval must be positive")
+	}
+	if c.InitialRatio < 0 || c.InitialRatio > 1 {
+		return fmt.Errorf("invalid initial sampling ratio %v: expected a ratio between 0 and 1", c.InitialRatio)
+	}
+	return nil
+}
+
+func newRemoteSampler(c remoteSamplingConfig) *remoteSampler {
+	s := &remoteSampler{
+		cfg: c,
+		// A plain client: an instrumented one would trace its own polls
+		client: &http.Client{Timeout: remoteSamplingTimeout},
+		done:   make(chan struct{}),
+	}
+	initial := sdktrace.TraceIDRatioBased(c.InitialRatio)
+	s.current.Store(&initial)
+	return s
+}
+
+// ShouldSample starts polling on first use, so a sampler built only to
+// validate the configuration never polls
+func (s *remoteSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.start.Do(func() { go s.poll() })
+	return (*s.current.Load()).ShouldSample(p)
+}
+
+func (s *remoteSampler) Description() string {
+	return fmt.Sprintf("JaegerRemoteSampler{%s,%s}", s.cfg.Endpoint, (*s.current.Load()).Description())
+}
+
+// stop ends the polling, once the sampler has been replaced by a reload
+func (s *remoteSampler) stop() {
+	s.stopped.Do(func() { close(s.done) })
+}
+
+func (s *remoteSampler) poll() {
+	ticker := time.NewTicker(s.cfg.PollingInterval)
+	defer ticker.Stop()
+	var last []byte
+	failing := false
+	for {
+		body, err := s.fetch()
+		if err == nil && !bytes.Equal(body, last) {
+			err = s.update(body)
+			if err == nil {
+				last = body
+			}
+		}
+		// Log the first failure of an outage and the recovery only
+		if err != nil && !failing {
+			telemetryLogger.Warn("Failed to get the sampling strategy, keeping the current one",
+				"endpoint", s.cfg.Endpoint, "error", err)
+		} else if err == nil && failing {
+			telemetryLogger.Info("Sampling strategy endpoint is back", "endpoint", s.cfg.Endpoint)
+		}
+		failing = err != nil
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *remoteSampler) fetch() ([]byte, error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("service", serviceName)
+	u.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSamplingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (s *remoteSampler) update(body []byte) error {
+	var strategy samplingStrategy
+	if err := json.Unmarshal(body, &strategy); err != nil {
+		return fmt.Errorf("invalid sampling strategy: %w", err)
+	}
+	sampler := strategy.sampler()
+	s.current.Store(&sampler)
+	telemetryLogger.Info("Sampling strategy updated", "sampler", sampler.Description())
+	return nil
+}
+
+// samplingStrategy is the JSON strategy of the Jaeger remote sampling API.
+// Its strategyType is left aside: the strategy that is present tells the
+// same, and the API has sent the type both as a number and as a name.
+type samplingStrategy struct {
+	ProbabilisticSampling *struct {
+		SamplingRate float64 `json:"samplingRate"`
+	} `json:"probabilisticSampling"`
+	RateLimitingSampling *struct {
+		MaxTracesPerSecond float64 `json:"maxTracesPerSecond"`
+	} `json:"rateLimitingSampling"`
+	OperationSampling *struct {
+		DefaultSamplingProbability       float64 `json:"defaultSamplingProbability"`
+		DefaultLowerBoundTracesPerSecond float64 `json:"defaultLowerBoundTracesPerSecond"`
+		PerOperationStrategies           []struct {
+			Operation             string `json:"operation"`
+			ProbabilisticSampling struct {
+				SamplingRate float64 `json:"samplingRate"`
+			} `json:"probabilisticSampling"`
+		} `json:"perOperationStrategies"`
+	} `json:"operationSampling"`
+}
+
+func (s samplingStrategy) sampler() sdktrace.Sampler {
+	switch {
+	case s.OperationSampling != nil:
+		o := s.OperationSampling
+		sampler := &operationSampler{
+			operations: make(map[string]sdktrace.Sampler, len(o.PerOperationStrategies)),
+			fallback:   sdktrace.TraceIDRatioBased(o.DefaultSamplingProbability),
+			lowerBound: rate.Limit(o.DefaultLowerBoundTracesPerSecond),
+			limiters:   make(map[string]*rate.Limiter),
+		}
+		for _, op := range o.PerOperationStrategies {
+			sampler.operations[op.Operation] = sdktrace.TraceIDRatioBased(op.ProbabilisticSampling.SamplingRate)
+		}
+		return sampler
+	case s.RateLimitingSampling != nil && s.RateLimitingSampling.MaxTracesPerSecond > 0:
+		return rateLimitingSampler{rate.NewLimiter(rate.Limit(s.RateLimitingSampling.MaxTracesPerSecond), 1)}
+	case s.ProbabilisticSampling != nil:
+		return sdktrace.TraceIDRatioBased(s.ProbabilisticSampling.SamplingRate)
+	default:
+		return sdktrace.NeverSample()
+	}
+}
+
+// rateLimitingSampler samples up to a number of spans per second
+type rateLimitingSampler struct {
+	limiter *rate.Limiter
+}
+
+func (s rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return decide(p, s.limiter.Allow())
+}
+
+func (s rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimitingSampler{%g}", float64(s.limiter.Limit()))
+}
+
+// operationSampler samples each span name at its own ratio, or at the
+// default ratio, and guarantees every operation at least lowerBound
+// sampled spans per second, so rare operations are never invisible
+type operationSampler struct {
+	operations map[string]sdktrace.Sampler
+	fallback   sdktrace.Sampler
+	lowerBound rate.Limit
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func (s *operationSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	sampler, ok := s.operations[p.Name]
+	if !ok {
+		sampler = s.fallback
+	}
+	if result := sampler.ShouldSample(p); result.Decision == sdktrace.RecordAndSample {
+		return result
+	}
+	return decide(p, s.allowLowerBound(p.Name))
+}
+
+func (s *operationSampler) allowLowerBound(operation string) bool {
+	if s.lowerBound <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limiter, ok := s.limiters[operation]
+	if !ok {
+		if len(s.limiters) >= remoteSamplingMaxOperations {
+			return false
+		}
+		limiter = rate.NewLimiter(s.lowerBound, 1)
+		s.limiters[operation] = limiter
+	}
+	return limiter.Allow()
+}
+
+func (s *operationSampler) Description() string {
+	return fmt.Sprintf("PerOperationSampler{operations=%d,default=%s,lowerBound=%g}",
+		len(s.operations), s.fallback.Description(), float64(s.lowerBound))
+}
+
+// decide turns a sampling decision into a result that keeps the parent's
+// trace state
+func decide(p sdktrace.SamplingParameters, sample bool) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	if sample {
+		decision = sdktrace.RecordAndSample
+	}
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
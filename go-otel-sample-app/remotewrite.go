@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriter pushes the series served on /metrics to a Prometheus remote
+// write endpoint, typically an Amazon Managed Service for Prometheus
+// workspace, signing every request with SigV4. It replaces a collector that
+// scrapes the pod: the series are exactly those a scrape would see, with the
+// job and instance labels a scrape would add.
+type remoteWriter struct {
+	url      string
+	region   string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	// client is deliberately not traced, so pushes don't add a trace every
+	// interval
+	client      *http.Client
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	// target are the job and instance labels added to every series
+	target []promLabel
+
+	wg sync.WaitGroup
+}
+
+type promLabel struct {
+	name, value string
+}
+
+func newRemoteWriter(awsCfg aws.Config, c remoteWriteConfig, gatherer prometheus.Gatherer) (*remoteWriter, error) {
+	region := c.Region
+	if region == "" {
+		region = awsCfg.Region
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no AWS region for remote write: set METRICS_REMOTE_WRITE_REGION or AWS_REGION")
+	}
+
+	instance, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteWriter{
+		url:         c.URL,
+		region:      region,
+		interval:    c.Interval,
+		gatherer:    gatherer,
+		client:      &http.Client{Timeout: c.Interval},
+		credentials: awsCfg.Credentials,
+		signer:      v4.NewSigner(),
+		target: []promLabel{
+			{"job", serviceName},
+			{"instance", instance},
+		},
+	}, nil
+}
+
+// start pushes every interval until ctx is done
+func (w *remoteWriter) start(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := w.push(ctx); err != nil {
+				telemetryLogger.WarnContext(ctx, "Prometheus remote write failed", "url", w.url, "error", err)
+			}
+		}
+	}()
+}
+
+// shutdown waits for the push loop to stop and pushes once more, so the
+// last interval before a rollout is not lost
+func (w *remoteWriter) shutdown(ctx context.Context) error {
+	w.wg.Wait()
+	return w.push(ctx)
+}
+
+func (w *remoteWriter) push(ctx context.Context) error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		telemetryLogger.WarnContext(ctx, "Gathering metrics for remote write was incomplete", "error", err)
+	}
+
+	body := snappy.Encode(nil, w.writeRequest(families, time.Now().UnixMilli()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "go-otel-sample-app")
+
+	creds, err := w.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving AWS credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := w.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "aps", w.region, time.Now()); err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// writeRequest encodes families as a remote write WriteRequest. Histograms
+// and summaries are split into their _bucket, _sum and _count series, as in
+// the text exposition format.
+func (w *remoteWriter) writeRequest(families []*dto.MetricFamily, now int64) []byte {
+	var b []byte
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			series := func(suffix string, value float64, extra ...promLabel) {
+				b = w.appendSeries(b, name+suffix, m.GetLabel(), extra, value, ts)
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				series("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				series("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				series("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series("", q.GetValue(), promLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				series("_sum", s.GetSampleSum())
+				series("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					if !math.IsInf(bucket.GetUpperBound(), 1) {
+						series("_bucket", float64(bucket.GetCumulativeCount()), promLabel{"le", formatFloat(bucket.GetUpperBound())})
+					}
+				}
+				series("_bucket", float64(h.GetSampleCount()), promLabel{"le", "+Inf"})
+				series("_sum", h.GetSampleSum())
+				series("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return b
+}
+
+// appendSeries appends one TimeSeries with a single sample to the
+// WriteRequest b. Labels must be sorted by name.
+func (w *remoteWriter) appendSeries(b []byte, name string, metricLabels []*dto.LabelPair, extra []promLabel, value float64, ts int64) []byte {
+	labels := make([]promLabel, 0, len(metricLabels)+len(extra)+len(w.target)+1)
+	labels = append(labels, promLabel{"__name__", name})
+	for _, l := range metricLabels {
+		labels = append(labels, promLabel{l.GetName(), l.GetValue()})
+	}
+	labels = append(labels, extra...)
+	for _, l := range w.target {
+		// Like a scrape with honor_labels, the metric's own label wins
+		if !slices.ContainsFunc(labels, func(existing promLabel) bool { return existing.name == l.name }) {
+			labels = append(labels, l)
+		}
+	}
+	slices.SortFunc(labels, func(a, b promLabel) int { return strings.Compare(a.name, b.name) })
+
+	var series []byte
+	for _, l := range labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.value)
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ts))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, series)
+}
+
+// formatFloat renders le and quantile values the way the text exposition
+// format does, so the series match those of a scrape
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID in both directions. Load balancers
+// and API gateways commonly set it, and customers quote it in tickets.
+const requestIDHeader = "X-Request-Id"
+
+// Response headers with the trace of the request: X-Trace-Id has the bare
+// trace ID to quote, traceresponse the traceparent format of W3C Trace
+// Context Level 2. A traceparent in the response would be taken for the
+// context of a call by proxies that propagate it.
+const (
+	traceIDHeader       = "X-Trace-Id"
+	traceResponseHeader = "traceresponse"
+)
+
+// traceResponse returns the trace ID to clients, set from the server
+// configuration at startup
+var traceResponse = true
+
+type requestIDKey struct{}
+
+// requestIDMiddleware keeps the X-Request-Id sent by the client or the
+// proxy in front of the app, or generates one, and puts it on the server
+// span as request.id, in every log record of the request, in the response
+// header and in error bodies. Searching traces for request.id then finds
+// the trace behind an ID a customer reported. Unless disabled, the trace ID
+// is returned as well, which leads to the trace without the search.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.String("request.id", id))
+		w.Header().Set(requestIDHeader, id)
+		if sc := span.SpanContext(); traceResponse && sc.IsValid() {
+			w.Header().Set(traceIDHeader, sc.TraceID().String())
+			w.Header().Set(traceResponseHeader, fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags()))
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters, so a
+// client can't inject arbitrary content into logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFromContext returns the ID set by requestIDMiddleware, or "" for
+// work outside a request
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// problem is an RFC 7807 problem details body. Error keeps the message
+// where clients of the earlier {"error": ...} bodies read it; RequestID and
+// TraceID are extension members.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// writeError answers with a problem details body that echoes the request
+// and trace IDs, so a client reporting the error has them at hand
+func writeError(ctx context.Context, w http.ResponseWriter, code int, msg string) {
+	body := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    msg,
+		Error:     msg,
+		RequestID: requestIDFromContext(ctx),
+	}
+	if sc := trace.SpanContextFromContext(ctx); traceResponse && sc.IsValid() {
+		body.TraceID = sc.TraceID().String()
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
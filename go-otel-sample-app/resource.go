@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// serviceAccountTokenPath exists in every pod that mounts a service account
+// token, which is the default on EKS (including Fargate)
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// serviceName is the service.name of the resource, for log fields and
+// other places outside the OTel SDK that name the service
+var serviceName = "go-otel-sample-app"
+
+// serviceVersion is the service.version of the resource
+var serviceVersion = build.Version
+
+// resourceOptions add the app's own attributes to the resource built by
+// the telemetry package: the service namespace and environment, the AWS
+// detectors named in RESOURCE_DETECTORS, and the pod metadata passed in
+// through the downward API.
+func resourceOptions() ([]resource.Option, error) {
+	detectors, err := awsDetectors()
+	if err != nil {
+		return nil, err
+	}
+	return []resource.Option{
+		resource.WithAttributes(
+			// Groups the frontend, backend and worker services
+			semconv.ServiceNamespace("go-otel-sample-app"),
+			attribute.String("environment", getEnv("ENVIRONMENT", "development")),
+		),
+		resource.WithAttributes(build.attributes()...),
+		resource.WithDetectors(detectors...),
+		resource.WithDetectors(podDetector{}),
+	}, nil
+}
+
+// awsDetectors returns the detectors listed in RESOURCE_DETECTORS. EC2 runs
+// before EKS so that cloud.platform ends up as aws_eks on EKS worker nodes.
+func awsDetectors() ([]resource.Detector, error) {
+	var detectors []resource.Detector
+	for _, name := range strings.Split(getEnv("RESOURCE_DETECTORS", "ec2,eks"), ",") {
+		switch strings.TrimSpace(name) {
+		case "ec2":
+			detectors = append(detectors, ec2Detector{client: imds.New(imds.Options{})})
+		case "eks":
+			detectors = append(detectors, eksDetector{})
+		case "", "none":
+		default:
+			return nil, fmt.Errorf("unsupported resource detector %q in RESOURCE_DETECTORS", name)
+		}
+	}
+	return detectors, nil
+}
+
+// ec2Detector reads the instance identity document from IMDSv2. On EKS with
+// managed node groups this identifies the worker node behind the pod.
+type ec2Detector struct {
+	client *imds.Client
+}
+
+func (d ec2Detector) Detect(ctx context.Context) (*resource.Resource, error) {
+	identity, err := d.client.GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	if err != nil {
+		// Not on EC2, or IMDS is blocked (Fargate, hop limit of 1)
+		return resource.Empty(), nil
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.CloudProviderAWS,
+		semconv.CloudPlatformAWSEC2,
+		semconv.CloudRegion(identity.Region),
+		semconv.CloudAvailabilityZone(identity.AvailabilityZone),
+		semconv.CloudAccountID(identity.AccountID),
+		semconv.HostID(identity.InstanceID),
+		semconv.HostImageID(identity.ImageID),
+		semconv.HostType(identity.InstanceType),
+	}
+
+	if out, err := d.client.GetMetadata(ctx, &imds.GetMetadataInput{Path: "hostname"}); err == nil {
+		defer out.Content.Close()
+		if hostname, err := io.ReadAll(out.Content); err == nil {
+			attrs = append(attrs, semconv.HostName(string(hostname)))
+		}
+	}
+
+	return resource.NewSchemaless(attrs...), nil
+}
+
+// eksDetector marks the platform as EKS when running in a Kubernetes pod.
+// The cluster name is not discoverable from inside the pod without extra
+// RBAC, so it is taken from CLUSTER_NAME, which the deployment sets.
+type eksDetector struct{}
+
+func (eksDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return resource.Empty(), nil
+	}
+	if _, err := os.Stat(serviceAccountTokenPath); err != nil {
+		return resource.Empty(), nil
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.CloudProviderAWS,
+		semconv.CloudPlatformAWSEKS,
+	}
+	if clusterName := os.Getenv("CLUSTER_NAME"); clusterName != "" {
+		attrs = append(attrs, semconv.K8SClusterName(clusterName))
+	}
+	if region := getEnv("AWS_REGION", ""); region != "" {
+		attrs = append(attrs, semconv.CloudRegion(region))
+	}
+
+	return resource.NewSchemaless(attrs...), nil
+}
+
+// podDetector reads the pod metadata that the deployment passes in through
+// the Kubernetes downward API, so replicas can be told apart without the
+// collector's k8sattributes processor. The pod UID doubles as
+// service.instance.id, which is unique per replica and changes on restart.
+type podDetector struct{}
+
+func (podDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	if name := os.Getenv("POD_NAME"); name != "" {
+		attrs = append(attrs, semconv.K8SPodName(name))
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(namespace))
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		attrs = append(attrs, semconv.K8SNodeName(node))
+	}
+	if uid := os.Getenv("POD_UID"); uid != "" {
+		attrs = append(attrs, semconv.K8SPodUID(uid), semconv.ServiceInstanceID(uid))
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
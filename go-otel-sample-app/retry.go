@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Outcomes of a downstream call over all its attempts, the outcome label
+// of downstream_calls_total
+const (
+	downstreamSuccess      = "success"
+	downstreamRetrySuccess = "success_after_retry"
+	downstreamFailure      = "failure"
+	downstreamExhausted    = "retries_exhausted"
+	downstreamRejected     = "short_circuited"
+)
+
+var (
+	downstreamRetries metric.Int64Counter
+	downstreamCalls   metric.Int64Counter
+
+	promRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "retries_total",
+			Help: "Retried downstream calls by target and reason",
+		},
+		[]string{"target", "reason"},
+	)
+	promDownstreamCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "downstream_calls_total",
+			Help: "Downstream calls by target and outcome over all their attempts",
+		},
+		[]string{"target", "outcome"},
+	)
+)
+
+// downstreamRetry is the retry policy of downstream calls, set from the
+// downstream configuration at startup
+var downstreamRetry = retryConfig{MaxAttempts: 1}
+
+func registerRetryMetrics() error {
+	var err error
+	if downstreamRetries, err = meter.Int64Counter("retries_total",
+		metric.WithDescription("Retried downstream calls by target and reason: the status code, timeout or error")); err != nil {
+		return err
+	}
+	if downstreamCalls, err = meter.Int64Counter("downstream_calls_total",
+		metric.WithDescription("Downstream calls by target and outcome over all their attempts")); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promRetries, promDownstreamCalls)
+	}
+	return nil
+}
+
+// downstreamStatusError is a downstream answer with a 5xx status
+type downstreamStatusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *downstreamStatusError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.url, e.status)
+}
+
+// retryReason returns why a failed attempt is worth retrying, or "" when
+// it is not: the caller gave up, the breaker is open, or the downstream
+// answered with an error another attempt would not change
+func retryReason(ctx context.Context, err error) string {
+	if ctx.Err() != nil || errors.Is(err, errBreakerOpen) {
+		return ""
+	}
+	var statusErr *downstreamStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return strconv.Itoa(statusErr.code)
+		}
+		return ""
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
+
+// backoff returns the wait before the given retry, 1 for the first:
+// exponential up to MaxBackoff and, with jitter, drawn uniformly below it
+// ("full jitter"), so clients that failed together do not retry together
+func (c retryConfig) backoff(retry int) time.Duration {
+	d := float64(c.InitialBackoff) * math.Pow(c.Multiplier, float64(retry-1))
+	d = min(d, float64(c.MaxBackoff))
+	if c.Jitter {
+		d = rand.Float64() * d
+	}
+	return time.Duration(d)
+}
+
+// retryCall runs attempt until it succeeds, up to MaxAttempts times,
+// waiting a backoff between attempts. Each attempt's client span carries
+// http.request.resend_count, and the span in ctx the number of attempts
+// and the outcome.
+func retryCall(ctx context.Context, target string, attempt func(ctx context.Context) error) error {
+	span := trace.SpanFromContext(ctx)
+	var (
+		err      error
+		attempts int
+		reason   string
+	)
+	for {
+		if attempts > 0 {
+			delay := downstreamRetry.backoff(attempts)
+			// A retry that would start past the request's deadline could
+			// only fail
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+				span.AddEvent("retry.skipped", trace.WithAttributes(
+					attribute.String("retry.reason", reason),
+					attribute.Float64("retry.delay_ms", float64(delay.Microseconds())/1000),
+				))
+				break
+			}
+			downstreamRetries.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("target", target), attribute.String("reason", reason)))
+			if !prometheusBridge {
+				promRetries.WithLabelValues(target, reason).Inc()
+			}
+			span.AddEvent("retry.backoff", trace.WithAttributes(
+				attribute.Int("retry.attempt", attempts+1),
+				attribute.String("retry.reason", reason),
+				attribute.Float64("retry.delay_ms", float64(delay.Microseconds())/1000),
+			))
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		err = attempt(context.WithValue(ctx, resendCountKey{}, attempts))
+		attempts++
+		if err == nil || attempts >= downstreamRetry.MaxAttempts {
+			break
+		}
+		if reason = retryReason(ctx, err); reason == "" {
+			break
+		}
+	}
+
+	outcome := downstreamSuccess
+	switch {
+	case errors.Is(err, errBreakerOpen):
+		outcome = downstreamRejected
+	case err != nil && attempts >= downstreamRetry.MaxAttempts && downstreamRetry.MaxAttempts > 1:
+		outcome = downstreamExhausted
+	case err != nil:
+		outcome = downstreamFailure
+	case attempts > 1:
+		outcome = downstreamRetrySuccess
+	}
+	span.SetAttributes(
+		attribute.Int("retry.attempts", attempts),
+		attribute.String("downstream.outcome", outcome),
+	)
+	downstreamCalls.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("target", target), attribute.String("outcome", outcome)))
+	if !prometheusBridge {
+		promDownstreamCalls.WithLabelValues(target, outcome).Inc()
+	}
+	expvarDownstreamCalls.Add(outcome, 1)
+	return err
+}
+
+// resendCountKey holds the number of earlier attempts of a call in the
+// context of the next
+type resendCountKey struct{}
+
+// resendCountTransport sets http.request.resend_count on the client span
+// of retried attempts, which otelhttp starts before calling it
+type resendCountTransport struct {
+	base http.RoundTripper
+}
+
+func (t resendCountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if n, _ := req.Context().Value(resendCountKey{}).(int); n > 0 {
+		trace.SpanFromContext(req.Context()).SetAttributes(semconv.HTTPRequestResendCount(n))
+	}
+	return t.base.RoundTrip(req)
+}
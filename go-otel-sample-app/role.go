@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// serviceRole is the part of the sample a process plays. Deployed as three
+// services from the same image, a request crosses the frontend, which
+// calls the backend over HTTP, and the worker, which consumes what the
+// backend published to SQS or Kafka. The traces then span three services
+// and the service map has more than one node. roleAll runs everything in
+// one process, as a single service.
+type serviceRole string
+
+const (
+	roleAll      serviceRole = "all"
+	roleFrontend serviceRole = "frontend"
+	roleBackend  serviceRole = "backend"
+	roleWorker   serviceRole = "worker"
+)
+
+func (r serviceRole) validate() error {
+	switch r {
+	case roleAll, roleFrontend, roleBackend, roleWorker:
+		return nil
+	}
+	return fmt.Errorf("unknown role %q: expected all, frontend, backend or worker", r)
+}
+
+// servesAPI reports whether the process serves /api. The frontend's /api
+// calls the downstream URLs, i.e. the backend; the backend's persists the
+// request and publishes it for the worker.
+func (r serviceRole) servesAPI() bool {
+	return r != roleWorker
+}
+
+// servesOrders reports whether the process serves the orders API over HTTP
+// and gRPC, and /dependency
+func (r serviceRole) servesOrders() bool {
+	return r == roleAll || r == roleBackend
+}
+
+// consumes reports whether the process runs the SQS and Kafka consumers and
+// the scheduled jobs
+func (r serviceRole) consumes() bool {
+	return r == roleAll || r == roleWorker
+}
+
+// serviceName is the default service.name of the role; OTEL_SERVICE_NAME
+// still overrides it
+func (r serviceRole) serviceName() string {
+	if r == roleAll {
+		return "go-otel-sample-app"
+	}
+	return "go-otel-" + string(r)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Runtime metric samples backing the go.memory.* instruments, named as in
+// the runtime/metrics package
+const (
+	memoryTotal    = "/memory/classes/total:bytes"
+	memoryReleased = "/memory/classes/heap/released:bytes"
+	memoryStacks   = "/memory/classes/heap/stacks:bytes"
+	memoryOSStacks = "/memory/classes/os-stacks:bytes"
+	memoryLimit    = "/gc/gomemlimit:bytes"
+)
+
+// startRuntimeMetrics exports goroutine, GC and heap metrics from the contrib
+// runtime instrumentation, plus memory broken down by class, through the
+// global MeterProvider
+func startRuntimeMetrics() error {
+	// ReadMemStats briefly stops the world, so the instrumentation caches it
+	// for 15s by default, well within the periodic reader's 60s interval
+	if err := runtime.Start(); err != nil {
+		return err
+	}
+	return registerMemoryClasses(otel.Meter("go-otel-sample-app/runtime"))
+}
+
+// registerMemoryClasses reports go.memory.used split into stack and other
+// memory, and the GOMEMLIMIT soft limit, following the OTel Go runtime
+// semantic conventions
+func registerMemoryClasses(m metric.Meter) error {
+	used, err := m.Int64ObservableUpDownCounter(
+		"go.memory.used",
+		metric.WithUnit("By"),
+		metric.WithDescription("Memory used by the Go runtime"),
+	)
+	if err != nil {
+		return err
+	}
+	limit, err := m.Int64ObservableUpDownCounter(
+		"go.memory.limit",
+		metric.WithUnit("By"),
+		metric.WithDescription("Go runtime memory limit configured by the user, if a limit exists"),
+	)
+	if err != nil {
+		return err
+	}
+
+	stackAttrs := metric.WithAttributes(attribute.String("go.memory.type", "stack"))
+	otherAttrs := metric.WithAttributes(attribute.String("go.memory.type", "other"))
+	samples := []metrics.Sample{
+		{Name: memoryTotal},
+		{Name: memoryReleased},
+		{Name: memoryStacks},
+		{Name: memoryOSStacks},
+		{Name: memoryLimit},
+	}
+
+	_, err = m.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		metrics.Read(samples)
+		values := make(map[string]int64, len(samples))
+		for _, s := range samples {
+			if s.Value.Kind() == metrics.KindUint64 {
+				values[s.Name] = int64(s.Value.Uint64())
+			}
+		}
+
+		stack := values[memoryStacks] + values[memoryOSStacks]
+		o.ObserveInt64(used, stack, stackAttrs)
+		o.ObserveInt64(used, values[memoryTotal]-values[memoryReleased]-stack, otherAttrs)
+		// Without GOMEMLIMIT the runtime reports math.MaxInt64
+		if l := values[memoryLimit]; l != math.MaxInt64 {
+			o.ObserveInt64(limit, l)
+		}
+		return nil
+	}, used, limit)
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// activeSampler is the sampler installed on the TracerProvider. It
+// delegates to the sampler built from the sampling configuration, so a
+// reloaded config file takes effect without recreating the provider, except
+// for debug trace requests, which are always sampled.
+var activeSampler = &reloadableSampler{}
+
+// newSampler builds the sampler described by c, using the OTEL_TRACES_SAMPLER
+// names from the OTel specification. Routes listed in c.IgnoreRoutes are
+// dropped before the configured sampler is consulted.
+func newSampler(c samplingConfig) (sdktrace.Sampler, error) {
+	if strings.HasSuffix(c.Sampler, "traceidratio") && (c.Ratio < 0 || c.Ratio > 1) {
+		return nil, fmt.Errorf("invalid sampling ratio %v: expected a ratio between 0 and 1", c.Ratio)
+	}
+	if strings.HasSuffix(c.Sampler, "jaeger_remote") {
+		if err := c.Remote.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var sampler sdktrace.Sampler
+	var remote *remoteSampler
+	switch strings.ToLower(c.Sampler) {
+	case "always_on":
+		sampler = sdktrace.AlwaysSample()
+	case "always_off":
+		sampler = sdktrace.NeverSample()
+	case "traceidratio":
+		sampler = sdktrace.TraceIDRatioBased(c.Ratio)
+	case "parentbased_always_on":
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	case "parentbased_always_off":
+		sampler = sdktrace.ParentBased(sdktrace.NeverSample())
+	case "parentbased_traceidratio":
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.Ratio))
+	case "jaeger_remote":
+		remote = newRemoteSampler(c.Remote)
+		sampler = remote
+	case "parentbased_jaeger_remote":
+		remote = newRemoteSampler(c.Remote)
+		sampler = sdktrace.ParentBased(remote)
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", c.Sampler)
+	}
+
+	if len(c.IgnoreRoutes) > 0 {
+		ignored := map[string]bool{}
+		for _, route := range c.IgnoreRoutes {
+			ignored[route] = true
+		}
+		sampler = routeSampler{ignored: ignored, next: sampler}
+	}
+	if remote != nil {
+		sampler = pollingSampler{Sampler: sampler, stop: remote.stop}
+	}
+
+	return sampler, nil
+}
+
+// pollingSampler is a sampler that polls for its strategy, which stop ends
+type pollingSampler struct {
+	sdktrace.Sampler
+	stop func()
+}
+
+// reloadableSampler forwards to a sampler that can be swapped at runtime
+type reloadableSampler struct {
+	current atomic.Pointer[sdktrace.Sampler]
+}
+
+// set installs sampler, and stops the polling of the sampler it replaces
+func (s *reloadableSampler) set(sampler sdktrace.Sampler) {
+	if prev := s.current.Swap(&sampler); prev != nil {
+		if polling, ok := (*prev).(pollingSampler); ok {
+			polling.stop()
+		}
+	}
+}
+
+func (s *reloadableSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if result, ok := forceSample(p); ok {
+		return result
+	}
+	return (*s.current.Load()).ShouldSample(p)
+}
+
+func (s *reloadableSampler) Description() string {
+	return (*s.current.Load()).Description()
+}
+
+// routeSampler drops server spans for noisy routes such as kubelet probes
+// and Prometheus scrapes, which otherwise dominate span volume while
+// carrying little diagnostic value. Child spans started under a dropped
+// local span are dropped as well, whatever the wrapped sampler would do.
+type routeSampler struct {
+	ignored map[string]bool
+	next    sdktrace.Sampler
+}
+
+func (s routeSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	drop := sdktrace.SamplingResult{
+		Decision:   sdktrace.Drop,
+		Tracestate: parent.TraceState(),
+	}
+
+	if parent.IsValid() && !parent.IsRemote() && !parent.IsSampled() {
+		return drop
+	}
+
+	for _, attr := range p.Attributes {
+		if attr.Key != "url.path" && attr.Key != "http.target" {
+			continue
+		}
+		path, _, _ := strings.Cut(attr.Value.AsString(), "?")
+		if s.ignored[path] {
+			return drop
+		}
+	}
+
+	return s.next.ShouldSample(p)
+}
+
+func (s routeSampler) Description() string {
+	return fmt.Sprintf("RouteSampler{ignored=%d,%s}", len(s.ignored), s.next.Description())
+}
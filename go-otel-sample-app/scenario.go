@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// scenarioTick is how often the scenario engine moves the traffic
+	// multiplier and starts or ends bursts and incidents
+	scenarioTick = time.Second
+	// backgroundConcurrency bounds the background requests in flight
+	backgroundConcurrency = 20
+	// backgroundRampUp spreads the first background requests while the
+	// server starts listening
+	backgroundRampUp = 30 * time.Second
+)
+
+// scenario shapes the traffic and faults over time when the scenario
+// engine is enabled, and is nil otherwise
+var scenario *scenarioEngine
+
+// scenarioEngine plays a day in the life of a service so long-running demo
+// clusters produce telemetry worth looking at without anyone driving them:
+// background traffic that follows a diurnal sine wave with random bursts on
+// top, and incidents that degrade a route on a schedule.
+type scenarioEngine struct {
+	cfg scenarioConfig
+
+	mu         sync.RWMutex
+	multiplier float64
+	bursting   bool
+	burstEnd   time.Time
+	nextBurst  time.Time
+	// active are the names of the incidents in progress
+	active map[string]bool
+}
+
+// newScenarioEngine registers the scenario metrics and computes the state
+// at startup, so an incident scheduled now is already in progress. It
+// returns nil when the engine is disabled.
+func newScenarioEngine(c scenarioConfig) (*scenarioEngine, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	s := &scenarioEngine{cfg: c, active: make(map[string]bool)}
+	now := time.Now()
+	if c.Bursts.Interval > 0 {
+		s.nextBurst = now.Add(s.burstInterval())
+	}
+	s.update(now)
+
+	if _, err := meter.Float64ObservableGauge("scenario_traffic_multiplier",
+		metric.WithDescription("Factor the traffic scenario applies to the background request rate"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(s.trafficMultiplier())
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+	if _, err := meter.Int64ObservableGauge("scenario_incident_active",
+		metric.WithDescription("Whether a scheduled scenario incident is in progress"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for _, incident := range c.Incidents {
+				o.Observe(s.incidentActive(incident.Name), metric.WithAttributes(
+					attribute.String("incident", incident.Name),
+					attribute.String("endpoint", incident.Route),
+				))
+			}
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+
+	if !prometheusBridge {
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scenario_traffic_multiplier",
+			Help: "Factor the traffic scenario applies to the background request rate",
+		}, s.trafficMultiplier))
+		for _, incident := range c.Incidents {
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "scenario_incident_active",
+				Help:        "Whether a scheduled scenario incident is in progress",
+				ConstLabels: prometheus.Labels{"incident": incident.Name, "endpoint": incident.Route},
+			}, func() float64 { return float64(s.incidentActive(incident.Name)) }))
+		}
+	}
+	return s, nil
+}
+
+// burstInterval draws the time from one burst to the next
+func (s *scenarioEngine) burstInterval() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(s.cfg.Bursts.Interval))
+}
+
+// diurnal is the factor of the sine wave at now, 1 at the mean
+func (s *scenarioEngine) diurnal(now time.Time) float64 {
+	d := s.cfg.Diurnal
+	if d.Period <= 0 || d.Amplitude == 0 {
+		return 1
+	}
+	phase := float64((now.UnixNano()-int64(d.Peak))%int64(d.Period)) / float64(d.Period)
+	return 1 + d.Amplitude*math.Cos(2*math.Pi*phase)
+}
+
+// activeAt reports whether the incident is in progress at now
+func (i incidentConfig) activeAt(now time.Time) bool {
+	phase := time.Duration(now.UnixNano() % int64(i.Every))
+	return (phase-i.Start+i.Every)%i.Every < i.Duration
+}
+
+func (s *scenarioEngine) trafficMultiplier() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.multiplier
+}
+
+func (s *scenarioEngine) incidentActive(name string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.active[name] {
+		return 1
+	}
+	return 0
+}
+
+// update moves the scenario to now, logging the bursts and incidents that
+// start or end
+func (s *scenarioEngine) update(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.cfg.Bursts
+	if b.Interval > 0 {
+		if s.bursting && !now.Before(s.burstEnd) {
+			s.bursting = false
+			s.nextBurst = now.Add(s.burstInterval())
+			backgroundLogger.Info("Traffic burst ended", "next_burst", s.nextBurst.Format(time.RFC3339))
+		}
+		if !s.bursting && !now.Before(s.nextBurst) {
+			s.bursting = true
+			s.burstEnd = now.Add(b.Duration)
+			backgroundLogger.Info("Traffic burst started",
+				"multiplier", b.Multiplier,
+				"duration", b.Duration.String(),
+			)
+		}
+	}
+	s.multiplier = s.diurnal(now)
+	if s.bursting {
+		s.multiplier *= b.Multiplier
+	}
+
+	for _, incident := range s.cfg.Incidents {
+		active := incident.activeAt(now)
+		if active == s.active[incident.Name] {
+			continue
+		}
+		s.active[incident.Name] = active
+		if active {
+			backgroundLogger.Warn("Scenario incident started",
+				"incident", incident.Name,
+				"route", incident.Route,
+				"duration", incident.Duration.String(),
+				"error_rate", incident.ErrorRate,
+				"latency_factor", incident.LatencyFactor,
+			)
+		} else {
+			backgroundLogger.Info("Scenario incident ended", "incident", incident.Name, "route", incident.Route)
+		}
+	}
+}
+
+// adjust applies an incident in progress on route to the route's chaos
+// rule: the route fails at least the incident's share of requests and its
+// latency is scaled. ok reports whether the route has a rule, and the
+// results are the rule to apply, the name of the incident, if any, and
+// whether there is a rule to apply at all.
+func (s *scenarioEngine) adjust(route string, rule chaosRule, ok bool) (chaosRule, string, bool) {
+	if s == nil {
+		return rule, "", ok
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, incident := range s.cfg.Incidents {
+		if incident.Route != route || !s.active[incident.Name] {
+			continue
+		}
+		return incident.apply(rule), incident.Name, true
+	}
+	return rule, "", ok
+}
+
+// apply degrades rule as the incident describes
+func (i incidentConfig) apply(rule chaosRule) chaosRule {
+	rule.ErrorRate = max(rule.ErrorRate, i.ErrorRate)
+	if i.ErrorStatus != 0 {
+		rule.ErrorStatus = i.ErrorStatus
+	}
+	if f := i.LatencyFactor; f > 0 {
+		rule.LatencyMs *= f
+		rule.LatencyJitterMs *= f
+		rule.SpikeMs *= f
+	}
+	return rule
+}
+
+// start moves the scenario every scenarioTick and, with a background rate,
+// sends the background traffic to the server on port until ctx is done.
+// With authentication on, the requests carry the first API key; routes
+// only accepting JWTs answer them with 401.
+func (s *scenarioEngine) start(ctx context.Context, port string, useTLS bool, auth authConfig) {
+	go func() {
+		ticker := time.NewTicker(scenarioTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.update(now)
+			}
+		}
+	}()
+
+	if s.cfg.BackgroundRPS <= 0 {
+		return
+	}
+	// Validated with the configuration
+	endpoints, _ := parseLoadgenEndpoints(strings.Join(s.cfg.Endpoints, ","))
+	target := "http://127.0.0.1:" + port
+	transport := &http.Transport{MaxIdleConnsPerHost: backgroundConcurrency}
+	if useTLS {
+		// The app calls itself over loopback, typically with a self-signed
+		// certificate
+		target = "https://127.0.0.1:" + port
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	headers := make(http.Header)
+	if len(auth.APIKeys) > 0 {
+		headers.Set(apiKeyHeader, auth.APIKeys[0])
+	}
+	l := &loadgen{
+		target:      target,
+		endpoints:   endpoints,
+		rps:         s.cfg.BackgroundRPS,
+		concurrency: backgroundConcurrency,
+		rampUp:      backgroundRampUp,
+		headers:     headers,
+		scale:       s.trafficMultiplier,
+		client:      &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+	go l.run(ctx)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Results reported in job_runs_total
+const (
+	jobSuccess = "success"
+	jobFailure = "failure"
+	jobSkipped = "skipped"
+)
+
+// jobTask is the work of a scheduled job. It should return when ctx is
+// done, which happens at its timeout or on shutdown.
+type jobTask func(ctx context.Context) error
+
+// jobTasks are the jobs that can be scheduled, by name. They simulate the
+// housekeeping a real service runs next to its request handling.
+var jobTasks = map[string]jobTask{
+	"cache_cleanup":         cacheCleanupJob,
+	"connection_pool_check": connectionPoolCheckJob,
+	"memory_check":          memoryCheckJob,
+	"slow_query_report":     slowQueryReportJob,
+}
+
+type jobConfig struct {
+	// Schedule is a five-field cron expression or a descriptor such as
+	// @hourly or "@every 30s"; an empty schedule disables the job
+	Schedule string `yaml:"schedule"`
+	// Timeout cancels a run that takes longer (default: 1m)
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+const defaultJobTimeout = time.Minute
+
+func (j jobConfig) validate() error {
+	if j.Schedule == "" {
+		return nil
+	}
+	if _, err := cron.ParseStandard(j.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", j.Schedule, err)
+	}
+	if j.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+func defaultJobs() map[string]jobConfig {
+	return map[string]jobConfig{
+		"cache_cleanup":         {Schedule: "@every 30s", Timeout: 10 * time.Second},
+		"connection_pool_check": {Schedule: "@every 1m", Timeout: 5 * time.Second},
+		"memory_check":          {Schedule: "@every 15s", Timeout: 5 * time.Second},
+		"slow_query_report":     {Schedule: "*/5 * * * *", Timeout: 30 * time.Second},
+	}
+}
+
+// parseJobSchedules sets the schedules in a JOB_SCHEDULES value, e.g.
+// "cache_cleanup=@every 1m;slow_query_report=". Entries are separated by
+// semicolons since cron expressions contain spaces and commas, and an empty
+// schedule disables the job.
+func parseJobSchedules(value string, jobs map[string]jobConfig) error {
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, schedule, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid JOB_SCHEDULES entry %q: expected <job>=<schedule>", entry)
+		}
+		name = strings.TrimSpace(name)
+		job := jobs[name]
+		job.Schedule = strings.TrimSpace(schedule)
+		jobs[name] = job
+	}
+	return nil
+}
+
+var (
+	jobRuns        metric.Int64Counter
+	jobDuration    metric.Float64Histogram
+	jobLastSuccess metric.Float64Gauge
+
+	promJobRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "job_runs_total",
+			Help: "Scheduled job runs by job and result",
+		},
+		[]string{"job", "result"},
+	)
+	// promJobDuration is created by newScheduler once the configured bucket
+	// boundaries are known
+	promJobDuration    *prometheus.HistogramVec
+	promJobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "job_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run of each scheduled job",
+		},
+		[]string{"job"},
+	)
+)
+
+// scheduler runs the configured jobs on their cron schedules. Every run is
+// the root span of its own trace, named after the job, and is recorded in
+// job_runs_total by result, in job_duration_seconds and, when it succeeds,
+// in job_last_success_timestamp_seconds, so a job that fails or stops
+// running can be alerted on like any batch job. A run still in progress
+// when the next one is due makes that one skip.
+type scheduler struct {
+	cron *cron.Cron
+	// ctx is canceled on shutdown, which cancels the running jobs
+	ctx context.Context
+}
+
+func newScheduler(ctx context.Context, jobs map[string]jobConfig) (*scheduler, error) {
+	var err error
+	jobRuns, err = meter.Int64Counter(
+		"job_runs_total",
+		metric.WithDescription("Scheduled job runs by job and result"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	jobDuration, err = meter.Float64Histogram(
+		"job_duration_seconds",
+		metric.WithDescription("Duration of scheduled job runs in seconds"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	jobLastSuccess, err = meter.Float64Gauge(
+		"job_last_success_timestamp_seconds",
+		metric.WithDescription("Unix time of the last successful run of each scheduled job"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promJobDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "job_duration_seconds",
+				Help:    "Duration of scheduled job runs in seconds",
+				Buckets: histogramBuckets["job_duration_seconds"],
+			},
+			[]string{"job", "result"},
+		)
+		promRegistry.MustRegister(promJobRuns, promJobDuration, promJobLastSuccess)
+	}
+
+	s := &scheduler{cron: cron.New(), ctx: ctx}
+	for name, job := range jobs {
+		if job.Schedule == "" {
+			continue
+		}
+		task, ok := jobTasks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown job %q", name)
+		}
+		var running atomic.Bool
+		run := func() {
+			if !running.CompareAndSwap(false, true) {
+				recordJobRun(context.Background(), name, jobSkipped, 0)
+				backgroundLogger.Warn("Job skipped, previous run still in progress", "job", name)
+				return
+			}
+			defer running.Store(false)
+			s.run(name, job, task)
+		}
+		if _, err := s.cron.AddFunc(job.Schedule, run); err != nil {
+			return nil, fmt.Errorf("job %s: %w", name, err)
+		}
+		backgroundLogger.Info("Job scheduled", "job", name, "schedule", job.Schedule)
+	}
+	return s, nil
+}
+
+// run runs one job in a new trace
+func (s *scheduler) run(name string, job jobConfig, task jobTask) {
+	timeout := job.Timeout
+	if timeout == 0 {
+		timeout = defaultJobTimeout
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "job "+name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("job.name", name),
+			attribute.String("job.schedule", job.Schedule),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	backgroundLogger.DebugContext(ctx, "Job started", "job", name)
+	err := runJobTask(ctx, task)
+	duration := time.Since(start)
+
+	if err != nil {
+		failSpan(span, err, "job failed")
+		recordJobRun(ctx, name, jobFailure, duration)
+		backgroundLogger.ErrorContext(ctx, "Job failed",
+			"job", name,
+			"error", err,
+			"duration_ms", duration.Milliseconds(),
+		)
+		return
+	}
+	recordJobRun(ctx, name, jobSuccess, duration)
+	backgroundLogger.InfoContext(ctx, "Job completed", "job", name, "duration_ms", duration.Milliseconds())
+}
+
+// runJobTask turns a panic in task into an error, so one broken job run
+// doesn't take down the app
+func runJobTask(ctx context.Context, task jobTask) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return task(ctx)
+}
+
+func (s *scheduler) start() {
+	s.cron.Start()
+}
+
+// stop stops scheduling runs and waits for the running ones, which were
+// canceled along with the scheduler's context, or until ctx is done
+func (s *scheduler) stop(ctx context.Context) error {
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func recordJobRun(ctx context.Context, name, result string, duration time.Duration) {
+	attrs := metric.WithAttributes(attribute.String("job", name), attribute.String("result", result))
+	jobRuns.Add(ctx, 1, attrs)
+	if result != jobSkipped {
+		jobDuration.Record(ctx, duration.Seconds(), attrs)
+	}
+	if result == jobSuccess {
+		jobLastSuccess.Record(ctx, float64(time.Now().Unix()), metric.WithAttributes(attribute.String("job", name)))
+	}
+	if !prometheusBridge {
+		promJobRuns.WithLabelValues(name, result).Inc()
+		if result != jobSkipped {
+			promJobDuration.WithLabelValues(name, result).Observe(duration.Seconds())
+		}
+		if result == jobSuccess {
+			promJobLastSuccess.WithLabelValues(name).SetToCurrentTime()
+		}
+	}
+}
+
+// sleepJob waits for d, or returns the context's error when the job is
+// canceled first
+func sleepJob(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cacheCleanupJob scans for expired entries and evicts them, failing now
+// and then
+func cacheCleanupJob(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "cache.scan")
+	err := sleepJob(ctx, time.Duration(rand.Intn(200)+50)*time.Millisecond)
+	span.End()
+	if err != nil {
+		return err
+	}
+	if rand.Float64() < 0.05 {
+		return errors.New("cache cleanup interrupted: scan cursor expired")
+	}
+
+	ctx, span = tracer.Start(ctx, "cache.evict")
+	defer span.End()
+	evicted := rand.Intn(500)
+	span.SetAttributes(attribute.Int("cache.evicted", evicted))
+	if err := sleepJob(ctx, time.Duration(evicted/10)*time.Millisecond); err != nil {
+		return err
+	}
+	backgroundLogger.InfoContext(ctx, "Cache cleanup operation", "evicted", evicted)
+	return nil
+}
+
+// connectionPoolCheckJob checks the database connection pool, which times
+// out on one run in ten
+func connectionPoolCheckJob(ctx context.Context) error {
+	if rand.Float64() < 0.1 {
+		// Hangs until the job's timeout cancels it
+		<-ctx.Done()
+		return fmt.Errorf("connection timeout occurred: %w", ctx.Err())
+	}
+	if err := sleepJob(ctx, time.Duration(rand.Intn(40)+10)*time.Millisecond); err != nil {
+		return err
+	}
+	backgroundLogger.InfoContext(ctx, "Database connection pool status check",
+		"open_connections", rand.Intn(10)+1,
+		"idle_connections", rand.Intn(5),
+	)
+	return nil
+}
+
+// memoryCheckJob compares the heap with the Go memory limit, which the
+// Deployment sets through GOMEMLIMIT from the container's memory limit.
+// Without one, e.g. when run locally, it only reports the heap.
+func memoryCheckJob(ctx context.Context) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	limit := debug.SetMemoryLimit(-1)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("memory.heap_bytes", int64(stats.HeapAlloc)))
+
+	if limit != math.MaxInt64 && float64(stats.HeapAlloc) > 0.8*float64(limit) {
+		backgroundLogger.WarnContext(ctx, "High memory usage detected",
+			"heap_bytes", stats.HeapAlloc,
+			"limit_bytes", limit,
+		)
+		return nil
+	}
+	backgroundLogger.InfoContext(ctx, "Memory usage within normal range", "heap_bytes", stats.HeapAlloc)
+	return nil
+}
+
+// slowQueryReportJob scans the simulated query log and reports the slow
+// queries it finds
+func slowQueryReportJob(ctx context.Context) error {
+	if err := sleepJob(ctx, time.Duration(rand.Intn(1500)+500)*time.Millisecond); err != nil {
+		return err
+	}
+	slow := rand.Intn(4)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("db.slow_queries", slow))
+	for i := 0; i < slow; i++ {
+		backgroundLogger.WarnContext(ctx, "Slow query detected",
+			"duration_ms", rand.Intn(4000)+1000,
+			"table", []string{"orders", "order_items", "customers"}[rand.Intn(3)],
+		)
+	}
+	return nil
+}
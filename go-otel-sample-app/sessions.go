@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// sessionTick is how often the simulator logs users in and out
+const sessionTick = time.Second
+
+var (
+	sessionLogins   metric.Int64Counter
+	sessionDuration metric.Float64Histogram
+
+	promSessionLogins = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "user_logins_total",
+			Help: "Simulated user logins by region",
+		},
+		[]string{"region"},
+	)
+	// promSessionDuration is created by newSessionSimulator once the
+	// configured bucket boundaries are known
+	promSessionDuration *prometheus.HistogramVec
+)
+
+// userSession is a simulated signed-in user
+type userSession struct {
+	start time.Time
+	end   time.Time
+}
+
+// sessionSimulator keeps a population of synthetic user sessions per
+// region. Logins arrive at random at the rate that keeps the number of
+// sessions around the target, and each session lasts an exponentially
+// distributed time around the mean, so active_users churns the way a real
+// user base does instead of jumping between unrelated values.
+type sessionSimulator struct {
+	mean time.Duration
+	// rate is the logins per second of each region
+	rate    float64
+	regions []string
+
+	mu        sync.Mutex
+	sessions  map[string][]userSession
+	nextLogin map[string]time.Time
+}
+
+// newSessionSimulator registers the session metrics and starts every
+// region at its target, with sessions that began at random times in the
+// past, so the gauge doesn't ramp up from zero after each deployment. It
+// returns nil when the simulation is disabled.
+func newSessionSimulator(c sessionConfig) (*sessionSimulator, error) {
+	if c.TargetUsers <= 0 {
+		return nil, nil
+	}
+	s := &sessionSimulator{
+		mean:      c.MeanDuration,
+		rate:      float64(c.TargetUsers) / c.MeanDuration.Seconds(),
+		regions:   c.Regions,
+		sessions:  make(map[string][]userSession),
+		nextLogin: make(map[string]time.Time),
+	}
+	now := time.Now()
+	for _, region := range s.regions {
+		// Sessions are memoryless: a session open now has been open for an
+		// exponential time and stays open for another one
+		for range c.TargetUsers {
+			s.sessions[region] = append(s.sessions[region], userSession{
+				start: now.Add(-s.randomDuration()),
+				end:   now.Add(s.randomDuration()),
+			})
+		}
+		s.nextLogin[region] = now.Add(s.randomInterval())
+	}
+
+	if _, err := meter.Int64ObservableGauge("active_users",
+		metric.WithDescription("Number of active users"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			for _, region := range s.regions {
+				o.Observe(s.active(region), metric.WithAttributes(attribute.String("region", region)))
+			}
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+	var err error
+	sessionLogins, err = meter.Int64Counter("user_logins_total",
+		metric.WithDescription("Simulated user logins by region"))
+	if err != nil {
+		return nil, err
+	}
+	sessionDuration, err = meter.Float64Histogram("user_session_duration_seconds",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of simulated user sessions, recorded at logout"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !prometheusBridge {
+		for _, region := range s.regions {
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "active_users",
+				Help:        "Active users",
+				ConstLabels: prometheus.Labels{"region": region},
+			}, func() float64 { return float64(s.active(region)) }))
+		}
+		promSessionDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "user_session_duration_seconds",
+				Help:    "Duration of simulated user sessions, recorded at logout",
+				Buckets: histogramBuckets["user_session_duration_seconds"],
+			},
+			[]string{"region"},
+		)
+		promRegistry.MustRegister(promSessionLogins, promSessionDuration)
+	}
+	return s, nil
+}
+
+// randomDuration draws the length of a session
+func (s *sessionSimulator) randomDuration() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(s.mean))
+}
+
+// randomInterval draws the time until the next login of a region
+func (s *sessionSimulator) randomInterval() time.Duration {
+	return time.Duration(rand.ExpFloat64() / s.rate * float64(time.Second))
+}
+
+// active returns the open sessions of region
+func (s *sessionSimulator) active(region string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.sessions[region]))
+}
+
+// start logs users in and out every sessionTick until ctx is done
+func (s *sessionSimulator) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sessionTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.tick(ctx, now)
+			}
+		}
+	}()
+}
+
+// tick ends the sessions that are over and starts the logins due by now
+func (s *sessionSimulator) tick(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, region := range s.regions {
+		attrs := metric.WithAttributes(attribute.String("region", region))
+		open := s.sessions[region][:0]
+		for _, session := range s.sessions[region] {
+			if session.end.After(now) {
+				open = append(open, session)
+				continue
+			}
+			duration := session.end.Sub(session.start).Seconds()
+			sessionDuration.Record(ctx, duration, attrs)
+			if !prometheusBridge {
+				promSessionDuration.WithLabelValues(region).Observe(duration)
+			}
+		}
+		for ; !s.nextLogin[region].After(now); s.nextLogin[region] = s.nextLogin[region].Add(s.randomInterval()) {
+			login := s.nextLogin[region]
+			open = append(open, userSession{start: login, end: login.Add(s.randomDuration())})
+			sessionLogins.Add(ctx, 1, attrs)
+			if !prometheusBridge {
+				promSessionLogins.WithLabelValues(region).Inc()
+			}
+		}
+		s.sessions[region] = open
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// sloObjective is a service level objective over the requests to some
+// routes. Without a latency threshold it is an availability SLO, under which
+// a request is bad when it fails with a 5xx; with one it is a latency SLO,
+// under which a request is bad when it takes longer than the threshold.
+type sloObjective struct {
+	// Routes are the endpoints as reported in http_requests_total, e.g.
+	// /api/orders/{id}
+	Routes []string `yaml:"routes"`
+	// Objective is the share of good requests to meet, e.g. 0.999
+	Objective float64       `yaml:"objective"`
+	Latency   time.Duration `yaml:"latency"`
+}
+
+func (o sloObjective) validate() error {
+	if len(o.Routes) == 0 {
+		return errors.New("at least one route is required")
+	}
+	if o.Objective <= 0 || o.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1, exclusive, got %v", o.Objective)
+	}
+	if o.Latency < 0 {
+		return fmt.Errorf("latency must not be negative, got %v", o.Latency)
+	}
+	return nil
+}
+
+// sli is the kind of indicator, reported in the sli label
+func (o sloObjective) sli() string {
+	if o.Latency > 0 {
+		return "latency"
+	}
+	return "availability"
+}
+
+// bad reports whether a request counts against the objective
+func (o sloObjective) bad(status string, duration time.Duration) bool {
+	if o.Latency > 0 {
+		return duration > o.Latency
+	}
+	return len(status) == 3 && status[0] == '5'
+}
+
+// defaultSLOs cover the API: 99.5% of requests succeed, and 99% of /api
+// requests complete within 250ms. The slo section of the config file
+// replaces them.
+func defaultSLOs() map[string]sloObjective {
+	return map[string]sloObjective{
+		"api-availability": {
+			Routes:    []string{"/api", "/api/orders", "/api/orders/{id}"},
+			Objective: 0.995,
+		},
+		"api-latency": {
+			Routes:    []string{"/api"},
+			Objective: 0.99,
+			Latency:   250 * time.Millisecond,
+		},
+	}
+}
+
+// slo is one configured objective with the labels of its series
+type slo struct {
+	sloObjective
+	attrs  metric.MeasurementOption
+	labels []string
+}
+
+// slos are the objectives set by initSLOs
+var slos []slo
+
+var (
+	sloRequests metric.Int64Counter
+	sloErrors   metric.Int64Counter
+
+	promSLORequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_requests_total",
+			Help: "Requests covered by an SLO",
+		},
+		[]string{"slo", "sli", "objective"},
+	)
+	promSLOErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slo_errors_total",
+			Help: "Requests covered by an SLO that count against it",
+		},
+		[]string{"slo", "sli", "objective"},
+	)
+	promSLOObjective = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "slo_objective",
+			Help: "Target share of good requests of an SLO",
+		},
+		[]string{"slo", "sli"},
+	)
+)
+
+// initSLOs creates the SLI counters and a slo_objective gauge per objective.
+// Burn rates are the error ratio over a window divided by the error budget,
+// 1 - slo_objective; the counters carry the objective as a label as well so
+// a single series is self-describing.
+func initSLOs(objectives map[string]sloObjective) error {
+	var err error
+	sloRequests, err = meter.Int64Counter(
+		"slo_requests_total",
+		metric.WithDescription("Requests covered by an SLO"),
+	)
+	if err != nil {
+		return err
+	}
+	sloErrors, err = meter.Int64Counter(
+		"slo_errors_total",
+		metric.WithDescription("Requests covered by an SLO that count against it"),
+	)
+	if err != nil {
+		return err
+	}
+	objectiveGauge, err := meter.Float64ObservableGauge(
+		"slo_objective",
+		metric.WithDescription("Target share of good requests of an SLO"),
+	)
+	if err != nil {
+		return err
+	}
+
+	slos = nil
+	for name, o := range objectives {
+		objective := strconv.FormatFloat(o.Objective, 'f', -1, 64)
+		s := slo{
+			sloObjective: o,
+			attrs: metric.WithAttributes(
+				attribute.String("slo", name),
+				attribute.String("sli", o.sli()),
+				attribute.String("objective", objective),
+			),
+			labels: []string{name, o.sli(), objective},
+		}
+		// Start the counters at zero so rate() sees the first error
+		sloRequests.Add(context.Background(), 0, s.attrs)
+		sloErrors.Add(context.Background(), 0, s.attrs)
+		slos = append(slos, s)
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		for _, s := range slos {
+			obs.ObserveFloat64(objectiveGauge, s.Objective, metric.WithAttributes(
+				attribute.String("slo", s.labels[0]),
+				attribute.String("sli", s.labels[1]),
+			))
+		}
+		return nil
+	}, objectiveGauge)
+	if err != nil {
+		return err
+	}
+
+	if !prometheusBridge {
+		promRegistry.MustRegister(promSLORequests, promSLOErrors, promSLOObjective)
+		for _, s := range slos {
+			promSLOObjective.WithLabelValues(s.labels[0], s.labels[1]).Set(s.Objective)
+			promSLORequests.WithLabelValues(s.labels...)
+			promSLOErrors.WithLabelValues(s.labels...)
+		}
+	}
+	return nil
+}
+
+// recordSLO counts a request against every objective covering endpoint
+func recordSLO(ctx context.Context, endpoint, status string, duration time.Duration) {
+	for _, s := range slos {
+		if !slices.Contains(s.Routes, endpoint) {
+			continue
+		}
+		sloRequests.Add(ctx, 1, s.attrs)
+		bad := s.bad(status, duration)
+		if bad {
+			sloErrors.Add(ctx, 1, s.attrs)
+		}
+		if !prometheusBridge {
+			promSLORequests.WithLabelValues(s.labels...).Inc()
+			if bad {
+				promSLOErrors.WithLabelValues(s.labels...).Inc()
+			}
+		}
+	}
+}
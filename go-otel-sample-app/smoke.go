@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runSmoke checks a deployment once: it waits for /readyz to answer 200,
+// then sends each check and fails unless every one answers 2xx. It suits a
+// CI step after a deploy, a Helm test or a Kubernetes Job, and exits
+// non-zero on failure. Each flag defaults to a SMOKE_* variable.
+func runSmoke(args []string) error {
+	fs := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	target := fs.String("target", getEnv("SMOKE_TARGET", "http://localhost:8080"), "base URL of the app")
+	// Served by every role, and not subject to the injected faults
+	checks := fs.String("checks", getEnv("SMOKE_CHECKS", "GET /livez,GET /version,GET /docs/openapi.yaml"), "comma-separated \"[METHOD] path\" entries that must answer 2xx")
+	wait := fs.Duration("wait", getEnvDuration("SMOKE_WAIT", time.Minute), "how long to wait for /readyz to answer 200")
+	timeout := fs.Duration("timeout", getEnvDuration("SMOKE_TIMEOUT", 10*time.Second), "per-request timeout")
+	apiKey := fs.String("api-key", getEnv("SMOKE_API_KEY", ""), "key sent in X-API-Key when the app requires authentication")
+	token := fs.String("token", getEnv("SMOKE_TOKEN", ""), "JWT sent as a bearer token when the app requires authentication")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	endpoints, err := parseLoadgenEndpoints(*checks)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimRight(*target, "/")
+	client := &http.Client{Timeout: *timeout}
+	headers := make(http.Header)
+	if *apiKey != "" {
+		headers.Set(apiKeyHeader, *apiKey)
+	}
+	if *token != "" {
+		headers.Set("Authorization", "Bearer "+*token)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	if err := waitReady(ctx, client, base, *wait); err != nil {
+		return err
+	}
+	logger.Info("Smoke test target is ready", "target", base, "waited_ms", time.Since(start).Milliseconds())
+
+	failed := 0
+	for _, e := range endpoints {
+		if err := smokeCheck(ctx, client, base, e, headers); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(endpoints))
+	}
+	logger.Info("Smoke test passed", "target", base, "checks", len(endpoints))
+	return nil
+}
+
+// waitReady polls /readyz every second until it answers 200 or wait
+// elapses
+func waitReady(ctx context.Context, client *http.Client, base string, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	var last string
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/readyz", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		switch {
+		case err == nil:
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			last = resp.Status
+		case ctx.Err() == nil:
+			// The error of the attempt cut short by the deadline would
+			// only say that it was
+			last = err.Error()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not ready after %s: %s", base, wait, last)
+		case <-poll.C:
+		}
+	}
+}
+
+// smokeCheck sends one check and logs its outcome with the trace ID the
+// app returned, so a failed check can be looked up
+func smokeCheck(ctx context.Context, client *http.Client, base string, e loadgenEndpoint, headers http.Header) error {
+	req, err := e.newRequest(ctx, base)
+	if err != nil {
+		return err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Smoke check failed", "method", e.method, "path", e.path, "error", err)
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	attrs := []any{
+		"method", e.method,
+		"path", e.path,
+		"status_code", resp.StatusCode,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"response_trace_id", responseTraceID(resp.Header),
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Error("Smoke check failed", attrs...)
+		return fmt.Errorf("%s %s answered %s", e.method, e.path, resp.Status)
+	}
+	logger.Info("Smoke check passed", attrs...)
+	return nil
+}
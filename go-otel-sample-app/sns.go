@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	orderv1 "go-otel-sample-app/gen/order/v1"
+)
+
+// Order event types published to SNS
+const (
+	orderCreated = "order.created"
+	orderUpdated = "order.updated"
+	orderDeleted = "order.deleted"
+)
+
+// orderTopic receives an event for every order written through
+// /api/orders when SNS_TOPIC_ARN is set, and is nil otherwise
+var orderTopic *snsTopic
+
+// snsTopic publishes order events to a single SNS topic
+type snsTopic struct {
+	client *sns.Client
+	arn    string
+	name   string
+
+	published metric.Int64Counter
+}
+
+// orderEvent is the JSON body of the order events
+type orderEvent struct {
+	Type       string    `json:"type"`
+	OrderID    string    `json:"order_id"`
+	CustomerID string    `json:"customer_id,omitempty"`
+	TotalCents int64     `json:"total_cents,omitempty"`
+	RequestID  string    `json:"request_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+func newSNSTopic(cfg aws.Config, arn string) (*snsTopic, error) {
+	published, err := meter.Int64Counter(
+		"sns_events_published_total",
+		metric.WithDescription("Number of order events published to SNS by type and status"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The topic name is the last field of arn:aws:sns:<region>:<account>:<name>
+	return &snsTopic{
+		client:    sns.NewFromConfig(cfg),
+		arn:       arn,
+		name:      arn[strings.LastIndex(arn, ":")+1:],
+		published: published,
+	}, nil
+}
+
+// publishOrderEvent announces a write of order when a topic is configured.
+// A failure is logged and recorded on the span, but does not fail the
+// request: the order is already stored.
+func publishOrderEvent(ctx context.Context, eventType string, order *orderv1.Order) {
+	if orderTopic == nil {
+		return
+	}
+	event := orderEvent{
+		Type:       eventType,
+		OrderID:    order.GetId(),
+		CustomerID: order.GetCustomerId(),
+		TotalCents: order.GetTotalCents(),
+		RequestID:  requestIDFromContext(ctx),
+		OccurredAt: time.Now(),
+	}
+	if err := orderTopic.publish(ctx, event); err != nil {
+		logger.WarnContext(ctx, "Failed to publish order event",
+			"topic", orderTopic.name,
+			"event_type", eventType,
+			"order_id", event.OrderID,
+			"error", err,
+		)
+	}
+}
+
+// publish sends event under a producer span whose context travels in the
+// message attributes. SNS hands them on to SQS subscribers with raw message
+// delivery, and in the MessageAttributes of the JSON envelope otherwise, so
+// consumers can continue the trace either way.
+func (t *snsTopic) publish(ctx context.Context, event orderEvent) error {
+	ctx, span := tracer.Start(ctx, t.name+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("aws_sns"),
+			semconv.MessagingDestinationName(t.name),
+			semconv.MessagingOperationTypePublish,
+			attribute.String("order.event_type", event.Type),
+		),
+	)
+	defer span.End()
+
+	status := "success"
+	err := t.send(ctx, span, event)
+	if err != nil {
+		status = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+	}
+	t.published.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("topic", t.name),
+		attribute.String("type", event.Type),
+		attribute.String("status", status),
+	))
+	return err
+}
+
+func (t *snsTopic) send(ctx context.Context, span trace.Span, event orderEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// event_type lets subscriptions filter without parsing the body
+	attrs := snsAttributeCarrier{}
+	attrs.Set("event_type", event.Type)
+	otel.GetTextMapPropagator().Inject(ctx, attrs)
+
+	out, err := t.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(t.arn),
+		Message:           aws.String(string(body)),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(semconv.MessagingMessageID(aws.ToString(out.MessageId)))
+	return nil
+}
+
+// snsAttributeCarrier lets propagators write SNS string message attributes
+type snsAttributeCarrier map[string]types.MessageAttributeValue
+
+var _ propagation.TextMapCarrier = snsAttributeCarrier(nil)
+
+func (c snsAttributeCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return aws.ToString(v.StringValue)
+	}
+	return ""
+}
+
+func (c snsAttributeCarrier) Set(key, value string) {
+	c[key] = types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func (c snsAttributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// snsAttributes adds the topic ARN to SNS spans
+func snsAttributes(_ context.Context, in middleware.InitializeInput) []attribute.KeyValue {
+	if params, ok := in.Parameters.(*sns.PublishInput); ok && params.TopicArn != nil {
+		return []attribute.KeyValue{attribute.String("aws.sns.topic.arn", *params.TopicArn)}
+	}
+	return nil
+}
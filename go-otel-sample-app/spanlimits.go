@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Bounds of what /api/oversized builds, so the endpoint can show the span
+// limits at work without becoming a way to exhaust the app's memory
+const (
+	oversizedMaxCount   = 1000
+	oversizedMaxValueKB = 1024
+)
+
+// Span parts dropped by the span limits, by kind
+var (
+	spanAttributesDropped atomic.Int64
+	spanEventsDropped     atomic.Int64
+	spanLinksDropped      atomic.Int64
+)
+
+var promSpanLimitDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "span_limit_dropped_total",
+		Help: "Span attributes, events and links dropped by the span limits",
+	},
+	[]string{"kind"},
+)
+
+// spanLimitProcessor counts what the span limits dropped from each ended
+// span. The SDK drops silently; truncated values are not reported at all.
+type spanLimitProcessor struct{}
+
+func (spanLimitProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (spanLimitProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	record := func(kind string, n int, total *atomic.Int64) {
+		if n > 0 {
+			total.Add(int64(n))
+			promSpanLimitDropped.WithLabelValues(kind).Add(float64(n))
+		}
+	}
+	record("attributes", s.DroppedAttributes(), &spanAttributesDropped)
+	record("events", s.DroppedEvents(), &spanEventsDropped)
+	record("links", s.DroppedLinks(), &spanLinksDropped)
+}
+
+func (spanLimitProcessor) Shutdown(context.Context) error   { return nil }
+func (spanLimitProcessor) ForceFlush(context.Context) error { return nil }
+
+// registerSpanLimitMetrics reports the dropped span parts in
+// span_limit_dropped_total
+func registerSpanLimitMetrics() error {
+	if _, err := meter.Int64ObservableCounter("span_limit_dropped_total",
+		metric.WithDescription("Span attributes, events and links dropped by the span limits, by kind"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(spanAttributesDropped.Load(), metric.WithAttributes(attribute.String("kind", "attributes")))
+			o.Observe(spanEventsDropped.Load(), metric.WithAttributes(attribute.String("kind", "events")))
+			o.Observe(spanLinksDropped.Load(), metric.WithAttributes(attribute.String("kind", "links")))
+			return nil
+		}),
+	); err != nil {
+		return err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promSpanLimitDropped)
+	}
+	return nil
+}
+
+// oversizedAPI serves /api/oversized, which builds a span with more
+// attributes, events and links and longer values than asked for, so the
+// effect of the span limits can be seen in the trace and in the answer:
+//
+//	GET /api/oversized?attributes=500&events=200&links=10&value_kb=64
+type oversizedAPI struct {
+	limits spanLimits
+}
+
+func (a oversizedAPI) register(mux *http.ServeMux) {
+	mux.Handle("GET /api/oversized", a)
+}
+
+func (a oversizedAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := requestLogger(r, "/api/oversized")
+	query := r.URL.Query()
+	counts := map[string]int{"attributes": 200, "events": 0, "links": 0, "value_kb": 16}
+	for name := range counts {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		limit := oversizedMaxCount
+		if name == "value_kb" {
+			limit = oversizedMaxValueKB
+		}
+		if err != nil || n < 0 || n > limit {
+			writeError(r.Context(), w, http.StatusBadRequest, fmt.Sprintf("%s must be between 0 and %d", name, limit))
+			return
+		}
+		counts[name] = n
+	}
+
+	// The links point at made-up spans of the same trace; only their number
+	// matters here
+	parent := trace.SpanContextFromContext(r.Context())
+	links := make([]trace.Link, counts["links"])
+	for i := range links {
+		links[i] = trace.Link{
+			SpanContext: parent,
+			Attributes:  []attribute.KeyValue{attribute.Int("oversized.link", i)},
+		}
+	}
+	ctx, span := tracer.Start(r.Context(), "oversized_span", trace.WithLinks(links...))
+
+	value := strings.Repeat("x", counts["value_kb"]<<10)
+	span.SetAttributes(attribute.String("oversized.value", value))
+	for i := range counts["attributes"] {
+		span.SetAttributes(attribute.Int(fmt.Sprintf("oversized.attribute.%03d", i), i))
+	}
+	for i := range counts["events"] {
+		span.AddEvent("oversized.event", trace.WithAttributes(attribute.Int("oversized.event.index", i)))
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
+	span.End()
+
+	// The SDK's span reports what the limits left of it once ended; an
+	// unsampled span records nothing
+	result := map[string]any{
+		"requested": counts,
+		"limits": map[string]int{
+			"attribute_value_length": a.limits.AttributeValueLengthLimit,
+			"attribute_count":        a.limits.AttributeCountLimit,
+			"event_count":            a.limits.EventCountLimit,
+			"link_count":             a.limits.LinkCountLimit,
+		},
+		"trace_id":   span.SpanContext().TraceID().String(),
+		"request_id": requestIDFromContext(ctx),
+	}
+	if s, ok := span.(sdktrace.ReadOnlySpan); ok {
+		kept := map[string]int{
+			"attributes": len(s.Attributes()),
+			"events":     len(s.Events()),
+			"links":      len(s.Links()),
+		}
+		for _, kv := range s.Attributes() {
+			if kv.Key == "oversized.value" {
+				kept["value_bytes"] = len(kv.Value.AsString())
+			}
+		}
+		result["recorded"] = kept
+		result["dropped"] = map[string]int{
+			"attributes": s.DroppedAttributes(),
+			"events":     s.DroppedEvents(),
+			"links":      s.DroppedLinks(),
+		}
+	}
+
+	log.InfoContext(ctx, "Oversized span built",
+		"status_code", http.StatusOK,
+		"attributes", counts["attributes"],
+		"events", counts["events"],
+		"links", counts["links"],
+		"value_kb", counts["value_kb"],
+	)
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestQueue carries /api requests to the SQS workers when SQS_QUEUE_URL
+// is set, and is nil otherwise
+var requestQueue *sqsQueue
+
+// sqsQueue publishes to and consumes from a single SQS queue
+type sqsQueue struct {
+	client *sqs.Client
+	url    string
+	name   string
+
+	processed metric.Int64Counter
+	wg        sync.WaitGroup
+}
+
+// queueMessage is the JSON body of the messages sent by /api
+type queueMessage struct {
+	RequestID string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newSQSQueue(cfg aws.Config, url string) (*sqsQueue, error) {
+	processed, err := meter.Int64Counter(
+		"sqs_messages_processed_total",
+		metric.WithDescription("Number of SQS messages processed by the workers"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueue{
+		client:    sqs.NewFromConfig(cfg),
+		url:       url,
+		name:      path.Base(url),
+		processed: processed,
+	}, nil
+}
+
+// publishRequest enqueues the /api request for asynchronous processing when
+// a queue is configured
+func publishRequest(ctx context.Context, requestID string) error {
+	if requestQueue == nil {
+		return nil
+	}
+	return requestQueue.publish(ctx, queueMessage{RequestID: requestID, CreatedAt: time.Now()})
+}
+
+// publish sends msg under a producer span whose context travels in the
+// message attributes, the only per-message metadata SQS carries end to end
+func (q *sqsQueue) publish(ctx context.Context, msg queueMessage) error {
+	ctx, span := tracer.Start(ctx, q.name+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemAWSSqs,
+			semconv.MessagingDestinationName(q.name),
+			semconv.MessagingOperationTypePublish,
+		),
+	)
+	defer span.End()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	attrs := map[string]types.MessageAttributeValue{}
+	otel.GetTextMapPropagator().Inject(ctx, messageAttributeCarrier(attrs))
+
+	out, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(q.url),
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		return err
+	}
+	span.SetAttributes(semconv.MessagingMessageID(aws.ToString(out.MessageId)))
+	return nil
+}
+
+// startWorkers runs n consumers until ctx is cancelled; wait blocks until
+// they have finished the messages they were processing
+func (q *sqsQueue) startWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.consume(ctx)
+		}()
+	}
+}
+
+func (q *sqsQueue) wait() {
+	q.wg.Wait()
+}
+
+func (q *sqsQueue) consume(ctx context.Context) {
+	for ctx.Err() == nil {
+		// Empty long polls happen every 20s per worker and carry no
+		// information, so they are not traced
+		out, err := q.client.ReceiveMessage(withoutAWSTracing(ctx), &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(q.url),
+			MaxNumberOfMessages:   10,
+			WaitTimeSeconds:       20,
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				backgroundLogger.WarnContext(ctx, "Failed to receive SQS messages", "queue", q.name, "error", err)
+				time.Sleep(5 * time.Second)
+			}
+			continue
+		}
+
+		// Messages already received are finished even during shutdown, so
+		// they do not reappear after the visibility timeout
+		for _, msg := range out.Messages {
+			q.process(context.WithoutCancel(ctx), msg)
+		}
+	}
+}
+
+// process handles one message in a new trace linked to the producer's span.
+// A consumer span is not made a child of the producer: the message may wait
+// in the queue for a long time, be processed several times, or be handled
+// in a batch together with messages from other traces.
+func (q *sqsQueue) process(ctx context.Context, msg types.Message) {
+	producer := trace.SpanContextFromContext(
+		otel.GetTextMapPropagator().Extract(ctx, messageAttributeCarrier(msg.MessageAttributes)))
+
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemAWSSqs,
+			semconv.MessagingDestinationName(q.name),
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingMessageID(aws.ToString(msg.MessageId)),
+		),
+	}
+	if producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+	ctx, span := tracer.Start(ctx, q.name+" process", opts...)
+	defer span.End()
+
+	status := "success"
+	err := q.handle(ctx, msg)
+	if err == nil {
+		_, err = q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.url),
+			ReceiptHandle: msg.ReceiptHandle,
+		})
+	}
+	if err != nil {
+		status = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "processing failed")
+		backgroundLogger.ErrorContext(ctx, "Failed to process SQS message",
+			"queue", q.name,
+			"message_id", aws.ToString(msg.MessageId),
+			"error", err,
+		)
+	}
+
+	q.processed.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("queue", q.name),
+		attribute.String("status", status),
+	))
+}
+
+// handle simulates the asynchronous part of an /api request. Failed
+// messages are left on the queue and retried after the visibility timeout.
+func (q *sqsQueue) handle(ctx context.Context, msg types.Message) error {
+	var body queueMessage
+	if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &body); err != nil {
+		return err
+	}
+
+	time.Sleep(consumerLatency.sample())
+	if rand.Float32() < 0.05 {
+		return errors.New("simulated processing failure")
+	}
+
+	backgroundLogger.InfoContext(ctx, "SQS message processed",
+		"queue", q.name,
+		"request_id", body.RequestID,
+		"queue_time_ms", time.Since(body.CreatedAt).Milliseconds(),
+	)
+	return nil
+}
+
+// messageAttributeCarrier lets propagators read and write SQS string
+// message attributes
+type messageAttributeCarrier map[string]types.MessageAttributeValue
+
+var _ propagation.TextMapCarrier = messageAttributeCarrier(nil)
+
+func (c messageAttributeCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return aws.ToString(v.StringValue)
+	}
+	return ""
+}
+
+func (c messageAttributeCarrier) Set(key, value string) {
+	c[key] = types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func (c messageAttributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// sqsAttributes adds the queue URL to SQS spans
+func sqsAttributes(_ context.Context, in middleware.InitializeInput) []attribute.KeyValue {
+	var url *string
+	switch params := in.Parameters.(type) {
+	case *sqs.SendMessageInput:
+		url = params.QueueUrl
+	case *sqs.ReceiveMessageInput:
+		url = params.QueueUrl
+	case *sqs.DeleteMessageInput:
+		url = params.QueueUrl
+	}
+	if url == nil {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("aws.sqs.queue_url", *url)}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// liveRequests counts the requests recorded by recordRequest, for the
+	// stats pushed on /events
+	liveRequests struct {
+		total  atomic.Int64
+		errors atomic.Int64
+	}
+	// activeStreams backs sse_active_streams and the stats events
+	activeStreams atomic.Int64
+
+	sseStreamDuration metric.Float64Histogram
+
+	// promSSEStreamDuration is created by registerEventStream once the
+	// configured bucket boundaries are known
+	promSSEStreamDuration prometheus.Histogram
+)
+
+// eventStream serves /events, a Server-Sent Events stream with the
+// request rate and error rate of the app, for dashboards or a demo page
+// that subscribe with EventSource. Every interval each stream gets a stats
+// event computed over the requests since its previous event.
+type eventStream struct {
+	interval time.Duration
+	// done is closed on server shutdown, which otherwise waits for streams
+	// to end on their own
+	done chan struct{}
+}
+
+// statsEvent is the data of a stats event
+type statsEvent struct {
+	Timestamp         time.Time `json:"timestamp"`
+	RequestsTotal     int64     `json:"requests_total"`
+	ErrorsTotal       int64     `json:"errors_total"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+	ErrorRate         float64   `json:"error_rate"`
+	ActiveStreams     int64     `json:"active_streams"`
+}
+
+// registerEventStream serves /events on mux. The returned stream's shutdown
+// must be registered with the server.
+func registerEventStream(mux *http.ServeMux, c sseConfig) (*eventStream, error) {
+	_, err := meter.Int64ObservableUpDownCounter(
+		"sse_active_streams",
+		metric.WithDescription("Open Server-Sent Events streams on /events"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(activeStreams.Load())
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sseStreamDuration, err = meter.Float64Histogram(
+		"sse_stream_duration_seconds",
+		metric.WithDescription("Duration of Server-Sent Events streams in seconds"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promSSEStreamDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "sse_stream_duration_seconds",
+			Help:    "Duration of Server-Sent Events streams in seconds",
+			Buckets: histogramBuckets["sse_stream_duration_seconds"],
+		})
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sse_active_streams",
+			Help: "Open Server-Sent Events streams on /events",
+		}, func() float64 { return float64(activeStreams.Load()) }))
+		promRegistry.MustRegister(promSSEStreamDuration)
+	}
+
+	s := &eventStream{interval: c.Interval, done: make(chan struct{})}
+	mux.HandleFunc("GET /events", s.handler)
+	return s, nil
+}
+
+func (s *eventStream) handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+	// The server's read and write timeouts bound ordinary requests, not a
+	// stream that stays open for as long as the client listens
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(ctx, w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	rc.SetReadDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx-style proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Clients reconnect after the interval when the stream drops
+	fmt.Fprintf(w, "retry: %d\n\n", s.interval.Milliseconds())
+	rc.Flush()
+
+	start := time.Now()
+	open := activeStreams.Add(1)
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("sse.stream_opened", trace.WithAttributes(attribute.Int64("sse.active_streams", open)))
+
+	reason, sent := s.stream(ctx, w, rc)
+
+	activeStreams.Add(-1)
+	duration := time.Since(start)
+	sseStreamDuration.Record(ctx, duration.Seconds())
+	if !prometheusBridge {
+		promSSEStreamDuration.Observe(duration.Seconds())
+	}
+	span.SetAttributes(
+		attribute.Int64("sse.events_sent", sent),
+		attribute.String("sse.close_reason", reason),
+	)
+	requestLogger(r, "/events").InfoContext(ctx, "Event stream closed",
+		"close_reason", reason,
+		"events_sent", sent,
+		"duration_ms", duration.Milliseconds(),
+	)
+}
+
+// stream writes a stats event every interval until the client disconnects,
+// a write fails or the server shuts down, and reports which
+func (s *eventStream) stream(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController) (reason string, sent int64) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastTotal, lastErrors := liveRequests.total.Load(), liveRequests.errors.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return "client_closed", sent
+		case <-s.done:
+			return "shutdown", sent
+		case now := <-ticker.C:
+			total, errors := liveRequests.total.Load(), liveRequests.errors.Load()
+			event := statsEvent{
+				Timestamp:     now.UTC(),
+				RequestsTotal: total,
+				ErrorsTotal:   errors,
+				ActiveStreams: activeStreams.Load(),
+			}
+			if requests := total - lastTotal; requests > 0 {
+				event.RequestsPerSecond = float64(requests) / now.Sub(last).Seconds()
+				event.ErrorRate = float64(errors-lastErrors) / float64(requests)
+			}
+			last, lastTotal, lastErrors = now, total, errors
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return "error", sent
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: stats\ndata: %s\n\n", sent+1, data); err != nil {
+				return "write_failed", sent
+			}
+			if err := rc.Flush(); err != nil {
+				return "write_failed", sent
+			}
+			sent++
+		}
+	}
+}
+
+// shutdown ends every stream; it is registered with
+// http.Server.RegisterOnShutdown
+func (s *eventStream) shutdown() {
+	close(s.done)
+}
+
+// countLiveRequest feeds the stats events; errors are 5xx responses, as in
+// the availability SLOs
+func countLiveRequest(status string) {
+	liveRequests.total.Add(1)
+	if statusClass(status) == "5xx" {
+		liveRequests.errors.Add(1)
+	}
+}
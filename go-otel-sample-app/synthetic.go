@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// syntheticAPI serves endpoints whose latency and status are chosen by the
+// caller, so synthetic monitors and load tools get exactly the behavior
+// they test for instead of the random faults of /api:
+//
+//	GET /api/slow?ms=500   answers 200 after 500ms
+//	GET /api/fail?code=503 answers 503, by default 500 or the first
+//	                       allowed code
+//
+// Both are bounded by the synthetic configuration.
+type syntheticAPI struct {
+	cfg syntheticConfig
+}
+
+func (s syntheticAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/slow", s.slow)
+	mux.HandleFunc("GET /api/fail", s.fail)
+}
+
+func (s syntheticAPI) slow(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "synthetic_slow")
+	defer span.End()
+
+	maxMs := s.cfg.MaxDelay.Milliseconds()
+	ms, err := strconv.ParseInt(r.URL.Query().Get("ms"), 10, 64)
+	if err != nil || ms < 0 || ms > maxMs {
+		code := http.StatusBadRequest
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		writeError(ctx, w, code, fmt.Sprintf("ms must be between 0 and %d", maxMs))
+		return
+	}
+	span.SetAttributes(attribute.Int64("synthetic.delay_ms", ms))
+
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// The client gave up; there is no one left to answer
+		span.AddEvent("synthetic.canceled")
+		return
+	case <-timer.C:
+	}
+
+	requestLogger(r, "/api/slow").InfoContext(ctx, "Synthetic delay served", "delay_ms", ms)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusOK))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"delay_ms":   ms,
+		"request_id": requestIDFromContext(ctx),
+	})
+}
+
+func (s syntheticAPI) fail(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "synthetic_fail")
+	defer span.End()
+
+	code := http.StatusInternalServerError
+	if len(s.cfg.FailCodes) > 0 {
+		code = s.cfg.FailCodes[0]
+	}
+	if value := r.URL.Query().Get("code"); value != "" {
+		var err error
+		if code, err = strconv.Atoi(value); err != nil || !s.allowed(code) {
+			code = http.StatusBadRequest
+			span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+			writeError(ctx, w, code, s.allowedMessage())
+			return
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("synthetic.status_code", code),
+		semconv.HTTPResponseStatusCode(code),
+	)
+
+	log := requestLogger(r, "/api/fail")
+	err := fmt.Errorf("synthetic failure: %d %s", code, http.StatusText(code))
+	if code >= http.StatusInternalServerError {
+		failSpan(span, err, "synthetic failure")
+		log.ErrorContext(ctx, "Synthetic failure served", "status_code", code)
+	} else {
+		// A 4xx leaves the span status unset, as for any client error
+		log.WarnContext(ctx, "Synthetic failure served", "status_code", code)
+	}
+	writeError(ctx, w, code, err.Error())
+}
+
+// allowed reports whether /api/fail may answer with code
+func (s syntheticAPI) allowed(code int) bool {
+	if len(s.cfg.FailCodes) > 0 {
+		return slices.Contains(s.cfg.FailCodes, code)
+	}
+	return code >= 400 && code <= 599
+}
+
+func (s syntheticAPI) allowedMessage() string {
+	if len(s.cfg.FailCodes) > 0 {
+		return fmt.Sprintf("code must be one of %v", s.cfg.FailCodes)
+	}
+	return "code must be a 4xx or 5xx status"
+}
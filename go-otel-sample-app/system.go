@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// systemSampleInterval is how long a node CPU and memory reading is
+// reused. cpu.Percent measures since its previous call, so a reading per
+// collector would split the interval between the periodic reader and the
+// scrapes, and each would see a different CPU usage.
+const systemSampleInterval = 5 * time.Second
+
+// systemUsage caches the latest node CPU and memory reading
+type systemUsage struct {
+	mu         sync.Mutex
+	sampledAt  time.Time
+	cpuPercent float64
+	memPercent float64
+}
+
+// sample returns the CPU and memory usage of the node in percent, reading
+// them again once the cached reading is older than systemSampleInterval
+func (s *systemUsage) sample() (cpuPercent, memPercent float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.sampledAt) >= systemSampleInterval {
+		s.sampledAt = time.Now()
+		s.cpuPercent, s.memPercent = 0, 0
+		if percent, err := cpu.Percent(0, false); err == nil && len(percent) > 0 {
+			s.cpuPercent = percent[0]
+		}
+		if vmem, err := mem.VirtualMemory(); err == nil {
+			s.memPercent = vmem.UsedPercent
+		}
+	}
+	return s.cpuPercent, s.memPercent
+}
+
+// registerSystemMetrics exports the node's CPU and memory usage as
+// go_cpu_usage_percent and go_memory_usage_percent, observed from the same
+// reading over OTLP and on /metrics
+func registerSystemMetrics() error {
+	usage := &systemUsage{}
+	cpuUsage, err := meter.Float64ObservableGauge("go_cpu_usage_percent",
+		metric.WithUnit("%"),
+		metric.WithDescription("CPU usage percentage of the node"))
+	if err != nil {
+		return err
+	}
+	memUsage, err := meter.Float64ObservableGauge("go_memory_usage_percent",
+		metric.WithUnit("%"),
+		metric.WithDescription("Memory usage percentage of the node"))
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		cpuPercent, memPercent := usage.sample()
+		o.ObserveFloat64(cpuUsage, cpuPercent)
+		o.ObserveFloat64(memUsage, memPercent)
+		return nil
+	}, cpuUsage, memUsage)
+	if err != nil {
+		return err
+	}
+
+	if !prometheusBridge {
+		appLabels := prometheus.Labels{"app": "go-otel-sample-app"}
+		promRegistry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "go_cpu_usage_percent",
+				Help:        "CPU usage percentage",
+				ConstLabels: appLabels,
+			}, func() float64 {
+				cpuPercent, _ := usage.sample()
+				return cpuPercent
+			}),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "go_memory_usage_percent",
+				Help:        "Memory usage percentage",
+				ConstLabels: appLabels,
+			}, func() float64 {
+				_, memPercent := usage.sample()
+				return memPercent
+			}),
+		)
+	}
+	return nil
+}
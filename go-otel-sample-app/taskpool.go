@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reasons reported in task_rejected_total
+const (
+	taskQueueFull    = "queue_full"
+	taskShuttingDown = "shutting_down"
+)
+
+// maxTaskBurst bounds the tasks a single POST /admin/tasks can submit
+const maxTaskBurst = 10000
+
+// errTaskRejected is returned by submit when the task is not queued
+var errTaskRejected = errors.New("task rejected")
+
+// tasks is the worker pool fed by /api and /admin/tasks
+var tasks *taskPool
+
+var (
+	taskQueueWait  metric.Float64Histogram
+	taskRejected   metric.Int64Counter
+	taskProcessed  metric.Int64Counter
+	taskWorkerBusy metric.Float64Counter
+
+	// promTaskQueueWait is created by newTaskPool once the configured bucket
+	// boundaries are known
+	promTaskQueueWait prometheus.Histogram
+	promTaskRejected  = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "task_rejected_total",
+			Help: "Tasks rejected by the worker pool by reason",
+		},
+		[]string{"reason"},
+	)
+	promTaskProcessed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "task_processed_total",
+			Help: "Tasks processed by the worker pool by result",
+		},
+		[]string{"result"},
+	)
+	promTaskWorkerBusy = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "task_worker_busy_seconds_total",
+		Help: "Time the workers of the pool spent processing tasks",
+	})
+)
+
+// taskPool is an in-memory queue drained by a fixed number of workers. It
+// exists to show saturation, the S of the USE method: when tasks arrive
+// faster than the workers finish them, utilization reaches 100%, the queue
+// fills, queue wait grows, and finally tasks are rejected. Each of those
+// steps has its own metric, so the pool can be alerted on before it rejects
+// anything.
+type taskPool struct {
+	queue   chan task
+	workers int
+	busy    atomic.Int64
+	wg      sync.WaitGroup
+
+	// mu guards closed, so no task is sent on the queue once it is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// task is a unit of simulated work
+type task struct {
+	// submitter is the span that submitted the task
+	submitter trace.SpanContext
+	queuedAt  time.Time
+	duration  time.Duration
+}
+
+func newTaskPool(c taskPoolConfig) (*taskPool, error) {
+	p := &taskPool{
+		queue:   make(chan task, c.QueueSize),
+		workers: c.Workers,
+	}
+
+	var err error
+	taskQueueWait, err = meter.Float64Histogram(
+		"task_queue_wait_seconds",
+		metric.WithDescription("Time tasks spent in the queue before a worker picked them up"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	taskRejected, err = meter.Int64Counter(
+		"task_rejected_total",
+		metric.WithDescription("Tasks rejected by the worker pool by reason"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	taskProcessed, err = meter.Int64Counter(
+		"task_processed_total",
+		metric.WithDescription("Tasks processed by the worker pool by result"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	taskWorkerBusy, err = meter.Float64Counter(
+		"task_worker_busy_seconds_total",
+		metric.WithDescription("Time the workers of the pool spent processing tasks"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promTaskQueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "task_queue_wait_seconds",
+			Help:    "Time tasks spent in the queue before a worker picked them up",
+			Buckets: histogramBuckets["task_queue_wait_seconds"],
+		})
+		promRegistry.MustRegister(promTaskQueueWait, promTaskRejected, promTaskProcessed, promTaskWorkerBusy)
+	}
+
+	// Utilization is busy over workers, saturation depth over capacity
+	gauges := []struct {
+		name, help string
+		value      func() int64
+	}{
+		{"task_queue_depth", "Tasks waiting in the queue", func() int64 { return int64(len(p.queue)) }},
+		{"task_queue_capacity", "Tasks the queue can hold", func() int64 { return int64(cap(p.queue)) }},
+		{"task_workers", "Workers of the pool", func() int64 { return int64(p.workers) }},
+		{"task_workers_busy", "Workers processing a task", p.busy.Load},
+	}
+	for _, g := range gauges {
+		if _, err := meter.Int64ObservableGauge(g.name,
+			metric.WithDescription(g.help),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(g.value())
+				return nil
+			}),
+		); err != nil {
+			return nil, err
+		}
+		if !prometheusBridge {
+			promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: g.name,
+				Help: g.help,
+			}, func() float64 { return float64(g.value()) }))
+		}
+	}
+	return p, nil
+}
+
+// start runs the workers; they stop once stop has closed the queue and
+// they have drained it
+func (p *taskPool) start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for t := range p.queue {
+				p.process(t)
+			}
+		}()
+	}
+}
+
+// submit queues a task taking d without blocking, or rejects it when the
+// queue is full or the pool is stopping
+func (p *taskPool) submit(ctx context.Context, d time.Duration) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	reason := taskShuttingDown
+	if !p.closed {
+		select {
+		case p.queue <- task{submitter: trace.SpanContextFromContext(ctx), queuedAt: time.Now(), duration: d}:
+			return nil
+		default:
+			reason = taskQueueFull
+		}
+	}
+	taskRejected.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+	if !prometheusBridge {
+		promTaskRejected.WithLabelValues(reason).Inc()
+	}
+	return errTaskRejected
+}
+
+// process runs t in a new trace linked to the span that submitted it, as
+// the SQS consumers do: under saturation a task may wait long after the
+// request that submitted it has ended
+func (p *taskPool) process(t task) {
+	wait := time.Since(t.queuedAt)
+	p.busy.Add(1)
+	defer p.busy.Add(-1)
+
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.Float64("task.queue_wait_s", wait.Seconds())),
+	}
+	if t.submitter.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: t.submitter}))
+	}
+	ctx, span := tracer.Start(context.Background(), "task.process", opts...)
+	defer span.End()
+
+	taskQueueWait.Record(ctx, wait.Seconds())
+	start := time.Now()
+	time.Sleep(t.duration)
+	result := "success"
+	if rand.Float64() < 0.02 {
+		result = "failure"
+		failSpan(span, errors.New("simulated task failure"), "task failed")
+		backgroundLogger.WarnContext(ctx, "Task failed", "queue_wait_ms", wait.Milliseconds())
+	}
+	busy := time.Since(start).Seconds()
+
+	taskProcessed.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	taskWorkerBusy.Add(ctx, busy)
+	if !prometheusBridge {
+		promTaskQueueWait.Observe(wait.Seconds())
+		promTaskProcessed.WithLabelValues(result).Inc()
+		promTaskWorkerBusy.Add(busy)
+	}
+}
+
+// stop rejects new tasks and waits for the workers to drain the queue, or
+// until ctx is done
+func (p *taskPool) stop(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// randomTaskDuration is the time a task submitted by /api takes
+func randomTaskDuration() time.Duration {
+	return taskLatency.sample()
+}
+
+// submitTask hands the asynchronous part of an /api request to the pool.
+// A rejected task doesn't fail the request; it is counted and logged.
+func submitTask(ctx context.Context) {
+	if err := tasks.submit(ctx, randomTaskDuration()); err != nil {
+		trace.SpanFromContext(ctx).AddEvent("task.rejected")
+		logger.WarnContext(ctx, "Task rejected by the worker pool", "error", err)
+	}
+}
+
+// registerTaskAdmin serves POST /admin/tasks?count=N&duration=D, which
+// submits a burst of N tasks taking D each to saturate the pool
+func registerTaskAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/tasks", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		count, err := strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count < 1 || count > maxTaskBurst {
+			writeError(ctx, w, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxTaskBurst))
+			return
+		}
+		duration := randomTaskDuration()
+		if d := r.URL.Query().Get("duration"); d != "" {
+			if duration, err = time.ParseDuration(d); err != nil || duration < 0 || duration > time.Minute {
+				writeError(ctx, w, http.StatusBadRequest, "duration must be a Go duration of at most 1m")
+				return
+			}
+		}
+
+		accepted := 0
+		for i := 0; i < count; i++ {
+			if tasks.submit(ctx, duration) == nil {
+				accepted++
+			}
+		}
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.Int("task.submitted", count),
+			attribute.Int("task.accepted", accepted),
+		)
+		logger.InfoContext(ctx, "Task burst submitted",
+			"count", count,
+			"accepted", accepted,
+			"duration_ms", duration.Milliseconds(),
+		)
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"submitted": count,
+			"accepted":  accepted,
+			"rejected":  count - accepted,
+		})
+	})
+}
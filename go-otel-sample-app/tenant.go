@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tenantKey is the baggage member, span attribute and metric attribute
+	// naming the tenant of a request
+	tenantKey = "tenant.id"
+	// unknownTenant replaces tenant IDs outside the configured tenants
+	unknownTenant = "unknown"
+)
+
+// tenantResolver sets tenant.id in the request baggage from the tenant
+// header, unless the caller already sent it, and folds tenant IDs outside
+// the configured tenants into "unknown". The baggage then carries the
+// tenant onto spans, metrics and downstream calls like any other member,
+// with a bounded set of values: anyone can send any header, and each
+// made-up tenant would otherwise be a new series.
+type tenantResolver struct {
+	header        string
+	tenants       map[string]bool
+	rejectUnknown bool
+}
+
+// newTenantResolver returns nil when tenancy is disabled
+func newTenantResolver(c tenancyConfig) *tenantResolver {
+	if !c.Enabled {
+		return nil
+	}
+	t := &tenantResolver{header: c.Header, rejectUnknown: c.RejectUnknown}
+	if len(c.Tenants) > 0 {
+		t.tenants = make(map[string]bool, len(c.Tenants))
+		for _, tenant := range c.Tenants {
+			t.tenants[tenant] = true
+		}
+	}
+	return t
+}
+
+// resolve returns bag with its tenant.id member set and checked against
+// the tenants
+func (t *tenantResolver) resolve(r *http.Request, bag baggage.Baggage) baggage.Baggage {
+	if t == nil {
+		return bag
+	}
+	tenant := bag.Member(tenantKey).Value()
+	if tenant == "" {
+		tenant = r.Header.Get(t.header)
+	}
+	if tenant == "" {
+		return bag
+	}
+	if t.tenants != nil && !t.tenants[tenant] {
+		tenant = unknownTenant
+	}
+	member, err := baggage.NewMemberRaw(tenantKey, tenant)
+	if err != nil {
+		return bag
+	}
+	if updated, err := bag.SetMember(member); err == nil {
+		bag = updated
+	}
+	return bag
+}
+
+// middleware answers 403 to requests of unknown tenants when they are
+// rejected; requests without a tenant are let through
+func (t *tenantResolver) middleware(next http.Handler) http.Handler {
+	if t == nil || !t.rejectUnknown {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if tenantFromContext(ctx) != unknownTenant {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := routeOf(r.Pattern)
+		trace.SpanFromContext(ctx).AddEvent("tenant.rejected",
+			trace.WithAttributes(attribute.String("tenant.header", r.Header.Get(t.header))))
+		requestLogger(r, route).WarnContext(ctx, "Request from an unknown tenant rejected")
+		writeError(ctx, w, http.StatusForbidden, "unknown tenant")
+	})
+}
+
+// tenantFromContext returns the tenant in the context's baggage, or ""
+func tenantFromContext(ctx context.Context) string {
+	return baggage.FromContext(ctx).Member(tenantKey).Value()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	requestTimeouts metric.Int64Counter
+
+	promRequestTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_timeouts_total",
+			Help: "Requests answered with 504 because they ran out of time",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// timeoutLimiter gives the requests of each route a deadline. The handler
+// runs with it in its context, so the downstream calls, queries and cache
+// lookups it makes are canceled once it passes; if the handler has not
+// answered by then, the client gets a 504 Gateway Timeout at once and
+// whatever the handler writes later is discarded. Unlike
+// http.TimeoutHandler, which answers 503 like an overloaded server, the 504
+// says the request itself ran out of time.
+type timeoutLimiter struct {
+	fallback time.Duration
+	routes   map[string]time.Duration
+}
+
+// newTimeoutLimiter returns nil when no request has a timeout
+func newTimeoutLimiter(c requestTimeoutConfig) (*timeoutLimiter, error) {
+	if c.Default <= 0 && len(c.Routes) == 0 {
+		return nil, nil
+	}
+	var err error
+	if requestTimeouts, err = meter.Int64Counter("http_request_timeouts_total",
+		metric.WithDescription("Requests answered with 504 because they ran out of time, by endpoint")); err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(promRequestTimeouts)
+	}
+	return &timeoutLimiter{fallback: c.Default, routes: c.Routes}, nil
+}
+
+// timeout returns the timeout of route; 0 means none
+func (t *timeoutLimiter) timeout(route string) time.Duration {
+	if d, ok := t.routes[route]; ok {
+		return d
+	}
+	return t.fallback
+}
+
+// middleware runs the handler of a route with a timeout in a goroutine of
+// its own, holding back its response until it returns. WebSocket upgrades
+// and event streams, which are meant to stay open, get no timeout. A nil
+// limiter sets none.
+func (t *timeoutLimiter) middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r.Pattern)
+		timeout := t.timeout(route)
+		if timeout <= 0 || r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.Int64("http.request.timeout_ms", timeout.Milliseconds()))
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			// Raised again here, for recoverMiddleware to turn into a 500
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			if tw.code != 0 {
+				w.WriteHeader(tw.code)
+			}
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client went away; there is no one to answer
+				return
+			}
+			err := fmt.Errorf("request timed out after %s", timeout)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "request timed out")
+			span.SetAttributes(semconv.ErrorTypeKey.String("timeout"))
+			requestTimeouts.Add(ctx, 1, metric.WithAttributes(attribute.String("endpoint", route)))
+			if !prometheusBridge {
+				promRequestTimeouts.WithLabelValues(route).Inc()
+			}
+			requestLogger(r, route).ErrorContext(ctx, "Request timed out",
+				"status_code", http.StatusGatewayTimeout,
+				"timeout_ms", timeout.Milliseconds(),
+			)
+			writeError(r.Context(), w, http.StatusGatewayTimeout, "request timed out")
+		}
+	})
+}
+
+// timeoutWriter holds back a response until the handler returns, and
+// turns away the writes of a handler that ran out of time
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// parseRouteTimeouts adds the timeouts in a REQUEST_TIMEOUTS value to
+// timeouts. The format is "<route>=<duration>", with entries separated by
+// commas, e.g.
+//
+//	/api=2s,/api/slow=10s
+func parseRouteTimeouts(value string, timeouts map[string]time.Duration) error {
+	for _, entry := range splitList(value) {
+		route, duration, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(route) == "" {
+			return fmt.Errorf("invalid REQUEST_TIMEOUTS entry %q: expected <route>=<duration>", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return fmt.Errorf("invalid request timeout %q for %s: %w", duration, route, err)
+		}
+		timeouts[strings.TrimSpace(route)] = d
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	tlsHandshakes metric.Float64Histogram
+
+	// promTLSHandshakes is created by newServerTLSConfig once the configured
+	// bucket boundaries are known
+	promTLSHandshakes *prometheus.HistogramVec
+)
+
+// newServerTLSConfig returns the TLS configuration of the HTTP server, or
+// nil when it serves plaintext. HTTP/2 is offered through ALPN, so clients
+// and an NLB in TLS passthrough mode get h2 end to end. Every completed
+// handshake is recorded in tls_handshake_duration_seconds.
+func newServerTLSConfig(c serverTLSConfig) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case c.CertFile != "":
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	case c.SelfSigned:
+		cert, err = selfSignedCertificate()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tlsHandshakes, err = meter.Float64Histogram(
+		"tls_handshake_duration_seconds",
+		metric.WithDescription("Duration of TLS handshakes of the HTTP server in seconds"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promTLSHandshakes = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tls_handshake_duration_seconds",
+				Help:    "Duration of TLS handshakes of the HTTP server in seconds",
+				Buckets: histogramBuckets["tls_handshake_duration_seconds"],
+			},
+			[]string{"tls_version", "alpn"},
+		)
+		promRegistry.MustRegister(promTLSHandshakes)
+	}
+
+	base := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	// The handshake is timed from the ClientHello to the point where the
+	// connection is verified, through a per-connection copy of the config
+	// whose VerifyConnection callback knows when that handshake started
+	config := base.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		start := time.Now()
+		conn := base.Clone()
+		conn.VerifyConnection = func(state tls.ConnectionState) error {
+			recordTLSHandshake(hello.Context(), state, time.Since(start))
+			return nil
+		}
+		return conn, nil
+	}
+	return config, nil
+}
+
+func recordTLSHandshake(ctx context.Context, state tls.ConnectionState, duration time.Duration) {
+	// "TLS 1.3" becomes "1.3", as in the tls.protocol.version attribute
+	version := strings.TrimPrefix(tls.VersionName(state.Version), "TLS ")
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "none"
+	}
+	tlsHandshakes.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("tls.protocol.version", version),
+		attribute.String("tls.next_protocol", alpn),
+	))
+	if !prometheusBridge {
+		promTLSHandshakes.WithLabelValues(version, alpn).Observe(duration.Seconds())
+	}
+}
+
+// selfSignedCertificate creates a certificate for the pod's hostname and
+// localhost, valid for a year. It is enough for an NLB in TLS passthrough
+// mode, which does not verify targets, and for local testing with curl -k.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname, Organization: []string{serviceName}},
+		DNSNames:     []string{hostname, "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
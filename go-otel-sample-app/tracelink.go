@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Consoles that trace links of LOG_TRACE_LINKS open
+const (
+	traceLinkNone       = "none"
+	traceLinkXRay       = "xray"
+	traceLinkCloudWatch = "cloudwatch"
+	traceLinkTemplate   = "template"
+)
+
+var traceLinkBackends = []string{traceLinkNone, traceLinkXRay, traceLinkCloudWatch, traceLinkTemplate}
+
+// traceLinker builds the trace_link attribute of error records, set from
+// the logging configuration at startup; nil adds none
+var traceLinker *traceLinks
+
+// traceLinks builds links to the trace of a record in a console, so the
+// trace behind an error in CloudWatch Logs or Loki is one click away
+type traceLinks struct {
+	backend  string
+	region   string
+	template string
+}
+
+// newTraceLinks returns nil when links are disabled
+func newTraceLinks(c traceLinkConfig) *traceLinks {
+	if c.Backend == traceLinkNone || c.Backend == "" {
+		return nil
+	}
+	return &traceLinks{backend: c.Backend, region: c.region(), template: c.Template}
+}
+
+// region is the console region, by default the pod's
+func (c traceLinkConfig) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return getEnv("AWS_REGION", "")
+}
+
+// link returns the console URL of the trace
+func (l *traceLinks) link(id trace.TraceID) string {
+	hex := id.String()
+	// X-Ray shows every trace ID as 1-<8 hex digits>-<24 hex digits>
+	xrayID := "1-" + hex[:8] + "-" + hex[8:]
+	switch l.backend {
+	case traceLinkXRay:
+		return fmt.Sprintf("https://%[1]s.console.aws.amazon.com/xray/home?region=%[1]s#/traces/%[2]s", l.region, xrayID)
+	case traceLinkCloudWatch:
+		return fmt.Sprintf("https://%[1]s.console.aws.amazon.com/cloudwatch/home?region=%[1]s#xray:traces/%[2]s", l.region, xrayID)
+	}
+	return strings.NewReplacer("{trace_id}", hex, "{xray_trace_id}", xrayID).Replace(l.template)
+}
+
+// traceLinkHandler adds trace_link to the error records of sampled traces;
+// the traces of the others were not recorded, so a link would lead
+// nowhere. It sits behind the redaction, whose patterns are meant for what
+// the code logs, not for the links the app builds itself.
+type traceLinkHandler struct {
+	next slog.Handler
+}
+
+func (h traceLinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h traceLinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	if traceLinker != nil && r.Level >= slog.LevelError {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+			r = r.Clone()
+			r.AddAttrs(slog.String("trace_link", traceLinker.link(sc.TraceID())))
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h traceLinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceLinkHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h traceLinkHandler) WithGroup(name string) slog.Handler {
+	return traceLinkHandler{next: h.next.WithGroup(name)}
+}
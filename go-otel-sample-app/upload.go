@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// uploadAPI serves /api/upload, which reads a payload of any content type
+// and answers with its size and SHA-256, so bandwidth dashboards have
+// request sizes to show beyond small JSON bodies:
+//
+//	curl --data-binary @payload.bin http://localhost:8080/api/upload
+//
+// Payloads above the configured size are answered with 413.
+type uploadAPI struct {
+	maxBytes int64
+}
+
+func (a uploadAPI) register(mux *http.ServeMux) {
+	mux.Handle("POST /api/upload", a)
+}
+
+func (a uploadAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "upload")
+	defer span.End()
+
+	start := time.Now()
+	hash := sha256.New()
+	n, err := io.Copy(hash, http.MaxBytesReader(w, r.Body, a.maxBytes))
+	elapsed := time.Since(start)
+	span.SetAttributes(attribute.Int64("upload.bytes", n))
+	if err != nil {
+		code, msg := http.StatusBadRequest, "failed to read the payload"
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code, msg = http.StatusRequestEntityTooLarge, fmt.Sprintf("payload larger than %d bytes", a.maxBytes)
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		requestLogger(r, "/api/upload").WarnContext(ctx, "Upload rejected", "status_code", code, "bytes", n, "error", err)
+		writeError(ctx, w, code, msg)
+		return
+	}
+
+	// The read time is the client's upload time, which is what bounds the
+	// throughput of a slow client
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(n) / elapsed.Seconds()
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	span.SetAttributes(
+		attribute.String("upload.sha256", sum),
+		attribute.Float64("upload.throughput_bytes_per_second", throughput),
+		semconv.HTTPResponseStatusCode(http.StatusOK),
+	)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bytes":        n,
+		"sha256":       sum,
+		"content_type": r.Header.Get("Content-Type"),
+		"read_ms":      elapsed.Milliseconds(),
+		"request_id":   requestIDFromContext(ctx),
+	})
+}
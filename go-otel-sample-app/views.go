@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// defaultLatencyBuckets suits endpoints that answer in a few to a few hundred
+// milliseconds. The SDK default (0, 5, 10, 25, ... 10000) assumes
+// milliseconds and puts every request of a seconds-based histogram into its
+// first buckets.
+var defaultLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5,
+}
+
+// cacheLatencyBuckets suits in-VPC cache round trips, which mostly take
+// well under a millisecond
+var cacheLatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+// tlsHandshakeBuckets suit handshakes, which take a few milliseconds of
+// CPU for the key exchange and signature
+var tlsHandshakeBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25,
+}
+
+// sseStreamBuckets suit event streams, which stay open from seconds to
+// hours
+var sseStreamBuckets = []float64{
+	1, 5, 15, 30, 60, 300, 900, 1800, 3600, 14400,
+}
+
+// sessionDurationBuckets suit user sessions, which last from a quick
+// look to a working day
+var sessionDurationBuckets = []float64{
+	30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400, 28800,
+}
+
+// jobDurationBuckets suit scheduled jobs, which run from milliseconds up
+// to their timeout
+var jobDurationBuckets = []float64{
+	0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60,
+}
+
+// asyncJobDurationBuckets suit the jobs of /api/jobs, which run from
+// moments to minutes
+var asyncJobDurationBuckets = []float64{
+	0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300,
+}
+
+// taskQueueWaitBuckets range from an idle pool, where tasks are picked up
+// at once, to a saturated one, where they wait behind a full queue
+var taskQueueWaitBuckets = []float64{
+	0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30,
+}
+
+// clientPhaseBuckets range from a connect within the VPC, well under a
+// millisecond, to a DNS lookup that waited out the resolver's 5s timeout
+// after a lost UDP packet, a classic with ndots:5 search lists on EKS
+var clientPhaseBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// payloadSizeBuckets range from small JSON bodies to multi-megabyte
+// uploads, in bytes
+var payloadSizeBuckets = []float64{
+	64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216,
+}
+
+// histogramBuckets maps instrument names to explicit bucket boundaries. It
+// drives both the OTel Views and the Prometheus histograms on /metrics, and
+// is set from the metrics configuration at startup.
+var histogramBuckets = defaultHistogramBuckets()
+
+func defaultHistogramBuckets() map[string][]float64 {
+	return map[string][]float64{
+		"http_request_duration_seconds":             defaultLatencyBuckets,
+		"http_request_size_bytes":                   payloadSizeBuckets,
+		"http_response_size_bytes":                  payloadSizeBuckets,
+		"cache_operation_duration_seconds":          cacheLatencyBuckets,
+		"kafka_message_processing_duration_seconds": defaultLatencyBuckets,
+		"tls_handshake_duration_seconds":            tlsHandshakeBuckets,
+		"http_client_phase_duration_seconds":        clientPhaseBuckets,
+		"http_client_connection_wait_seconds":       taskQueueWaitBuckets,
+		"sse_stream_duration_seconds":               sseStreamBuckets,
+		"job_duration_seconds":                      jobDurationBuckets,
+		"task_queue_wait_seconds":                   taskQueueWaitBuckets,
+		"user_session_duration_seconds":             sessionDurationBuckets,
+		"fanout_branch_duration_seconds":            defaultLatencyBuckets,
+		"async_job_duration_seconds":                asyncJobDurationBuckets,
+	}
+}
+
+// parseHistogramBuckets adds the boundaries in a METRICS_HISTOGRAM_BUCKETS
+// value to buckets. The format is "<instrument>=<b1>,<b2>,...", with
+// entries separated by semicolons, e.g.
+//
+//	http_request_duration_seconds=0.001,0.005,0.01,0.05,0.1,0.5
+func parseHistogramBuckets(value string, buckets map[string][]float64) error {
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid METRICS_HISTOGRAM_BUCKETS entry %q: expected <instrument>=<boundaries>", entry)
+		}
+
+		var boundaries []float64
+		for _, b := range strings.Split(list, ",") {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+			if err != nil {
+				return fmt.Errorf("invalid bucket boundary %q for %s: %w", b, name, err)
+			}
+			boundaries = append(boundaries, parsed)
+		}
+		if err := checkBucketBoundaries(name, boundaries); err != nil {
+			return err
+		}
+		buckets[strings.TrimSpace(name)] = boundaries
+	}
+	return nil
+}
+
+// checkBucketBoundaries requires strictly increasing boundaries; the
+// Prometheus client panics on a repeated one
+func checkBucketBoundaries(name string, boundaries []float64) error {
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i] <= boundaries[i-1] {
+			return fmt.Errorf("bucket boundaries for %s must be strictly increasing, got %v after %v", strings.TrimSpace(name), boundaries[i], boundaries[i-1])
+		}
+	}
+	return nil
+}
+
+// metricViews turns histogramBuckets and attributeLimiters into metric
+// Views that override the aggregation and the attributes of the matching
+// instruments. An instrument named in both gets a single View, as the SDK
+// exports a separate stream for each View that matches.
+func metricViews() []sdkmetric.View {
+	streams := make(map[string]sdkmetric.Stream, len(histogramBuckets)+len(attributeLimiters))
+	for name, boundaries := range histogramBuckets {
+		s := streams[name]
+		s.Aggregation = sdkmetric.AggregationExplicitBucketHistogram{Boundaries: boundaries}
+		streams[name] = s
+	}
+	for name, limiter := range attributeLimiters {
+		s := streams[name]
+		s.AttributeFilter = limiter.keep
+		streams[name] = s
+	}
+
+	views := make([]sdkmetric.View, 0, len(streams))
+	for name, stream := range streams {
+		views = append(views, sdkmetric.NewView(sdkmetric.Instrument{Name: name}, stream))
+	}
+	return views
+}
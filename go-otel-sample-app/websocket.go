@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// wsPongWait is how long a connection may stay silent, pongs included,
+	// before it is considered dead; pings go out well within it
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsWriteWait  = 10 * time.Second
+	// wsReadLimit caps the messages clients may send; they have nothing to
+	// say beyond control frames
+	wsReadLimit = 4096
+)
+
+var (
+	wsMessagesSent metric.Int64Counter
+
+	promWSMessagesSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_messages_sent_total",
+			Help: "Messages pushed to WebSocket clients by type",
+		},
+		[]string{"type"},
+	)
+)
+
+// wsHub pushes an event every interval to each client connected to /ws.
+// Every connection gets a websocket.connection span that starts with the
+// upgrade request and ends when the connection closes, with the close code
+// and the number of messages sent; long-lived connections that never show
+// up as slow requests are then visible in traces.
+type wsHub struct {
+	interval time.Duration
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+// wsEvent is the JSON message pushed to clients
+type wsEvent struct {
+	Type            string    `json:"type"`
+	Sequence        int64     `json:"sequence"`
+	Timestamp       time.Time `json:"timestamp"`
+	OpenConnections int       `json:"open_connections"`
+}
+
+// registerWebSocket serves /ws on mux. The returned hub's shutdown must be
+// registered with the server, which doesn't close hijacked connections.
+func registerWebSocket(mux *http.ServeMux, c websocketConfig) (*wsHub, error) {
+	h := &wsHub{
+		interval: c.PushInterval,
+		conns:    make(map[*websocket.Conn]struct{}),
+	}
+	// The open connections are read from the hub when metrics are
+	// collected, so OTLP and /metrics report the same number
+	_, err := meter.Int64ObservableUpDownCounter(
+		"websocket_connections",
+		metric.WithDescription("Open WebSocket connections on /ws"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(h.count()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	wsMessagesSent, err = meter.Int64Counter(
+		"websocket_messages_sent_total",
+		metric.WithDescription("Messages pushed to WebSocket clients by type"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !prometheusBridge {
+		promRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "websocket_connections",
+			Help: "Open WebSocket connections on /ws",
+		}, func() float64 { return float64(h.count()) }))
+		promRegistry.MustRegister(promWSMessagesSent)
+	}
+	mux.HandleFunc("GET /ws", h.handler)
+	return h, nil
+}
+
+func (h *wsHub) handler(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered with 400 or 403
+		requestLogger(r, "/ws").WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+	// The server's read and write timeouts were set on the connection
+	// before it was hijacked, and would cut the session short
+	conn.NetConn().SetDeadline(time.Time{})
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	// As with a CPU burn, the span outlives the request that started it
+	ctx, span := tracer.Start(r.Context(), "websocket.connection", trace.WithAttributes(
+		semconv.NetworkPeerAddress(peer),
+		attribute.String("request.id", requestIDFromContext(r.Context())),
+	))
+	go h.serve(ctx, span, conn)
+}
+
+// serve pushes events to conn until the client goes away or the server
+// shuts down
+func (h *wsHub) serve(ctx context.Context, span trace.Span, conn *websocket.Conn) {
+	start := time.Now()
+	open := h.add(conn)
+	span.AddEvent("websocket.open", trace.WithAttributes(attribute.Int("websocket.open_connections", open)))
+	logger.InfoContext(ctx, "WebSocket connection opened", "remote_addr", conn.RemoteAddr().String())
+
+	// Only the read loop reads; it handles pongs and the close handshake
+	// and reports why the connection ended
+	closed := make(chan error, 1)
+	go func() {
+		conn.SetReadLimit(wsReadLimit)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	push := time.NewTicker(h.interval)
+	defer push.Stop()
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	var sent int64
+	var err error
+loop:
+	for {
+		select {
+		case err = <-closed:
+			break loop
+		case now := <-push.C:
+			conn.SetWriteDeadline(now.Add(wsWriteWait))
+			if err = conn.WriteJSON(wsEvent{
+				Type:            "tick",
+				Sequence:        sent + 1,
+				Timestamp:       now.UTC(),
+				OpenConnections: h.count(),
+			}); err != nil {
+				break loop
+			}
+			sent++
+			recordWSMessageSent(ctx, "tick")
+		case now := <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, now.Add(wsWriteWait)); err != nil {
+				break loop
+			}
+		}
+	}
+	conn.Close()
+
+	h.remove(conn)
+
+	code := websocket.CloseAbnormalClosure
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		code = closeErr.Code
+	}
+	duration := time.Since(start)
+	span.SetAttributes(
+		attribute.Int("websocket.close_code", code),
+		attribute.Int64("websocket.messages_sent", sent),
+	)
+	span.AddEvent("websocket.close")
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "connection closed abnormally")
+	}
+	span.End()
+	logger.InfoContext(ctx, "WebSocket connection closed",
+		"remote_addr", conn.RemoteAddr().String(),
+		"close_code", code,
+		"messages_sent", sent,
+		"duration_ms", duration.Milliseconds(),
+	)
+}
+
+// shutdown starts the close handshake with every client; each connection
+// ends once the client answers. It is registered with
+// http.Server.RegisterOnShutdown.
+func (h *wsHub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn := range h.conns {
+		// WriteControl may be called concurrently with the push loop
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+}
+
+func (h *wsHub) add(conn *websocket.Conn) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[conn] = struct{}{}
+	return len(h.conns)
+}
+
+func (h *wsHub) remove(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn)
+}
+
+func (h *wsHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+func recordWSMessageSent(ctx context.Context, msgType string) {
+	wsMessagesSent.Add(ctx, 1, metric.WithAttributes(attribute.String("type", msgType)))
+	if !prometheusBridge {
+		promWSMessagesSent.WithLabelValues(msgType).Inc()
+	}
+}